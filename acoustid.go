@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// defaultAcoustIDURL is AcoustID's public lookup endpoint. The endpoint is a
+// setting so it can point at a local mock (or a self-hosted server).
+const defaultAcoustIDURL = "https://api.acoustid.org/v2/lookup"
+
+// TagSuggestion is one candidate identification for a file, as returned by an
+// AcoustID lookup: the MusicBrainz recording plus a release group it appears on.
+type TagSuggestion struct {
+	Score       float64 // AcoustID's match confidence, 0..1
+	RecordingID string  // MusicBrainz recording ID
+	Fields      TagFields
+}
+
+// acoustIDResponse is the subset of the lookup response we use.
+type acoustIDResponse struct {
+	Status string `json:"status"`
+	Error  struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Results []struct {
+		Score      float64 `json:"score"`
+		Recordings []struct {
+			ID      string `json:"id"`
+			Title   string `json:"title"`
+			Artists []struct {
+				Name       string `json:"name"`
+				JoinPhrase string `json:"joinphrase"`
+			} `json:"artists"`
+			ReleaseGroups []struct {
+				Title string `json:"title"`
+				Type  string `json:"type"`
+			} `json:"releasegroups"`
+		} `json:"recordings"`
+	} `json:"results"`
+}
+
+// identifyDoneMsg carries the result of an AcoustID lookup for one song.
+type identifyDoneMsg struct {
+	song        Song
+	suggestions []TagSuggestion
+	err         error
+}
+
+// identifyCmd fingerprints song (reusing the cached fingerprint when there is
+// one) and looks it up on AcoustID in the background.
+func identifyCmd(song Song, fps *FingerprintStore, endpoint, key string) tea.Cmd {
+	return func() tea.Msg {
+		fps.Ensure(song.FilePath)
+		fp := fps.Get(song.FilePath)
+		if fp == "" {
+			return identifyDoneMsg{song: song, err: fmt.Errorf("couldn't fingerprint this file")}
+		}
+		sugs, err := acoustIDLookup(endpoint, key, int(song.DurationSecs+0.5), fp)
+		return identifyDoneMsg{song: song, suggestions: sugs, err: err}
+	}
+}
+
+// acoustIDLookup asks an AcoustID-compatible endpoint which recordings match a
+// fingerprint. Suggestions come back best first, one per recording and
+// release group.
+func acoustIDLookup(endpoint, key string, durationSecs int, fingerprint string) ([]TagSuggestion, error) {
+	form := url.Values{
+		"client":      {key},
+		"duration":    {strconv.Itoa(durationSecs)},
+		"fingerprint": {fingerprint},
+		"meta":        {"recordings releasegroups compress"},
+		"format":      {"json"},
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r acoustIDResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("bad response from %s: %v", endpoint, err)
+	}
+	if r.Status != "ok" {
+		if r.Error.Message != "" {
+			return nil, fmt.Errorf("%s", r.Error.Message)
+		}
+		return nil, fmt.Errorf("lookup failed (%s)", resp.Status)
+	}
+
+	var sugs []TagSuggestion
+	seen := make(map[string]bool)
+	for _, res := range r.Results {
+		for _, rec := range res.Recordings {
+			if rec.Title == "" {
+				continue
+			}
+			var artist strings.Builder
+			for _, a := range rec.Artists {
+				artist.WriteString(a.Name + a.JoinPhrase)
+			}
+			albums := []string{""}
+			if len(rec.ReleaseGroups) > 0 {
+				albums = albums[:0]
+				for _, rg := range rec.ReleaseGroups {
+					albums = append(albums, rg.Title)
+				}
+			}
+			for _, album := range albums {
+				key := rec.ID + "\x00" + album
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				fields := TagFields{"title": rec.Title}
+				if artist.Len() > 0 {
+					fields["artist"] = artist.String()
+				}
+				if album != "" {
+					fields["album"] = album
+				}
+				sugs = append(sugs, TagSuggestion{Score: res.Score, RecordingID: rec.ID, Fields: fields})
+			}
+		}
+	}
+	sort.SliceStable(sugs, func(i, j int) bool { return sugs[i].Score > sugs[j].Score })
+	return sugs, nil
+}
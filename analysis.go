@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// Tempo and key estimation, for DJing from the library. Both work on up to two
+// minutes of 11025 Hz mono audio taken after the first 30 seconds (intros are
+// often beatless or off-key). Tempo comes from the autocorrelation of an onset
+// envelope (spectral flux); key from a chromagram matched against the
+// Krumhansl-Schmuckler major and minor key profiles.
+const (
+	anSampleRate  = 11025
+	anSkipSeconds = 30
+	anMaxSeconds  = 120
+	anOnsetFrame  = 1024
+	anOnsetHop    = 128 // ~86 onset frames a second
+	anMinBPM      = 60
+	anMaxBPM      = 200
+	anKeyFrame    = 8192 // ~1.3 Hz bins, fine enough to tell low notes apart
+	anKeyMinFreq  = 55   // A1
+	anKeyMaxFreq  = 1760 // A6
+)
+
+// keyNames are the pitch classes from C, spelled as DJ software writes them.
+var keyNames = [12]string{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+
+// Krumhansl-Kessler key profiles: how strongly each scale degree (from the
+// tonic) is felt to belong to a major or minor key.
+var (
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+// analyzeFile estimates a file's tempo in BPM and its key ("Am", "F#"). A
+// value that can't be estimated (silence, no steady beat) comes back as zero
+// or "".
+func analyzeFile(path string) (float64, string, error) {
+	samples, err := decodeMono(path, anSampleRate, anSkipSeconds+anMaxSeconds)
+	if err != nil {
+		return 0, "", err
+	}
+	// Only skip the intro of tracks long enough to have one to spare.
+	if skip := anSkipSeconds * anSampleRate; len(samples) > 3*skip {
+		samples = samples[skip:]
+	}
+	return estimateTempo(samples), estimateKey(samples), nil
+}
+
+// analyzeSong fills in whichever of a song's tempo and key its tags left
+// unknown. Tagged values are kept: they're usually from DJ software or the
+// artist, and better than an estimate.
+func analyzeSong(s *Song) error {
+	bpm, key, err := analyzeFile(s.FilePath)
+	if err != nil {
+		return err
+	}
+	if s.BPM == 0 {
+		s.BPM = bpm
+	}
+	if s.Key == "" {
+		s.Key = key
+	}
+	s.Analyzed = true
+	return nil
+}
+
+// tempoKeyDoneMsg reports a finished tempoKeyCmd: the songs whose tempo or
+// key changed, how many files were tagged, and the first error of any that
+// failed.
+type tempoKeyDoneMsg struct {
+	songs   []Song
+	written int
+	failed  int
+	err     error
+}
+
+// tempoKeyCmd analyzes songs whose tempo or key isn't known yet and writes
+// what the analysis found to each song's tags, in the background. Values that
+// were already known came from the tags or an earlier analysis, and aren't
+// written again.
+func tempoKeyCmd(songs []Song) tea.Cmd {
+	return func() tea.Msg {
+		var msg tempoKeyDoneMsg
+		fail := func(s Song, err error) {
+			msg.failed++
+			if msg.err == nil {
+				msg.err = fmt.Errorf("%s: %w", filepath.Base(s.FilePath), err)
+			}
+		}
+		for _, s := range songs {
+			if s.FilePath == "" || s.Analyzed || (s.BPM != 0 && s.Key != "") {
+				continue
+			}
+			bpm, key := s.BPM, s.Key
+			if err := analyzeSong(&s); err != nil {
+				fail(s, err)
+				continue
+			}
+			msg.songs = append(msg.songs, s)
+			found := Song{FilePath: s.FilePath}
+			if bpm == 0 {
+				found.BPM = s.BPM
+			}
+			if key == "" {
+				found.Key = s.Key
+			}
+			if len(tempoKeyFields(found)) == 0 {
+				continue // nothing found to write
+			}
+			if err := writeTempoKey(found); err != nil {
+				fail(s, err)
+				continue
+			}
+			msg.written++
+		}
+		return msg
+	}
+}
+
+// carryAnalysis copies a rescanned song's earlier analysis from prev, the same
+// audio as it was last scanned, so a rescan doesn't redo it.
+func carryAnalysis(s *Song, prev Song) {
+	if !prev.Analyzed {
+		return
+	}
+	if s.BPM == 0 {
+		s.BPM = prev.BPM
+	}
+	if s.Key == "" {
+		s.Key = prev.Key
+	}
+	s.Analyzed = true
+}
+
+// estimateTempo returns the tempo of mono samples at anSampleRate, to one
+// decimal place.
+func estimateTempo(samples []float64) float64 {
+	onsets := onsetEnvelope(samples)
+	fps := float64(anSampleRate) / anOnsetHop
+	minLag := int(fps * 60 / anMaxBPM)
+	maxLag := int(math.Ceil(fps * 60 / anMinBPM))
+	if len(onsets) < 4*maxLag {
+		return 0
+	}
+
+	acf := make([]float64, 2*maxLag+2)
+	for lag := range acf {
+		sum := 0.0
+		for t := 0; t+lag < len(onsets); t++ {
+			sum += onsets[t] * onsets[t+lag]
+		}
+		acf[lag] = sum / float64(len(onsets)-lag)
+	}
+	if acf[0] <= 0 {
+		return 0
+	}
+
+	// A beat period also shows up at twice its lag, so the candidates are
+	// scored with that harmonic too, then weighted towards moderate tempos
+	// (a log-normal prior around 120 BPM) to settle half/double-time doubts.
+	score := func(lag int) float64 {
+		bpm := fps * 60 / float64(lag)
+		prior := math.Exp(-0.5 * math.Pow(math.Log2(bpm/120), 2))
+		return (acf[lag] + acf[2*lag]/2) * prior
+	}
+	best := 0
+	for lag := minLag; lag <= maxLag; lag++ {
+		if best == 0 || score(lag) > score(best) {
+			best = lag
+		}
+	}
+	if acf[best] <= 0 {
+		return 0
+	}
+
+	// Refine the peak between lags with a parabola through its neighbours.
+	lag := float64(best)
+	if best > minLag && best < maxLag {
+		a, b, c := acf[best-1], acf[best], acf[best+1]
+		if d := a - 2*b + c; d < 0 {
+			lag += 0.5 * (a - c) / d
+		}
+	}
+	return math.Round(fps*60/lag*10) / 10
+}
+
+// onsetEnvelope is the spectral flux of the samples: for each hop, how much
+// louder the spectrum got (in log terms) since the previous one. Its mean over
+// the surrounding half second is subtracted so only the peaks remain.
+func onsetEnvelope(samples []float64) []float64 {
+	window := hannWindow(anOnsetFrame)
+	buf := make([]complex128, anOnsetFrame)
+	prev := make([]float64, anOnsetFrame/2)
+	var flux []float64
+	for start := 0; start+anOnsetFrame <= len(samples); start += anOnsetHop {
+		for i := range buf {
+			buf[i] = complex(samples[start+i]*window[i], 0)
+		}
+		fft(buf)
+		sum := 0.0
+		for k := 1; k < anOnsetFrame/2; k++ {
+			mag := math.Log1p(1000 * math.Hypot(real(buf[k]), imag(buf[k])))
+			if d := mag - prev[k]; d > 0 && start > 0 {
+				sum += d
+			}
+			prev[k] = mag
+		}
+		flux = append(flux, sum)
+	}
+
+	half := anSampleRate / anOnsetHop / 4
+	out := make([]float64, len(flux))
+	for i := range flux {
+		lo, hi := max(0, i-half), min(len(flux), i+half+1)
+		mean := 0.0
+		for _, v := range flux[lo:hi] {
+			mean += v
+		}
+		mean /= float64(hi - lo)
+		if v := flux[i] - mean; v > 0 {
+			out[i] = v
+		}
+	}
+	return out
+}
+
+// estimateKey returns the key of mono samples at anSampleRate, "" if there is
+// no tonal content to go on.
+func estimateKey(samples []float64) string {
+	window := hannWindow(anKeyFrame)
+	buf := make([]complex128, anKeyFrame)
+
+	// Map each FFT bin in range to its pitch class (C = 0).
+	pitchClass := make([]int, anKeyFrame/2)
+	for k := range pitchClass {
+		freq := float64(k) * anSampleRate / anKeyFrame
+		pitchClass[k] = -1
+		if freq >= anKeyMinFreq && freq <= anKeyMaxFreq {
+			midi := int(math.Round(69 + 12*math.Log2(freq/440)))
+			pitchClass[k] = midi % 12
+		}
+	}
+
+	// Each frame's chroma is normalized before it's added, so quiet passages
+	// count as much as loud ones.
+	var chroma [12]float64
+	for start := 0; start+anKeyFrame <= len(samples); start += anKeyFrame / 2 {
+		for i := range buf {
+			buf[i] = complex(samples[start+i]*window[i], 0)
+		}
+		fft(buf)
+		var frame [12]float64
+		for k, pc := range pitchClass {
+			if pc >= 0 {
+				frame[pc] += math.Hypot(real(buf[k]), imag(buf[k]))
+			}
+		}
+		peak := 0.0
+		for _, v := range frame {
+			peak = math.Max(peak, v)
+		}
+		if peak < 1e-6 {
+			continue
+		}
+		for i, v := range frame {
+			chroma[i] += v / peak
+		}
+	}
+
+	bestKey, bestCorr := "", 0.0
+	for tonic := 0; tonic < 12; tonic++ {
+		for _, minor := range []bool{false, true} {
+			profile := majorProfile
+			if minor {
+				profile = minorProfile
+			}
+			var rotated [12]float64
+			for i := range rotated {
+				rotated[(tonic+i)%12] = profile[i]
+			}
+			if c := correlation(chroma[:], rotated[:]); c > bestCorr {
+				bestCorr, bestKey = c, keyName(tonic, minor)
+			}
+		}
+	}
+	return bestKey
+}
+
+// correlation is Pearson's correlation coefficient of two equal-length series,
+// 0 if either is constant.
+func correlation(a, b []float64) float64 {
+	var ma, mb float64
+	for i := range a {
+		ma += a[i]
+		mb += b[i]
+	}
+	ma /= float64(len(a))
+	mb /= float64(len(b))
+	var cov, va, vb float64
+	for i := range a {
+		cov += (a[i] - ma) * (b[i] - mb)
+		va += (a[i] - ma) * (a[i] - ma)
+		vb += (b[i] - mb) * (b[i] - mb)
+	}
+	if va == 0 || vb == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(va*vb)
+}
+
+func hannWindow(n int) []float64 {
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	}
+	return w
+}
+
+// keyName spells a key the way TKEY and DJ software do: "F#", "Am".
+func keyName(tonic int, minor bool) string {
+	if minor {
+		return keyNames[tonic] + "m"
+	}
+	return keyNames[tonic]
+}
+
+// parseKey reads a key as tags and searches write it: "Am", "A minor",
+// "A#m", "Bbmin", "C", "C major", or a Camelot code like "8A". It returns the
+// tonic's pitch class and whether the key is minor.
+func parseKey(s string) (tonic int, minor bool, ok bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false, false
+	}
+	// Camelot: 1-12 then A (minor) or B (major).
+	if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n >= 1 && n <= 12 {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "A":
+			// Inverse of camelotCode: n ≡ 7·tonic+5 (mod 12), and 7 is its
+			// own inverse mod 12.
+			return ((n-5)*7%12 + 12) % 12, true, true
+		case "B":
+			return ((n-8)*7%12 + 12) % 12, false, true
+		}
+		return 0, false, false
+	}
+
+	letters := map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+	tonic, ok = letters[strings.ToUpper(s[:1])[0]]
+	if !ok {
+		return 0, false, false
+	}
+	rest := s[1:]
+	for _, acc := range []struct {
+		sign   string
+		adjust int
+	}{{"#", 1}, {"♯", 1}, {"b", -1}, {"♭", -1}} {
+		if strings.HasPrefix(rest, acc.sign) {
+			tonic += acc.adjust
+			rest = rest[len(acc.sign):]
+			break
+		}
+	}
+	tonic = (tonic + 12) % 12
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "", "maj", "major":
+		return tonic, false, true
+	case "m", "min", "minor":
+		return tonic, true, true
+	}
+	return 0, false, false
+}
+
+// normalizeKey rewrites a key in any form parseKey reads to the form keyName
+// writes, or "" if it isn't one.
+func normalizeKey(s string) string {
+	tonic, minor, ok := parseKey(s)
+	if !ok {
+		return ""
+	}
+	return keyName(tonic, minor)
+}
+
+// camelotCode gives a key's position on the Camelot wheel ("8A" for A minor),
+// where neighbouring numbers and the same number's A/B mix harmonically.
+func camelotCode(key string) string {
+	tonic, minor, ok := parseKey(key)
+	if !ok {
+		return ""
+	}
+	n, letter := (7*tonic+8)%12, "B"
+	if minor {
+		n, letter = (7*tonic+5)%12, "A"
+	}
+	if n == 0 {
+		n = 12
+	}
+	return fmt.Sprintf("%d%s", n, letter)
+}
+
+// tempoKeyTags reads the tempo and key already tagged in a file, from dhowden/tag's
+// raw tag map: TBPM/TKEY in ID3v2 (TBP/TKE in v2.2), BPM and INITIALKEY (or
+// KEY) in Vorbis comments.
+func tempoKeyTags(raw map[string]interface{}) (float64, string) {
+	text := func(names ...string) string {
+		for _, n := range names {
+			if v, ok := raw[n].(string); ok && strings.TrimSpace(v) != "" {
+				return strings.TrimSpace(v)
+			}
+		}
+		return ""
+	}
+	bpm, _ := strconv.ParseFloat(text("TBPM", "TBP", "bpm"), 64)
+	if bpm < 0 || bpm > 999 {
+		bpm = 0
+	}
+	return bpm, normalizeKey(text("TKEY", "TKE", "initialkey", "key"))
+}
+
+// tempoKeyLabel renders a song's tempo and key for song lists, e.g.
+// "128 BPM • Am (8A)", or "" if neither is known.
+func tempoKeyLabel(s Song) string {
+	var parts []string
+	if s.BPM > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f BPM", s.BPM))
+	}
+	if code := camelotCode(s.Key); code != "" {
+		parts = append(parts, s.Key+" ("+code+")")
+	}
+	return strings.Join(parts, " • ")
+}
+
+// keysCompatible reports whether two keys mix harmonically: the same key, its
+// neighbours on the Camelot wheel, or its relative major or minor.
+func keysCompatible(a, b string) bool {
+	ia, ib := camelotIndex(a), camelotIndex(b)
+	if ia == 24 || ib == 24 {
+		return false
+	}
+	na, nb := ia/2, ib/2
+	switch {
+	case na == nb:
+		return true // the same key, or its relative major/minor
+	case ia%2 == ib%2:
+		return (na+1)%12 == nb || (nb+1)%12 == na
+	}
+	return false
+}
+
+// tempoLess orders songs slowest first, with unknown tempos last.
+func tempoLess(a, b Song) bool {
+	if (a.BPM > 0) != (b.BPM > 0) {
+		return a.BPM > 0
+	}
+	return a.BPM < b.BPM
+}
+
+// keyLess orders songs around the Camelot wheel (1A, 1B, 2A, …), so keys
+// that mix well end up next to each other; unknown keys go last.
+func keyLess(a, b Song) bool {
+	return camelotIndex(a.Key) < camelotIndex(b.Key)
+}
+
+// camelotIndex is a key's position in keyLess order, 24 if unknown.
+func camelotIndex(key string) int {
+	tonic, minor, ok := parseKey(key)
+	if !ok {
+		return 24
+	}
+	if minor {
+		return 2 * ((7*tonic + 4) % 12)
+	}
+	return 2*((7*tonic+7)%12) + 1
+}
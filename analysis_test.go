@@ -0,0 +1,85 @@
+package main
+
+import "testing"
+
+func TestNormalizeKey(t *testing.T) {
+	tests := map[string]string{
+		"Am":       "Am",
+		"A minor":  "Am",
+		"a#m":      "Bbm",
+		"Bbmin":    "Bbm",
+		"C":        "C",
+		"C major":  "C",
+		"D♭":       "C#",
+		"F♯ minor": "F#m",
+		"8A":       "Am",
+		"8B":       "C",
+		"12a":      "C#m",
+		"1B":       "B",
+		"13A":      "",
+		"H":        "",
+		"Am7":      "",
+		"":         "",
+	}
+	for in, want := range tests {
+		if got := normalizeKey(in); got != want {
+			t.Errorf("normalizeKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCamelotCode(t *testing.T) {
+	tests := map[string]string{
+		"Am": "8A", "C": "8B", "Em": "9A", "G": "9B", "Abm": "1A", "B": "1B",
+		"F#m": "11A", "A": "11B", "C#m": "12A", "E": "12B", "Dm": "7A", "F": "7B",
+		"": "",
+	}
+	for key, want := range tests {
+		if got := camelotCode(key); got != want {
+			t.Errorf("camelotCode(%q) = %q, want %q", key, got, want)
+		}
+	}
+	// Every key survives a trip through its Camelot code, and the codes
+	// order as the wheel does.
+	for tonic := 0; tonic < 12; tonic++ {
+		for _, minor := range []bool{false, true} {
+			key := keyName(tonic, minor)
+			code := camelotCode(key)
+			if back := normalizeKey(code); back != key {
+				t.Errorf("%s -> %s -> %s", key, code, back)
+			}
+			n := 0
+			for _, c := range code[:len(code)-1] {
+				n = n*10 + int(c-'0')
+			}
+			want := 2 * (n - 1)
+			if !minor {
+				want++
+			}
+			if got := camelotIndex(key); got != want {
+				t.Errorf("camelotIndex(%q) = %d, want %d (%s)", key, got, want, code)
+			}
+		}
+	}
+}
+
+func TestKeysCompatible(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Am", "Am", true},
+		{"Am", "C", true},    // relative major
+		{"Am", "Em", true},   // 8A-9A
+		{"Am", "Dm", true},   // 8A-7A
+		{"C#m", "Abm", true}, // 12A-1A wraps around
+		{"Am", "G", false},   // 8A-9B
+		{"Am", "Bbm", false},
+		{"Am", "", false},
+	}
+	for _, tt := range tests {
+		if got := keysCompatible(tt.a, tt.b); got != tt.want {
+			t.Errorf("keysCompatible(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
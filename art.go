@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/charmbracelet/x/ansi/iterm2"
+	"github.com/charmbracelet/x/ansi/sixel"
+	"github.com/dhowden/tag"
+)
+
+// Album art. ArtCache finds one cover per album — the first embedded picture,
+// else a cover/folder image beside the tracks — keeps a copy of the raw image
+// under ~/.resona/art, and holds a decoded thumbnail in memory. Covers are
+// drawn with the kitty, sixel or iTerm2 image protocols when the terminal
+// supports them, and with half-block characters otherwise.
+
+// Cover is a decoded album cover.
+type Cover struct {
+	ID     int         // stable per album; used as the kitty image ID
+	Image  image.Image // square thumbnail, at most coverThumbSize pixels
+	Colors [2]string   // two dominant colors ("#rrggbb") for cover gradients
+	Path   string      // the copy of the full image under ~/.resona/art, if it could be saved
+}
+
+const coverThumbSize = 256
+
+// coverFileNames are the folder images looked for, in order of preference.
+var coverFileNames = []string{"cover", "folder", "front", "album", "albumart", "albumartsmall"}
+
+// coverLoadedMsg reports that a cover lookup finished (found or not).
+type coverLoadedMsg struct{ key string }
+
+type ArtCache struct {
+	dir     string
+	mu      sync.Mutex
+	covers  map[string]*Cover // nil value: looked up, no cover found
+	pending map[string]bool
+}
+
+func NewArtCache() (*ArtCache, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	dir := filepath.Join(homeDir, ".resona", "art")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create art cache directory: %v", err)
+	}
+	return &ArtCache{
+		dir:     dir,
+		covers:  make(map[string]*Cover),
+		pending: make(map[string]bool),
+	}, nil
+}
+
+// coverKey identifies the album a song belongs to: its folder plus album name,
+// so two albums sharing a folder still get their own embedded covers.
+func coverKey(s Song) string {
+	return filepath.Dir(s.FilePath) + "\x00" + strings.ToLower(s.Album)
+}
+
+// Cover returns the song's cover if it has been loaded. ok is false while the
+// lookup hasn't run yet; a finished lookup without a cover returns (nil, true).
+func (ac *ArtCache) Cover(s Song) (cover *Cover, ok bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	cover, ok = ac.covers[coverKey(s)]
+	return cover, ok
+}
+
+// LoadCmd starts a background lookup of the song's cover, or returns nil if
+// it is already loaded or in flight.
+func (ac *ArtCache) LoadCmd(s Song) tea.Cmd {
+	if s.FilePath == "" {
+		return nil
+	}
+	key := coverKey(s)
+	ac.mu.Lock()
+	_, done := ac.covers[key]
+	if done || ac.pending[key] {
+		ac.mu.Unlock()
+		return nil
+	}
+	ac.pending[key] = true
+	ac.mu.Unlock()
+
+	return func() tea.Msg {
+		cover := ac.load(key, s.FilePath)
+		ac.mu.Lock()
+		ac.covers[key] = cover
+		delete(ac.pending, key)
+		ac.mu.Unlock()
+		return coverLoadedMsg{key: key}
+	}
+}
+
+// load reads the cover from the disk cache, or finds it and caches it.
+func (ac *ArtCache) load(key, songPath string) *Cover {
+	sum := sha1.Sum([]byte(key))
+	cachePath := filepath.Join(ac.dir, hex.EncodeToString(sum[:8])+".img")
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		if data = findCoverData(songPath); data == nil {
+			return nil
+		}
+		if os.WriteFile(cachePath, data, 0644) != nil {
+			cachePath = ""
+		}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	thumb := squareThumbnail(img, coverThumbSize)
+	start, end := dominantColors(thumb)
+	return &Cover{
+		ID:     int(crc32.ChecksumIEEE([]byte(key))&0x7fffffff) | 1,
+		Image:  thumb,
+		Colors: [2]string{start, end},
+		Path:   cachePath,
+	}
+}
+
+// findCoverData returns the raw image embedded in the track, or the best
+// cover/folder image in its directory.
+func findCoverData(songPath string) []byte {
+	if f, err := os.Open(songPath); err == nil {
+		m, err := tag.ReadFrom(f)
+		f.Close()
+		if err == nil && m.Picture() != nil && len(m.Picture().Data) > 0 {
+			return m.Picture().Data
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(songPath))
+	if err != nil {
+		return nil
+	}
+	best, bestRank := "", len(coverFileNames)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := strings.ToLower(e.Name())
+		ext := filepath.Ext(name)
+		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+			continue
+		}
+		for rank, base := range coverFileNames {
+			if strings.TrimSuffix(name, ext) == base && rank < bestRank {
+				best, bestRank = e.Name(), rank
+			}
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(songPath), best))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// squareThumbnail center-crops img to a square and box-filters it down to at
+// most size pixels per side.
+func squareThumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+	size = min(size, side)
+	return resizeImage(img, crop, size, size)
+}
+
+// resizeImage box-filters the src rectangle of img into a w×h image.
+func resizeImage(img image.Image, src image.Rectangle, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if src.Empty() || w <= 0 || h <= 0 {
+		return dst
+	}
+	for y := 0; y < h; y++ {
+		y0 := src.Min.Y + y*src.Dy()/h
+		y1 := max(src.Min.Y+(y+1)*src.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := src.Min.X + x*src.Dx()/w
+			x1 := max(src.Min.X+(x+1)*src.Dx()/w, x0+1)
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, _ := img.At(sx, sy).RGBA()
+					r, g, bl, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), 0xff})
+		}
+	}
+	return dst
+}
+
+// dominantColors picks the two most common distinct colors of a cover,
+// skipping near-greys, and lifts dark ones so they read on a dark background.
+func dominantColors(img *image.RGBA) (string, string) {
+	type bucket struct {
+		r, g, b, n int
+	}
+	buckets := make(map[int]*bucket)
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])
+		if max(r, max(g, b))-min(r, min(g, b)) < 24 {
+			continue // greys make a dull gradient
+		}
+		k := r>>5<<6 | g>>5<<3 | b>>5
+		bk := buckets[k]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[k] = bk
+		}
+		bk.r, bk.g, bk.b, bk.n = bk.r+r, bk.g+g, bk.b+b, bk.n+1
+	}
+	var ranked []RGB
+	var counts []*bucket
+	for _, bk := range buckets {
+		counts = append(counts, bk)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].n > counts[j].n })
+	for _, bk := range counts {
+		ranked = append(ranked, liftColor(RGB{float64(bk.r / bk.n), float64(bk.g / bk.n), float64(bk.b / bk.n)}))
+	}
+	if len(ranked) == 0 {
+		return "", ""
+	}
+	first, second := ranked[0], ranked[0]
+	for _, c := range ranked[1:] {
+		dr, dg, db := c.R-first.R, c.G-first.G, c.B-first.B
+		if dr*dr+dg*dg+db*db > 80*80 {
+			second = c
+			break
+		}
+	}
+	if second == first {
+		second = blendColors(first, RGB{255, 255, 255}, 0.5)
+	}
+	return rgbToHex(first), rgbToHex(second)
+}
+
+// liftColor scales a color up so its brightest channel is at least 160.
+func liftColor(c RGB) RGB {
+	peak := c.R
+	if c.G > peak {
+		peak = c.G
+	}
+	if c.B > peak {
+		peak = c.B
+	}
+	if peak >= 160 || peak == 0 {
+		return c
+	}
+	f := 160 / peak
+	return RGB{c.R * f, c.G * f, c.B * f}
+}
+
+// coverBlocks renders the cover as cols×rows cells of "▀" half-blocks, each
+// cell showing two vertically stacked pixels.
+func coverBlocks(cover *Cover, cols, rows int) []string {
+	small := resizeImage(cover.Image, cover.Image.Bounds(), cols, rows*2)
+	lines := make([]string, rows)
+	for y := 0; y < rows; y++ {
+		var sb strings.Builder
+		for x := 0; x < cols; x++ {
+			top, bottom := small.RGBAAt(x, y*2), small.RGBAAt(x, y*2+1)
+			fmt.Fprintf(&sb, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		sb.WriteString("\x1b[0m")
+		lines[y] = sb.String()
+	}
+	return lines
+}
+
+// detectImageProtocol guesses the terminal's image protocol from the
+// environment: "kitty", "iterm" or "sixel", or "blocks" when none is known.
+// Multiplexers need passthrough that this doesn't attempt, so tmux and screen
+// always get blocks.
+func detectImageProtocol() string {
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen") || strings.HasPrefix(term, "tmux"):
+		return "blocks"
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" || program == "ghostty":
+		return "kitty"
+	case program == "iTerm.app" || program == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return "iterm"
+	case strings.Contains(term, "foot") || strings.Contains(term, "mlterm") || strings.Contains(term, "sixel") || program == "contour":
+		return "sixel"
+	}
+	return "blocks"
+}
+
+// artPlacement is a cover drawn with an image protocol at a cell position.
+type artPlacement struct {
+	cover                *Cover
+	id, x, y, cols, rows int
+}
+
+// artSlots numbers the places a cover can appear; kitty uses the number as
+// the placement ID.
+var artSlots = map[string]int{"np": 1, "lib": 2}
+
+// placeCoverSeq returns the escape sequence that draws cover over the cells
+// at (x, y), restoring the cursor afterwards. slot distinguishes the kitty
+// placement so the same cover can be shown twice.
+func placeCoverSeq(protocol string, cover *Cover, slot, x, y, cols, rows int) string {
+	var body bytes.Buffer
+	switch protocol {
+	case "kitty":
+		var data bytes.Buffer
+		if png.Encode(&data, cover.Image) != nil {
+			return ""
+		}
+		payload := base64.StdEncoding.EncodeToString(data.Bytes())
+		opts := []string{"a=T", "f=100", "q=2", fmt.Sprintf("i=%d", cover.ID), fmt.Sprintf("p=%d", slot),
+			fmt.Sprintf("c=%d", cols), fmt.Sprintf("r=%d", rows), "C=1"}
+		// The protocol caps each escape at 4096 bytes of payload; every chunk
+		// but the last carries m=1.
+		for len(payload) > 0 {
+			n := min(len(payload), 4096)
+			more := "m=0"
+			if n < len(payload) {
+				more = "m=1"
+			}
+			body.WriteString(ansi.KittyGraphics([]byte(payload[:n]), append(opts, more)...))
+			payload = payload[n:]
+			opts = []string{"q=2"}
+		}
+	case "iterm":
+		var data bytes.Buffer
+		if png.Encode(&data, cover.Image) != nil {
+			return ""
+		}
+		body.WriteString(ansi.ITerm2(iterm2.File{
+			Name:            "cover",
+			Size:            int64(data.Len()),
+			Width:           iterm2.Cells(cols),
+			Height:          iterm2.Cells(rows),
+			Inline:          true,
+			DoNotMoveCursor: true,
+			Content:         []byte(base64.StdEncoding.EncodeToString(data.Bytes())),
+		}))
+	case "sixel":
+		// Without a pixel-size query, assume the common 10×20 cell.
+		img := resizeImage(cover.Image, cover.Image.Bounds(), cols*10, rows*20)
+		var payload bytes.Buffer
+		if (&sixel.Encoder{}).Encode(&payload, img) != nil {
+			return ""
+		}
+		body.WriteString(ansi.SixelGraphics(0, 1, 0, payload.Bytes()))
+	default:
+		return ""
+	}
+	return ansi.SaveCursor + ansi.CursorPosition(x+1, y+1) + body.String() + ansi.RestoreCursor
+}
+
+// deleteCoverSeq removes a kitty placement made by placeCoverSeq.
+func deleteCoverSeq(id, slot int) string {
+	return ansi.KittyGraphics(nil, "a=d", "d=i", fmt.Sprintf("i=%d", id), fmt.Sprintf("p=%d", slot), "q=2")
+}
@@ -9,11 +9,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/effects"
 	"github.com/gopxl/beep/v2/flac"
 	"github.com/gopxl/beep/v2/mp3"
 	"github.com/gopxl/beep/v2/speaker"
@@ -134,12 +136,20 @@ type AudioPlayer struct {
 	startTime   time.Time
 	pausedTime  time.Duration
 	duration    float64 // Total duration in seconds
-	// Seeking (local files only; HTTP/radio streams aren't seekable)
-	streamer beep.StreamSeekCloser
-	seekable bool
+	// Position, seeking and the A-B loop (local files only; HTTP/radio
+	// streams aren't seekable)
+	source       *loopStreamer
+	loopA, loopB float64 // loop points in seconds, -1 while unmarked
 	// Audio visualization
 	audioSamples []float64
 	sampleMutex  sync.RWMutex
+	// Title of what a radio stream is playing, from its ICY metadata. It is
+	// set from the decoder, under speaker.Lock, so it has its own mutex.
+	streamTitle string
+	titleMutex  sync.Mutex
+	// Output volume, applied after the mixer; level is linear, 0..1.
+	volume *effects.Volume
+	level  float64
 }
 
 func NewAudioPlayer() (*AudioPlayer, error) {
@@ -159,13 +169,16 @@ func NewAudioPlayer() (*AudioPlayer, error) {
 
 	// Create a mixer for better control
 	mixer := &beep.Mixer{}
-	speaker.Play(mixer)
+	volume := &effects.Volume{Streamer: mixer, Base: 2}
+	speaker.Play(volume)
 
 	return &AudioPlayer{
 		mixer:       mixer,
 		isPlaying:   false,
 		isPaused:    false,
 		speakerInit: true,
+		volume:      volume,
+		level:       1,
 	}, nil
 }
 
@@ -282,8 +295,8 @@ func (ap *AudioPlayer) playDirectly(filePath string) error {
 		req.Header.Set("Sec-Fetch-Dest", "audio")
 		req.Header.Set("Sec-Fetch-Mode", "cors")
 		req.Header.Set("Sec-Fetch-Site", "same-origin")
-		// Remove Icy-MetaData for now as it might trigger detection
-		// req.Header.Set("Icy-MetaData", "1") // Request ICY metadata
+		// Ask for ICY metadata, which carries the title of what's playing
+		req.Header.Set("Icy-MetaData", "1")
 		req.Header.Set("Cache-Control", "no-cache")
 		req.Header.Set("Pragma", "no-cache")
 		
@@ -302,7 +315,12 @@ func (ap *AudioPlayer) playDirectly(filePath string) error {
 		}
 		// Wrap the response body with a buffered reader for better stream handling
 		log.Printf("DEBUG: Setting up buffered reader")
-		bufferedReader := bufio.NewReaderSize(resp.Body, 32*1024) // 32KB buffer
+		var body io.Reader = resp.Body
+		if metaint, _ := strconv.Atoi(resp.Header.Get("icy-metaint")); metaint > 0 {
+			// Strip the metadata out of the audio, keeping the titles
+			body = newICYReader(resp.Body, metaint, ap.setStreamTitle)
+		}
+		bufferedReader := bufio.NewReaderSize(body, 32*1024) // 32KB buffer
 		reader = &bufferedHTTPReader{
 			reader: bufferedReader,
 			closer: resp.Body,
@@ -374,9 +392,18 @@ func (ap *AudioPlayer) playDirectly(filePath string) error {
 	}
 	log.Printf("DEBUG: Audio decoding successful, format: %+v", format)
 
+	// Local files go through a loopStreamer, which tracks the position and
+	// plays the A-B loop.
+	var source *loopStreamer
+	var decoded beep.Streamer = streamer
+	if !isURL {
+		source = newLoopStreamer(streamer, format.SampleRate)
+		decoded = source
+	}
+
 	// Resample if necessary
 	log.Printf("DEBUG: Resampling from %v to 44100", format.SampleRate)
-	resampled := beep.Resample(4, format.SampleRate, beep.SampleRate(44100), streamer)
+	resampled := beep.Resample(4, format.SampleRate, beep.SampleRate(44100), decoded)
 
 	// Wrap with sample capture streamer for visualization
 	log.Printf("DEBUG: Setting up sample capture streamer")
@@ -392,8 +419,8 @@ func (ap *AudioPlayer) playDirectly(filePath string) error {
 	ap.startTime = time.Now()
 	ap.pausedTime = 0
 	// Local files are seekable; live streams are not.
-	ap.streamer = streamer
-	ap.seekable = !isURL
+	ap.source = source
+	ap.loopA, ap.loopB = -1, -1
 	ap.mutex.Unlock()
 
 	// Add to mixer with callback for cleanup
@@ -403,8 +430,7 @@ func (ap *AudioPlayer) playDirectly(filePath string) error {
 		ap.mutex.Lock()
 		ap.isPlaying = false
 		ap.isPaused = false
-		ap.streamer = nil
-		ap.seekable = false
+		ap.source = nil
 		ap.mutex.Unlock()
 		streamer.Close()
 		reader.Close()
@@ -483,11 +509,47 @@ func (ap *AudioPlayer) Stop() {
 	ap.mixer.Clear()
 	speaker.Unlock()
 	
-	ap.streamer = nil
-	ap.seekable = false
+	ap.source = nil
 	ap.isPlaying = false
 	ap.isPaused = false
 	ap.currentSong = ""
+	ap.setStreamTitle("")
+}
+
+// Volume returns the output volume, from 0 (silent) to 1 (full).
+func (ap *AudioPlayer) Volume() float64 {
+	ap.mutex.RLock()
+	defer ap.mutex.RUnlock()
+	return ap.level
+}
+
+// SetVolume sets the output volume, from 0 (silent) to 1 (full); the level
+// scales the amplitude, so 0.5 is about 6 dB down.
+func (ap *AudioPlayer) SetVolume(level float64) {
+	level = math.Max(0, math.Min(level, 1))
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	ap.level = level
+	speaker.Lock()
+	ap.volume.Silent = level == 0
+	if level > 0 {
+		ap.volume.Volume = math.Log2(level)
+	}
+	speaker.Unlock()
+}
+
+// StreamTitle returns what the radio stream says it is playing, usually
+// "Artist - Title", or "" if it doesn't say.
+func (ap *AudioPlayer) StreamTitle() string {
+	ap.titleMutex.Lock()
+	defer ap.titleMutex.Unlock()
+	return ap.streamTitle
+}
+
+func (ap *AudioPlayer) setStreamTitle(title string) {
+	ap.titleMutex.Lock()
+	ap.streamTitle = title
+	ap.titleMutex.Unlock()
 }
 
 func (ap *AudioPlayer) IsPlaying() bool {
@@ -542,6 +604,10 @@ func (ap *AudioPlayer) GetPosition() float64 {
 	if !ap.isPlaying && !ap.isPaused {
 		return 0
 	}
+	// Local files know exactly where they are, loops and seeks included.
+	if ap.source != nil {
+		return ap.source.seconds()
+	}
 	
 	var elapsed time.Duration
 	if ap.isPaused {
@@ -583,7 +649,7 @@ func (ap *AudioPlayer) GetProgress() float64 {
 func (ap *AudioPlayer) CanSeek() bool {
 	ap.mutex.RLock()
 	defer ap.mutex.RUnlock()
-	return ap.seekable && ap.streamer != nil
+	return ap.source != nil
 }
 
 // Seek jumps to the given fraction (0..1) of the track. Returns an error if the
@@ -591,40 +657,66 @@ func (ap *AudioPlayer) CanSeek() bool {
 func (ap *AudioPlayer) Seek(fraction float64) error {
 	ap.mutex.Lock()
 	defer ap.mutex.Unlock()
-
-	if !ap.seekable || ap.streamer == nil {
+	if ap.source == nil {
 		return fmt.Errorf("seeking is not supported for this source")
 	}
-	if fraction < 0 {
-		fraction = 0
-	}
-	if fraction > 1 {
-		fraction = 1
-	}
+	fraction = math.Max(0, math.Min(1, fraction))
+	return ap.seekSample(int(fraction * float64(ap.source.src.Len())))
+}
 
-	total := ap.streamer.Len()
-	target := int(fraction * float64(total))
-	if target >= total {
-		target = total - 1
-	}
-	if target < 0 {
-		target = 0
+// SeekTo jumps to the given time in seconds, clamped to the track.
+func (ap *AudioPlayer) SeekTo(seconds float64) error {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	if ap.source == nil {
+		return fmt.Errorf("seeking is not supported for this source")
 	}
+	return ap.seekSample(ap.source.rate.N(time.Duration(seconds * float64(time.Second))))
+}
+
+// SeekBy jumps delta seconds forwards (or backwards, if negative).
+func (ap *AudioPlayer) SeekBy(delta float64) error {
+	return ap.SeekTo(ap.GetPosition() + delta)
+}
 
+// seekSample moves the source to sample n. The caller holds ap.mutex.
+func (ap *AudioPlayer) seekSample(n int) error {
 	// speaker.Lock keeps us from seeking mid-Stream call.
 	speaker.Lock()
-	err := ap.streamer.Seek(target)
-	speaker.Unlock()
-	if err != nil {
-		return err
-	}
+	defer speaker.Unlock()
+	return ap.source.seek(n)
+}
 
-	// Re-anchor the time-based position tracking to the new position.
-	ap.pausedTime = time.Duration(fraction * ap.duration * float64(time.Second))
-	ap.startTime = time.Now()
+// SetLoop marks the A-B loop's points in seconds, -1 for an unmarked one.
+// With both marked and b after a, the section between them repeats until the
+// loop is cleared or another track starts.
+func (ap *AudioPlayer) SetLoop(a, b float64) error {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	if ap.source == nil {
+		return fmt.Errorf("looping is not supported for this source")
+	}
+	ap.loopA, ap.loopB = a, b
+	speaker.Lock()
+	if a >= 0 && b > a {
+		ap.source.setLoop(a, b)
+	} else {
+		ap.source.setLoop(0, 0)
+	}
+	speaker.Unlock()
 	return nil
 }
 
+// Loop returns the A-B loop's points in seconds, -1 for an unmarked one.
+func (ap *AudioPlayer) Loop() (a, b float64) {
+	ap.mutex.RLock()
+	defer ap.mutex.RUnlock()
+	if ap.source == nil {
+		return -1, -1
+	}
+	return ap.loopA, ap.loopB
+}
+
 func (ap *AudioPlayer) Close() {
 	ap.Stop()
 	speaker.Close()
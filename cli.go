@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The resona subcommands. Most control the running player through its
+// control socket (daemon.go), whether that's `resona daemon` or a TUI playing
+// by itself; `resona library rescan` and `resona radio list` work on the
+// files in ~/.resona directly.
+
+const cliUsage = `Usage: resona [command]
+
+With no command, resona starts the player, or attaches to a running one.
+
+  play [path... | query]     play files, folders and playlist files, or the
+                             library songs a search query matches (the search
+                             box's syntax: artist:radiohead year:>2000 ...);
+                             with neither, carry on playing
+  pause, toggle, stop
+  next, prev
+  seek [+|-]time             seek to, or by, 90 or 1:30 seconds
+  volume [+|-]percent        set, or change, the volume
+  status [--json]            what's playing
+  queue [--json]             the play queue
+  queue add path... | query  add to the end of the queue
+  radio list                 the saved stations
+  radio play name            tune in to a saved station
+  library rescan             rescan the library's folders
+  daemon                     play without a terminal, taking commands
+  quit                       stop the running player
+`
+
+// cliMethods are the commands that are just a control method.
+var cliMethods = map[string]string{
+	"pause":  "Pause",
+	"toggle": "Toggle",
+	"stop":   "Stop",
+	"next":   "Next",
+	"prev":   "Previous",
+	"quit":   "Shutdown",
+}
+
+// searchPlayLimit is how many songs a free-text search plays at most, as
+// many as the search box shows.
+const searchPlayLimit = 50
+
+// runCommand runs `resona <args>`.
+func runCommand(args []string) error {
+	cmd, args := args[0], args[1:]
+	jsonOut := false
+	if len(args) > 0 && args[len(args)-1] == "--json" && (cmd == "status" || cmd == "queue") {
+		jsonOut, args = true, args[:len(args)-1]
+	}
+
+	switch {
+	case cmd == "help" || cmd == "-h" || cmd == "--help":
+		fmt.Print(cliUsage)
+		return nil
+	case cmd == "daemon" && len(args) == 0:
+		return runDaemon()
+	case cmd == "library" && len(args) == 1 && args[0] == "rescan":
+		return rescanLibrary()
+	case cmd == "radio" && len(args) == 1 && args[0] == "list":
+		return listStations()
+	}
+
+	var run func(r *remotePlayer) error
+	switch {
+	case cliMethods[cmd] != "" && len(args) == 0:
+		run = func(r *remotePlayer) error { return r.call(cliMethods[cmd], NoArgs{}) }
+	case cmd == "play":
+		run = func(r *remotePlayer) error { return cliPlay(r, args) }
+	case cmd == "seek" && len(args) == 1:
+		run = func(r *remotePlayer) error { return cliSeek(r, args[0]) }
+	case cmd == "volume" && len(args) == 1:
+		run = func(r *remotePlayer) error { return cliVolume(r, args[0]) }
+	case cmd == "status" && len(args) == 0:
+		run = func(r *remotePlayer) error { return printStatus(r.status, jsonOut) }
+	case cmd == "queue" && len(args) == 0:
+		run = func(r *remotePlayer) error { return printQueue(r, jsonOut) }
+	case cmd == "queue" && len(args) > 1 && args[0] == "add":
+		run = func(r *remotePlayer) error { return cliEnqueue(r, args[1:]) }
+	case cmd == "radio" && len(args) > 1 && args[0] == "play":
+		run = func(r *remotePlayer) error { return cliPlayStation(r, strings.Join(args[1:], " ")) }
+	default:
+		return fmt.Errorf("don't know `%s`\n\n%s", strings.Join(append([]string{cmd}, args...), " "), cliUsage)
+	}
+
+	r, err := dialDaemon()
+	if err != nil {
+		return errors.New("resona isn't running; start it, or `resona daemon` to play without a terminal")
+	}
+	defer r.Close()
+	return run(r)
+}
+
+// cliPlay plays what the arguments name, or resumes without any.
+func cliPlay(r *remotePlayer, args []string) error {
+	if len(args) == 0 {
+		return r.call("Play", NoArgs{})
+	}
+	songs, err := songsForArgs(args)
+	if err != nil {
+		return err
+	}
+	if err := r.call("PlayQueue", PlayQueueArgs{Songs: songs}); err != nil {
+		return err
+	}
+	fmt.Println("Playing " + songsLabel(songs))
+	return nil
+}
+
+func cliEnqueue(r *remotePlayer, args []string) error {
+	songs, err := songsForArgs(args)
+	if err != nil {
+		return err
+	}
+	if err := r.call("Enqueue", EnqueueArgs{Songs: songs}); err != nil {
+		return err
+	}
+	fmt.Println("Queued " + songsLabel(songs))
+	return nil
+}
+
+// songsLabel names the first of songs and counts the rest.
+func songsLabel(songs []Song) string {
+	label := songs[0].Title
+	if songs[0].Artist != "" {
+		label += " by " + songs[0].Artist
+	}
+	if len(songs) > 1 {
+		label += fmt.Sprintf(" and %d more", len(songs)-1)
+	}
+	return label
+}
+
+// songsForArgs resolves the arguments of play and queue add: the files,
+// folders and playlist files they name if they all exist, otherwise the
+// library songs they match as a search query.
+func songsForArgs(args []string) ([]Song, error) {
+	library, err := NewLibraryManager()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, arg := range args {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			break
+		}
+		if _, err := os.Stat(path); err != nil {
+			break
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) < len(args) {
+		return searchLibrary(strings.Join(args, " "), library)
+	}
+
+	var songs []Song
+	for _, path := range paths {
+		info, _ := os.Stat(path)
+		switch {
+		case info.IsDir():
+			folder := songsForFiles(audioFilesUnder(path), songsByPath(library.GetSongs()))
+			sortByFolder(folder)
+			songs = append(songs, folder...)
+		case isPlaylistFile(path):
+			listed, _, err := importPlaylistFile(path, library.GetSongs())
+			if err != nil {
+				return nil, err
+			}
+			songs = append(songs, listed...)
+		case isSupportedAudio(path):
+			songs = append(songs, songsForFiles([]string{path}, songsByPath(library.GetSongs()))...)
+		default:
+			return nil, fmt.Errorf("%s isn't an audio or playlist file", path)
+		}
+	}
+	if len(songs) == 0 {
+		return nil, errors.New("no audio files there")
+	}
+	return songs, nil
+}
+
+// searchLibrary finds the library songs matching a search query. Free text
+// ranks them as the search box does, best first; a query of field terms
+// alone lists its songs in album order.
+func searchLibrary(query string, library *LibraryManager) ([]Song, error) {
+	settings, err := NewSettingsManager()
+	if err != nil {
+		return nil, err
+	}
+	history, err := NewHistoryManager()
+	if err != nil {
+		return nil, err
+	}
+	translit := settings.GetSettings().Transliterate
+	terms, err := parseSearchTerms(query, translit)
+	if err != nil {
+		return nil, err
+	}
+
+	type hit struct {
+		song  Song
+		score int
+	}
+	var hits []hit
+	now := time.Now()
+	for _, s := range library.GetSongs() {
+		if s.FilePath == "" || terms.active() && !terms.matches(s, library, history, now) {
+			continue
+		}
+		score := 1
+		if terms.text != "" {
+			score = scoreSong(s, terms.text, translit)
+		}
+		if score > 0 {
+			hits = append(hits, hit{s, score})
+		}
+	}
+	if len(hits) == 0 {
+		return nil, fmt.Errorf("nothing in the library matches %q", query)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		a, b := hits[i].song, hits[j].song
+		if a.Album != b.Album || albumArtistOf(a) != albumArtistOf(b) {
+			return albumArtistOf(a)+"\x00"+a.Album < albumArtistOf(b)+"\x00"+b.Album
+		}
+		return trackLess(a, b)
+	})
+	if terms.text != "" && len(hits) > searchPlayLimit {
+		hits = hits[:searchPlayLimit]
+	}
+	songs := make([]Song, len(hits))
+	for i, h := range hits {
+		songs[i] = h.song
+	}
+	return songs, nil
+}
+
+// cliSeek seeks to a time, or by one with a sign: 90, 1:30, +10, -0:30.
+func cliSeek(r *remotePlayer, arg string) error {
+	relative := strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-")
+	seconds, err := parseClock(strings.TrimLeft(arg, "+-"))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(arg, "-") {
+		seconds = -seconds
+	}
+	return r.call("Seek", SeekArgs{Seconds: seconds, Relative: relative})
+}
+
+// parseClock parses seconds written as 90, 1:30 or 1:02:03.
+func parseClock(s string) (float64, error) {
+	var seconds float64
+	for _, part := range strings.Split(s, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("%q isn't a time", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// cliVolume sets the volume in percent, or changes it with a sign: 70, +5.
+func cliVolume(r *remotePlayer, arg string) error {
+	percent, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return fmt.Errorf("%q isn't a volume", arg)
+	}
+	if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+		percent += math.Round(r.status.Volume * 100)
+	}
+	level := math.Max(0, math.Min(percent/100, 1))
+	return r.call("SetVolume", VolumeArgs{Level: level})
+}
+
+func cliPlayStation(r *remotePlayer, name string) error {
+	radio, err := NewRadioLibrary()
+	if err != nil {
+		return err
+	}
+	station, err := findStation(radio.GetStations(), name)
+	if err != nil {
+		return err
+	}
+	if err := r.call("PlayStation", station); err != nil {
+		return err
+	}
+	fmt.Println("Tuned in to " + station.Name)
+	return nil
+}
+
+// findStation finds the station called name, ignoring case, or else the one
+// station whose name contains it.
+func findStation(stations []RadioStation, name string) (*RadioStation, error) {
+	var found []*RadioStation
+	for i := range stations {
+		if strings.EqualFold(stations[i].Name, name) {
+			return &stations[i], nil
+		}
+		if strings.Contains(strings.ToLower(stations[i].Name), strings.ToLower(name)) {
+			found = append(found, &stations[i])
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no station called %q", name)
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, len(found))
+	for i, st := range found {
+		names[i] = st.Name
+	}
+	return nil, fmt.Errorf("%q could be %s", name, strings.Join(names, ", "))
+}
+
+func listStations() error {
+	radio, err := NewRadioLibrary()
+	if err != nil {
+		return err
+	}
+	for _, st := range radio.GetStations() {
+		if st.Genre != "" {
+			fmt.Printf("%s (%s)\n", st.Name, st.Genre)
+		} else {
+			fmt.Println(st.Name)
+		}
+	}
+	return nil
+}
+
+// rescanLibrary rescans the library's folders as the TUI's rescan does, and
+// has a running player reload the result.
+func rescanLibrary() error {
+	library, err := NewLibraryManager()
+	if err != nil {
+		return err
+	}
+	settings, err := NewSettingsManager()
+	if err != nil {
+		return err
+	}
+	folders := library.GetFolders()
+	if len(folders) == 0 {
+		return errors.New("the library has no folders yet")
+	}
+
+	fmt.Printf("Scanning %d folders…\n", len(folders))
+	opts := scanOptionsFor(settings.GetSettings(), library)
+	songs, diags := scanFoldersProgress(folders, opts, nil)
+	if fps := opts.fingerprints; fps != nil {
+		fps.Prune()
+		fps.Save()
+	}
+	library.SetDiagnostics(diags, true)
+	if err := library.SetSongs(songs); err != nil {
+		return err
+	}
+	fmt.Printf("%d songs in the library\n", library.GetSongCount())
+
+	if r, err := dialDaemon(); err == nil {
+		defer r.Close()
+		return r.call("ReloadLibrary", NoArgs{})
+	}
+	return nil
+}
+
+func printStatus(st PlayerStatus, jsonOut bool) error {
+	if jsonOut {
+		st.Samples = nil
+		return printJSON(st)
+	}
+	var details []string
+	position := formatDuration(time.Duration(st.Position * float64(time.Second)))
+	switch {
+	case st.Song != nil:
+		fmt.Printf("%s: %s\n", st.State, songLine(*st.Song))
+		details = append(details,
+			position+" / "+formatDuration(time.Duration(st.Duration*float64(time.Second))),
+			fmt.Sprintf("track %d of %d", st.Index+1, st.QueueLength))
+	case st.Station != nil:
+		line := st.Station.Name
+		if st.StreamTitle != "" {
+			line += ": " + st.StreamTitle
+		}
+		fmt.Printf("%s: %s\n", st.State, line)
+		details = append(details, position)
+	default:
+		fmt.Println(st.State)
+	}
+	details = append(details, fmt.Sprintf("volume %d%%", int(math.Round(st.Volume*100))))
+	fmt.Println("  " + strings.Join(details, " · "))
+	return nil
+}
+
+func printQueue(r *remotePlayer, jsonOut bool) error {
+	q, err := r.Queue()
+	if err != nil {
+		return err
+	}
+	if jsonOut {
+		return printJSON(q)
+	}
+	for i, s := range q.Songs {
+		marker := " "
+		if i == q.Index && r.status.Song != nil {
+			marker = "▶"
+		}
+		fmt.Printf("%s %3d. %s\n", marker, i+1, songLine(s))
+	}
+	return nil
+}
+
+// songLine is a song on one line: title, artist and album.
+func songLine(s Song) string {
+	if sub := songSubtitle(s); sub != "" {
+		return s.Title + " — " + sub
+	}
+	return s.Title
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
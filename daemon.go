@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// `resona daemon` plays without a terminal: it runs the same model as the
+// TUI, headless, so the queue carries on, plays are recorded and scrobbled
+// and the MPRIS service answers, and takes requests on a Unix socket. The
+// TUI attaches to a running daemon instead of playing itself (remote.go),
+// and quitting it just detaches; the resona subcommands (cli.go) are
+// clients too.
+//
+// The API is JSON-RPC (1.0, as net/rpc/jsonrpc speaks it) on the socket, one
+// request per line:
+//
+//	{"method": "Player.Status", "params": [{}], "id": 1}
+//	{"method": "Player.Seek", "params": [{"seconds": 30, "relative": true}], "id": 2}
+//
+// Every method but Queue replies with a PlayerStatus. Requests run on the
+// model's own goroutine, as controlMsg, so they never race with playback.
+
+// PlayerStatus is what the daemon is playing.
+type PlayerStatus struct {
+	State        string        `json:"state"` // "playing", "paused" or "stopped"
+	Song         *Song         `json:"song,omitempty"`
+	Station      *RadioStation `json:"station,omitempty"`
+	StreamTitle  string        `json:"stream_title,omitempty"`
+	Position     float64       `json:"position"` // seconds
+	Duration     float64       `json:"duration"`
+	Volume       float64       `json:"volume"` // 0..1
+	CanSeek      bool          `json:"can_seek"`
+	LoopA        float64       `json:"loop_a"` // -1 while unmarked
+	LoopB        float64       `json:"loop_b"`
+	Index        int           `json:"index"` // of the song in the queue
+	QueueLength  int           `json:"queue_length"`
+	QueueVersion int           `json:"queue_version"` // changes whenever the queue does
+	Samples      []float64     `json:"samples,omitempty"`
+}
+
+// QueueReply is the play queue.
+type QueueReply struct {
+	Songs   []Song `json:"songs"`
+	Index   int    `json:"index"`
+	Version int    `json:"version"`
+}
+
+// Arguments of the control methods.
+type (
+	NoArgs struct{}
+
+	// PlayQueueArgs replaces the queue with Songs, or with the files at
+	// Paths, and plays it from Index. With neither, it plays Index of the
+	// current queue.
+	PlayQueueArgs struct {
+		Songs []Song   `json:"songs,omitempty"`
+		Paths []string `json:"paths,omitempty"`
+		Index int      `json:"index"`
+	}
+
+	// SetQueueArgs replaces the queue without interrupting playback; Index
+	// is where the playing song is in it.
+	SetQueueArgs struct {
+		Songs []Song `json:"songs"`
+		Index int    `json:"index"`
+	}
+
+	// EnqueueArgs adds Songs, or the files at Paths, to the queue.
+	EnqueueArgs struct {
+		Songs []Song   `json:"songs,omitempty"`
+		Paths []string `json:"paths,omitempty"`
+	}
+
+	SeekArgs struct {
+		Seconds  float64 `json:"seconds"`
+		Relative bool    `json:"relative"` // from the current position
+	}
+
+	VolumeArgs struct {
+		Level float64 `json:"level"` // 0..1
+	}
+
+	LoopArgs struct {
+		A float64 `json:"a"` // seconds; -1 clears the loop
+		B float64 `json:"b"`
+	}
+)
+
+// controlMsg runs a control request on the model. Its sender waits for it
+// to be done, and may give up first, after which it's dropped rather than
+// run: its results are only read once it's done, so they're never written
+// while the sender is looking at them.
+type controlMsg struct {
+	run   func(m *model) tea.Cmd
+	done  chan struct{}
+	state *atomic.Int32 // controlPending until it runs or is given up on
+}
+
+// The states of a controlMsg.
+const (
+	controlPending = iota
+	controlRunning
+	controlAbandoned
+)
+
+func newControlMsg(run func(m *model) tea.Cmd) controlMsg {
+	return controlMsg{run: run, done: make(chan struct{}), state: new(atomic.Int32)}
+}
+
+// wait waits for the request to be done, up to timeout, and reports whether
+// it was. One the model has already started is always waited for.
+func (c controlMsg) wait(timeout <-chan time.Time) bool {
+	select {
+	case <-c.done:
+		return true
+	case <-timeout:
+		if c.state.CompareAndSwap(controlPending, controlAbandoned) {
+			return false
+		}
+		<-c.done
+		return true
+	}
+}
+
+// runControl runs a request on the model, unless its sender has given up.
+func (m *model) runControl(c controlMsg) tea.Cmd {
+	if !c.state.CompareAndSwap(controlPending, controlRunning) {
+		return nil
+	}
+	defer close(c.done)
+	m.reloadChanged()
+	return c.run(m)
+}
+
+// controlSocketPath is where the daemon listens: in $XDG_RUNTIME_DIR if
+// there is one, otherwise in ~/.resona.
+func controlSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "resona.sock")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".resona", "resona.sock")
+}
+
+// runDaemon runs the headless player until it's shut down or interrupted.
+func runDaemon() error {
+	ln, err := listenControl()
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	audioPlayer, err := NewAudioPlayer()
+	if err != nil {
+		return err
+	}
+	m := initialModel(audioPlayer)
+	m.headless = true
+	m.artProtocol = "blocks" // there's no screen to place covers on
+	defer m.audioPlayer.Close()
+	defer m.mpris.Close()
+	defer m.mpd.Close()
+
+	p := tea.NewProgram(m, tea.WithoutRenderer(), tea.WithInput(nil), tea.WithOutput(io.Discard))
+	if err := serveControl(ln, p); err != nil {
+		return err
+	}
+	fmt.Printf("Resona daemon listening on %s\n", ln.Addr())
+	if _, err := p.Run(); err != nil && !errors.Is(err, tea.ErrInterrupted) {
+		return err
+	}
+	return nil
+}
+
+// listenControl opens the control socket. A TUI playing by itself listens
+// on it too, so the command-line controls (cli.go) reach it. Closing the
+// listener removes the socket.
+func listenControl() (net.Listener, error) {
+	path := controlSocketPath()
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("resona is already running on %s", path)
+	}
+	os.Remove(path) // left by one that didn't shut down cleanly
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	os.Chmod(path, 0600)
+	return ln, nil
+}
+
+// serveControl answers requests on ln with p's model until ln is closed.
+func serveControl(ln net.Listener, p *tea.Program) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Player", &ControlService{program: p}); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return nil
+}
+
+// ControlService is the Player API. Its exported methods are the RPC
+// methods.
+type ControlService struct {
+	program *tea.Program
+}
+
+// do runs fn on the model and fills in the status afterwards. A request the
+// model doesn't get to in time isn't run at all.
+func (cs *ControlService) do(fn func(m *model) (tea.Cmd, error), reply *PlayerStatus) error {
+	var (
+		err error
+		st  PlayerStatus
+	)
+	msg := newControlMsg(func(m *model) tea.Cmd {
+		var cmd tea.Cmd
+		cmd, err = fn(m)
+		if reply != nil {
+			st = m.playerStatus()
+		}
+		return cmd
+	})
+	go cs.program.Send(msg)
+	if !msg.wait(time.After(5 * time.Second)) {
+		return errors.New("the player didn't answer")
+	}
+	if reply != nil {
+		*reply = st
+	}
+	return err
+}
+
+// transport runs one of the transport commands MPRIS uses too.
+func (cs *ControlService) transport(command string, value float64, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) { return m.transport(command, value), nil }, reply)
+}
+
+func (cs *ControlService) Status(_ NoArgs, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) { return nil, nil }, reply)
+}
+
+func (cs *ControlService) Queue(_ NoArgs, reply *QueueReply) error {
+	var q QueueReply
+	err := cs.do(func(m *model) (tea.Cmd, error) {
+		// A copy, as the reply is encoded off the model's goroutine.
+		q = QueueReply{Songs: append([]Song(nil), m.currentPlaylist...), Index: m.currentTrackIndex, Version: m.queueVersion}
+		return nil, nil
+	}, nil)
+	*reply = q
+	return err
+}
+
+func (cs *ControlService) PlayQueue(args PlayQueueArgs, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) {
+		switch {
+		case len(args.Paths) > 0:
+			m.setPlaylist(songsForFiles(args.Paths, songsByPath(m.libraryManager.GetSongs())), args.Index)
+		case len(args.Songs) > 0:
+			m.setPlaylist(args.Songs, args.Index)
+		}
+		return m.playQueueIndex(args.Index)
+	}, reply)
+}
+
+// Enqueue adds songs, or the files at Paths, to the end of the queue.
+func (cs *ControlService) Enqueue(args EnqueueArgs, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) {
+		songs := args.Songs
+		if len(args.Paths) > 0 {
+			songs = songsForFiles(args.Paths, songsByPath(m.libraryManager.GetSongs()))
+		}
+		m.insertSongs(len(m.currentPlaylist), songs)
+		return nil, nil
+	}, reply)
+}
+
+func (cs *ControlService) SetQueue(args SetQueueArgs, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) {
+		m.setPlaylist(args.Songs, args.Index)
+		return nil, nil
+	}, reply)
+}
+
+func (cs *ControlService) PlayStation(station RadioStation, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) {
+		if !m.playStation(&station) {
+			return nil, fmt.Errorf("couldn't play %s", station.Name)
+		}
+		return tickCmd(), nil
+	}, reply)
+}
+
+func (cs *ControlService) Play(_ NoArgs, reply *PlayerStatus) error {
+	return cs.transport("play", 0, reply)
+}
+
+func (cs *ControlService) Pause(_ NoArgs, reply *PlayerStatus) error {
+	return cs.transport("pause", 0, reply)
+}
+
+func (cs *ControlService) Toggle(_ NoArgs, reply *PlayerStatus) error {
+	return cs.transport("playpause", 0, reply)
+}
+
+func (cs *ControlService) Stop(_ NoArgs, reply *PlayerStatus) error {
+	return cs.transport("stop", 0, reply)
+}
+
+func (cs *ControlService) Next(_ NoArgs, reply *PlayerStatus) error {
+	return cs.transport("next", 0, reply)
+}
+
+func (cs *ControlService) Previous(_ NoArgs, reply *PlayerStatus) error {
+	return cs.transport("previous", 0, reply)
+}
+
+func (cs *ControlService) Seek(args SeekArgs, reply *PlayerStatus) error {
+	if args.Relative {
+		return cs.transport("seek", args.Seconds, reply)
+	}
+	return cs.transport("position", args.Seconds, reply)
+}
+
+func (cs *ControlService) SetVolume(args VolumeArgs, reply *PlayerStatus) error {
+	return cs.transport("volume", args.Level, reply)
+}
+
+func (cs *ControlService) SetLoop(args LoopArgs, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) {
+		return nil, m.audioPlayer.SetLoop(args.A, args.B)
+	}, reply)
+}
+
+// ReloadSettings rereads settings.json, after a client has changed it.
+func (cs *ControlService) ReloadSettings(_ NoArgs, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) {
+		if err := m.settingsManager.LoadSettings(); err != nil {
+			return nil, err
+		}
+		m.scrobbler.Configure(m.settingsManager.GetSettings())
+		return tea.Batch(m.lastFMSignIn(), m.configureMPD()), nil
+	}, reply)
+}
+
+// ReloadLibrary rereads library.json, after a client has rescanned.
+func (cs *ControlService) ReloadLibrary(_ NoArgs, reply *PlayerStatus) error {
+	return cs.do(func(m *model) (tea.Cmd, error) {
+		if err := m.libraryManager.LoadLibrary(); err != nil {
+			return nil, err
+		}
+		m.libraryBrowser.Refresh()
+		return nil, nil
+	}, reply)
+}
+
+// Shutdown stops playback and the daemon.
+func (cs *ControlService) Shutdown(_ NoArgs, reply *PlayerStatus) error {
+	return cs.transport("quit", 0, reply)
+}
+
+// playQueueIndex plays the index'th song of the queue.
+func (m *model) playQueueIndex(index int) (tea.Cmd, error) {
+	if index < 0 || index >= len(m.currentPlaylist) {
+		return nil, fmt.Errorf("no song %d in the queue", index)
+	}
+	m.currentTrackIndex = index
+	if !m.playCurrentTrack() {
+		return nil, fmt.Errorf("couldn't play %s", m.currentPlaylist[index].FilePath)
+	}
+	return tickCmd(), nil
+}
+
+// playerStatus describes what the model is playing.
+func (m *model) playerStatus() PlayerStatus {
+	st := PlayerStatus{
+		State:        "stopped",
+		StreamTitle:  m.audioPlayer.StreamTitle(),
+		Volume:       m.audioPlayer.Volume(),
+		CanSeek:      m.audioPlayer.CanSeek(),
+		Index:        m.currentTrackIndex,
+		QueueLength:  len(m.currentPlaylist),
+		QueueVersion: m.queueVersion,
+	}
+	st.LoopA, st.LoopB = m.audioPlayer.Loop()
+	// Copies, as the status is encoded off the model's goroutine.
+	if m.playingSong != nil {
+		song := *m.playingSong
+		st.Song = &song
+	}
+	if m.playingStation != nil {
+		station := *m.playingStation
+		st.Station = &station
+	}
+	if st.Song == nil && st.Station == nil {
+		return st
+	}
+	st.State = "playing"
+	if m.audioPlayer.IsPaused() {
+		st.State = "paused"
+	}
+	st.Position = m.audioPlayer.GetPosition()
+	st.Duration = m.audioPlayer.GetDuration()
+	st.Samples = m.audioPlayer.GetAudioSamples()
+	return st
+}
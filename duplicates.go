@@ -0,0 +1,239 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// duplicateDurationSlack is how far apart (in seconds) two copies of the same
+// artist and title may be and still count as the same recording. Different
+// encoders pad the start and end of a track differently, so exact matches are
+// rare; a live or extended version is usually off by far more than this.
+const duplicateDurationSlack = 3.0
+
+// DuplicateGroup is a set of library songs that look like copies of one
+// recording. Keep is the index of the copy that survives a cleanup.
+type DuplicateGroup struct {
+	Songs []Song
+	Sizes []int64 // file sizes, parallel to Songs (0 if the file is missing)
+	Keep  int
+}
+
+// formatRank orders formats from most to least preferred when picking which
+// copy to keep: lossless first, then the lossy formats.
+var formatRank = map[string]int{".flac": 0, ".wav": 1, ".ogg": 2, ".mp3": 3}
+
+// normalizeForMatch folds a tag value for duplicate matching: case and
+// punctuation are ignored and runs of whitespace collapse to one space.
+func normalizeForMatch(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// findDuplicates groups songs that share a normalized artist and title and
+// whose durations are within duplicateDurationSlack of each other. Songs with
+// an unknown duration join the first group for their artist and title. When
+// fps is non-nil, songs whose acoustic fingerprints match are grouped too,
+// which catches copies with missing or different tags. Groups are sorted by
+// artist then title, and each group's Keep defaults to the best copy (see
+// betterCopy).
+func findDuplicates(songs []Song, fps *FingerprintStore) []DuplicateGroup {
+	var candidates []Song
+	for _, s := range songs {
+		if s.FilePath != "" && normalizeForMatch(s.Title) != "" {
+			candidates = append(candidates, s)
+		}
+	}
+
+	// Union-find over candidate indices.
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(a, b int) { parent[find(a)] = find(b) }
+
+	byName := make(map[string][]int)
+	for i, s := range candidates {
+		key := normalizeForMatch(s.Artist) + "\x00" + normalizeForMatch(s.Title)
+		byName[key] = append(byName[key], i)
+	}
+	for _, idx := range byName {
+		sort.SliceStable(idx, func(a, b int) bool {
+			return candidates[idx[a]].DurationSecs < candidates[idx[b]].DurationSecs
+		})
+		// Unknown durations sort first and fold into the first real cluster.
+		for k := 1; k < len(idx); k++ {
+			prev, cur := candidates[idx[k-1]].DurationSecs, candidates[idx[k]].DurationSecs
+			if prev <= 0 || cur-prev <= duplicateDurationSlack {
+				union(idx[k-1], idx[k])
+			}
+		}
+	}
+	if fps != nil {
+		for _, pair := range fingerprintMatches(candidates, fps) {
+			union(pair[0], pair[1])
+		}
+	}
+
+	members := make(map[int][]Song)
+	var roots []int
+	for i, s := range candidates {
+		r := find(i)
+		if _, ok := members[r]; !ok {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], s)
+	}
+	var groups []DuplicateGroup
+	for _, r := range roots {
+		groups = appendDuplicateGroup(groups, members[r])
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].Songs[0], groups[j].Songs[0]
+		if na, nb := normalizeForMatch(a.Artist), normalizeForMatch(b.Artist); na != nb {
+			return na < nb
+		}
+		return normalizeForMatch(a.Title) < normalizeForMatch(b.Title)
+	})
+	return groups
+}
+
+// fingerprintMatches returns pairs of song indices whose fingerprints match.
+// Comparing every pair would be far too slow for a large library, so songs
+// are first paired up through sub-fingerprints they share exactly (re-encodes
+// of one recording share many; unrelated songs very few), and only those
+// candidates are compared in full.
+func fingerprintMatches(songs []Song, fps *FingerprintStore) [][2]int {
+	const (
+		indexed    = 120 // sub-fingerprints indexed per song (~15 s)
+		commonSkip = 50  // ignore values shared by this many songs (silence etc.)
+		minShared  = 3
+	)
+	prints := make([][]uint32, len(songs))
+	index := make(map[uint32][]int)
+	for i, s := range songs {
+		fp := fps.Raw(s.FilePath)
+		prints[i] = fp
+		seen := make(map[uint32]bool)
+		for _, v := range fp[:min(len(fp), indexed)] {
+			if !seen[v] {
+				seen[v] = true
+				index[v] = append(index[v], i)
+			}
+		}
+	}
+
+	shared := make(map[[2]int]int)
+	for _, idx := range index {
+		if len(idx) < 2 || len(idx) >= commonSkip {
+			continue
+		}
+		for a := 0; a < len(idx); a++ {
+			for b := a + 1; b < len(idx); b++ {
+				shared[[2]int{idx[a], idx[b]}]++
+			}
+		}
+	}
+
+	var pairs [][2]int
+	for pair, n := range shared {
+		if n < minShared {
+			continue
+		}
+		a, b := songs[pair[0]], songs[pair[1]]
+		if a.DurationSecs > 0 && b.DurationSecs > 0 && math.Abs(a.DurationSecs-b.DurationSecs) > duplicateDurationSlack {
+			continue
+		}
+		if fingerprintSimilarity(prints[pair[0]], prints[pair[1]]) >= fpMatchMinimum {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// appendDuplicateGroup adds songs as a group if there is more than one of them,
+// picking the default copy to keep.
+func appendDuplicateGroup(groups []DuplicateGroup, songs []Song) []DuplicateGroup {
+	if len(songs) < 2 {
+		return groups
+	}
+	g := DuplicateGroup{Songs: songs, Sizes: make([]int64, len(songs))}
+	for i, s := range songs {
+		if info, err := os.Stat(s.FilePath); err == nil {
+			g.Sizes[i] = info.Size()
+		}
+	}
+	for i := range songs {
+		if g.betterCopy(i, g.Keep) {
+			g.Keep = i
+		}
+	}
+	return append(groups, g)
+}
+
+// betterCopy reports whether copy i should be kept over copy j: a preferred
+// format wins, then the larger file (usually the higher bitrate), then the
+// one with more tags filled in.
+func (g DuplicateGroup) betterCopy(i, j int) bool {
+	ri, rj := formatRankOf(g.Songs[i].FilePath), formatRankOf(g.Songs[j].FilePath)
+	if ri != rj {
+		return ri < rj
+	}
+	if g.Sizes[i] != g.Sizes[j] {
+		return g.Sizes[i] > g.Sizes[j]
+	}
+	return filledTags(g.Songs[i]) > filledTags(g.Songs[j])
+}
+
+// filledTags counts the non-empty tag fields on a song.
+func filledTags(s Song) int {
+	n := 0
+	for _, v := range songTagFields(s) {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func formatRankOf(path string) int {
+	if r, ok := formatRank[strings.ToLower(filepath.Ext(path))]; ok {
+		return r
+	}
+	return len(formatRank)
+}
+
+// Extras returns every copy except the one being kept.
+func (g DuplicateGroup) Extras() []Song {
+	var out []Song
+	for i, s := range g.Songs {
+		if i != g.Keep {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/flac"
+	"github.com/gopxl/beep/v2/mp3"
+	"github.com/gopxl/beep/v2/vorbis"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// Acoustic fingerprints follow Chromaprint's default algorithm (the one
+// fpcalc and AcoustID use): the first two minutes of audio are downmixed to
+// 11025 Hz mono, turned into a 12-bin chroma sequence, and 16 fixed filters
+// over that "image" yield one 32-bit sub-fingerprint every ~124 ms. The
+// encoded form is Chromaprint's compressed, URL-safe base64 string, so it can
+// be sent to AcoustID as-is.
+const (
+	fpSampleRate   = 11025
+	fpFrameSize    = 4096
+	fpFrameStep    = fpFrameSize / 3
+	fpMaxSeconds   = 120
+	fpMinFreq      = 28
+	fpMaxFreq      = 3520
+	fpAlgorithm    = 1 // Chromaprint's CHROMAPRINT_ALGORITHM_TEST2 (the default)
+	fpFilterWidth  = 16
+	fpNormEpsilon  = 0.01
+	fpChromaBands  = 12
+	fpMatchMinimum = 0.75 // fraction of equal bits for two tracks to match
+)
+
+// fpChromaFilter smooths the chroma sequence over five frames.
+var fpChromaFilter = [5]float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+// fpClassifier is one of Chromaprint's trained filter/quantizer pairs. The
+// filter covers width frames starting at the row offset, and height chroma
+// bands starting at band y.
+type fpClassifier struct {
+	kind, y, height, width int
+	t0, t1, t2             float64
+}
+
+var fpClassifiers = [16]fpClassifier{
+	{0, 4, 3, 15, 1.98215, 2.35817, 2.63523},
+	{4, 4, 6, 15, -1.03809, -0.651211, -0.282167},
+	{1, 0, 4, 16, -0.298702, 0.119262, 0.558497},
+	{3, 8, 2, 12, -0.105439, 0.0153946, 0.135898},
+	{3, 4, 4, 8, -0.142891, 0.0258736, 0.200632},
+	{4, 0, 3, 5, -0.826319, -0.590612, -0.368214},
+	{1, 2, 2, 9, -0.557409, -0.233035, 0.0534525},
+	{2, 7, 3, 4, -0.0646826, 0.00620476, 0.0784847},
+	{2, 6, 2, 16, -0.192387, -0.029699, 0.215855},
+	{2, 1, 3, 2, -0.0397818, -0.00568076, 0.0292026},
+	{5, 10, 1, 15, -0.53823, -0.369934, -0.190235},
+	{3, 6, 2, 10, -0.124877, 0.0296483, 0.139239},
+	{2, 1, 1, 14, -0.101475, 0.0225617, 0.231971},
+	{3, 5, 6, 4, -0.0799915, -0.00729616, 0.063262},
+	{1, 9, 2, 12, -0.272556, 0.019424, 0.302559},
+	{3, 4, 2, 14, -0.164292, -0.0321188, 0.0846339},
+}
+
+// decodeAudioFile opens a local audio file with the beep decoder for its
+// extension, as playback does.
+func decodeAudioFile(f *os.File) (beep.StreamSeekCloser, beep.Format, error) {
+	switch ext := strings.ToLower(filepath.Ext(f.Name())); ext {
+	case ".mp3":
+		return mp3.Decode(f)
+	case ".wav":
+		return wav.Decode(f)
+	case ".flac":
+		return flac.Decode(f)
+	case ".ogg":
+		return vorbis.Decode(f)
+	default:
+		return nil, beep.Format{}, fmt.Errorf("unsupported format: %s", ext)
+	}
+}
+
+// decodeMono decodes up to maxSecs seconds of a local audio file (0 for all
+// of it), downmixed to mono and resampled to rate.
+func decodeMono(path string, rate int, maxSecs float64) ([]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	streamer, format, err := decodeAudioFile(f)
+	if err != nil {
+		return nil, err
+	}
+	defer streamer.Close()
+
+	var s beep.Streamer = streamer
+	if int(format.SampleRate) != rate {
+		s = beep.Resample(3, format.SampleRate, beep.SampleRate(rate), streamer)
+	}
+	limit := math.MaxInt
+	if maxSecs > 0 {
+		limit = int(maxSecs * float64(rate))
+	}
+
+	var out []float64
+	buf := make([][2]float64, 4096)
+	for len(out) < limit {
+		n, ok := s.Stream(buf)
+		for _, frame := range buf[:n] {
+			out = append(out, (frame[0]+frame[1])/2)
+		}
+		if !ok {
+			break
+		}
+	}
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// fft computes an in-place radix-2 FFT; len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// chromaprint computes the raw sub-fingerprints for mono samples at
+// fpSampleRate.
+func chromaprint(samples []float64) []uint32 {
+	// Map each FFT bin in the analysed range to its pitch class.
+	minIdx := max(1, int(math.Round(fpFrameSize*fpMinFreq/float64(fpSampleRate))))
+	maxIdx := min(fpFrameSize/2, int(math.Round(fpFrameSize*fpMaxFreq/float64(fpSampleRate))))
+	notes := make([]int, maxIdx)
+	for i := minIdx; i < maxIdx; i++ {
+		freq := float64(i) * fpSampleRate / fpFrameSize
+		octave := math.Log2(freq / (440.0 / 16))
+		notes[i] = int(fpChromaBands * (octave - math.Floor(octave)))
+	}
+
+	window := make([]float64, fpFrameSize)
+	for i := range window {
+		window[i] = 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(fpFrameSize-1))
+	}
+
+	var chroma [][fpChromaBands]float64
+	frame := make([]complex128, fpFrameSize)
+	for start := 0; start+fpFrameSize <= len(samples); start += fpFrameStep {
+		for i := range frame {
+			// Chromaprint works on 16-bit samples; keep its scale so the
+			// silence threshold below means the same thing.
+			frame[i] = complex(samples[start+i]*32767*window[i], 0)
+		}
+		fft(frame)
+		var bands [fpChromaBands]float64
+		for i := minIdx; i < maxIdx; i++ {
+			re, im := real(frame[i]), imag(frame[i])
+			bands[notes[i]] += re*re + im*im
+		}
+		chroma = append(chroma, bands)
+	}
+
+	// Smooth over time, then normalise each frame.
+	var image [][fpChromaBands]float64
+	for t := 0; t+len(fpChromaFilter) <= len(chroma); t++ {
+		var row [fpChromaBands]float64
+		for k, c := range fpChromaFilter {
+			for b := range row {
+				row[b] += c * chroma[t+k][b]
+			}
+		}
+		norm := 0.0
+		for _, v := range row {
+			norm += v * v
+		}
+		norm = math.Sqrt(norm)
+		for b := range row {
+			if norm < fpNormEpsilon {
+				row[b] = 0
+			} else {
+				row[b] /= norm
+			}
+		}
+		image = append(image, row)
+	}
+	if len(image) < fpFilterWidth {
+		return nil
+	}
+
+	// Integral image: integral[r][c] sums image rows 0..r and bands 0..c.
+	integral := make([][fpChromaBands]float64, len(image))
+	for r, row := range image {
+		for c, v := range row {
+			sum := v
+			if r > 0 {
+				sum += integral[r-1][c]
+			}
+			if c > 0 {
+				sum += integral[r][c-1]
+			}
+			if r > 0 && c > 0 {
+				sum -= integral[r-1][c-1]
+			}
+			integral[r][c] = sum
+		}
+	}
+	area := func(r1, c1, r2, c2 int) float64 {
+		if r2 <= r1 || c2 <= c1 {
+			return 0
+		}
+		a := integral[r2-1][c2-1]
+		if r1 > 0 {
+			a -= integral[r1-1][c2-1]
+			if c1 > 0 {
+				a += integral[r1-1][c1-1]
+			}
+		}
+		if c1 > 0 {
+			a -= integral[r2-1][c1-1]
+		}
+		return a
+	}
+
+	grayCode := [4]uint32{0, 1, 3, 2}
+	fp := make([]uint32, 0, len(image)-fpFilterWidth+1)
+	for x := 0; x+fpFilterWidth <= len(image); x++ {
+		var sub uint32
+		for _, cl := range fpClassifiers {
+			v := fpFilter(area, cl, x)
+			var q int
+			switch {
+			case v < cl.t0:
+				q = 0
+			case v < cl.t1:
+				q = 1
+			case v < cl.t2:
+				q = 2
+			default:
+				q = 3
+			}
+			sub = sub<<2 | grayCode[q]
+		}
+		fp = append(fp, sub)
+	}
+	return fp
+}
+
+// fpFilter applies one of Chromaprint's six Haar-like filter shapes at row x,
+// comparing the two halves (or thirds) by the difference of their logs.
+func fpFilter(area func(r1, c1, r2, c2 int) float64, cl fpClassifier, x int) float64 {
+	y, w, h := cl.y, cl.width, cl.height
+	var a, b float64
+	switch cl.kind {
+	case 0:
+		a = area(x, y, x+w, y+h)
+	case 1:
+		h2 := h / 2
+		a = area(x, y+h2, x+w, y+h)
+		b = area(x, y, x+w, y+h2)
+	case 2:
+		w2 := w / 2
+		a = area(x+w2, y, x+w, y+h)
+		b = area(x, y, x+w2, y+h)
+	case 3:
+		w2, h2 := w/2, h/2
+		a = area(x, y, x+w2, y+h2) + area(x+w2, y+h2, x+w, y+h)
+		b = area(x+w2, y, x+w, y+h2) + area(x, y+h2, x+w2, y+h)
+	case 4:
+		h3 := h / 3
+		a = area(x, y+h3, x+w, y+2*h3)
+		b = area(x, y, x+w, y+h3) + area(x, y+2*h3, x+w, y+h)
+	case 5:
+		w3 := w / 3
+		a = area(x+w3, y, x+2*w3, y+h)
+		b = area(x, y, x+w3, y+h) + area(x+2*w3, y, x+w, y+h)
+	}
+	return math.Log1p(a) - math.Log1p(b)
+}
+
+// fingerprintFile fingerprints the first two minutes of a local file.
+func fingerprintFile(path string) ([]uint32, error) {
+	samples, err := decodeMono(path, fpSampleRate, fpMaxSeconds)
+	if err != nil {
+		return nil, err
+	}
+	fp := chromaprint(samples)
+	if len(fp) == 0 {
+		return nil, fmt.Errorf("too short to fingerprint")
+	}
+	return fp, nil
+}
+
+// bitWriter packs values least-significant bit first, as Chromaprint does.
+type bitWriter struct {
+	buf   []byte
+	nbits int
+}
+
+func (w *bitWriter) write(v uint32, n int) {
+	for i := 0; i < n; i++ {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>i&1 != 0 {
+			w.buf[len(w.buf)-1] |= 1 << (w.nbits % 8)
+		}
+		w.nbits++
+	}
+}
+
+// encodeFingerprint compresses a fingerprint the way chromaprint_encode_fingerprint
+// does and returns it as URL-safe base64. Each sub-fingerprint is XORed with
+// the previous one and stored as the gaps between its set bits.
+func encodeFingerprint(fp []uint32) string {
+	var gaps []uint32
+	var prev uint32
+	for _, sub := range fp {
+		x, last := sub^prev, 0
+		for bit := 1; x != 0; bit, x = bit+1, x>>1 {
+			if x&1 != 0 {
+				gaps = append(gaps, uint32(bit-last))
+				last = bit
+			}
+		}
+		gaps = append(gaps, 0)
+		prev = sub
+	}
+
+	out := []byte{fpAlgorithm, byte(len(fp) >> 16), byte(len(fp) >> 8), byte(len(fp))}
+	var normal, exceptional bitWriter
+	for _, g := range gaps {
+		normal.write(uint32(min(int(g), 7)), 3)
+		if g >= 7 {
+			exceptional.write(g-7, 5)
+		}
+	}
+	out = append(out, normal.buf...)
+	out = append(out, exceptional.buf...)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// decodeFingerprint reverses encodeFingerprint.
+func decodeFingerprint(s string) ([]uint32, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) < 4 {
+		return nil, fmt.Errorf("invalid fingerprint")
+	}
+	count := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	data = data[4:]
+	read := func(pos, n int) uint32 {
+		var v uint32
+		for i := 0; i < n; i++ {
+			p := pos + i
+			if p/8 < len(data) && data[p/8]>>(p%8)&1 != 0 {
+				v |= 1 << i
+			}
+		}
+		return v
+	}
+
+	// First pass: the 3-bit gaps, up to count terminating zeros.
+	var gaps []uint32
+	pos, zeros := 0, 0
+	for zeros < count {
+		if (pos+3+7)/8 > len(data) {
+			return nil, fmt.Errorf("truncated fingerprint")
+		}
+		g := read(pos, 3)
+		pos += 3
+		gaps = append(gaps, g)
+		if g == 0 {
+			zeros++
+		}
+	}
+	// Exceptional values start on the next byte boundary.
+	pos = (pos + 7) / 8 * 8
+	for i, g := range gaps {
+		if g == 7 {
+			if (pos+5+7)/8 > len(data) {
+				return nil, fmt.Errorf("truncated fingerprint")
+			}
+			gaps[i] += read(pos, 5)
+			pos += 5
+		}
+	}
+
+	fp := make([]uint32, 0, count)
+	var x, prev uint32
+	bit := 0
+	for _, g := range gaps {
+		if g == 0 {
+			prev ^= x
+			fp = append(fp, prev)
+			x, bit = 0, 0
+			continue
+		}
+		bit += int(g)
+		x |= 1 << (bit - 1)
+	}
+	return fp, nil
+}
+
+// fingerprintSimilarity returns the best fraction of matching bits between
+// two fingerprints over a small range of time offsets (different encoders
+// add different amounts of leading silence).
+func fingerprintSimilarity(a, b []uint32) float64 {
+	const maxShift = 16 // ~2 seconds
+	best := 0.0
+	for shift := -maxShift; shift <= maxShift; shift++ {
+		diff, n := 0, 0
+		for i := max(0, -shift); i < len(a) && i+shift < len(b); i++ {
+			diff += bits.OnesCount32(a[i] ^ b[i+shift])
+			n++
+		}
+		if n < fpFilterWidth {
+			continue
+		}
+		if sim := 1 - float64(diff)/float64(32*n); sim > best {
+			best = sim
+		}
+	}
+	return best
+}
+
+// fingerprintEntry is one cached fingerprint, valid while the file's size and
+// modification time are unchanged.
+type fingerprintEntry struct {
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mtime"`
+	Fingerprint string `json:"fingerprint"` // encodeFingerprint form; "" if the file couldn't be decoded
+}
+
+// FingerprintStore caches acoustic fingerprints by file path in
+// ~/.resona/fingerprints.json, so a rescan only decodes new or changed files.
+// It is safe for concurrent use: scans fill it from a background goroutine.
+type FingerprintStore struct {
+	mu      sync.Mutex
+	file    string
+	entries map[string]fingerprintEntry
+	decoded map[string][]uint32 // decodeFingerprint results, built lazily
+	dirty   bool
+}
+
+func NewFingerprintStore() *FingerprintStore {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	fs := &FingerprintStore{
+		file:    filepath.Join(homeDir, ".resona", "fingerprints.json"),
+		entries: map[string]fingerprintEntry{},
+		decoded: map[string][]uint32{},
+	}
+	if data, err := os.ReadFile(fs.file); err == nil {
+		json.Unmarshal(data, &fs.entries)
+	}
+	return fs
+}
+
+// Save writes the store to disk if anything changed since the last save.
+func (fs *FingerprintStore) Save() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.dirty {
+		return nil
+	}
+	data, err := json.Marshal(fs.entries)
+	if err != nil {
+		return err
+	}
+	fs.dirty = false
+	return os.WriteFile(fs.file, data, 0644)
+}
+
+// Ensure fingerprints path unless an up-to-date fingerprint is cached.
+func (fs *FingerprintStore) Ensure(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	fs.mu.Lock()
+	e, ok := fs.entries[path]
+	fs.mu.Unlock()
+	if ok && e.Size == info.Size() && e.ModTime == info.ModTime().Unix() {
+		return
+	}
+
+	e = fingerprintEntry{Size: info.Size(), ModTime: info.ModTime().Unix()}
+	if fp, err := fingerprintFile(path); err == nil {
+		e.Fingerprint = encodeFingerprint(fp)
+	}
+	fs.mu.Lock()
+	fs.entries[path] = e
+	delete(fs.decoded, path)
+	fs.dirty = true
+	fs.mu.Unlock()
+}
+
+// Get returns the cached fingerprint for path in encoded form, or "".
+func (fs *FingerprintStore) Get(path string) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.entries[path].Fingerprint
+}
+
+// Raw returns the cached fingerprint for path as sub-fingerprints, or nil.
+func (fs *FingerprintStore) Raw(path string) []uint32 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fp, ok := fs.decoded[path]; ok {
+		return fp
+	}
+	e, ok := fs.entries[path]
+	if !ok || e.Fingerprint == "" {
+		return nil
+	}
+	fp, _ := decodeFingerprint(e.Fingerprint)
+	fs.decoded[path] = fp
+	return fp
+}
+
+// Prune drops cached fingerprints for files that no longer exist.
+func (fs *FingerprintStore) Prune() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for path := range fs.entries {
+		if _, err := os.Stat(path); err != nil {
+			delete(fs.entries, path)
+			delete(fs.decoded, path)
+			fs.dirty = true
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprintEncodeRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	fps := [][]uint32{
+		{},
+		{0},
+		{1, 1, 0x80000000, 0xFFFFFFFF, 0}, // repeats, and gaps of 7 bits and more
+	}
+	random := make([]uint32, 500)
+	for i := range random {
+		random[i] = rng.Uint32()
+	}
+	fps = append(fps, random)
+
+	for _, fp := range fps {
+		got, err := decodeFingerprint(encodeFingerprint(fp))
+		if err != nil {
+			t.Errorf("decoding %d sub-fingerprints: %v", len(fp), err)
+			continue
+		}
+		if len(fp) == 0 && len(got) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, fp) {
+			t.Errorf("round trip of %v gave %v", fp, got)
+		}
+	}
+
+	if _, err := decodeFingerprint("not base64!"); err == nil {
+		t.Errorf("invalid base64 wasn't an error")
+	}
+	enc := encodeFingerprint(random)
+	if _, err := decodeFingerprint(enc[:len(enc)/2]); err == nil {
+		t.Errorf("truncated fingerprint wasn't an error")
+	}
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	a := make([]uint32, 200)
+	for i := range a {
+		a[i] = rng.Uint32()
+	}
+	if sim := fingerprintSimilarity(a, a); sim != 1 {
+		t.Errorf("identical fingerprints: similarity %v", sim)
+	}
+	// The same audio with some leading silence matches at an offset.
+	shifted := append(make([]uint32, 5), a...)
+	if sim := fingerprintSimilarity(a, shifted); sim != 1 {
+		t.Errorf("shifted fingerprint: similarity %v", sim)
+	}
+	b := make([]uint32, 200)
+	for i := range b {
+		b[i] = rng.Uint32()
+	}
+	if sim := fingerprintSimilarity(a, b); sim > 0.6 {
+		t.Errorf("unrelated fingerprints: similarity %v", sim)
+	}
+	if sim := fingerprintSimilarity(a[:fpFilterWidth-1], a); sim != 0 {
+		t.Errorf("too short to compare: similarity %v", sim)
+	}
+}
+
+func TestChromaprintStable(t *testing.T) {
+	// Ten seconds of a chord that changes every second.
+	samples := make([]float64, 10*fpSampleRate)
+	notes := []float64{261.63, 329.63, 392.00, 440.00, 349.23}
+	for i := range samples {
+		f := notes[i/fpSampleRate%len(notes)]
+		x := float64(i) / fpSampleRate
+		samples[i] = 0.3*math.Sin(2*math.Pi*f*x) + 0.2*math.Sin(2*math.Pi*f*1.5*x)
+	}
+	fp := chromaprint(samples)
+	if len(fp) == 0 {
+		t.Fatal("no fingerprint for ten seconds of audio")
+	}
+	// Quieter audio fingerprints the same: the features are relative.
+	quiet := make([]float64, len(samples))
+	for i, v := range samples {
+		quiet[i] = v / 2
+	}
+	if sim := fingerprintSimilarity(fp, chromaprint(quiet)); sim < 0.95 {
+		t.Errorf("same audio at half volume: similarity %v", sim)
+	}
+	if fp2 := chromaprint(samples[:fpSampleRate/10]); len(fp2) != 0 {
+		t.Errorf("a tenth of a second gave %d sub-fingerprints", len(fp2))
+	}
+}
@@ -59,6 +59,7 @@ func (fb *FolderBrowser) refreshEntries() error {
 		".m3u":  true,  // Playlist files
 		".m3u8": true,  // Playlist files
 		".pls":  true,  // Playlist files
+		".xspf": true,  // Playlist files
 	}
 	
 	for _, entry := range entries {
@@ -220,4 +221,19 @@ func (fb *FolderBrowser) SetSelectedIndex(i int) {
 	}
 	fb.selected = i
 	fb.adjustViewport()
-}
\ No newline at end of file
+}
+
+// AudioFiles returns the playable files in the current directory, as full
+// paths in listing order.
+func (fb *FolderBrowser) AudioFiles() []string {
+	var files []string
+	for _, entry := range fb.entries {
+		if entry != ".." && isSupportedAudio(entry) {
+			path := filepath.Join(fb.currentPath, entry)
+			if !fb.IsDirectory(path) {
+				files = append(files, path)
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileDiagnostic records what the scanner found out about one file, so
+// problems that extractMetadata papers over with defaults (unreadable tags,
+// undecodable audio, a 0:00 duration) can be reported instead of surfacing
+// only when playback fails.
+type FileDiagnostic struct {
+	Format         string   `json:"format"`
+	Size           int64    `json:"size"`
+	TagError       string   `json:"tag_error,omitempty"`    // tags couldn't be read
+	DecodeError    string   `json:"decode_error,omitempty"` // audio couldn't be opened or decoded
+	DurationMethod string   `json:"duration_method"`        // how the duration was found; "none" if it wasn't
+	Duration       float64  `json:"duration"`
+	Missing        []string `json:"missing,omitempty"` // tag fields that were empty
+}
+
+// healthFilters are the report's filters, in the order they cycle.
+var healthFilters = []string{"all", "errors", "duration", "tags"}
+
+var healthFilterLabels = map[string]string{
+	"all":      "All problems",
+	"errors":   "Unplayable",
+	"duration": "No duration",
+	"tags":     "Missing tags",
+}
+
+// HealthRow is one problem file in the health report.
+type HealthRow struct {
+	Path string
+	Diag FileDiagnostic
+}
+
+// Unplayable reports whether the file couldn't be opened or decoded.
+func (d FileDiagnostic) Unplayable() bool { return d.DecodeError != "" }
+
+// NoDuration reports whether no duration could be determined.
+func (d FileDiagnostic) NoDuration() bool { return d.DurationMethod == "none" || d.Duration <= 0 }
+
+// Matches reports whether the diagnostic shows a problem of the given kind.
+func (d FileDiagnostic) Matches(filter string) bool {
+	switch filter {
+	case "errors":
+		return d.Unplayable()
+	case "duration":
+		return d.NoDuration()
+	case "tags":
+		return d.TagError != "" || len(d.Missing) > 0
+	default:
+		// Missing genre, track or year alone is too common to call a problem.
+		return d.Unplayable() || d.NoDuration() || d.TagError != "" || d.missingCore()
+	}
+}
+
+func (d FileDiagnostic) missingCore() bool {
+	for _, f := range d.Missing {
+		if f == "title" || f == "artist" || f == "album" {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary is a one-line description of the file's worst problems.
+func (d FileDiagnostic) Summary() string {
+	var parts []string
+	if d.DecodeError != "" {
+		parts = append(parts, "won't decode: "+d.DecodeError)
+	}
+	if d.NoDuration() {
+		parts = append(parts, "no duration")
+	}
+	if d.TagError != "" {
+		parts = append(parts, "tags unreadable: "+d.TagError)
+	} else if len(d.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(d.Missing, ", "))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// missingTags lists which of the basic tags are empty.
+func missingTags(title, artist, album, genre string, track, year int) []string {
+	var missing []string
+	for _, f := range []struct {
+		name string
+		ok   bool
+	}{
+		{"title", strings.TrimSpace(title) != ""},
+		{"artist", strings.TrimSpace(artist) != ""},
+		{"album", strings.TrimSpace(album) != ""},
+		{"genre", strings.TrimSpace(genre) != ""},
+		{"track", track > 0},
+		{"year", year > 0},
+	} {
+		if !f.ok {
+			missing = append(missing, f.name)
+		}
+	}
+	return missing
+}
+
+// probeDecode opens the file with the same decoder playback uses and decodes
+// a little audio, which catches corrupt files and unsupported codecs (an Opus
+// stream in an .ogg, say) at scan time.
+func probeDecode(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	streamer, _, err := decodeAudioFile(f)
+	if err != nil {
+		return err
+	}
+	defer streamer.Close()
+
+	buf := make([][2]float64, 4096)
+	if n, ok := streamer.Stream(buf); !ok && n == 0 {
+		if err := streamer.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("no audio")
+	}
+	return streamer.Err()
+}
+
+// healthReport lists the library files whose diagnostics match filter,
+// sorted by path.
+func healthReport(songs []Song, diags map[string]FileDiagnostic, filter string) []HealthRow {
+	var rows []HealthRow
+	for _, s := range songs {
+		if d, ok := diags[s.FilePath]; ok && d.Matches(filter) {
+			rows = append(rows, HealthRow{Path: s.FilePath, Diag: d})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Path < rows[j].Path })
+	return rows
+}
+
+// exportHealthReport writes rows to ~/.resona as library-health.csv and
+// library-health.json, returning the directory written to.
+func exportHealthReport(rows []HealthRow) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	dir := filepath.Join(homeDir, ".resona")
+
+	f, err := os.Create(filepath.Join(dir, "library-health.csv"))
+	if err != nil {
+		return "", err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"path", "format", "size", "decode_error", "tag_error", "duration", "duration_method", "missing"})
+	for _, r := range rows {
+		w.Write([]string{
+			r.Path, r.Diag.Format, strconv.FormatInt(r.Diag.Size, 10), r.Diag.DecodeError, r.Diag.TagError,
+			strconv.FormatFloat(r.Diag.Duration, 'f', 2, 64), r.Diag.DurationMethod, strings.Join(r.Diag.Missing, ";"),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	type jsonRow struct {
+		Path string `json:"path"`
+		FileDiagnostic
+	}
+	out := make([]jsonRow, len(rows))
+	for i, r := range rows {
+		out[i] = jsonRow{Path: r.Path, FileDiagnostic: r.Diag}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return dir, os.WriteFile(filepath.Join(dir, "library-health.json"), data, 0644)
+}
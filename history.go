@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// PlayEvent is one entry in the listening history: a track that was played,
+// how long it was listened to and how playback ended. A track that was
+// neither completed nor skipped was stopped (or the player quit) part way.
+type PlayEvent struct {
+	Time      time.Time `json:"time"` // when playback started
+	Path      string    `json:"path"`
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist"`
+	Album     string    `json:"album"`
+	Genre     string    `json:"genre"`
+	Duration  float64   `json:"duration"`  // track length in seconds
+	Listened  float64   `json:"listened"`  // seconds actually played, pauses excluded
+	Completed bool      `json:"completed"` // played through to the end
+	Skipped   bool      `json:"skipped"`   // another track was started before the end
+}
+
+// Counted reports whether the event counts as a play: the track was finished,
+// or listened to for 30 seconds, or for half its length if that's shorter.
+// Anything less was a skip through. This is more lenient than the scrobbling
+// rule (half the track or four minutes), so a play can count here without
+// being scrobbled.
+func (e PlayEvent) Counted() bool {
+	if e.Completed {
+		return true
+	}
+	need := 30.0
+	if e.Duration > 0 && e.Duration/2 < need {
+		need = e.Duration / 2
+	}
+	return e.Listened >= need
+}
+
+// PlayStats is the per-song summary shown on library rows.
+type PlayStats struct {
+	Count      int
+	LastPlayed time.Time
+}
+
+// HistoryManager owns the listening history, an append-only log of play
+// events in ~/.resona/history.jsonl (one JSON event per line, so recording a
+// play never rewrites the file).
+type HistoryManager struct {
+	events      []PlayEvent
+	stats       map[string]PlayStats
+	historyFile string
+	stamp       fileStamp // of history.jsonl as last read or written
+}
+
+func NewHistoryManager() (*HistoryManager, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+
+	configDir := filepath.Join(homeDir, ".resona")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %v", err)
+	}
+
+	hm := &HistoryManager{
+		stats:       make(map[string]PlayStats),
+		historyFile: filepath.Join(configDir, "history.jsonl"),
+	}
+
+	// A missing or unreadable file just means "no history yet".
+	_ = hm.Load()
+	return hm, nil
+}
+
+// Load reads the history from disk. Lines that don't parse (a write cut short
+// by a crash, say) are skipped rather than losing the whole log.
+func (hm *HistoryManager) Load() error {
+	f, err := os.Open(hm.historyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	hm.stamp = stampOf(hm.historyFile)
+
+	hm.events = nil
+	hm.stats = make(map[string]PlayStats)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ev PlayEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		hm.add(ev)
+	}
+	return scanner.Err()
+}
+
+// Record appends an event to the history.
+func (hm *HistoryManager) Record(ev PlayEvent) error {
+	hm.add(ev)
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(hm.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	hm.stamp = stampOf(hm.historyFile)
+	return nil
+}
+
+// ReloadIfChanged rereads the history if another process has added to it
+// since this one last read or wrote it, reporting whether it did.
+func (hm *HistoryManager) ReloadIfChanged() bool {
+	return hm.stamp.changed(hm.historyFile) && hm.Load() == nil
+}
+
+func (hm *HistoryManager) add(ev PlayEvent) {
+	hm.events = append(hm.events, ev)
+	if !ev.Counted() {
+		return
+	}
+	st := hm.stats[ev.Path]
+	st.Count++
+	if ev.Time.After(st.LastPlayed) {
+		st.LastPlayed = ev.Time
+	}
+	hm.stats[ev.Path] = st
+}
+
+// GetStats returns the play count and last-played time of a file.
+func (hm *HistoryManager) GetStats(path string) PlayStats {
+	return hm.stats[path]
+}
+
+// EventCount is the number of events in the history; it changes whenever a
+// play is recorded.
+func (hm *HistoryManager) EventCount() int {
+	return len(hm.events)
+}
+
+// PlayLabel summarises a file's plays for a library row, e.g. "▶ 12 · 3d ago",
+// or "" if it has never been played.
+func (hm *HistoryManager) PlayLabel(path string) string {
+	st := hm.stats[path]
+	if st.Count == 0 {
+		return ""
+	}
+	return "▶ " + strconv.Itoa(st.Count) + " · " + formatAgo(time.Since(st.LastPlayed))
+}
+
+// formatAgo renders an elapsed time coarsely: "just now", "5m ago", "3h ago",
+// "2d ago", "6w ago", "1y ago".
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return strconv.Itoa(int(d.Minutes())) + "m ago"
+	case d < 24*time.Hour:
+		return strconv.Itoa(int(d.Hours())) + "h ago"
+	case d < 14*24*time.Hour:
+		return strconv.Itoa(int(d.Hours()/24)) + "d ago"
+	case d < 365*24*time.Hour:
+		return strconv.Itoa(int(d.Hours()/(24*7))) + "w ago"
+	default:
+		return strconv.Itoa(int(d.Hours()/(24*365))) + "y ago"
+	}
+}
+
+// formatListeningTime renders a total listening time as "3h 12m" or "45m".
+func formatListeningTime(secs float64) string {
+	d := time.Duration(secs) * time.Second
+	if h := int(d.Hours()); h > 0 {
+		return fmt.Sprintf("%dh %02dm", h, int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+// statsWindows are the stats view's time windows, in the order they cycle.
+var statsWindows = []string{"week", "month", "year", "all"}
+
+var statsWindowLabels = map[string]string{
+	"week":  "Last 7 days",
+	"month": "Last 30 days",
+	"year":  "Last year",
+	"all":   "All time",
+}
+
+// statsWindowStart is the earliest event time included in a window.
+func statsWindowStart(window string, now time.Time) time.Time {
+	switch window {
+	case "week":
+		return now.AddDate(0, 0, -7)
+	case "month":
+		return now.AddDate(0, 0, -30)
+	case "year":
+		return now.AddDate(-1, 0, 0)
+	default:
+		return time.Time{}
+	}
+}
+
+// StatEntry is one row of a top-N list.
+type StatEntry struct {
+	Name    string
+	Plays   int
+	Seconds float64
+}
+
+// ListeningStats summarises the history over a time window.
+type ListeningStats struct {
+	Plays   int     // events that counted as plays
+	Skips   int     // events that were skipped
+	Seconds float64 // total listening time
+	Artists []StatEntry
+	Albums  []StatEntry
+	Genres  []StatEntry
+	ByHour  [24]float64 // listening seconds by local hour of day
+}
+
+// Stats summarises the events since the given time. Top lists are ranked by
+// plays, then by listening time.
+func (hm *HistoryManager) Stats(since time.Time) ListeningStats {
+	var ls ListeningStats
+	artists := make(map[string]*StatEntry)
+	albums := make(map[string]*StatEntry)
+	genres := make(map[string]*StatEntry)
+	tally := func(m map[string]*StatEntry, name string, ev PlayEvent) {
+		e := m[name]
+		if e == nil {
+			e = &StatEntry{Name: name}
+			m[name] = e
+		}
+		if ev.Counted() {
+			e.Plays++
+		}
+		e.Seconds += ev.Listened
+	}
+
+	for _, ev := range hm.events {
+		if ev.Time.Before(since) {
+			continue
+		}
+		if ev.Counted() {
+			ls.Plays++
+		}
+		if ev.Skipped {
+			ls.Skips++
+		}
+		ls.Seconds += ev.Listened
+		ls.ByHour[ev.Time.Local().Hour()] += ev.Listened
+
+		artist := fieldOrUnknown(ev.Artist, "Unknown Artist")
+		tally(artists, artist, ev)
+		tally(albums, fieldOrUnknown(ev.Album, "Unknown Album")+" — "+artist, ev)
+		tally(genres, fieldOrUnknown(ev.Genre, "Unknown Genre"), ev)
+	}
+
+	ls.Artists = rankStats(artists)
+	ls.Albums = rankStats(albums)
+	ls.Genres = rankStats(genres)
+	return ls
+}
+
+func rankStats(m map[string]*StatEntry) []StatEntry {
+	out := make([]StatEntry, 0, len(m))
+	for _, e := range m {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Plays != out[j].Plays {
+			return out[i].Plays > out[j].Plays
+		}
+		if out[i].Seconds != out[j].Seconds {
+			return out[i].Seconds > out[j].Seconds
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// listenSession tracks the song currently being listened to, so a play event
+// can be recorded when playback moves on. Listening time is accumulated tick
+// by tick while audio is actually playing, so pauses and seeks don't count.
+type listenSession struct {
+	song      Song
+	started   time.Time
+	listened  time.Duration
+	lastTick  time.Time
+	scrobbled bool // sent to the scrobbler; see updateScrobbles
+}
+
+// advance adds the time since the last tick if audio was playing throughout.
+func (ls *listenSession) advance(playing bool, now time.Time) {
+	if playing {
+		ls.listened += now.Sub(ls.lastTick)
+	}
+	ls.lastTick = now
+}
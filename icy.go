@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// icyReader strips the ICY (SHOUTcast/Icecast) metadata a radio stream
+// interleaves with its audio once asked to with "Icy-MetaData: 1": after
+// every metaint bytes of audio comes a length byte (in 16-byte units) and
+// that much metadata, such as StreamTitle='Artist - Title';. Each title is
+// passed to onTitle.
+type icyReader struct {
+	r       io.Reader
+	metaint int
+	left    int // audio bytes before the next metadata block
+	onTitle func(string)
+}
+
+func newICYReader(r io.Reader, metaint int, onTitle func(string)) *icyReader {
+	return &icyReader{r: r, metaint: metaint, left: metaint, onTitle: onTitle}
+}
+
+func (ir *icyReader) Read(p []byte) (int, error) {
+	if ir.left == 0 {
+		if err := ir.readMeta(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > ir.left {
+		p = p[:ir.left]
+	}
+	n, err := ir.r.Read(p)
+	ir.left -= n
+	return n, err
+}
+
+// readMeta reads one metadata block.
+func (ir *icyReader) readMeta() error {
+	var size [1]byte
+	if _, err := io.ReadFull(ir.r, size[:]); err != nil {
+		return err
+	}
+	ir.left = ir.metaint
+	if size[0] == 0 {
+		return nil // no change since the last block
+	}
+	meta := make([]byte, int(size[0])*16)
+	if _, err := io.ReadFull(ir.r, meta); err != nil {
+		return err
+	}
+	if title, ok := parseStreamTitle(string(meta)); ok {
+		ir.onTitle(title)
+	}
+	return nil
+}
+
+// parseStreamTitle finds the StreamTitle in an ICY metadata block.
+func parseStreamTitle(meta string) (string, bool) {
+	const key = "StreamTitle='"
+	i := strings.Index(meta, key)
+	if i < 0 {
+		return "", false
+	}
+	rest := strings.TrimRight(meta[i+len(key):], "\x00")
+	if end := strings.Index(rest, "';"); end >= 0 {
+		rest = rest[:end]
+	} else {
+		rest = strings.TrimSuffix(rest, "'")
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// splitStreamTitle reads the usual "Artist - Title" form of a stream title.
+// ok is false when it isn't in that form, since a scrobble needs both.
+func splitStreamTitle(title string) (artist, track string, ok bool) {
+	artist, track, ok = strings.Cut(title, " - ")
+	artist, track = strings.TrimSpace(artist), strings.TrimSpace(track)
+	return artist, track, ok && artist != "" && track != ""
+}
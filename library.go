@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -22,12 +26,23 @@ import (
 type Song struct {
 	Title         string
 	Artist        string
+	AlbumArtist   string  // Artist the album is filed under (e.g. "Various Artists")
 	Album         string
 	Genre         string
+	Composer      string
+	Comment       string
+	Year          int
 	TrackNumber   int     // Track number within the album
+	TrackTotal    int     // Number of tracks on the disc, if tagged
+	DiscNumber    int     // Disc number within a multi-disc release
+	DiscTotal     int     // Number of discs in the release, if tagged
 	FilePath      string
 	Duration      string  // Human readable duration (e.g., "3:45")
 	DurationSecs  float64 // Duration in seconds for calculations
+	ContentHash   string  // Identifies the audio wherever the file is; see contentHash
+	BPM           float64 // Tempo from the file's tags or analysis, 0 if unknown
+	Key           string  // Musical key ("Am", "F#") from the file's tags or analysis
+	Analyzed      bool    // Tempo and key analysis has run, so unknown values aren't retried
 }
 
 // supportedAudioExts are the file extensions the audio player can decode.
@@ -77,18 +92,27 @@ func countAudioFiles(folders []string) int {
 	return total
 }
 
+// scanOptions are the optional, slower extras a scan does for each file.
+type scanOptions struct {
+	fingerprints *FingerprintStore // fill with fingerprints (cached ones are reused); nil to skip
+	analyze      bool              // estimate tempo and key where the tags don't give them
+	known        map[string]Song   // previous scan's songs by content hash, whose analysis carries over
+}
+
 // scanFoldersProgress scans the given folders for supported audio files and
-// extracts metadata for each. onProgress, if non-nil, is invoked after each
-// file with the running count and the precomputed total. It performs no shared
-// mutation, so it is safe to run from a goroutine (onProgress runs on that same
-// goroutine).
-func scanFoldersProgress(folders []string, onProgress func(done, total int)) []Song {
+// extracts metadata and a diagnostic (keyed by path) for each, plus whatever
+// extras opts asks for. onProgress, if non-nil, is invoked after each file
+// with the running count and the precomputed total. Apart from the fingerprint
+// store, which locks, it performs no shared mutation, so it is safe to run
+// from a goroutine (onProgress runs on that same goroutine).
+func scanFoldersProgress(folders []string, opts scanOptions, onProgress func(done, total int)) ([]Song, map[string]FileDiagnostic) {
 	total := countAudioFiles(folders)
 	if onProgress != nil {
 		onProgress(0, total)
 	}
 
 	var songs []Song
+	diags := make(map[string]FileDiagnostic)
 	done := 0
 	for _, folder := range folders {
 		filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
@@ -96,7 +120,29 @@ func scanFoldersProgress(folders []string, onProgress func(done, total int)) []S
 				return nil
 			}
 			if !info.IsDir() && isSupportedAudio(path) {
-				songs = append(songs, extractMetadata(path))
+				song, diag := inspectFile(path)
+				if diag.DecodeError == "" {
+					if err := probeDecode(path); err != nil {
+						diag.DecodeError = err.Error()
+					}
+				}
+				if prev, ok := opts.known[song.ContentHash]; ok && song.ContentHash != "" {
+					carryAnalysis(&song, prev)
+				}
+				if opts.analyze && !song.Analyzed && (song.BPM == 0 || song.Key == "") {
+					// A file that can't be analyzed is marked as tried all
+					// the same; the mark carries over by content hash, so it
+					// isn't tried again until its audio changes.
+					if err := analyzeSong(&song); err != nil {
+						log.Printf("analyze %s: %v", path, err)
+						song.Analyzed = true
+					}
+				}
+				songs = append(songs, song)
+				diags[path] = diag
+				if opts.fingerprints != nil {
+					opts.fingerprints.Ensure(path)
+				}
 				done++
 				if onProgress != nil {
 					onProgress(done, total)
@@ -105,7 +151,20 @@ func scanFoldersProgress(folders []string, onProgress func(done, total int)) []S
 			return nil
 		})
 	}
-	return songs
+	return songs, diags
+}
+
+// audioFilesUnder lists the supported audio files in dir and its subfolders,
+// as the scanner finds them.
+func audioFilesUnder(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && isSupportedAudio(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
 }
 
 func getFilenameWithoutExt(filePath string) string {
@@ -114,6 +173,17 @@ func getFilenameWithoutExt(filePath string) string {
 }
 
 func extractMetadata(filePath string) Song {
+	song, _ := inspectFile(filePath)
+	return song
+}
+
+// inspectFile reads a file's tags and duration like extractMetadata, and also
+// returns a diagnostic recording what went wrong along the way: unreadable
+// tags, how (or whether) the duration was found, and which tags are missing.
+// It doesn't decode the audio; the library scan does that with probeDecode.
+func inspectFile(filePath string) (Song, FileDiagnostic) {
+	diag := FileDiagnostic{Format: strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), ".")}
+
 	// Default song with filename as title
 	song := Song{
 		Title:        getFilenameWithoutExt(filePath),
@@ -129,9 +199,15 @@ func extractMetadata(filePath string) Song {
 	// Try to read metadata from file
 	file, err := os.Open(filePath)
 	if err != nil {
-		return song
+		diag.DecodeError = err.Error()
+		diag.DurationMethod = "none"
+		return song, diag
 	}
 	defer file.Close()
+	if info, err := file.Stat(); err == nil {
+		diag.Size = info.Size()
+		song.ContentHash = contentHash(file, diag.Size)
+	}
 
 	metadata, err := tag.ReadFrom(file)
 	if err == nil {
@@ -150,10 +226,32 @@ func extractMetadata(filePath string) Song {
 		}
 		if track, total := metadata.Track(); track > 0 {
 			song.TrackNumber = track
-			_ = total // We have the total tracks if needed later
+			song.TrackTotal = total
 		}
+		if disc, total := metadata.Disc(); disc > 0 {
+			song.DiscNumber = disc
+			song.DiscTotal = total
+		}
+		song.AlbumArtist = strings.TrimSpace(metadata.AlbumArtist())
+		song.Composer = strings.TrimSpace(metadata.Composer())
+		song.Comment = strings.TrimSpace(metadata.Comment())
+		song.Year = metadata.Year()
+		song.BPM, song.Key = tempoKeyTags(metadata.Raw())
+		diag.Missing = missingTags(metadata.Title(), metadata.Artist(), metadata.Album(), metadata.Genre(), song.TrackNumber, song.Year)
+	} else if strings.EqualFold(filepath.Ext(filePath), ".wav") {
+		// dhowden/tag doesn't read RIFF; fall back to the LIST/INFO chunk
+		info := readRIFFInfo(filePath)
+		applyRIFFInfo(&song, info)
+		track, _ := parsePosition(info["ITRK"])
+		diag.Missing = missingTags(info["INAM"], info["IART"], info["IPRD"], info["IGNR"], track, song.Year)
+	} else {
+		// A file with no tags at all just has every tag missing.
+		if err != tag.ErrNoTagsFound {
+			diag.TagError = err.Error()
+		}
+		diag.Missing = missingTags("", "", "", "", 0, 0)
 	}
-	
+
 	// If no track number found in metadata, try to extract from filename
 	if song.TrackNumber == 0 {
 		if trackNum := extractTrackFromFilename(filepath.Base(filePath)); trackNum > 0 {
@@ -162,16 +260,228 @@ func extractMetadata(filePath string) Song {
 	}
 
 	// Calculate duration based on file type
-	duration := calculateDuration(filePath)
+	duration, method := calculateDuration(filePath)
 	if duration > 0 {
 		song.DurationSecs = duration
 		song.Duration = formatDuration(time.Duration(duration * float64(time.Second)))
+	} else {
+		method = "none"
 	}
+	diag.Duration, diag.DurationMethod = duration, method
 
-	return song
+	return song, diag
+}
+
+// contentHashSpan is how much of the end of a file contentHash reads.
+const contentHashSpan = 64 * 1024
+
+// contentHash identifies a file's audio independently of where it is, so a
+// playlist can find a file again after it's moved or renamed. It hashes the
+// last 64 KiB of the audio itself, leaving out what retagging rewrites: the
+// ID3v1 trailer of an MP3, the chunks around a WAV's data (its LIST/INFO
+// tags are written at the end), the metadata boxes around an M4A's mdat, and
+// the page headers of an Ogg stream, whose sequence numbers and checksums
+// change when the comment header is re-paginated. ID3v2 tags and FLAC
+// metadata are at the front, out of reach. It returns "" if the file can't
+// be read.
+func contentHash(f *os.File, size int64) string {
+	defer f.Seek(0, io.SeekStart)
+	start, end := audioPayload(f, size)
+	if end-start > contentHashSpan {
+		start = end - contentHashSpan
+	}
+	buf := make([]byte, end-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return ""
+	}
+	if oggMagic(f) {
+		buf = oggPageBodies(buf)
+	}
+	sum := sha1.Sum(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// oggMagic reports whether f is an Ogg stream.
+func oggMagic(f *os.File) bool {
+	magic := make([]byte, 4)
+	_, err := f.ReadAt(magic, 0)
+	return err == nil && string(magic) == "OggS"
+}
+
+// audioPayload returns the span of a file holding its audio: a WAV's data
+// chunk, an M4A's mdat box, or otherwise the whole file less any ID3v1
+// trailer.
+func audioPayload(f *os.File, size int64) (start, end int64) {
+	magic := make([]byte, 12)
+	if _, err := f.ReadAt(magic, 0); err == nil {
+		switch {
+		case string(magic[0:4]) == "RIFF" && string(magic[8:12]) == "WAVE":
+			if chunks, err := readRIFFChunks(f); err == nil {
+				for _, c := range chunks {
+					if c.id == "data" {
+						end := c.offset + int64(c.size)
+						if end > size {
+							end = size
+						}
+						return c.offset, end
+					}
+				}
+			}
+		case string(magic[4:8]) == "ftyp":
+			if start, end, ok := mp4Box(f, size, "mdat"); ok {
+				return start, end
+			}
+		}
+	}
+	end = size
+	if size >= 128 {
+		trailer := make([]byte, 3)
+		if _, err := f.ReadAt(trailer, size-128); err == nil && string(trailer) == "TAG" {
+			end -= 128
+		}
+	}
+	return 0, end
+}
+
+// mp4Box finds the body of a top-level MP4 box.
+func mp4Box(f *os.File, size int64, boxType string) (start, end int64, ok bool) {
+	hdr := make([]byte, 16)
+	for pos := int64(0); pos+8 <= size; {
+		if _, err := f.ReadAt(hdr[:8], pos); err != nil {
+			return 0, 0, false
+		}
+		boxSize, body := int64(binary.BigEndian.Uint32(hdr[0:4])), pos+8
+		switch boxSize {
+		case 0: // runs to the end of the file
+			boxSize = size - pos
+		case 1: // 64-bit size follows the type
+			if _, err := f.ReadAt(hdr[8:16], pos+8); err != nil {
+				return 0, 0, false
+			}
+			boxSize, body = int64(binary.BigEndian.Uint64(hdr[8:16])), pos+16
+		}
+		if boxSize < body-pos {
+			return 0, 0, false
+		}
+		if string(hdr[4:8]) == boxType {
+			end := pos + boxSize
+			if end > size {
+				end = size
+			}
+			return body, end, true
+		}
+		pos += boxSize
+	}
+	return 0, 0, false
+}
+
+// oggPageBodies keeps the packet data of the whole Ogg pages in b, dropping
+// their headers and any partial page at either end.
+func oggPageBodies(b []byte) []byte {
+	var out []byte
+	for i := 0; i+27 <= len(b); {
+		j := bytes.Index(b[i:], []byte("OggS"))
+		if j < 0 {
+			break
+		}
+		i += j
+		if i+27 > len(b) {
+			break
+		}
+		nsegs := int(b[i+26])
+		if b[i+4] != 0 || i+27+nsegs > len(b) {
+			i++
+			continue
+		}
+		n := 0
+		for _, s := range b[i+27 : i+27+nsegs] {
+			n += int(s)
+		}
+		body := i + 27 + nsegs
+		if body+n > len(b) {
+			break
+		}
+		out = append(out, b[body:body+n]...)
+		i = body + n
+	}
+	return out
 }
 
-func calculateDuration(filePath string) float64 {
+// albumArtistOf returns the artist an album is filed under: the album-artist tag
+// when present, otherwise the track artist. Grouping by this keeps compilations
+// together instead of splitting them across every guest artist.
+func albumArtistOf(s Song) string {
+	if s.AlbumArtist != "" {
+		return s.AlbumArtist
+	}
+	if s.Artist == "" {
+		return "Unknown Artist"
+	}
+	return s.Artist
+}
+
+// trackLess orders songs within an album: by disc, then track number (tracks
+// with a number before those without), then title. An untagged disc counts as
+// disc 1 so single-disc albums are unaffected.
+func trackLess(a, b Song) bool {
+	discA, discB := max(a.DiscNumber, 1), max(b.DiscNumber, 1)
+	if discA != discB {
+		return discA < discB
+	}
+	if (a.TrackNumber > 0) != (b.TrackNumber > 0) {
+		return a.TrackNumber > 0
+	}
+	if a.TrackNumber != b.TrackNumber {
+		return a.TrackNumber < b.TrackNumber
+	}
+	return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+}
+
+// trackLabel prefixes a title with its position on the album: "3. Title", or
+// "2-03. Title" for multi-disc releases.
+func trackLabel(s Song) string {
+	if s.TrackNumber <= 0 {
+		return s.Title
+	}
+	if s.DiscTotal > 1 || s.DiscNumber > 1 {
+		return fmt.Sprintf("%d-%02d. %s", max(s.DiscNumber, 1), s.TrackNumber, s.Title)
+	}
+	return fmt.Sprintf("%d. %s", s.TrackNumber, s.Title)
+}
+
+// songDetails joins the secondary tag fields (year, disc/track position,
+// composer, comment) into one display line, skipping any that are unset.
+func songDetails(s Song) string {
+	var parts []string
+	if s.Year > 0 {
+		parts = append(parts, strconv.Itoa(s.Year))
+	}
+	if s.DiscTotal > 1 || s.DiscNumber > 1 {
+		disc := "Disc " + strconv.Itoa(max(s.DiscNumber, 1))
+		if s.DiscTotal > 0 {
+			disc += "/" + strconv.Itoa(s.DiscTotal)
+		}
+		parts = append(parts, disc)
+	}
+	if s.TrackNumber > 0 {
+		track := "Track " + strconv.Itoa(s.TrackNumber)
+		if s.TrackTotal > 0 {
+			track += "/" + strconv.Itoa(s.TrackTotal)
+		}
+		parts = append(parts, track)
+	}
+	if s.Composer != "" && s.Composer != s.Artist {
+		parts = append(parts, "Composer: "+s.Composer)
+	}
+	if s.Comment != "" {
+		parts = append(parts, "“"+s.Comment+"”")
+	}
+	return strings.Join(parts, " • ")
+}
+
+// calculateDuration returns a file's duration in seconds and how it was found
+// (e.g. "vbr-header", "cbr-estimate", "streaminfo"), for the health report.
+func calculateDuration(filePath string) (float64, string) {
 	ext := strings.ToLower(filepath.Ext(filePath))
 	
 	switch ext {
@@ -180,24 +490,24 @@ func calculateDuration(filePath string) float64 {
 	case ".flac":
 		return calculateFLACDuration(filePath)
 	case ".wav":
-		return calculateWAVDuration(filePath)
+		return calculateWAVDuration(filePath), "pcm-length"
 	case ".m4a":
-		return calculateM4ADuration(filePath)
+		return calculateM4ADuration(filePath), "mp4"
 	case ".ogg":
-		return calculateOGGDuration(filePath)
+		return calculateOGGDuration(filePath), "ogg-length"
 	default:
-		return 0
+		return 0, "none"
 	}
 }
 
 // calculateMP3Duration returns the duration of an MP3 file. It first tries the
 // fast path of reading the frame and VBR (Xing/Info/VBRI) headers; only if that
 // fails does it fall back to decoding every frame (accurate but slow).
-func calculateMP3Duration(filePath string) float64 {
-	if d := mp3DurationFromHeader(filePath); d > 0 {
-		return d
+func calculateMP3Duration(filePath string) (float64, string) {
+	if d, method := mp3DurationFromHeader(filePath); d > 0 {
+		return d, method
 	}
-	return mp3DurationByDecoding(filePath)
+	return mp3DurationByDecoding(filePath), "decoded"
 }
 
 // MP3 lookup tables, indexed by the 4-bit fields of the frame header. Only
@@ -293,17 +603,18 @@ func mp3VBRFrameCount(frame []byte, fi mp3FrameInfo) int {
 
 // mp3DurationFromHeader computes duration by reading only the first frame's
 // headers: an exact value for VBR files with a Xing/VBRI tag, or a constant-
-// bitrate estimate otherwise. Returns 0 if the headers can't be parsed.
-func mp3DurationFromHeader(filePath string) float64 {
+// bitrate estimate otherwise, along with which of the two it was ("vbr-header"
+// or "cbr-estimate"). Returns 0 if the headers can't be parsed.
+func mp3DurationFromHeader(filePath string) (float64, string) {
 	f, err := os.Open(filePath)
 	if err != nil {
-		return 0
+		return 0, ""
 	}
 	defer f.Close()
 
 	stat, err := f.Stat()
 	if err != nil {
-		return 0
+		return 0, ""
 	}
 	fileSize := stat.Size()
 
@@ -320,7 +631,7 @@ func mp3DurationFromHeader(filePath string) float64 {
 
 	// Read a window large enough to hold the first frame and its VBR header.
 	if _, err := f.Seek(dataStart, io.SeekStart); err != nil {
-		return 0
+		return 0, ""
 	}
 	buf := make([]byte, 4096)
 	n, _ := io.ReadFull(f, buf)
@@ -340,7 +651,7 @@ func mp3DurationFromHeader(filePath string) float64 {
 		// VBR: exact duration from the stored frame count.
 		if frames := mp3VBRFrameCount(buf[i:], frameInfo); frames > 0 {
 			totalSamples := int64(frames) * int64(frameInfo.samplesPerFrame)
-			return float64(totalSamples) / float64(frameInfo.sampleRate)
+			return float64(totalSamples) / float64(frameInfo.sampleRate), "vbr-header"
 		}
 
 		// CBR: estimate from the audio byte length and the constant bitrate.
@@ -349,11 +660,11 @@ func mp3DurationFromHeader(filePath string) float64 {
 			audioBytes -= 128
 		}
 		if audioBytes <= 0 {
-			return 0
+			return 0, ""
 		}
-		return float64(audioBytes) * 8.0 / float64(frameInfo.bitrate)
+		return float64(audioBytes) * 8.0 / float64(frameInfo.bitrate), "cbr-estimate"
 	}
-	return 0
+	return 0, ""
 }
 
 func mp3HasID3v1(f *os.File, fileSize int64) bool {
@@ -401,22 +712,24 @@ func mp3DurationByDecoding(filePath string) float64 {
 	return float64(totalSamples) / float64(sampleRate)
 }
 
-func calculateFLACDuration(filePath string) float64 {
+func calculateFLACDuration(filePath string) (float64, string) {
 	// Parse FLAC file to get the StreamInfo block, which usually contains the
 	// exact sample count.
 	stream, err := flac.ParseFile(filePath)
 	if err != nil {
-		return 0
+		return 0, ""
 	}
 	defer stream.Close()
 
 	info := stream.Info
 	if info == nil || info.SampleRate == 0 {
-		return 0
+		return 0, ""
 	}
 
 	totalSamples := info.NSamples
+	method := "streaminfo"
 	if totalSamples == 0 {
+		method = "frame-scan"
 		// STREAMINFO is allowed to record a total sample count of 0 ("unknown"),
 		// which some encoders/rippers do. The stream is positioned at the first
 		// audio frame, so walk the frames and sum their block sizes rather than
@@ -430,10 +743,10 @@ func calculateFLACDuration(filePath string) float64 {
 		}
 	}
 	if totalSamples == 0 {
-		return 0
+		return 0, ""
 	}
 
-	return float64(totalSamples) / float64(info.SampleRate)
+	return float64(totalSamples) / float64(info.SampleRate), method
 }
 
 func calculateWAVDuration(filePath string) float64 {
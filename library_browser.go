@@ -14,7 +14,8 @@ type LibraryBrowser struct {
 	contents         []LibraryItem
 	categoryIndex    int
 	contentIndex     int
-	breadcrumb       []string // Track navigation path
+	breadcrumb       []string        // Track navigation path
+	marked           map[int]bool // songs marked in the open playlist, by entry index
 	libraryManager   *LibraryManager
 	playlistManager  *PlaylistManager
 	categoryViewport viewport
@@ -31,6 +32,7 @@ type LibraryItem struct {
 	Title    string
 	Subtitle string // For albums: artist, for songs: artist - album
 	Song     *Song  // Only for song items
+	Missing  bool   // A playlist entry whose file can't be found
 }
 
 func NewLibraryBrowser(libraryManager *LibraryManager, playlistManager *PlaylistManager) *LibraryBrowser {
@@ -103,18 +105,26 @@ func (lb *LibraryBrowser) refreshContents() {
 
 // getPlaylists builds the top-level playlist list.
 func (lb *LibraryBrowser) getPlaylists() []LibraryItem {
+	// The built-in Favorites and Top rated lists always come first, so this
+	// is never empty.
 	playlists := lb.playlistManager.GetPlaylists()
-	if len(playlists) == 0 {
-		return []LibraryItem{
-			{Type: "empty", Title: "No playlists yet", Subtitle: "Add tracks with Ctrl+P, or press n to create one"},
-		}
-	}
 	var items []LibraryItem
 	for _, p := range playlists {
+		subtitle := playlistCountLabel(len(p.Entries))
+		if n := lb.playlistManager.MissingCount(p.Name); n > 0 {
+			subtitle += " · " + strconv.Itoa(n) + " missing"
+		}
+		if p.IsSmart() {
+			kind := "Smart"
+			if IsBuiltin(p.Name) {
+				kind = "Built in"
+			}
+			subtitle = kind + " · " + playlistCountLabel(len(lb.playlistManager.SongsOf(p.Name)))
+		}
 		items = append(items, LibraryItem{
 			Type:     "playlist",
 			Title:    p.Name,
-			Subtitle: playlistCountLabel(len(p.Songs)),
+			Subtitle: subtitle,
 		})
 	}
 	return items
@@ -122,15 +132,21 @@ func (lb *LibraryBrowser) getPlaylists() []LibraryItem {
 
 // drillDownToPlaylist shows the songs in the named playlist.
 func (lb *LibraryBrowser) drillDownToPlaylist(name string) *Song {
-	songs := lb.playlistManager.SongsOf(name)
+	entries := lb.playlistManager.ItemsOf(name)
+	if lb.CurrentPlaylistName() != name || len(entries) != len(lb.contents) {
+		// Marks are entry positions, meaningless in another playlist or
+		// once entries were added or removed.
+		lb.marked = nil
+	}
 	var items []LibraryItem
-	for i := range songs {
-		s := songs[i]
+	for _, item := range entries {
+		s := item.Song
 		items = append(items, LibraryItem{
 			Type:     "song",
 			Title:    s.Title,
 			Subtitle: s.Artist + " - " + s.Album,
 			Song:     &s,
+			Missing:  item.Missing,
 		})
 	}
 	lb.contents = items
@@ -148,6 +164,60 @@ func (lb *LibraryBrowser) CurrentPlaylistName() string {
 	return ""
 }
 
+// ToggleMarked marks or unmarks the highlighted song of the open playlist
+// for a multi-song action, and moves the highlight down so a run of songs can
+// be marked by repeating the key.
+func (lb *LibraryBrowser) ToggleMarked() {
+	if lb.CurrentPlaylistName() == "" || lb.contentIndex >= len(lb.contents) || lb.contents[lb.contentIndex].Song == nil {
+		return
+	}
+	if lb.marked == nil {
+		lb.marked = make(map[int]bool)
+	}
+	if lb.marked[lb.contentIndex] {
+		delete(lb.marked, lb.contentIndex)
+	} else {
+		lb.marked[lb.contentIndex] = true
+	}
+	lb.MoveDown()
+}
+
+// IsMarked reports whether the open playlist's entry at index is marked.
+func (lb *LibraryBrowser) IsMarked(index int) bool {
+	return lb.marked[index]
+}
+
+// Marked returns the entry indexes of the marked songs.
+func (lb *LibraryBrowser) Marked() map[int]bool {
+	return lb.marked
+}
+
+// MoveMarked carries the marks along when the entry at from is moved to to,
+// shifting the entries in between (see PlaylistManager.MoveSong).
+func (lb *LibraryBrowser) MoveMarked(from, to int) {
+	if len(lb.marked) == 0 {
+		return
+	}
+	moved := make(map[int]bool, len(lb.marked))
+	for i := range lb.marked {
+		switch {
+		case i == from:
+			i = to
+		case from < to && i > from && i <= to:
+			i--
+		case to < from && i >= to && i < from:
+			i++
+		}
+		moved[i] = true
+	}
+	lb.marked = moved
+}
+
+// ClearMarked unmarks every song.
+func (lb *LibraryBrowser) ClearMarked() {
+	lb.marked = nil
+}
+
 // SongsForSelected returns all songs represented by the highlighted content
 // item — a single song, or every song of an artist/album/genre/playlist.
 func (lb *LibraryBrowser) SongsForSelected() []Song {
@@ -173,13 +243,71 @@ func (lb *LibraryBrowser) SongsForSelected() []Song {
 	case "album":
 		artist := strings.Split(item.Subtitle, " • ")[0]
 		return filterSongs(lb.libraryManager.GetSongs(), func(s Song) bool {
-			return fieldOrUnknown(s.Artist, "Unknown Artist") == artist &&
-				fieldOrUnknown(s.Album, "Unknown Album") == item.Title
+			return lb.albumMember(s, item.Title, artist)
 		})
 	}
 	return nil
 }
 
+// albumMember reports whether s belongs to the album row (album, artist). Rows
+// in the Albums category are keyed by album artist so compilations stay whole;
+// album rows reached through an artist are keyed by the track artist.
+func (lb *LibraryBrowser) albumMember(s Song, album, artist string) bool {
+	if fieldOrUnknown(s.Album, "Unknown Album") != album {
+		return false
+	}
+	if lb.categoryType == "albums" {
+		return albumArtistOf(s) == artist
+	}
+	return fieldOrUnknown(s.Artist, "Unknown Artist") == artist
+}
+
+// albumYear returns the most common non-zero year among an album's songs, or 0.
+func albumYear(songs []Song) int {
+	counts := make(map[int]int)
+	best := 0
+	for _, s := range songs {
+		if s.Year <= 0 {
+			continue
+		}
+		counts[s.Year]++
+		if counts[s.Year] > counts[best] {
+			best = s.Year
+		}
+	}
+	return best
+}
+
+// albumSubtitle renders "Artist • 1997 • N songs" for an album row. The artist
+// must stay first: drillDownToAlbum and SongsForSelected parse it back out.
+func albumSubtitle(artist string, songs []Song) string {
+	subtitle := artist
+	if year := albumYear(songs); year > 0 {
+		subtitle += " • " + strconv.Itoa(year)
+	}
+	return subtitle + " • " + strconv.Itoa(len(songs)) + " songs"
+}
+
+// ArtSong returns a song whose cover represents the current album context:
+// the open album, the highlighted album row, or the highlighted song.
+func (lb *LibraryBrowser) ArtSong() *Song {
+	if lb.categoryType != "playlists" && len(lb.breadcrumb) == 2 && len(lb.contents) > 0 {
+		return lb.contents[0].Song
+	}
+	if lb.currentPane != "contents" || lb.contentIndex >= len(lb.contents) {
+		return nil
+	}
+	switch item := lb.contents[lb.contentIndex]; item.Type {
+	case "song":
+		return item.Song
+	case "album":
+		if songs := lb.SongsForSelected(); len(songs) > 0 {
+			return &songs[0]
+		}
+	}
+	return nil
+}
+
 // SelectedLabel describes the highlighted content item for the picker header
 // (a song title, or "<name> (N songs)" for a group/playlist).
 func (lb *LibraryBrowser) SelectedLabel() string {
@@ -257,7 +385,7 @@ func (lb *LibraryBrowser) getAlbums(songs []Song) []LibraryItem {
 		if album == "" {
 			album = "Unknown Album"
 		}
-		key := album + " - " + song.Artist
+		key := album + " - " + albumArtistOf(song)
 		albumMap[key] = append(albumMap[key], song)
 	}
 	
@@ -280,7 +408,7 @@ func (lb *LibraryBrowser) getAlbums(songs []Song) []LibraryItem {
 		items = append(items, LibraryItem{
 			Type:     "album",
 			Title:    album,
-			Subtitle: artist + " • " + strconv.Itoa(len(songs)) + " songs",
+			Subtitle: albumSubtitle(artist, songs),
 		})
 	}
 	
@@ -541,7 +669,7 @@ func (lb *LibraryBrowser) drillDownToArtist(artist string) *Song {
 		items = append(items, LibraryItem{
 			Type:     "album",
 			Title:    album,
-			Subtitle: artist + " • " + strconv.Itoa(len(albumSongs)) + " songs",
+			Subtitle: albumSubtitle(artist, albumSongs),
 		})
 	}
 	
@@ -566,50 +694,28 @@ func (lb *LibraryBrowser) drillDownToAlbum(album, subtitle string) *Song {
 	var albumSongs []Song
 	
 	for _, song := range songs {
-		songArtist := song.Artist
-		if songArtist == "" {
-			songArtist = "Unknown Artist"
-		}
-		songAlbum := song.Album
-		if songAlbum == "" {
-			songAlbum = "Unknown Album"
-		}
-		
-		if songArtist == artist && songAlbum == album {
+		if lb.albumMember(song, album, artist) {
 			albumSongs = append(albumSongs, song)
 		}
 	}
 	
-	// Sort by track number if available, otherwise by title
-	sort.Slice(albumSongs, func(i, j int) bool {
-		// If both songs have track numbers, sort by track number
-		if albumSongs[i].TrackNumber > 0 && albumSongs[j].TrackNumber > 0 {
-			return albumSongs[i].TrackNumber < albumSongs[j].TrackNumber
-		}
-		// If only one has a track number, prioritize it
-		if albumSongs[i].TrackNumber > 0 && albumSongs[j].TrackNumber == 0 {
-			return true
-		}
-		if albumSongs[i].TrackNumber == 0 && albumSongs[j].TrackNumber > 0 {
-			return false
-		}
-		// If neither has track numbers, sort alphabetically by title
-		return strings.ToLower(albumSongs[i].Title) < strings.ToLower(albumSongs[j].Title)
-	})
+	// Sort by disc and track number if available, otherwise by title
+	sortByTrack(albumSongs)
 	
 	// Show songs in this album
 	var items []LibraryItem
 	for _, song := range albumSongs {
-		// Format title with track number if available
-		title := song.Title
-		if song.TrackNumber > 0 {
-			title = fmt.Sprintf("%d. %s", song.TrackNumber, song.Title)
+		// Track artists differ from the album artist on compilations, so show
+		// them alongside the song's year/composer details.
+		subtitle := song.Artist + " - " + song.Album
+		if details := songDetails(song); details != "" {
+			subtitle += " • " + details
 		}
 		
 		items = append(items, LibraryItem{
 			Type:     "song",
-			Title:    title,
-			Subtitle: song.Artist + " - " + song.Album,
+			Title:    trackLabel(song),
+			Subtitle: subtitle,
 			Song:     &song,
 		})
 	}
@@ -690,6 +796,7 @@ func (lb *LibraryBrowser) GoBack() {
 	
 	// Remove last breadcrumb
 	lb.breadcrumb = lb.breadcrumb[:len(lb.breadcrumb)-1]
+	lb.marked = nil
 	
 	if len(lb.breadcrumb) == 0 {
 		// Back to top level
@@ -706,6 +813,49 @@ func (lb *LibraryBrowser) GoBack() {
 	// Don't call adjustContentViewport() here as we want to force top position
 }
 
+// Reload rebuilds the visible list from the managers after songs were edited,
+// keeping the drill-down and selection where possible. If an edit moved every
+// song out of the open artist/album/genre, it falls back to the top level.
+func (lb *LibraryBrowser) Reload() {
+	index, top := lb.contentIndex, lb.contentViewport.top
+	crumb := append([]string(nil), lb.breadcrumb...)
+	kind := ""
+	if len(lb.contents) > 0 {
+		kind = lb.contents[0].Type
+	}
+
+	switch {
+	case len(crumb) == 0:
+		lb.refreshContents()
+	case lb.categoryType == "playlists":
+		lb.drillDownToPlaylist(crumb[0])
+	case len(crumb) == 2:
+		lb.breadcrumb = nil
+		lb.drillDownToAlbum(crumb[1], crumb[0])
+	case kind == "album":
+		lb.drillDownToArtist(crumb[0])
+	case kind == "artist":
+		lb.drillDownToGenre(crumb[0])
+	default:
+		lb.breadcrumb = nil
+		lb.refreshContents()
+	}
+	if len(lb.contents) == 0 {
+		lb.breadcrumb = nil
+		index, top = 0, 0
+		lb.refreshContents()
+	}
+
+	lb.contentIndex = min(index, len(lb.contents)-1)
+	lb.contentViewport.top = top
+	lb.adjustContentViewport()
+}
+
+// GetViewportHeight returns the number of visible content rows.
+func (lb *LibraryBrowser) GetViewportHeight() int {
+	return lb.contentViewport.height
+}
+
 func (lb *LibraryBrowser) GetCategories() []string {
 	return lb.categories
 }
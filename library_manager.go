@@ -7,17 +7,31 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 type LibraryManager struct {
-	libraryFile string
-	folders     []string
-	songs       []Song
+	libraryFile  string
+	folders      []string
+	songs        []Song
+	hidden       map[string]bool // file paths the user hid (e.g. duplicate copies)
+	diagnostics  map[string]FileDiagnostic
+	fingerprints *FingerprintStore
+	added        map[string]time.Time // when each file first appeared in the library
+	ratings      map[string]int       // 1-5 star ratings by file path
+	favorites    map[string]bool
+	generation   int                  // bumped on every save, so derived views know to refresh
+	stamp        fileStamp            // of library.json as last read or written
 }
 
 type LibraryData struct {
-	Folders []string `json:"folders"`
-	Songs   []Song   `json:"songs"`
+	Folders     []string                  `json:"folders"`
+	Songs       []Song                    `json:"songs"`
+	Hidden      []string                  `json:"hidden,omitempty"`
+	Diagnostics map[string]FileDiagnostic `json:"diagnostics,omitempty"`
+	Added       map[string]time.Time      `json:"added,omitempty"`
+	Ratings     map[string]int            `json:"ratings,omitempty"`
+	Favorites   []string                  `json:"favorites,omitempty"`
 }
 
 func NewLibraryManager() (*LibraryManager, error) {
@@ -35,9 +49,15 @@ func NewLibraryManager() (*LibraryManager, error) {
 	libraryFile := filepath.Join(configDir, "library.json")
 	
 	lm := &LibraryManager{
-		libraryFile: libraryFile,
-		folders:     []string{},
-		songs:       []Song{},
+		libraryFile:  libraryFile,
+		folders:      []string{},
+		songs:        []Song{},
+		hidden:       map[string]bool{},
+		diagnostics:  map[string]FileDiagnostic{},
+		fingerprints: NewFingerprintStore(),
+		added:        map[string]time.Time{},
+		ratings:      map[string]int{},
+		favorites:    map[string]bool{},
 	}
 	
 	// Load existing library if it exists
@@ -61,9 +81,37 @@ func (lm *LibraryManager) LoadLibrary() error {
 	if err := json.Unmarshal(data, &libraryData); err != nil {
 		return err
 	}
+	lm.stamp = stampOf(lm.libraryFile)
 	
 	lm.folders = libraryData.Folders
 	lm.songs = libraryData.Songs
+	lm.hidden = make(map[string]bool, len(libraryData.Hidden))
+	for _, p := range libraryData.Hidden {
+		lm.hidden[p] = true
+	}
+	lm.diagnostics = libraryData.Diagnostics
+	if lm.diagnostics == nil {
+		lm.diagnostics = map[string]FileDiagnostic{}
+	}
+	lm.ratings = libraryData.Ratings
+	if lm.ratings == nil {
+		lm.ratings = map[string]int{}
+	}
+	lm.favorites = make(map[string]bool, len(libraryData.Favorites))
+	for _, p := range libraryData.Favorites {
+		lm.favorites[p] = true
+	}
+	lm.added = libraryData.Added
+	if lm.added == nil {
+		// Libraries saved before added dates were kept: the file's
+		// modification time is the best guess at when it was added.
+		lm.added = make(map[string]time.Time, len(lm.songs))
+		for _, s := range lm.songs {
+			if info, err := os.Stat(s.FilePath); err == nil && s.FilePath != "" {
+				lm.added[s.FilePath] = info.ModTime()
+			}
+		}
+	}
 	
 	// If no songs but we have folders, rescan
 	if len(lm.songs) == 0 && len(lm.folders) > 0 {
@@ -74,17 +122,107 @@ func (lm *LibraryManager) LoadLibrary() error {
 }
 
 func (lm *LibraryManager) SaveLibrary() error {
+	lm.stampAdded()
+	lm.generation++
 	libraryData := LibraryData{
-		Folders: lm.folders,
-		Songs:   lm.songs,
+		Folders:     lm.folders,
+		Songs:       lm.songs,
+		Diagnostics: lm.diagnostics,
+		Added:       lm.added,
+		Ratings:     lm.ratings,
+	}
+	for p := range lm.hidden {
+		libraryData.Hidden = append(libraryData.Hidden, p)
+	}
+	sort.Strings(libraryData.Hidden)
+	for p := range lm.favorites {
+		libraryData.Favorites = append(libraryData.Favorites, p)
 	}
+	sort.Strings(libraryData.Favorites)
 	
 	data, err := json.MarshalIndent(libraryData, "", "  ")
 	if err != nil {
 		return err
 	}
 	
-	return os.WriteFile(lm.libraryFile, data, 0644)
+	if err := os.WriteFile(lm.libraryFile, data, 0644); err != nil {
+		return err
+	}
+	lm.stamp = stampOf(lm.libraryFile)
+	return nil
+}
+
+// ReloadIfChanged rereads library.json if another process has written it
+// since this one last read or wrote it, reporting whether it did.
+func (lm *LibraryManager) ReloadIfChanged() bool {
+	if !lm.stamp.changed(lm.libraryFile) || lm.LoadLibrary() != nil {
+		return false
+	}
+	lm.generation++
+	return true
+}
+
+// stampAdded records now as the added date of songs new to the library, and
+// forgets the dates of songs that have left it.
+func (lm *LibraryManager) stampAdded() {
+	now := time.Now()
+	present := make(map[string]bool, len(lm.songs))
+	for _, s := range lm.songs {
+		if s.FilePath == "" {
+			continue
+		}
+		present[s.FilePath] = true
+		if _, ok := lm.added[s.FilePath]; !ok {
+			lm.added[s.FilePath] = now
+		}
+	}
+	for p := range lm.added {
+		if !present[p] {
+			delete(lm.added, p)
+		}
+	}
+}
+
+// AddedAt returns when a file was added to the library (zero if unknown).
+func (lm *LibraryManager) AddedAt(path string) time.Time {
+	return lm.added[path]
+}
+
+// Generation changes whenever the library is modified.
+func (lm *LibraryManager) Generation() int {
+	return lm.generation
+}
+
+// Rating returns a file's star rating, 0 if unrated.
+func (lm *LibraryManager) Rating(path string) int {
+	return lm.ratings[path]
+}
+
+// SetRating sets a file's rating, 0 to 5 stars (0 clears it). Ratings are
+// kept by path, separately from the songs, so rescans don't lose them.
+func (lm *LibraryManager) SetRating(path string, stars int) error {
+	if stars <= 0 {
+		delete(lm.ratings, path)
+	} else {
+		lm.ratings[path] = min(stars, 5)
+	}
+	return lm.SaveLibrary()
+}
+
+// IsFavorite reports whether a file is marked as a favorite.
+func (lm *LibraryManager) IsFavorite(path string) bool {
+	return lm.favorites[path]
+}
+
+// ToggleFavorite flips a file's favorite mark and returns the new state.
+func (lm *LibraryManager) ToggleFavorite(path string) (bool, error) {
+	fav := !lm.favorites[path]
+	if fav {
+		lm.favorites[path] = true
+	} else {
+		delete(lm.favorites, path)
+	}
+	return fav, lm.SaveLibrary()
 }
 
 func (lm *LibraryManager) AddFolder(folderPath string) error {
@@ -107,7 +245,7 @@ func (lm *LibraryManager) AddFolder(folderPath string) error {
 	
 	// Add new songs to library, avoiding duplicates
 	for _, newSong := range songs {
-		isDuplicate := false
+		isDuplicate := lm.hidden[newSong.FilePath]
 		for _, existingSong := range lm.songs {
 			if existingSong.FilePath == newSong.FilePath {
 				isDuplicate = true
@@ -163,7 +301,7 @@ func (lm *LibraryManager) RescanLibrary() error {
 		
 		// Add songs, avoiding duplicates
 		for _, newSong := range songs {
-			isDuplicate := false
+			isDuplicate := lm.hidden[newSong.FilePath]
 			for _, existingSong := range lm.songs {
 				if existingSong.FilePath == newSong.FilePath {
 					isDuplicate = true
@@ -186,14 +324,15 @@ func (lm *LibraryManager) RescanLibrary() error {
 }
 
 // mergeSongs adds the given songs to the library, skipping any whose file path
-// is already present, then sorts the library by title. Dedup is O(n) via a map.
+// is already present or hidden, then sorts the library by title. Dedup is O(n)
+// via a map.
 func (lm *LibraryManager) mergeSongs(scanned []Song) {
 	existing := make(map[string]bool, len(lm.songs))
 	for _, s := range lm.songs {
 		existing[s.FilePath] = true
 	}
 	for _, s := range scanned {
-		if s.FilePath != "" && !existing[s.FilePath] {
+		if s.FilePath != "" && !existing[s.FilePath] && !lm.hidden[s.FilePath] {
 			lm.songs = append(lm.songs, s)
 			existing[s.FilePath] = true
 		}
@@ -230,6 +369,62 @@ func (lm *LibraryManager) SetSongs(scanned []Song) error {
 	return lm.SaveLibrary()
 }
 
+// UpdateSongs replaces library entries with the given songs, matched by file
+// path (e.g. after their tags were edited), and re-sorts and saves.
+func (lm *LibraryManager) UpdateSongs(updated []Song) error {
+	byPath := make(map[string]Song, len(updated))
+	for _, s := range updated {
+		byPath[s.FilePath] = s
+	}
+	for i, s := range lm.songs {
+		if u, ok := byPath[s.FilePath]; ok && s.FilePath != "" {
+			lm.songs[i] = u
+		}
+	}
+	sort.Slice(lm.songs, func(i, j int) bool {
+		return strings.ToLower(lm.songs[i].Title) < strings.ToLower(lm.songs[j].Title)
+	})
+	return lm.SaveLibrary()
+}
+
+// HideSongs removes songs from the library and remembers their paths so later
+// scans don't bring them back. The files themselves are left alone.
+func (lm *LibraryManager) HideSongs(paths []string) error {
+	for _, p := range paths {
+		lm.hidden[p] = true
+	}
+	return lm.RemoveSongs(paths)
+}
+
+// RemoveSongs drops the songs with the given file paths from the library.
+func (lm *LibraryManager) RemoveSongs(paths []string) error {
+	drop := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		drop[p] = true
+	}
+	kept := lm.songs[:0]
+	for _, s := range lm.songs {
+		if !drop[s.FilePath] {
+			kept = append(kept, s)
+		}
+	}
+	lm.songs = kept
+	return lm.SaveLibrary()
+}
+
+// UnhideAll forgets every hidden path; the songs come back on the next rescan.
+// It returns how many paths were un-hidden.
+func (lm *LibraryManager) UnhideAll() (int, error) {
+	n := len(lm.hidden)
+	lm.hidden = map[string]bool{}
+	return n, lm.SaveLibrary()
+}
+
+// GetHiddenCount returns how many file paths are hidden from the library.
+func (lm *LibraryManager) GetHiddenCount() int {
+	return len(lm.hidden)
+}
+
 func (lm *LibraryManager) GetSongs() []Song {
 	if len(lm.songs) == 0 {
 		return []Song{
@@ -239,6 +434,29 @@ func (lm *LibraryManager) GetSongs() []Song {
 	return lm.songs
 }
 
+// SetDiagnostics records scan diagnostics by file path. With replace, the
+// previous diagnostics are dropped first (a full rescan); otherwise the new
+// ones are merged in. The library is saved by the SetSongs/AddFolderWithSongs
+// call that follows a scan.
+func (lm *LibraryManager) SetDiagnostics(diags map[string]FileDiagnostic, replace bool) {
+	if replace || lm.diagnostics == nil {
+		lm.diagnostics = make(map[string]FileDiagnostic, len(diags))
+	}
+	for path, d := range diags {
+		lm.diagnostics[path] = d
+	}
+}
+
+// GetDiagnostics returns the scan diagnostics, keyed by file path.
+func (lm *LibraryManager) GetDiagnostics() map[string]FileDiagnostic {
+	return lm.diagnostics
+}
+
+// Fingerprints returns the acoustic fingerprint cache for library files.
+func (lm *LibraryManager) Fingerprints() *FingerprintStore {
+	return lm.fingerprints
+}
+
 func (lm *LibraryManager) GetFolders() []string {
 	return lm.folders
 }
@@ -254,6 +472,11 @@ func (lm *LibraryManager) GetFolderCount() int {
 // ClearLibrary clears all music library data
 func (lm *LibraryManager) ClearLibrary() error {
 	lm.folders = []string{}
+	lm.hidden = map[string]bool{}
+	lm.diagnostics = map[string]FileDiagnostic{}
+	lm.added = map[string]time.Time{}
+	lm.ratings = map[string]int{}
+	lm.favorites = map[string]bool{}
 	lm.songs = []Song{
 		{Title: "No library loaded - Press 'f' to browse folders, 'a' to add folder to library", FilePath: ""},
 	}
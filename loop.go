@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// loopStreamer sits between a local file's decoder and the rest of the
+// playback chain. It keeps track of the decoder's position, so the player
+// can report and seek by time rather than by wall clock, and plays an A-B
+// loop: when the position reaches B it seeks back to A within the same Stream
+// call, so the repeat is sample-accurate with no gap.
+//
+// Stream runs on the speaker goroutine; the loop points are changed only
+// under speaker.Lock, and the position is published atomically for readers
+// that don't hold it.
+type loopStreamer struct {
+	src  beep.StreamSeekCloser
+	rate beep.SampleRate
+	a, b int          // loop points in source samples; the loop is on when b > a
+	pos  atomic.Int64 // src.Position() as of the last Stream or Seek
+}
+
+func newLoopStreamer(src beep.StreamSeekCloser, rate beep.SampleRate) *loopStreamer {
+	return &loopStreamer{src: src, rate: rate}
+}
+
+func (ls *loopStreamer) Stream(samples [][2]float64) (int, bool) {
+	filled := 0
+	for filled < len(samples) {
+		want := len(samples) - filled
+		if ls.b > ls.a {
+			pos := ls.src.Position()
+			if pos >= ls.b || pos < ls.a {
+				if err := ls.src.Seek(ls.a); err != nil {
+					break
+				}
+				pos = ls.a
+			}
+			want = min(want, ls.b-pos)
+		}
+		n, ok := ls.src.Stream(samples[filled : filled+want])
+		filled += n
+		if !ok || n == 0 {
+			break
+		}
+	}
+	ls.pos.Store(int64(ls.src.Position()))
+	return filled, filled > 0
+}
+
+func (ls *loopStreamer) Err() error {
+	return ls.src.Err()
+}
+
+// seek moves to sample n, clamped to the track. Call it under speaker.Lock.
+func (ls *loopStreamer) seek(n int) error {
+	n = clamp(n, 0, max(ls.src.Len()-1, 0))
+	if err := ls.src.Seek(n); err != nil {
+		return err
+	}
+	ls.pos.Store(int64(n))
+	return nil
+}
+
+// setLoop sets the loop points in seconds; b <= a turns the loop off. Call it
+// under speaker.Lock.
+func (ls *loopStreamer) setLoop(a, b float64) {
+	ls.a = clamp(ls.rate.N(time.Duration(a*float64(time.Second))), 0, ls.src.Len())
+	ls.b = clamp(ls.rate.N(time.Duration(b*float64(time.Second))), 0, ls.src.Len())
+}
+
+// seconds is the playback position in seconds.
+func (ls *loopStreamer) seconds() float64 {
+	return ls.rate.D(int(ls.pos.Load())).Seconds()
+}
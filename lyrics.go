@@ -0,0 +1,272 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/dhowden/tag"
+)
+
+// Lyrics come from a .lrc file beside the track, the track's own lyrics tag
+// (ID3v2 USLT, Vorbis LYRICS, MP4 ©lyr) or a .txt file beside it, in that
+// order. LRC timestamps ([mm:ss.xx]), in a file or a tag, make the lyrics
+// synced: the line being sung follows playback and clicking a line seeks
+// there.
+
+// LyricLine is one line of lyrics. Time is where it starts, in seconds into
+// the track; it is -1 for lyrics that aren't synced.
+type LyricLine struct {
+	Time float64
+	Text string
+}
+
+// Lyrics are a track's lyrics, in order.
+type Lyrics struct {
+	Lines  []LyricLine
+	Synced bool
+	Source string // "lrc", "tag" or "txt"
+}
+
+// lyricsLoadedMsg reports that a lyrics lookup finished (found or not).
+type lyricsLoadedMsg struct{ path string }
+
+// LyricsCache holds the lyrics of tracks that have been played. It mirrors
+// ArtCache, without the disk copy: lyrics are cheap to read again.
+type LyricsCache struct {
+	mu      sync.Mutex
+	lyrics  map[string]*Lyrics // nil value: looked up, none found
+	pending map[string]bool
+}
+
+func NewLyricsCache() *LyricsCache {
+	return &LyricsCache{
+		lyrics:  make(map[string]*Lyrics),
+		pending: make(map[string]bool),
+	}
+}
+
+// Lyrics returns a track's lyrics, or nil if it has none or they haven't
+// been looked up (yet).
+func (lc *LyricsCache) Lyrics(path string) *Lyrics {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.lyrics[path]
+}
+
+// LoadCmd starts a background lookup of the song's lyrics, or returns nil if
+// it is already loaded or in flight.
+func (lc *LyricsCache) LoadCmd(s Song) tea.Cmd {
+	if s.FilePath == "" {
+		return nil
+	}
+	lc.mu.Lock()
+	_, done := lc.lyrics[s.FilePath]
+	if done || lc.pending[s.FilePath] {
+		lc.mu.Unlock()
+		return nil
+	}
+	lc.pending[s.FilePath] = true
+	lc.mu.Unlock()
+
+	return func() tea.Msg {
+		lyrics := loadLyrics(s.FilePath)
+		lc.mu.Lock()
+		lc.lyrics[s.FilePath] = lyrics
+		delete(lc.pending, s.FilePath)
+		lc.mu.Unlock()
+		return lyricsLoadedMsg{path: s.FilePath}
+	}
+}
+
+// loadLyrics finds a track's lyrics, or returns nil if it has none.
+func loadLyrics(songPath string) *Lyrics {
+	base := strings.TrimSuffix(songPath, filepath.Ext(songPath))
+	if text := readSidecar(base, ".lrc"); text != "" {
+		if l := parseLyrics(text, "lrc"); l != nil {
+			return l
+		}
+	}
+	if text := embeddedLyrics(songPath); text != "" {
+		if l := parseLyrics(text, "tag"); l != nil {
+			return l
+		}
+	}
+	if text := readSidecar(base, ".txt"); text != "" {
+		return parseLyrics(text, "txt")
+	}
+	return nil
+}
+
+// readSidecar reads base+ext, trying the extension in upper case too.
+func readSidecar(base, ext string) string {
+	for _, e := range []string{ext, strings.ToUpper(ext)} {
+		if data, err := os.ReadFile(base + e); err == nil {
+			return string(data)
+		}
+	}
+	return ""
+}
+
+// embeddedLyrics reads the lyrics tag from dhowden/tag's raw frames: USLT
+// (ULT in ID3v2.2, suffixed when a file has several), LYRICS or
+// UNSYNCEDLYRICS in Vorbis comments, and ©lyr in MP4.
+func embeddedLyrics(songPath string) string {
+	f, err := os.Open(songPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		return ""
+	}
+	raw := m.Raw()
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names) // USLT before USLT_1
+	for _, name := range names {
+		upper := strings.ToUpper(name)
+		switch {
+		case strings.HasPrefix(upper, "USLT") || strings.HasPrefix(upper, "ULT"):
+			if c, ok := raw[name].(*tag.Comm); ok && strings.TrimSpace(c.Text) != "" {
+				return c.Text
+			}
+		case upper == "LYRICS" || upper == "UNSYNCEDLYRICS":
+			if s, ok := raw[name].(string); ok && strings.TrimSpace(s) != "" {
+				return s
+			}
+		}
+	}
+	return m.Lyrics()
+}
+
+// parseLyrics reads plain or LRC lyrics. Lines may carry several timestamps
+// ([00:12.30][01:40.00]chorus); ID tags such as [ar:…] are skipped, [offset:]
+// is applied, and enhanced-LRC word times (<00:12.50>) are dropped. Returns
+// nil when there's no text.
+func parseLyrics(text, source string) *Lyrics {
+	l := &Lyrics{Source: source}
+	offset := 0.0
+	var plain []LyricLine
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		var times []float64
+		isTag := false
+		for strings.HasPrefix(line, "[") {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				break
+			}
+			stamp := line[1:end]
+			if t, ok := parseLRCTime(stamp); ok {
+				times = append(times, t)
+			} else if key, value, ok := strings.Cut(stamp, ":"); ok && isLRCTagKey(key) {
+				isTag = true
+				if strings.EqualFold(key, "offset") {
+					if ms, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+						offset = float64(ms) / 1000
+					}
+				}
+			} else {
+				break // a bracketed word, not a tag
+			}
+			line = strings.TrimSpace(line[end+1:])
+		}
+		if isTag && len(times) == 0 {
+			continue
+		}
+		line = strings.TrimSpace(stripWordTimes(line))
+		if len(times) == 0 {
+			plain = append(plain, LyricLine{Time: -1, Text: line})
+			continue
+		}
+		for _, t := range times {
+			l.Lines = append(l.Lines, LyricLine{Time: t, Text: line})
+		}
+	}
+
+	if len(l.Lines) > 0 {
+		// A positive offset shows the lyrics earlier.
+		for i := range l.Lines {
+			l.Lines[i].Time = math.Max(l.Lines[i].Time-offset, 0)
+		}
+		sort.SliceStable(l.Lines, func(i, j int) bool { return l.Lines[i].Time < l.Lines[j].Time })
+		l.Synced = true
+		return l
+	}
+
+	// Unsynced: keep blank lines between verses, but not at either end.
+	for len(plain) > 0 && plain[0].Text == "" {
+		plain = plain[1:]
+	}
+	for len(plain) > 0 && plain[len(plain)-1].Text == "" {
+		plain = plain[:len(plain)-1]
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+	l.Lines = plain
+	return l
+}
+
+// parseLRCTime reads an LRC timestamp: mm:ss, mm:ss.xx or mm:ss:xx.
+func parseLRCTime(stamp string) (float64, bool) {
+	m, rest, ok := strings.Cut(stamp, ":")
+	if !ok {
+		return 0, false
+	}
+	mins, err := strconv.Atoi(m)
+	if err != nil || mins < 0 {
+		return 0, false
+	}
+	rest = strings.Replace(rest, ":", ".", 1)
+	secs, err := strconv.ParseFloat(rest, 64)
+	if err != nil || secs < 0 || secs >= 60 {
+		return 0, false
+	}
+	return float64(mins)*60 + secs, true
+}
+
+// isLRCTagKey reports whether key names an LRC ID tag ([ar:Artist] and so on).
+func isLRCTagKey(key string) bool {
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "ar", "al", "ti", "au", "by", "length", "offset", "re", "ve", "#", "lang", "tool":
+		return true
+	}
+	return false
+}
+
+// stripWordTimes removes enhanced-LRC word timestamps such as <00:12.50>.
+func stripWordTimes(line string) string {
+	for {
+		start := strings.IndexByte(line, '<')
+		if start < 0 {
+			return line
+		}
+		end := strings.IndexByte(line[start:], '>')
+		if end < 0 {
+			return line
+		}
+		if _, ok := parseLRCTime(line[start+1 : start+end]); !ok {
+			return line
+		}
+		line = line[:start] + line[start+end+1:]
+	}
+}
+
+// Current returns the index of the line being sung pos seconds into the
+// track, or -1 before the first line and for unsynced lyrics.
+func (l *Lyrics) Current(pos float64) int {
+	if !l.Synced {
+		return -1
+	}
+	return sort.Search(len(l.Lines), func(i int) bool { return l.Lines[i].Time > pos }) - 1
+}
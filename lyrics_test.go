@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLyricsSynced(t *testing.T) {
+	text := "[ar:Nina]\r\n[ti:Blue Song]\r\n[offset:500]\r\n" +
+		"[00:12.30][01:40.00]Chorus line\r\n" +
+		"[00:05.00]<00:05.00>First <00:05.50>words\r\n" +
+		"[00:20:50]Colon hundredths\r\n" +
+		"[00:00.20]Before the offset\r\n" +
+		"[00:30.00]\r\n"
+	l := parseLyrics(text, "lrc")
+	if l == nil || !l.Synced || l.Source != "lrc" {
+		t.Fatalf("got %+v, want synced lyrics", l)
+	}
+	want := []LyricLine{
+		{0, "Before the offset"},
+		{4.5, "First words"},
+		{11.8, "Chorus line"},
+		{20, "Colon hundredths"},
+		{29.5, ""},
+		{99.5, "Chorus line"},
+	}
+	if len(l.Lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %+v", len(l.Lines), len(want), l.Lines)
+	}
+	for i, w := range want {
+		if got := l.Lines[i]; got.Text != w.Text || got.Time < w.Time-1e-9 || got.Time > w.Time+1e-9 {
+			t.Errorf("line %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestParseLyricsPlain(t *testing.T) {
+	text := "\n\nFirst verse\n[Chorus]\nSecond line\n\nSecond verse\n\n"
+	l := parseLyrics(text, "txt")
+	if l == nil || l.Synced {
+		t.Fatalf("got %+v, want unsynced lyrics", l)
+	}
+	var lines []string
+	for _, line := range l.Lines {
+		if line.Time != -1 {
+			t.Errorf("unsynced line %q has time %v", line.Text, line.Time)
+		}
+		lines = append(lines, line.Text)
+	}
+	want := []string{"First verse", "[Chorus]", "Second line", "", "Second verse"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+
+	if l := parseLyrics("[ar:Nina]\n\n  \n", "lrc"); l != nil {
+		t.Errorf("tags only: got %+v, want nil", l)
+	}
+}
+
+func TestParseLRCTime(t *testing.T) {
+	tests := []struct {
+		stamp string
+		want  float64
+		ok    bool
+	}{
+		{"01:02.50", 62.5, true},
+		{"01:02:50", 62.5, true},
+		{"1:02", 62, true},
+		{"00:60.00", 0, false},
+		{"ar:Nina", 0, false},
+		{"-1:00", 0, false},
+		{"12", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseLRCTime(tt.stamp)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseLRCTime(%q) = %v, %v; want %v, %v", tt.stamp, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"fmt"
 	"image/color"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/NimbleMarkets/ntcharts/v2/barchart"
 	"github.com/NimbleMarkets/ntcharts/v2/linechart/wavelinechart"
@@ -31,6 +34,16 @@ func tickCmd() tea.Cmd {
 	})
 }
 
+// artTickMsg drives syncArt, which keeps album covers loaded and any
+// image-protocol placements in step with the screen.
+type artTickMsg time.Time
+
+func artTickCmd() tea.Cmd {
+	return tea.Tick(time.Second/4, func(t time.Time) tea.Msg {
+		return artTickMsg(t)
+	})
+}
+
 // scanState holds the live progress of a library scan running on a background
 // goroutine. The scan goroutine writes via atomics; the UI goroutine reads them
 // on each scanTickMsg, so there is no shared-memory data race.
@@ -47,6 +60,7 @@ type scanDoneMsg struct {
 	mode   string // "add" or "rescan"
 	folder string // folder added, when mode == "add"
 	songs  []Song
+	diags  map[string]FileDiagnostic
 }
 
 func scanTickCmd() tea.Cmd {
@@ -56,14 +70,19 @@ func scanTickCmd() tea.Cmd {
 }
 
 // startScanCmd walks the given folders on a background goroutine, reporting
-// progress through st, and returns a scanDoneMsg when complete.
-func startScanCmd(folders []string, mode, folder string, st *scanState) tea.Cmd {
+// progress through st, and returns a scanDoneMsg when complete. Files are
+// fingerprinted and analyzed along the way as opts asks.
+func startScanCmd(folders []string, mode, folder string, st *scanState, opts scanOptions) tea.Cmd {
 	return func() tea.Msg {
-		songs := scanFoldersProgress(folders, func(done, total int) {
+		songs, diags := scanFoldersProgress(folders, opts, func(done, total int) {
 			st.total.Store(int64(total))
 			st.done.Store(int64(done))
 		})
-		return scanDoneMsg{mode: mode, folder: folder, songs: songs}
+		if fps := opts.fingerprints; fps != nil {
+			fps.Prune()
+			fps.Save()
+		}
+		return scanDoneMsg{mode: mode, folder: folder, songs: songs, diags: diags}
 	}
 }
 
@@ -74,11 +93,24 @@ type model struct {
 	playing           string
 	playingSong       *Song
 	playingStation    *RadioStation
+	listen            *listenSession // play-history session for playingSong
+	radioListen       *radioListen   // scrobbling session for the stream title playingStation sends
+	scrobbler         *Scrobbler
+	mpris             *MPRIS // desktop media controls; nil without a session bus
+	mpd               *MPDServer // MPD protocol server; nil while it's off
+	mpdErr            error      // why the MPD server couldn't start
 	selected          int
-	audioPlayer       *AudioPlayer
+	audioPlayer       Player
+	remote            *remotePlayer // the daemon's player, while attached to one; see remote.go
+	headless          bool          // running as the daemon, with no terminal
+	queueVersion      int           // bumped whenever currentPlaylist is replaced or grows
+	queueIDs          []int         // one per queue entry, kept while it's queued (MPD's song ids)
+	lastQueueID       int
+	lastTick          time.Time     // when the last tickMsg was handled
 	folderBrowser     *FolderBrowser
 	libraryManager    *LibraryManager
 	playlistManager   *PlaylistManager
+	historyManager    *HistoryManager
 	libraryBrowser    *LibraryBrowser
 	radioLibrary      *RadioLibrary
 	radioBrowser      *RadioBrowser
@@ -92,6 +124,8 @@ type model struct {
 	searchCategory    string // "songs", "artists", "albums", "genres"
 	searchResults     []searchResult
 	searchSelected    int
+	searchErr         error  // the first query term that didn't parse, shown inline
+	searchText        string // the query's free text, folded, for highlighting matches
 	// Add-to-playlist picker (shared modal overlay, opened via ctrl+p)
 	playlistPicker       bool
 	playlistPickerIndex  int
@@ -102,15 +136,30 @@ type model struct {
 	// Inline text prompt (new playlist name / rename) and delete confirm
 	textInputActive       bool
 	textInputBuffer       string
-	textInputPurpose      string // "new-playlist-add", "new-playlist-empty", "rename-playlist"
-	playlistRenameTarget  string // playlist being renamed
+	textInputPurpose      string // "new-playlist-add", "new-playlist-empty", "rename-playlist", "new-smart-playlist", "smart-rules", "export-playlist"
+	playlistRenameTarget  string // playlist being renamed, exported, or whose smart rules are being edited
+	exportRelative        bool   // export playlists with paths relative to the playlist file
+	playlistSort          int    // playlistSortKeys entry the next o sorts by
+	playlistDragRow       int    // row being dragged in the open playlist, or -1
 	playlistConfirmDelete bool
 	statusFlash           string // transient confirmation message
+	// Edit-tags dialog (modal, opened with t in the library)
+	tagEditor *TagEditor
+	// Album art
+	artCache    *ArtCache
+	waveforms   *WaveformCache
+	lyrics      *LyricsCache
+	artProtocol string                  // image protocol detected at startup
+	artPlaced   map[string]artPlacement // covers drawn with artProtocol, by slot
+	// Lyrics view
+	lyricsScroll   int    // first line shown when the lyrics aren't synced
+	lyricsPrevView string // view y returns to from the lyrics
 	// Main content viewport
 	contentViewport   viewport
 	contentLines      []string
 	// Playlist/queue management
 	currentPlaylist   []Song
+	mix               *Mix // set while the queue is an endless mix
 	currentTrackIndex int
 	// Radio spinner and timer
 	spinner           spinner.Model
@@ -131,13 +180,9 @@ type model struct {
 }
 
 
-func initialModel() model {
-	audioPlayer, err := NewAudioPlayer()
-	if err != nil {
-		fmt.Printf("Error initializing audio player: %v\n", err)
-		os.Exit(1)
-	}
-	
+// initialModel builds the model around a player: the AudioPlayer, or a
+// remotePlayer when attaching to a daemon.
+func initialModel(audioPlayer Player) model {
 	folderBrowser, err := NewFolderBrowser()
 	if err != nil {
 		fmt.Printf("Error initializing folder browser: %v\n", err)
@@ -150,7 +195,13 @@ func initialModel() model {
 		os.Exit(1)
 	}
 
-	playlistManager, err := NewPlaylistManager()
+	historyManager, err := NewHistoryManager()
+	if err != nil {
+		fmt.Printf("Error initializing play history: %v\n", err)
+		os.Exit(1)
+	}
+
+	playlistManager, err := NewPlaylistManager(libraryManager, historyManager)
 	if err != nil {
 		fmt.Printf("Error initializing playlist manager: %v\n", err)
 		os.Exit(1)
@@ -172,7 +223,19 @@ func initialModel() model {
 		os.Exit(1)
 	}
 	
-	settingsBrowser := NewSettingsBrowser(settingsManager, libraryManager, radioLibrary)
+	settingsBrowser := NewSettingsBrowser(settingsManager, libraryManager, radioLibrary, historyManager)
+
+	artCache, err := NewArtCache()
+	if err != nil {
+		fmt.Printf("Error initializing album art cache: %v\n", err)
+		os.Exit(1)
+	}
+
+	waveforms, err := NewWaveformCache()
+	if err != nil {
+		fmt.Printf("Error initializing waveform cache: %v\n", err)
+		os.Exit(1)
+	}
 	
 	// Initialize spinner
 	s := spinner.New()
@@ -192,22 +255,42 @@ func initialModel() model {
 		folderBrowser:     folderBrowser,
 		libraryManager:    libraryManager,
 		playlistManager:   playlistManager,
+		historyManager:    historyManager,
 		libraryBrowser:    libraryBrowser,
 		radioLibrary:      radioLibrary,
 		radioBrowser:      radioBrowser,
 		settingsManager:   settingsManager,
 		settingsBrowser:   settingsBrowser,
+		artCache:          artCache,
+		waveforms:         waveforms,
+		lyrics:            NewLyricsCache(),
+		artProtocol:       detectImageProtocol(),
 		nowPlayingFocused: false,
 		controlSelected:   1, // Start with play/pause selected
 		spinner:           s,
 		visualizer:        visualizer,
 		currentChartType:  "unicode", // Start with the high-res unicode visualizer
+		playlistDragRow:   -1,
 		scanProgress: progress.New(progress.WithColors(
 			lipgloss.Color(settingsManager.GetTheme().Primary),
 			lipgloss.Color(settingsManager.GetTheme().Secondary),
 		)),
 	}
 	
+	if remote, ok := audioPlayer.(*remotePlayer); ok {
+		// The daemon owns playback, so it's the one that scrobbles and
+		// answers MPRIS.
+		m.remote = remote
+		m.statusFlash = "Attached to the running player: q detaches, and the music plays on"
+	} else {
+		m.audioPlayer.SetVolume(float64(settingsManager.GetSettings().Volume) / 100)
+		m.scrobbler = NewScrobbler(settingsManager.GetSettings())
+		if m.mpris, err = StartMPRIS(); err != nil {
+			log.Printf("DEBUG: MPRIS unavailable: %v", err)
+		}
+		m.configureMPD()
+	}
+	
 	// Reset viewport to ensure proper initial display
 	m.libraryBrowser.ResetViewport()
 	
@@ -215,7 +298,19 @@ func initialModel() model {
 }
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(tickCmd(), m.spinner.Tick)
+	return tea.Batch(tickCmd(), m.spinner.Tick, artTickCmd(), m.lastFMSignIn(), m.mpris.Listen(), m.mpd.Listen())
+}
+
+// lastFMSignIn returns a command to sign in to Last.fm when this process
+// scrobbles, scrobbling is on and there's a username and password but no
+// session key yet, or nil.
+func (m model) lastFMSignIn() tea.Cmd {
+	s := m.settingsManager.GetSettings()
+	if m.scrobbler == nil || !s.Scrobble || s.LastFMSession != "" || s.LastFMUser == "" || s.LastFMPassword == "" ||
+		s.LastFMKey == "" || s.LastFMSecret == "" {
+		return nil
+	}
+	return lastFMSessionCmd(s)
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -236,6 +331,64 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case folderQueueMsg:
+		switch {
+		case msg.err != nil:
+			m.statusFlash = "Couldn't load " + msg.label + ": " + msg.err.Error()
+		case len(msg.songs) == 0:
+			m.statusFlash = "No playable tracks in " + msg.label
+		default:
+			m.setPlaylist(msg.songs, msg.start)
+			if m.playCurrentTrack() {
+				m.statusFlash = fmt.Sprintf("Playing %s (%s)", msg.label, playlistCountLabel(len(msg.songs)))
+				if msg.missing > 0 {
+					m.statusFlash += fmt.Sprintf(", %d not found", msg.missing)
+				}
+				return m, tickCmd()
+			}
+		}
+		return m, nil
+
+	case identifyDoneMsg:
+		if msg.err != nil {
+			m.statusFlash = "Lookup failed: " + msg.err.Error()
+		} else if len(msg.suggestions) == 0 {
+			m.statusFlash = "No AcoustID match for " + msg.song.Title
+		} else if m.tagEditor == nil {
+			if te := NewTagEditor([]Song{msg.song}); te != nil {
+				te.Suggest(msg.suggestions)
+				m.tagEditor = te
+			}
+		}
+		return m, nil
+
+	case tempoKeyDoneMsg:
+		var saveErr error
+		if len(msg.songs) > 0 {
+			saveErr = m.updateSongs(msg.songs)
+		}
+		m.statusFlash = fmt.Sprintf("Wrote BPM and key to %s", playlistCountLabel(msg.written))
+		if msg.written == 0 && len(msg.songs) == 0 && msg.err == nil {
+			m.statusFlash = "Nothing to tag: BPM and key were already known"
+		}
+		if msg.err != nil {
+			m.statusFlash += fmt.Sprintf(" (%d failed: %v)", msg.failed, msg.err)
+		}
+		if saveErr != nil {
+			m.statusFlash += "; couldn't save the library: " + saveErr.Error()
+		}
+		return m, nil
+
+	case tagEditDoneMsg:
+		m.saveTagEdits(msg)
+		return m, nil
+
+	case ratingWrittenMsg:
+		if msg.err != nil {
+			m.statusFlash = fmt.Sprintf("Rating of \"%s\" not written to file: %v", msg.song.Title, msg.err)
+		}
+		return m, nil
+
 	case scanDoneMsg:
 		m.scanning = false
 		m.scanState = nil
@@ -243,8 +396,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.songs != nil || msg.mode == "rescan" {
 			switch msg.mode {
 			case "add":
+				m.libraryManager.SetDiagnostics(msg.diags, false)
 				m.libraryManager.AddFolderWithSongs(msg.folder, msg.songs)
 			case "rescan":
+				m.libraryManager.SetDiagnostics(msg.diags, true)
 				m.libraryManager.SetSongs(msg.songs)
 			}
 			m.libraryBrowser.Refresh()
@@ -254,9 +409,50 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case mprisMsg:
+		return m.handleMPRIS(msg)
+
+	case remoteStatusMsg:
+		return m, m.applyRemote(msg)
+
+	case controlMsg:
+		return m, m.runControl(msg)
+
+	case mpdMsg:
+		return m, tea.Batch(m.runControl(controlMsg(msg)), m.mpd.Listen())
+
+	case lastFMSessionMsg:
+		if msg.err != nil {
+			m.statusFlash = "Last.fm sign-in failed: " + msg.err.Error()
+			return m, nil
+		}
+		err := m.settingsManager.SetLastFMSession(msg.key)
+		m.scrobbler.Configure(m.settingsManager.GetSettings())
+		m.statusFlash = "Signed in to Last.fm"
+		if err != nil {
+			m.statusFlash += ", but couldn't save the session: " + err.Error()
+		}
+		return m, nil
+
 	case tickMsg:
+		// Starting playback starts another tick chain while the last one may
+		// still be running; a tick too soon after the last ends its chain,
+		// so only one carries on.
+		if now := time.Time(msg); now.Sub(m.lastTick) < time.Second/25 {
+			return m, nil
+		} else {
+			m.lastTick = now
+		}
+		if m.remote != nil {
+			return m, m.remote.syncCmd()
+		}
+		if m.listen != nil {
+			m.listen.advance(m.audioPlayer.IsPlaying(), time.Time(msg))
+		}
+		m.updateScrobbles(time.Time(msg))
 		// Check if current track has finished and auto-play next
 		if m.isTrackFinished() {
+			m.endListen(true, false)
 			if m.playNextTrack() {
 				return m, tickCmd()
 			} else {
@@ -274,9 +470,39 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 		
+	case artTickMsg:
+		var waveform, lyrics tea.Cmd
+		if m.playingSong != nil && m.playingStation == nil {
+			waveform = m.waveforms.LoadCmd(*m.playingSong)
+			lyrics = m.lyrics.LoadCmd(*m.playingSong)
+		}
+		var sync tea.Cmd
+		if m.remote != nil && m.playingSong == nil && m.playingStation == nil {
+			// Nothing's ticking; look out for the daemon starting to play.
+			sync = m.remote.syncCmd()
+		}
+		m.reloadChanged()
+		m.publishMPRIS()
+		m.publishMPD()
+		return m, tea.Batch(m.syncArt(), waveform, lyrics, sync, artTickCmd())
+
+	case coverLoadedMsg:
+		// The next frame picks the cover up; nothing else to do.
+		return m, nil
+
+	case waveformLoadedMsg:
+		// Likewise the waveform.
+		return m, nil
+
+	case lyricsLoadedMsg:
+		// And the lyrics.
+		return m, nil
+
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
+		// Covers drawn with an image protocol are re-placed on the next art tick.
+		artCmd := m.clearArt()
 		
 		// Calculate proper content height
 		headerHeight := 1
@@ -296,18 +522,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.folderBrowser.SetViewportHeight(contentHeight)
 		m.libraryBrowser.SetViewportHeight(contentHeight)
 		
-		return m, nil
+		return m, artCmd
 
 	case tea.MouseClickMsg:
 		return m.handleMouseClick(msg)
 
 	case tea.MouseMotionMsg:
-		// Left-drag across the progress bar scrubs/seeks.
+		// Left-drag across the progress bar scrubs/seeks; on a row of the
+		// open playlist it drags the song to a new position.
 		if msg.Button == tea.MouseLeft {
-			m.seekFromMouse(msg)
+			if m.playlistDragRow >= 0 {
+				m.dragPlaylistSong(msg)
+			} else {
+				m.seekFromMouse(msg)
+			}
 		}
 		return m, nil
 
+	case tea.MouseReleaseMsg:
+		m.playlistDragRow = -1
+		return m, nil
+
 	case tea.KeyPressMsg:
 		keyStr := msg.String()
 
@@ -327,6 +562,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			case "space", " ":
 				m.textInputBuffer += " "
+			case "tab":
+				if m.textInputPurpose == "export-playlist" {
+					m.exportRelative = !m.exportRelative
+				}
 			default:
 				if len(keyStr) == 1 && keyStr[0] >= 32 && keyStr[0] <= 126 {
 					m.textInputBuffer += keyStr
@@ -340,6 +579,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.handlePlaylistPickerKey(keyStr)
 		}
 
+		// The tag editor is modal too; typing edits the highlighted field.
+		if m.tagEditor != nil {
+			return m.handleTagEditorKey(msg)
+		}
+
 		// Delete-playlist confirmation: y deletes, any other key cancels.
 		if m.playlistConfirmDelete {
 			m.playlistConfirmDelete = false
@@ -381,6 +625,57 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		// The duplicates tool under Settings has its own cleanup keys.
+		if m.currentView == "settings" && m.settingsBrowser.GetCurrentView() == "duplicates" && !m.searchMode {
+			switch keyStr {
+			case "x":
+				m.resolveDuplicates(false, false)
+				return m, nil
+			case "X":
+				m.resolveDuplicates(true, false)
+				return m, nil
+			case "d":
+				m.settingsBrowser.ConfirmDeleteDuplicates()
+				return m, nil
+			case "u":
+				if n, err := m.libraryManager.UnhideAll(); err == nil && n > 0 {
+					m.statusFlash = fmt.Sprintf("Un-hid %d files; rescan to bring them back", n)
+				}
+				return m, nil
+			}
+		}
+
+		// The health report filters with ←/→ and exports with e.
+		if m.currentView == "settings" && m.settingsBrowser.GetCurrentView() == "health" && !m.searchMode {
+			switch keyStr {
+			case "left", "h", "shift+tab":
+				m.settingsBrowser.CycleHealthFilter(-1)
+				return m, nil
+			case "right", "l", "tab":
+				m.settingsBrowser.CycleHealthFilter(1)
+				return m, nil
+			case "e":
+				if dir, err := exportHealthReport(m.settingsBrowser.GetHealthRows()); err != nil {
+					m.statusFlash = "Export failed: " + err.Error()
+				} else {
+					m.statusFlash = "Exported library-health.csv and .json to " + dir
+				}
+				return m, nil
+			}
+		}
+
+		// The stats view changes its time window with ←/→.
+		if m.currentView == "settings" && m.settingsBrowser.GetCurrentView() == "stats" && !m.searchMode {
+			switch keyStr {
+			case "left", "h", "shift+tab":
+				m.settingsBrowser.CycleStatsWindow(-1)
+				return m, nil
+			case "right", "l", "tab":
+				m.settingsBrowser.CycleStatsWindow(1)
+				return m, nil
+			}
+		}
+
 		// Handle search mode
 		if m.searchMode {
 			switch keyStr {
@@ -399,6 +694,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, tickCmd()
 				}
 				return m, nil
+			case "alt+0", "alt+1", "alt+2", "alt+3", "alt+4", "alt+5":
+				// Rate the highlighted song (digits themselves go to the query).
+				if m.searchSelected < len(m.searchResults) && m.searchResults[m.searchSelected].song != nil {
+					return m, m.rateSong(*m.searchResults[m.searchSelected].song, int(keyStr[len(keyStr)-1]-'0'))
+				}
+				return m, nil
+			case "ctrl+l":
+				if m.searchSelected < len(m.searchResults) && m.searchResults[m.searchSelected].song != nil {
+					m.toggleFavorite(*m.searchResults[m.searchSelected].song)
+				}
+				return m, nil
 			case "ctrl+a":
 				// Play everything currently listed (e.g. all jazz sub-genres).
 				if m.playAllSearchResults() {
@@ -445,6 +751,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.searchCategory = "songs"
 			m.searchResults = nil
 			m.searchSelected = 0
+			m.searchErr = nil
 			return m, nil
 		case "space", " ":
 			if m.nowPlayingFocused {
@@ -452,29 +759,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m.activateControl()
 			} else {
 				// Normal play/pause toggle
-				wasPaused := m.audioPlayer.IsPaused()
-				m.audioPlayer.TogglePause()
-				
-				// Handle radio pause tracking
-				if m.playingStation != nil {
-					if wasPaused {
-						// Resuming from pause - update pause tracking
-						if m.radioWasPaused {
-							m.radioPausedTime += time.Since(m.radioStartTime)
-						}
-						m.radioStartTime = time.Now()
-					} else {
-						// Pausing - mark as paused
-						m.radioWasPaused = true
-					}
-				}
-				
-				if m.audioPlayer.IsPlaying() {
-					return m, tickCmd() // Start ticking when resuming
-				}
-				return m, nil
+				return m, m.togglePause()
 			}
 		case "q", "ctrl+c":
+			if m.remote != nil {
+				// Detach: the daemon plays on.
+				return m, tea.Quit
+			}
+			m.endListen(false, false)
 			m.audioPlayer.Stop()
 			return m, tea.Quit
 		case "s":
@@ -488,38 +780,30 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					stations := m.radioBrowser.GetStations()
 					if len(stations) > 0 {
 						lastStation := &stations[len(stations)-1]
-						if err := m.audioPlayer.Play(lastStation.StreamURL); err == nil {
-							m.playing = lastStation.Name
-							m.playingSong = nil
-							m.playingStation = lastStation
+						if m.playStation(lastStation) {
 							return m, tickCmd()
 						}
 					}
 				}
 			} else {
-				m.audioPlayer.Stop()
-				m.playing = ""
-				m.playingSong = nil
-				m.playingStation = nil
-				m.radioStartTime = time.Time{}
-				m.radioPausedTime = 0
-				m.radioWasPaused = false
-				m.nowPlayingFocused = false
+				m.stopPlayback()
 			}
 			return m, nil
 		case "p":
 			if m.currentView == "radio" && m.radioBrowser.GetCurrentView() == "quickadd" {
 				if station, err := m.radioBrowser.PlayQuickStation(); err == nil {
-					if err := m.audioPlayer.Play(station.StreamURL); err == nil {
-						m.playing = station.Name
-						m.playingSong = nil
-						m.playingStation = station
-						m.radioStartTime = time.Now()
-						m.radioPausedTime = 0
-						m.radioWasPaused = false
+					if m.playStation(station) {
 						return m, tickCmd()
 					}
 				}
+			} else if m.currentView == "folder" {
+				// Play the highlighted folder, subfolders and all; on a
+				// file, the folder being browsed.
+				dir := m.folderBrowser.GetSelected()
+				if !m.folderBrowser.IsDirectory(dir) || dir == filepath.Dir(m.folderBrowser.GetCurrentPath()) {
+					dir = m.folderBrowser.GetCurrentPath() // a file, or ".."
+				}
+				return m, m.playFolder(dir)
 			} else if m.currentView == "library" && m.libraryBrowser.GetCategoryType() == "playlists" {
 				// Play the whole selected/open playlist.
 				name := m.selectedLibraryPlaylistName()
@@ -545,6 +829,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.currentView = "visualizer"
 				m.selected = 0
 			} else if m.currentView == "visualizer" {
+				m.currentView = "lyrics"
+				m.selected = 0
+			} else if m.currentView == "lyrics" {
 				m.currentView = "settings"
 				m.selected = 0
 			} else if m.currentView == "settings" {
@@ -582,7 +869,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						m.scanPercent = 0
 						m.scanDone, m.scanTotal = 0, 0
 						m.scanLabel = "Adding folder: " + selected
-						return m, tea.Batch(startScanCmd([]string{selected}, "add", selected, m.scanState), scanTickCmd())
+						return m, tea.Batch(startScanCmd([]string{selected}, "add", selected, m.scanState, m.scanOptions()), scanTickCmd())
 					}
 				}
 			} else if m.currentView == "radio" {
@@ -594,16 +881,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return m, nil
 		case "r":
-			if m.currentView == "library" && !m.scanning {
-				// Rescan all library folders on a background goroutine.
-				if folders := m.libraryManager.GetFolders(); len(folders) > 0 {
-					m.scanning = true
-					m.scanState = &scanState{}
-					m.scanPercent = 0
-					m.scanDone, m.scanTotal = 0, 0
-					m.scanLabel = "Rescanning library…"
-					return m, tea.Batch(startScanCmd(folders, "rescan", "", m.scanState), scanTickCmd())
-				}
+			if m.currentView == "library" {
+				return m, m.startRescan()
 			}
 			return m, nil
 		case "n":
@@ -613,10 +892,44 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.startTextInput("new-playlist-empty", "")
 			}
 			return m, nil
+		case "N":
+			// New smart playlist: asks for a name, then its rules.
+			if m.currentView == "library" && m.libraryBrowser.GetCategoryType() == "playlists" &&
+				len(m.libraryBrowser.GetBreadcrumb()) == 0 {
+				m.startTextInput("new-smart-playlist", "")
+			}
+			return m, nil
+		case "R":
+			// Edit the rules of the selected (or open) smart playlist.
+			if m.currentView == "library" && m.libraryBrowser.GetCategoryType() == "playlists" {
+				name := m.selectedLibraryPlaylistName()
+				if name == "" {
+					name = m.libraryBrowser.CurrentPlaylistName()
+				}
+				if p := m.playlistManager.Get(name); p != nil && p.IsSmart() && !IsBuiltin(name) {
+					m.playlistRenameTarget = name
+					m.startTextInput("smart-rules", p.Smart.String())
+				}
+			}
+			return m, nil
+		case "E":
+			// Export the selected (or open) playlist to a playlist file,
+			// saved in the folder browser's current folder by default.
+			if m.currentView == "library" && m.libraryBrowser.GetCategoryType() == "playlists" {
+				name := m.selectedLibraryPlaylistName()
+				if name == "" {
+					name = m.libraryBrowser.CurrentPlaylistName()
+				}
+				if name != "" {
+					m.playlistRenameTarget = name
+					m.startTextInput("export-playlist", filepath.Join(m.folderBrowser.GetCurrentPath(), name+".m3u8"))
+				}
+			}
+			return m, nil
 		case "e":
 			// Rename the selected playlist.
 			if m.currentView == "library" && m.libraryBrowser.GetCategoryType() == "playlists" {
-				if name := m.selectedLibraryPlaylistName(); name != "" {
+				if name := m.selectedLibraryPlaylistName(); name != "" && !IsBuiltin(name) {
 					m.playlistRenameTarget = name
 					m.startTextInput("rename-playlist", name)
 				}
@@ -625,22 +938,187 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "d":
 			// Delete the selected playlist (asks for confirmation).
 			if m.currentView == "library" && m.libraryBrowser.GetCategoryType() == "playlists" {
-				if m.selectedLibraryPlaylistName() != "" {
+				if name := m.selectedLibraryPlaylistName(); name != "" && !IsBuiltin(name) {
 					m.playlistConfirmDelete = true
 				}
 			}
 			return m, nil
+		case "t":
+			// Edit tags of the highlighted song, or of every song in the
+			// highlighted album/artist/genre/playlist.
+			if m.currentView == "library" && !m.nowPlayingFocused {
+				m.tagEditor = NewTagEditor(m.libraryBrowser.SongsForSelected())
+			}
+			return m, nil
+		case "i":
+			// Identify the highlighted song by its fingerprint; the matches
+			// open in the tag editor for review.
+			if m.currentView == "library" && !m.nowPlayingFocused {
+				return m, m.identifySelected()
+			}
+			// In the Folder tab, import the highlighted playlist file as a
+			// library playlist.
+			if selected := m.folderBrowser.GetSelected(); m.currentView == "folder" && isPlaylistFile(selected) {
+				m.importPlaylist(selected)
+			}
+			return m, nil
+		case "B":
+			// Analyze tempo and key where unknown, then write them to the
+			// files' tags, for the highlighted row or the playing song.
+			songs := m.tempoKeyTargets()
+			if len(songs) == 0 {
+				m.statusFlash = "Highlight a song, album or artist to tag its BPM and key"
+				return m, nil
+			}
+			m.statusFlash = "Analyzing and tagging " + playlistCountLabel(len(songs)) + "…"
+			return m, tempoKeyCmd(songs)
+		case ",", ".", "<", ">":
+			// Seek the playing track: , and . by 5 seconds, < and > by 30.
+			m.seekBy(map[string]float64{",": -5, ".": 5, "<": -30, ">": 30}[keyStr])
+			return m, nil
+		case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9":
+			// Jump to 0-90% of the playing track.
+			if m.canSeek() {
+				m.audioPlayer.Seek(float64(keyStr[0]-'0') / 10)
+			}
+			return m, nil
+		case "[", "]":
+			m.markLoop(keyStr == "[")
+			return m, nil
+		case "-", "=", "+":
+			// Volume down or up by 5%.
+			step := 0.05
+			if keyStr == "-" {
+				step = -step
+			}
+			m.setVolume(m.audioPlayer.Volume() + step)
+			return m, nil
+		case "\\":
+			if m.canSeek() {
+				m.audioPlayer.SetLoop(-1, -1)
+				m.statusFlash = "Loop cleared"
+			}
+			return m, nil
+		case "alt+0", "alt+1", "alt+2", "alt+3", "alt+4", "alt+5":
+			// Rate the highlighted (or playing) song; 0 clears the rating.
+			// The same keys rate in search, where digits go to the query.
+			if s := m.ratingTarget(); s != nil {
+				return m, m.rateSong(*s, int(keyStr[len(keyStr)-1]-'0'))
+			}
+			return m, nil
+		case "M":
+			// Start an endless mix from the highlighted song or artist, or
+			// from the playing song.
+			if m.currentView == "library" && !m.nowPlayingFocused && m.libraryBrowser.GetCurrentPane() == "contents" {
+				contents := m.libraryBrowser.GetContents()
+				if idx := m.libraryBrowser.GetContentIndex(); idx < len(contents) && contents[idx].Type == "artist" {
+					artist := contents[idx].Title
+					if m.startMix(NewArtistMix(artist, m.libraryBrowser.SongsForSelected()), nil) {
+						return m, tickCmd()
+					}
+					return m, nil
+				}
+			}
+			if s := m.ratingTarget(); s != nil && s.FilePath != "" {
+				seed := *s
+				if m.startMix(NewSongMix(seed), &seed) {
+					return m, tickCmd()
+				}
+			}
+			return m, nil
+		case "y":
+			// Open the lyrics full screen, or go back to where we were.
+			if m.currentView == "lyrics" {
+				m.currentView = m.lyricsPrevView
+				if m.currentView == "" {
+					m.currentView = "library"
+				}
+			} else {
+				m.lyricsPrevView = m.currentView
+				m.currentView = "lyrics"
+				m.lyricsScroll = 0
+			}
+			return m, nil
+		case "L":
+			// Love: toggle the highlighted (or playing) song as a favorite.
+			if s := m.ratingTarget(); s != nil {
+				m.toggleFavorite(*s)
+			}
+			return m, nil
 		case "x":
-			// Remove the highlighted song from the open playlist.
-			if m.currentView == "library" {
-				if name := m.libraryBrowser.CurrentPlaylistName(); name != "" {
+			// Remove the marked songs (or just the highlighted one) from the
+			// open playlist.
+			if name := m.editablePlaylist(); name != "" {
+				indexes := m.libraryBrowser.Marked()
+				if len(indexes) == 0 {
 					contents := m.libraryBrowser.GetContents()
 					idx := m.libraryBrowser.GetContentIndex()
-					if idx < len(contents) && contents[idx].Song != nil {
-						m.playlistManager.RemoveSong(name, contents[idx].Song.FilePath)
-						m.libraryBrowser.drillDownToPlaylist(name)
-						m.statusFlash = fmt.Sprintf("Removed from \"%s\"", name)
+					if idx >= len(contents) || contents[idx].Song == nil {
+						return m, nil
 					}
+					indexes = map[int]bool{idx: true}
+				}
+				if n, err := m.playlistManager.RemoveSongs(name, indexes); err != nil {
+					m.statusFlash = err.Error()
+				} else {
+					m.libraryBrowser.ClearMarked()
+					m.libraryBrowser.Reload()
+					m.statusFlash = fmt.Sprintf("Removed %s from \"%s\"", playlistCountLabel(n), name)
+				}
+			}
+			return m, nil
+		case "v":
+			// Mark the highlighted song for x to remove along with others.
+			if m.editablePlaylist() != "" {
+				m.libraryBrowser.ToggleMarked()
+			}
+			return m, nil
+		case "K", "shift+up", "J", "shift+down":
+			// Move the highlighted song up or down the open playlist.
+			if name := m.editablePlaylist(); name != "" {
+				from := m.libraryBrowser.GetContentIndex()
+				to := from - 1
+				if keyStr == "J" || keyStr == "shift+down" {
+					to = from + 1
+				}
+				m.movePlaylistSong(name, from, to)
+			}
+			return m, nil
+		case "o":
+			// Sort the open playlist; pressing again sorts by the next key.
+			if name := m.editablePlaylist(); name != "" {
+				by := playlistSortKeys[m.playlistSort%len(playlistSortKeys)]
+				if err := m.playlistManager.SortSongs(name, by); err != nil {
+					m.statusFlash = err.Error()
+				} else {
+					m.playlistSort++
+					m.libraryBrowser.Reload()
+					m.statusFlash = fmt.Sprintf("Sorted by %s (o again for %s)", by,
+						playlistSortKeys[m.playlistSort%len(playlistSortKeys)])
+				}
+			}
+			return m, nil
+		case "S":
+			// Shuffle the open playlist for good (not just this play).
+			if name := m.editablePlaylist(); name != "" {
+				if err := m.playlistManager.Shuffle(name); err != nil {
+					m.statusFlash = err.Error()
+				} else {
+					m.libraryBrowser.Reload()
+					m.statusFlash = fmt.Sprintf("Shuffled \"%s\"", name)
+				}
+			}
+			return m, nil
+		case "D":
+			// Drop repeats of the same song from the open playlist.
+			if name := m.editablePlaylist(); name != "" {
+				if n, err := m.playlistManager.Dedup(name); err != nil {
+					m.statusFlash = err.Error()
+				} else if n == 0 {
+					m.statusFlash = "No duplicates in \"" + name + "\""
+				} else {
+					m.libraryBrowser.Reload()
+					m.statusFlash = fmt.Sprintf("Removed %s of duplicates from \"%s\"", playlistCountLabel(n), name)
 				}
 			}
 			return m, nil
@@ -672,6 +1150,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.radioBrowser.MoveUp()
 			} else if m.currentView == "visualizer" {
 				// No up/down navigation needed for visualizer
+			} else if m.currentView == "lyrics" {
+				m.stepLyrics(-1)
 			} else if m.currentView == "settings" {
 				m.settingsBrowser.MoveUp()
 			}
@@ -687,6 +1167,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.radioBrowser.MoveDown()
 			} else if m.currentView == "visualizer" {
 				// No up/down navigation needed for visualizer
+			} else if m.currentView == "lyrics" {
+				m.stepLyrics(1)
 			} else if m.currentView == "settings" {
 				m.settingsBrowser.MoveDown()
 			}
@@ -741,18 +1223,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else if m.currentView == "library" {
 				return m.enterLibrarySelection()
 			} else if m.currentView == "folder" {
-				m.enterFolderSelection()
+				return m, m.enterFolderSelection()
 			} else if m.currentView == "radio" {
 				return m.handleRadioEnter()
 			} else if m.currentView == "visualizer" {
 				// No specific enter action needed for visualizer
 			} else if m.currentView == "settings" {
+				if m.settingsBrowser.GetCurrentView() == "confirm_delete_duplicates" && m.settingsBrowser.GetConfirmSelected() == 1 {
+					m.resolveDuplicates(false, true)
+				}
 				if err := m.settingsBrowser.EnterSelected(); err != nil {
 					// Handle error - could add error display
 				}
 				// Update spinner color when theme changes
 				theme := m.settingsManager.GetTheme()
 				m.spinner.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Primary))
+				m.scrobbler.Configure(m.settingsManager.GetSettings())
+				if m.remote != nil {
+					m.remote.ReloadSettings()
+				}
+				return m, tea.Batch(m.lastFMSignIn(), m.configureMPD())
 			}
 			return m, nil
 		case "esc":
@@ -770,6 +1260,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						m.radioBrowser.CancelQuickAdd()
 					}
 				}
+			} else if m.currentView == "library" {
+				m.libraryBrowser.ClearMarked()
 			} else if m.currentView == "visualizer" {
 				// No escape action needed for visualizer
 			} else if m.currentView == "settings" {
@@ -795,6 +1287,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m model) View() tea.View {
+	if m.headless {
+		return tea.NewView("") // the daemon has no screen
+	}
 	// zone.Scan records the screen positions of all marked clickable regions.
 	v := tea.NewView(zone.Scan(m.renderView()))
 	v.AltScreen = true
@@ -922,19 +1417,21 @@ func (m model) renderView() string {
 	
 	var controlsText string
 	if m.nowPlayingFocused {
-		controlsText = "←/→ navigate controls, enter/space to activate, ↑ to exit controls, / to search, q to quit"
+		controlsText = "←/→ navigate controls, enter/space to activate, ,/. seek 5s, </> 30s, 0-9 jump to 0-90%, [/] loop A-B, \\ end loop, alt+0-5 rate, L love, M mix, B tag BPM/key, y lyrics, ↑ to exit controls, / to search, q to quit"
 	} else if m.currentView == "library" {
 		if m.libraryBrowser.GetCurrentPane() == "categories" {
 			controlsText = "↑/↓ navigate categories, tab to switch panes, enter/space to pause, shift+tab for controls, / to search, f for folder browser, r to rescan, q to quit"
 		} else if m.libraryBrowser.GetCategoryType() == "playlists" {
 			if m.libraryBrowser.CurrentPlaylistName() != "" {
-				controlsText = "↑/↓ navigate, enter to play, x remove track, backspace to go back, ^P add to playlist, / search, q quit"
+				controlsText = "↑/↓ navigate, enter to play, K/J move, v mark, x remove, o sort, S shuffle, D dedup, t edit tags, alt+0-5 rate, 0-9 jump, L love, E export, backspace to go back, ^P add, / search, q quit"
 			} else {
-				controlsText = "↑/↓ navigate, enter to open, p play, n new, e rename, d delete, ^P add, / search, q quit"
+				controlsText = "↑/↓ navigate, enter to open, p play, n new, N new smart, R edit rules, e rename, d delete, E export, ^P add, / search, q quit"
 			}
 		} else {
-			controlsText = "↑/↓ navigate, enter to select/play, backspace to go back, tab to switch panes, ^P add to playlist, / search, f folder browser, q quit"
+			controlsText = "↑/↓ navigate, enter to select/play, backspace to go back, tab to switch panes, t edit tags, i identify, alt+0-5 rate, 0-9 jump, L love, M mix, B tag BPM/key, ^P add to playlist, / search, f folder browser, q quit"
 		}
+	} else if m.currentView == "lyrics" {
+		controlsText = "↑/↓ previous/next line (scroll if not synced), click a line to seek there, y to go back, f next view, / to search, q to quit"
 	} else if m.currentView == "radio" {
 		if m.radioBrowser.GetCurrentView() == "add" {
 			if m.radioBrowser.IsInputMode() {
@@ -952,7 +1449,7 @@ func (m model) renderView() string {
 			controlsText = "↑/↓ navigate, enter to play station, 'a' to add station, f to switch view, q to quit"
 		}
 	} else {
-		controlsText = "↑/↓ navigate, enter to open, a to add folder to library, backspace to go back, / to search, f for library, q to quit"
+		controlsText = "↑/↓ navigate, enter to open or play (a song queues its folder), p play folder and subfolders, i import playlist, a to add folder to library, backspace to go back, / to search, f for library, q to quit"
 	}
 	
 	// A pending delete confirmation or transient flash takes over the help slot.
@@ -1031,6 +1528,8 @@ func (m model) renderView() string {
 	} else if m.textInputActive {
 		// New/rename prompt opened from the Library (no picker behind it).
 		view = m.renderTextPrompt(view)
+	} else if m.tagEditor != nil {
+		view = m.renderTagEditor(view)
 	}
 	return view
 }
@@ -1060,6 +1559,7 @@ func (m model) renderTabs() string {
 	filesTab := "Files"
 	radioTab := "Radio"
 	visualizerTab := "Visualizer"
+	lyricsTab := "Lyrics"
 	settingsTab := "Settings"
 	
 	if m.currentView == "library" {
@@ -1067,30 +1567,42 @@ func (m model) renderTabs() string {
 		filesTab = inactiveTabStyle.Render(filesTab)
 		radioTab = inactiveTabStyle.Render(radioTab)
 		visualizerTab = inactiveTabStyle.Render(visualizerTab)
+		lyricsTab = inactiveTabStyle.Render(lyricsTab)
 		settingsTab = inactiveTabStyle.Render(settingsTab)
 	} else if m.currentView == "folder" {
 		libraryTab = inactiveTabStyle.Render(libraryTab)
 		filesTab = activeTabStyle.Render(filesTab)
 		radioTab = inactiveTabStyle.Render(radioTab)
 		visualizerTab = inactiveTabStyle.Render(visualizerTab)
+		lyricsTab = inactiveTabStyle.Render(lyricsTab)
 		settingsTab = inactiveTabStyle.Render(settingsTab)
 	} else if m.currentView == "radio" {
 		libraryTab = inactiveTabStyle.Render(libraryTab)
 		filesTab = inactiveTabStyle.Render(filesTab)
 		radioTab = activeTabStyle.Render(radioTab)
 		visualizerTab = inactiveTabStyle.Render(visualizerTab)
+		lyricsTab = inactiveTabStyle.Render(lyricsTab)
 		settingsTab = inactiveTabStyle.Render(settingsTab)
 	} else if m.currentView == "visualizer" {
 		libraryTab = inactiveTabStyle.Render(libraryTab)
 		filesTab = inactiveTabStyle.Render(filesTab)
 		radioTab = inactiveTabStyle.Render(radioTab)
 		visualizerTab = activeTabStyle.Render(visualizerTab)
+		lyricsTab = inactiveTabStyle.Render(lyricsTab)
+		settingsTab = inactiveTabStyle.Render(settingsTab)
+	} else if m.currentView == "lyrics" {
+		libraryTab = inactiveTabStyle.Render(libraryTab)
+		filesTab = inactiveTabStyle.Render(filesTab)
+		radioTab = inactiveTabStyle.Render(radioTab)
+		visualizerTab = inactiveTabStyle.Render(visualizerTab)
+		lyricsTab = activeTabStyle.Render(lyricsTab)
 		settingsTab = inactiveTabStyle.Render(settingsTab)
 	} else if m.currentView == "settings" {
 		libraryTab = inactiveTabStyle.Render(libraryTab)
 		filesTab = inactiveTabStyle.Render(filesTab)
 		radioTab = inactiveTabStyle.Render(radioTab)
 		visualizerTab = inactiveTabStyle.Render(visualizerTab)
+		lyricsTab = inactiveTabStyle.Render(lyricsTab)
 		settingsTab = activeTabStyle.Render(settingsTab)
 	}
 	
@@ -1100,6 +1612,7 @@ func (m model) renderTabs() string {
 		zone.Mark("tab_folder", filesTab), " ",
 		zone.Mark("tab_radio", radioTab), " ",
 		zone.Mark("tab_visualizer", visualizerTab), " ",
+		zone.Mark("tab_lyrics", lyricsTab), " ",
 		zone.Mark("tab_settings", settingsTab))
 
 	return tabsLine
@@ -1117,6 +1630,8 @@ func (m model) renderMainContent(availableHeight int) string {
 		rawContent = m.renderRadio()
 	case "visualizer":
 		rawContent = m.renderFullScreenVisualizer(availableHeight)
+	case "lyrics":
+		rawContent = m.renderLyricsView(availableHeight)
 	case "settings":
 		rawContent = m.renderSettings()
 	default:
@@ -1190,6 +1705,11 @@ func blendColors(colorA, colorB RGB, ratio float64) RGB {
 
 // colorCodeToRGB converts ANSI color codes to RGB values
 func colorCodeToRGB(colorCode string) RGB {
+	// Hex colors (e.g. cover-derived gradients) convert directly
+	if strings.HasPrefix(colorCode, "#") {
+		return hexToRGB(colorCode)
+	}
+
 	// Basic ANSI color mapping to approximate RGB values
 	colorMap := map[string]RGB{
 		"0":   {0, 0, 0},         // Black
@@ -1302,7 +1822,17 @@ func (m model) renderNowPlaying() string {
 			songInfo += fmt.Sprintf(" - %s", m.playingSong.Artist)
 		}
 		if m.playingSong.Album != "Unknown Album" {
-			songInfo += fmt.Sprintf(" (%s)", m.playingSong.Album)
+			if m.playingSong.AlbumArtist != "" && m.playingSong.AlbumArtist != m.playingSong.Artist {
+				songInfo += fmt.Sprintf(" (%s, %s)", m.playingSong.Album, m.playingSong.AlbumArtist)
+			} else {
+				songInfo += fmt.Sprintf(" (%s)", m.playingSong.Album)
+			}
+		}
+		if marks := m.songMarks(m.playingSong.FilePath); marks != "" {
+			songInfo += "  " + marks
+		}
+		if m.mix != nil {
+			songInfo += "  ∞ " + m.mix.Label()
 		}
 	} else if m.playingStation != nil {
 		songInfo = fmt.Sprintf("📻 %s", m.playingStation.Name)
@@ -1371,6 +1901,21 @@ func (m model) renderNowPlaying() string {
 	// content area is totalWidth - 2 (border) - 4 (padding) = totalWidth - 6.
 	innerWidth := totalWidth - 6
 
+	// Album cover to the left of the text, as tall as the 7 content lines
+	var coverLines []string
+	if cover := m.coverFor(m.playingSong); cover != nil && innerWidth >= 60 {
+		coverLines = m.renderCover("np", cover, 14, 7)
+		innerWidth -= 16
+	}
+
+	// Lyrics to the right of the text, when there's room for them
+	var lyricLines []string
+	if l := m.playingLyrics(); l != nil && innerWidth >= 90 {
+		w := min(innerWidth/3, 48)
+		lyricLines = m.lyricsWindow(l, "np", w, 7, false)
+		innerWidth -= w + 2
+	}
+
 	// Progress bar
 	progressBar := m.renderProgressBar(innerWidth)
 
@@ -1384,12 +1929,18 @@ func (m model) renderNowPlaying() string {
 	// Build content without borders - let lipgloss handle the box
 	var contentLines []string
 	
-	// Now Playing title
-	contentLines = append(contentLines, nowPlayingStyle.Render("Now Playing"))
+	// Now Playing title, followed by the track's secondary tags when known
+	title := "Now Playing"
+	if m.playingSong != nil {
+		if details := songDetails(*m.playingSong); details != "" {
+			title += lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted)).Render("  ·  " + details)
+		}
+	}
+	contentLines = append(contentLines, nowPlayingStyle.Render(ansi.Truncate(title, innerWidth-2, "…")))
 	contentLines = append(contentLines, "")
 	
 	// Song info
-	contentLines = append(contentLines, nowPlayingStyle.Render(songInfo))
+	contentLines = append(contentLines, nowPlayingStyle.Render(ansi.Truncate(songInfo, innerWidth-2, "…")))
 	contentLines = append(contentLines, "")
 	
 	// Progress bar
@@ -1398,6 +1949,13 @@ func (m model) renderNowPlaying() string {
 	
 	// Controls
 	contentLines = append(contentLines, controlsLine)
+
+	for i := range lyricLines {
+		contentLines[i] = padToWidth(ansi.Truncate(contentLines[i], innerWidth, ""), innerWidth) + "  " + lyricLines[i]
+	}
+	for i := range coverLines {
+		contentLines[i] = coverLines[i] + "  " + contentLines[i]
+	}
 	
 	// Join content and apply box style
 	content := strings.Join(contentLines, "\n")
@@ -1406,28 +1964,146 @@ func (m model) renderNowPlaying() string {
 	return boxContent
 }
 
-func (m model) renderProgressBar(width int) string {
-	if m.playingSong == nil && m.playingStation == nil {
-		return ""
+// artMode returns how covers are drawn: an image protocol ("kitty", "sixel",
+// "iterm"), "blocks", or "off".
+func (m model) artMode() string {
+	if mode := m.settingsManager.AlbumArtMode(); mode != "auto" {
+		return mode
 	}
-	
-	// Radio stations show spinner and listening time
-	if m.playingStation != nil {
-		theme := m.settingsManager.GetTheme()
-		
-		// Check if radio is paused
-		if m.audioPlayer.IsPaused() {
-			// Radio is paused - show paused state
-			displayStr := "⏸  Paused"
-			return lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted)).Render(displayStr)
+	return m.artProtocol
+}
+
+// coverFor returns s's cover, or nil when there is none, it hasn't loaded yet,
+// or album art is off.
+func (m model) coverFor(s *Song) *Cover {
+	if s == nil || m.artMode() == "off" {
+		return nil
+	}
+	cover, _ := m.artCache.Cover(*s)
+	return cover
+}
+
+// renderCover lays a cover out as rows lines of cols cells. Half-blocks are
+// drawn inline; for image protocols the block is left blank and zone-marked so
+// syncArt can place the image over it.
+func (m model) renderCover(slot string, cover *Cover, cols, rows int) []string {
+	if m.artMode() == "blocks" {
+		return coverBlocks(cover, cols, rows)
+	}
+	blank := make([]string, rows)
+	for i := range blank {
+		blank[i] = strings.Repeat(" ", cols)
+	}
+	marked := zone.Mark(fmt.Sprintf("art_%s_%d", slot, cover.ID), strings.Join(blank, "\n"))
+	return strings.Split(marked, "\n")
+}
+
+// syncArt loads covers for the playing track and the library selection,
+// updates the cover-derived gradient, and draws, moves or removes protocol
+// images to match the blank blocks renderCover left in the last frame.
+func (m *model) syncArt() tea.Cmd {
+	var cmds []tea.Cmd
+	shown := map[string]*Song{"np": m.playingSong}
+	if m.currentView == "library" && !m.scanning {
+		shown["lib"] = m.libraryBrowser.ArtSong()
+	}
+	if m.artMode() != "off" {
+		for _, s := range shown {
+			if s != nil {
+				cmds = append(cmds, m.artCache.LoadCmd(*s))
+			}
 		}
-		
-		// Calculate listening time (accounting for pause time)
-		var listeningTime time.Duration
-		if m.radioWasPaused {
-			listeningTime = m.radioPausedTime + time.Since(m.radioStartTime)
-		} else {
-			listeningTime = time.Since(m.radioStartTime)
+	}
+
+	start, end := "", ""
+	if cover := m.coverFor(m.playingSong); cover != nil {
+		start, end = cover.Colors[0], cover.Colors[1]
+	}
+	m.settingsManager.SetGradientOverride(start, end)
+
+	if m.artProtocol == "blocks" {
+		return tea.Batch(cmds...)
+	}
+	want := make(map[string]artPlacement)
+	modal := m.searchMode || m.playlistPicker || m.textInputActive || m.tagEditor != nil
+	if m.artMode() == m.artProtocol && !modal {
+		for slot, s := range shown {
+			cover := m.coverFor(s)
+			if cover == nil {
+				continue
+			}
+			z := zone.Get(fmt.Sprintf("art_%s_%d", slot, cover.ID))
+			if z.IsZero() {
+				continue
+			}
+			want[slot] = artPlacement{cover: cover, id: cover.ID, x: z.StartX, y: z.StartY,
+				cols: z.EndX - z.StartX + 1, rows: z.EndY - z.StartY + 1}
+		}
+	}
+
+	var seq strings.Builder
+	for slot, old := range m.artPlaced {
+		if want[slot] == old {
+			continue
+		}
+		if m.artProtocol != "kitty" {
+			// Sixel and iTerm images are pixels in the cells, so removing or
+			// moving one takes a repaint; the next tick redraws what's wanted.
+			m.artPlaced = nil
+			return tea.Batch(append(cmds, tea.ClearScreen)...)
+		}
+		seq.WriteString(deleteCoverSeq(old.id, artSlots[slot]))
+	}
+	for slot, p := range want {
+		if m.artPlaced[slot] != p {
+			seq.WriteString(placeCoverSeq(m.artProtocol, p.cover, artSlots[slot], p.x, p.y, p.cols, p.rows))
+		}
+	}
+	m.artPlaced = want
+	if seq.Len() > 0 {
+		cmds = append(cmds, tea.Raw(seq.String()))
+	}
+	return tea.Batch(cmds...)
+}
+
+// clearArt forgets placed protocol images (deleting kitty's) so the next art
+// tick draws them afresh, e.g. after a resize repaints the screen.
+func (m *model) clearArt() tea.Cmd {
+	var seq strings.Builder
+	if m.artProtocol == "kitty" {
+		for slot, p := range m.artPlaced {
+			seq.WriteString(deleteCoverSeq(p.id, artSlots[slot]))
+		}
+	}
+	m.artPlaced = nil
+	if seq.Len() == 0 {
+		return nil
+	}
+	return tea.Raw(seq.String())
+}
+
+func (m model) renderProgressBar(width int) string {
+	if m.playingSong == nil && m.playingStation == nil {
+		return ""
+	}
+	
+	// Radio stations show spinner and listening time
+	if m.playingStation != nil {
+		theme := m.settingsManager.GetTheme()
+		
+		// Check if radio is paused
+		if m.audioPlayer.IsPaused() {
+			// Radio is paused - show paused state
+			displayStr := "⏸  Paused"
+			return lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted)).Render(displayStr)
+		}
+		
+		// Calculate listening time (accounting for pause time)
+		var listeningTime time.Duration
+		if m.radioWasPaused {
+			listeningTime = m.radioPausedTime + time.Since(m.radioStartTime)
+		} else {
+			listeningTime = time.Since(m.radioStartTime)
 		}
 		timeStr := formatDurationFromSeconds(listeningTime.Seconds())
 		
@@ -1491,52 +2167,71 @@ func (m model) renderProgressBar(width int) string {
 	backgroundStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color(theme.Muted))
 	
-	// Build the progress bar
+	// Local tracks draw their waveform once it's been worked out, so the
+	// loud and quiet parts can be seen (and clicked to); until then, and for
+	// files that can't be decoded, a plain bar.
 	var barParts []string
-	for i := 0; i < barWidth; i++ {
-		if i < actualFilledWidth {
-			// Full filled character
-			if i < len(gradientStyles) {
-				barParts = append(barParts, gradientStyles[i].Render("█"))
-			} else {
-				barParts = append(barParts, gradientStyles[len(gradientStyles)-1].Render("█"))
-			}
-		} else if i == actualFilledWidth && remainder > 0 {
-			// This character position has some virtual progress
-			// Use a partial character based on the remainder
-			var partialChar string
-			if remainder == 1 {
-				partialChar = "▏"
-			} else if remainder == 2 {
-				partialChar = "▎"
-			} else if remainder == 3 {
-				partialChar = "▍"
-			} else if remainder == 4 {
-				partialChar = "▌"
-			} else if remainder == 5 {
-				partialChar = "▋"
-			} else if remainder == 6 {
-				partialChar = "▊"
-			} else if remainder == 7 {
-				partialChar = "▉"
-			} else {
-				partialChar = "█"
-			}
+	if levels := m.waveforms.Levels(m.playingSong.FilePath); levels != nil {
+		barParts = waveformBarCells(levels, barWidth, progress, gradientStyles, backgroundStyle)
+	} else {
+		// Build the progress bar
+		for i := 0; i < barWidth; i++ {
+			if i < actualFilledWidth {
+				// Full filled character
+				if i < len(gradientStyles) {
+					barParts = append(barParts, gradientStyles[i].Render("█"))
+				} else {
+					barParts = append(barParts, gradientStyles[len(gradientStyles)-1].Render("█"))
+				}
+			} else if i == actualFilledWidth && remainder > 0 {
+				// This character position has some virtual progress
+				// Use a partial character based on the remainder
+				var partialChar string
+				if remainder == 1 {
+					partialChar = "▏"
+				} else if remainder == 2 {
+					partialChar = "▎"
+				} else if remainder == 3 {
+					partialChar = "▍"
+				} else if remainder == 4 {
+					partialChar = "▌"
+				} else if remainder == 5 {
+					partialChar = "▋"
+				} else if remainder == 6 {
+					partialChar = "▊"
+				} else if remainder == 7 {
+					partialChar = "▉"
+				} else {
+					partialChar = "█"
+				}
 			
-			var partialStyle lipgloss.Style
-			if i < len(gradientStyles) {
-				partialStyle = gradientStyles[i]
+				var partialStyle lipgloss.Style
+				if i < len(gradientStyles) {
+					partialStyle = gradientStyles[i]
+				} else {
+					partialStyle = gradientStyles[len(gradientStyles)-1]
+				}
+			
+				barParts = append(barParts, partialStyle.Render(partialChar))
 			} else {
-				partialStyle = gradientStyles[len(gradientStyles)-1]
+				// Background character
+				barParts = append(barParts, backgroundStyle.Render("░"))
 			}
-			
-			barParts = append(barParts, partialStyle.Render(partialChar))
-		} else {
-			// Background character
-			barParts = append(barParts, backgroundStyle.Render("░"))
 		}
 	}
-	
+
+	// Show the A-B loop's points, [ and ], over the bar.
+	loopA, loopB := m.audioPlayer.Loop()
+	markStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Highlight)).Bold(true)
+	for _, mark := range []struct {
+		at    float64
+		glyph string
+	}{{loopA, "["}, {loopB, "]"}} {
+		if mark.at >= 0 && duration > 0 {
+			i := clamp(int(mark.at/duration*float64(barWidth)), 0, barWidth-1)
+			barParts[i] = markStyle.Render(mark.glyph)
+		}
+	}
 	bar := strings.Join(barParts, "")
 	
 	timeStyle := lipgloss.NewStyle().
@@ -1555,6 +2250,114 @@ func formatDurationFromSeconds(seconds float64) string {
 	return fmt.Sprintf("%d:%04.1f", minutes, secs)
 }
 
+// itemSubtitle is a library row's subtitle, with the play count and
+// last-played time appended for songs. It's added at render time so the
+// counts stay current without rebuilding the browser's contents.
+func (m model) itemSubtitle(item LibraryItem) string {
+	if item.Type != "song" || item.Song == nil {
+		return item.Subtitle
+	}
+	parts := []string{item.Subtitle}
+	if item.Missing {
+		parts = append(parts, "missing: "+item.Song.FilePath)
+	}
+	if tk := tempoKeyLabel(*item.Song); tk != "" {
+		parts = append(parts, tk)
+	}
+	if marks := m.songMarks(item.Song.FilePath); marks != "" {
+		parts = append(parts, marks)
+	}
+	if plays := m.historyManager.PlayLabel(item.Song.FilePath); plays != "" {
+		parts = append(parts, plays)
+	}
+	if parts[0] == "" {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, " • ")
+}
+
+// songMarks renders a song's favorite heart and star rating, e.g. "♥ ★★★★☆",
+// or "" if it has neither.
+func (m model) songMarks(path string) string {
+	var marks []string
+	if m.libraryManager.IsFavorite(path) {
+		marks = append(marks, "♥")
+	}
+	if stars := m.libraryManager.Rating(path); stars > 0 {
+		marks = append(marks, strings.Repeat("★", stars)+strings.Repeat("☆", 5-stars))
+	}
+	return strings.Join(marks, " ")
+}
+
+// ratingTarget is the song the rating and favorite keys apply to: the playing
+// song when the Now Playing controls are focused or in the Visualizer,
+// otherwise the highlighted library song.
+func (m *model) ratingTarget() *Song {
+	if m.nowPlayingFocused || m.currentView == "visualizer" {
+		return m.playingSong
+	}
+	if m.currentView != "library" || m.libraryBrowser.GetCurrentPane() != "contents" {
+		return nil
+	}
+	contents := m.libraryBrowser.GetContents()
+	if idx := m.libraryBrowser.GetContentIndex(); idx < len(contents) && contents[idx].Type == "song" {
+		return contents[idx].Song
+	}
+	return nil
+}
+
+// rateSong sets a song's star rating (0 clears it). When that setting is on,
+// the returned command also writes it to the file's tags.
+func (m *model) rateSong(s Song, stars int) tea.Cmd {
+	if s.FilePath == "" {
+		return nil
+	}
+	if err := m.libraryManager.SetRating(s.FilePath, stars); err != nil {
+		m.statusFlash = "Couldn't save rating: " + err.Error()
+		return nil
+	}
+	if stars == 0 {
+		m.statusFlash = fmt.Sprintf("Cleared rating of \"%s\"", s.Title)
+	} else {
+		m.statusFlash = fmt.Sprintf("Rated \"%s\" %s", s.Title, strings.Repeat("★", stars))
+	}
+	m.refreshPlaylistListIfShown()
+	if !m.settingsManager.GetSettings().WriteRatings {
+		return nil
+	}
+	return ratingWriteCmd(s, stars)
+}
+
+// ratingWrittenMsg reports a finished ratingWriteCmd.
+type ratingWrittenMsg struct {
+	song Song
+	err  error
+}
+
+// ratingWriteCmd writes a rating to the song's file in the background.
+func ratingWriteCmd(s Song, stars int) tea.Cmd {
+	return func() tea.Msg {
+		return ratingWrittenMsg{song: s, err: writeRating(s.FilePath, stars)}
+	}
+}
+
+// toggleFavorite marks or unmarks a song as a favorite.
+func (m *model) toggleFavorite(s Song) {
+	if s.FilePath == "" {
+		return
+	}
+	fav, err := m.libraryManager.ToggleFavorite(s.FilePath)
+	switch {
+	case err != nil:
+		m.statusFlash = "Couldn't save favorite: " + err.Error()
+	case fav:
+		m.statusFlash = fmt.Sprintf("♥ Added \"%s\" to Favorites", s.Title)
+	default:
+		m.statusFlash = fmt.Sprintf("Removed \"%s\" from Favorites", s.Title)
+	}
+	m.refreshPlaylistListIfShown()
+}
+
 func (m model) renderLibrary() string {
 	// Calculate pane widths
 	totalWidth := m.width
@@ -1613,6 +2416,12 @@ func (m model) renderLibrary() string {
 // enterLibrarySelection plays the currently selected library item (or drills
 // into it). Shared by the Enter key and mouse clicks.
 func (m model) enterLibrarySelection() (model, tea.Cmd) {
+	contents := m.libraryBrowser.GetContents()
+	if idx := m.libraryBrowser.GetContentIndex(); m.libraryBrowser.GetCurrentPane() == "contents" &&
+		idx < len(contents) && contents[idx].Missing {
+		m.statusFlash = "File not found: " + contents[idx].Song.FilePath
+		return m, nil
+	}
 	if song := m.libraryBrowser.EnterSelected(); song != nil {
 		playlist := m.createPlaylistFromContext(song)
 		songIndex := m.findSongInPlaylist(playlist, song)
@@ -1627,13 +2436,103 @@ func (m model) enterLibrarySelection() (model, tea.Cmd) {
 }
 
 // enterFolderSelection opens the currently selected folder entry if it's a
-// directory. Shared by the Enter key and mouse clicks.
-func (m model) enterFolderSelection() {
-	if selected := m.folderBrowser.GetSelected(); selected != "" {
-		if m.folderBrowser.IsDirectory(selected) {
-			m.folderBrowser.EnterDirectory(selected)
+// directory, or imports it if it's a playlist file. Shared by the Enter key
+// and mouse clicks.
+func (m *model) enterFolderSelection() tea.Cmd {
+	selected := m.folderBrowser.GetSelected()
+	switch {
+	case selected == "":
+	case m.folderBrowser.IsDirectory(selected):
+		m.folderBrowser.EnterDirectory(selected)
+	case isPlaylistFile(selected):
+		m.statusFlash = "Loading " + filepath.Base(selected) + "…"
+		return queuePlaylistFileCmd(selected, m.libraryManager.GetSongs())
+	case isSupportedAudio(selected):
+		// Queue the whole directory as listed, starting from this file.
+		files := m.folderBrowser.AudioFiles()
+		start := 0
+		for i, f := range files {
+			if f == selected {
+				start = i
+			}
+		}
+		return queueFilesCmd(files, start, filepath.Base(m.folderBrowser.GetCurrentPath()), m.libraryManager.GetSongs())
+	}
+	return nil
+}
+
+// playFolder queues every audio file under dir, subfolders included, and
+// plays it from the top. The folder is walked and read in the background.
+func (m *model) playFolder(dir string) tea.Cmd {
+	m.statusFlash = "Loading " + filepath.Base(dir) + "…"
+	known := songsByPath(m.libraryManager.GetSongs())
+	return func() tea.Msg {
+		songs := songsForFiles(audioFilesUnder(dir), known)
+		sortByFolder(songs)
+		return folderQueueMsg{songs: songs, label: filepath.Base(dir)}
+	}
+}
+
+// folderQueueMsg carries songs read from the Folder tab, to replace the play
+// queue with.
+type folderQueueMsg struct {
+	songs   []Song
+	start   int    // index of the song to play first
+	label   string // folder or playlist name, for the status line
+	missing int    // playlist entries that couldn't be found
+	err     error
+}
+
+// queueFilesCmd reads the files' tags in the background for a folderQueueMsg,
+// keeping them in the given order. Songs already in the library are taken
+// from it rather than re-read.
+func queueFilesCmd(files []string, start int, label string, library []Song) tea.Cmd {
+	known := songsByPath(library)
+	return func() tea.Msg {
+		return folderQueueMsg{songs: songsForFiles(files, known), start: start, label: label}
+	}
+}
+
+// sortByFolder puts songs in folder order, each folder's in track order.
+func sortByFolder(songs []Song) {
+	sort.SliceStable(songs, func(i, j int) bool {
+		if a, b := filepath.Dir(songs[i].FilePath), filepath.Dir(songs[j].FilePath); a != b {
+			return a < b
+		}
+		return trackLess(songs[i], songs[j])
+	})
+}
+
+// songsByPath indexes songs by file path.
+func songsByPath(songs []Song) map[string]Song {
+	known := make(map[string]Song, len(songs))
+	for _, s := range songs {
+		known[s.FilePath] = s
+	}
+	return known
+}
+
+// songsForFiles returns the songs at the given paths: from known when it has
+// them, otherwise read from the files' tags.
+func songsForFiles(files []string, known map[string]Song) []Song {
+	songs := make([]Song, len(files))
+	for i, f := range files {
+		if s, ok := known[f]; ok {
+			songs[i] = s
+		} else {
+			songs[i] = extractMetadata(f)
 		}
 	}
+	return songs
+}
+
+// queuePlaylistFileCmd reads a playlist file in the background for a
+// folderQueueMsg, matching its entries as importing does.
+func queuePlaylistFileCmd(path string, library []Song) tea.Cmd {
+	return func() tea.Msg {
+		songs, missing, err := importPlaylistFile(path, library)
+		return folderQueueMsg{songs: songs, label: getFilenameWithoutExt(path), missing: missing, err: err}
+	}
 }
 
 // seekFromMouse maps a mouse position within the progress-bar zone to a 0..1
@@ -1655,6 +2554,105 @@ func (m model) seekFromMouse(msg tea.MouseMsg) bool {
 	return true
 }
 
+// seekToLyric seeks to a synced lyric line that was clicked, in the Now
+// Playing pane or the lyrics view.
+func (m model) seekToLyric(msg tea.MouseMsg) bool {
+	l := m.playingLyrics()
+	if l == nil || !l.Synced || !m.audioPlayer.CanSeek() {
+		return false
+	}
+	for _, slot := range []string{"np", "full"} {
+		for i := range l.Lines {
+			if zone.Get(fmt.Sprintf("lyric_%s_%d", slot, i)).InBounds(msg) {
+				m.audioPlayer.SeekTo(l.Lines[i].Time)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stepLyrics moves through the lyrics view: synced lyrics seek to the
+// previous or next line, others scroll.
+func (m *model) stepLyrics(dir int) {
+	l := m.playingLyrics()
+	if l == nil {
+		return
+	}
+	if !l.Synced {
+		m.lyricsScroll = clamp(m.lyricsScroll+dir, 0, len(l.Lines)-1)
+		return
+	}
+	if m.canSeek() {
+		i := clamp(l.Current(m.audioPlayer.GetPosition())+dir, 0, len(l.Lines)-1)
+		m.audioPlayer.SeekTo(l.Lines[i].Time)
+	}
+}
+
+// canSeek reports whether the playing track can be seeked (and looped),
+// saying why not if it can't.
+func (m *model) canSeek() bool {
+	if m.playingSong == nil || !m.audioPlayer.CanSeek() {
+		m.statusFlash = "Seeking works on local tracks only"
+		return false
+	}
+	return true
+}
+
+// seekBy moves the playing track delta seconds forwards or back.
+func (m *model) seekBy(delta float64) {
+	if m.canSeek() {
+		m.audioPlayer.SeekBy(delta)
+	}
+}
+
+// markLoop marks the A-B loop's start (a) or end at the playing position.
+// Marking the end after the start starts the loop; marking the start again
+// moves it.
+func (m *model) markLoop(a bool) {
+	if !m.canSeek() {
+		return
+	}
+	pos := m.audioPlayer.GetPosition()
+	loopA, loopB := m.audioPlayer.Loop()
+	if a {
+		loopA = pos
+		if loopB <= loopA {
+			loopB = -1
+		}
+		m.statusFlash = "Loop from " + formatDurationFromSeconds(pos) + " (] marks the end)"
+	} else {
+		if loopA < 0 {
+			m.statusFlash = "Mark the loop's start with [ first"
+			return
+		}
+		if pos <= loopA {
+			m.statusFlash = "The loop's end has to come after its start"
+			return
+		}
+		loopB = pos
+		m.statusFlash = "Looping " + formatDurationFromSeconds(loopA) + " – " + formatDurationFromSeconds(loopB) + " (\\ to stop)"
+	}
+	m.audioPlayer.SetLoop(loopA, loopB)
+}
+
+// dragPlaylistSong moves the song being dragged to the playlist row under the
+// pointer, if that's a different row.
+func (m *model) dragPlaylistSong(msg tea.MouseMsg) {
+	name := m.libraryBrowser.CurrentPlaylistName()
+	if name == "" || m.currentView != "library" {
+		m.playlistDragRow = -1
+		return
+	}
+	for i := range m.libraryBrowser.GetContents() {
+		if i != m.playlistDragRow && zone.Get(fmt.Sprintf("libitem_%d", i)).InBounds(msg) {
+			m.movePlaylistSong(name, m.playlistDragRow, i)
+			m.playlistDragRow = i
+			return
+		}
+	}
+}
+
 // handleMouseClick routes a left-click to whatever marked zone it lands on:
 // tabs, now-playing controls, or a library/folder row. A click on an
 // already-selected row activates it (play/open), like pressing Enter.
@@ -1669,6 +2667,7 @@ func (m model) handleMouseClick(msg tea.MouseClickMsg) (tea.Model, tea.Cmd) {
 		{"tab_folder", "folder"},
 		{"tab_radio", "radio"},
 		{"tab_visualizer", "visualizer"},
+		{"tab_lyrics", "lyrics"},
 		{"tab_settings", "settings"},
 	} {
 		if zone.Get(t.id).InBounds(msg) {
@@ -1694,6 +2693,11 @@ func (m model) handleMouseClick(msg tea.MouseClickMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	// A synced lyric line seeks to where it's sung.
+	if m.seekToLyric(msg) {
+		return m, nil
+	}
+
 	// Content rows.
 	switch m.currentView {
 	case "library":
@@ -1708,6 +2712,10 @@ func (m model) handleMouseClick(msg tea.MouseClickMsg) (tea.Model, tea.Cmd) {
 				already := m.libraryBrowser.GetCurrentPane() == "contents" &&
 					m.libraryBrowser.GetContentIndex() == i
 				m.libraryBrowser.SetContentIndex(i)
+				if name := m.libraryBrowser.CurrentPlaylistName(); name != "" && !m.playlistManager.IsSmart(name) {
+					// Holding the button down and moving drags the song.
+					m.playlistDragRow = i
+				}
 				if already {
 					return m.enterLibrarySelection()
 				}
@@ -1720,7 +2728,7 @@ func (m model) handleMouseClick(msg tea.MouseClickMsg) (tea.Model, tea.Cmd) {
 				already := m.folderBrowser.GetSelectedIndex() == i
 				m.folderBrowser.SetSelectedIndex(i)
 				if already {
-					m.enterFolderSelection()
+					return m, m.enterFolderSelection()
 				}
 				return m, nil
 			}
@@ -1730,6 +2738,66 @@ func (m model) handleMouseClick(msg tea.MouseClickMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// playStation starts playing a radio station, reporting whether it could.
+func (m *model) playStation(station *RadioStation) bool {
+	playURL := station.StreamURL
+	if playURL == "" {
+		playURL = station.URL
+	}
+	m.endListen(false, true)
+	if m.remote != nil {
+		m.remote.PlayStation(station)
+	} else if err := m.audioPlayer.Play(playURL); err != nil {
+		return false
+	}
+	m.playing = station.Name
+	m.playingSong = nil
+	m.playingStation = station
+	m.radioStartTime = time.Now()
+	m.radioPausedTime = 0
+	m.radioWasPaused = false
+	return true
+}
+
+// togglePause pauses or resumes playback, returning the tick command when
+// playback resumes.
+func (m *model) togglePause() tea.Cmd {
+	wasPaused := m.audioPlayer.IsPaused()
+	m.audioPlayer.TogglePause()
+	
+	// Handle radio pause tracking
+	if m.playingStation != nil {
+		if wasPaused {
+			// Resuming from pause - update pause tracking
+			if m.radioWasPaused {
+				m.radioPausedTime += time.Since(m.radioStartTime)
+			}
+			m.radioStartTime = time.Now()
+		} else {
+			// Pausing - mark as paused
+			m.radioWasPaused = true
+		}
+	}
+	
+	if m.audioPlayer.IsPlaying() {
+		return tickCmd() // Start ticking when resuming
+	}
+	return nil
+}
+
+// stopPlayback stops whatever is playing.
+func (m *model) stopPlayback() {
+	m.endListen(false, false)
+	m.audioPlayer.Stop()
+	m.playing = ""
+	m.playingSong = nil
+	m.playingStation = nil
+	m.radioStartTime = time.Time{}
+	m.radioPausedTime = 0
+	m.radioWasPaused = false
+	m.nowPlayingFocused = false
+}
+
 func (m model) activateControl() (model, tea.Cmd) {
 	switch m.controlSelected {
 	case 0: // Previous
@@ -1738,41 +2806,14 @@ func (m model) activateControl() (model, tea.Cmd) {
 		}
 		return m, nil
 	case 1: // Play/Pause
-		wasPaused := m.audioPlayer.IsPaused()
-		m.audioPlayer.TogglePause()
-		
-		// Handle radio pause tracking
-		if m.playingStation != nil {
-			if wasPaused {
-				// Resuming from pause - update pause tracking
-				if m.radioWasPaused {
-					m.radioPausedTime += time.Since(m.radioStartTime)
-				}
-				m.radioStartTime = time.Now()
-			} else {
-				// Pausing - mark as paused
-				m.radioWasPaused = true
-			}
-		}
-		
-		if m.audioPlayer.IsPlaying() {
-			return m, tickCmd()
-		}
-		return m, nil
+		return m, m.togglePause()
 	case 2: // Next
 		if m.playNextTrack() {
 			return m, tickCmd()
 		}
 		return m, nil
 	case 3: // Stop
-		m.audioPlayer.Stop()
-		m.playing = ""
-		m.playingSong = nil
-		m.playingStation = nil
-		m.radioStartTime = time.Time{}
-		m.radioPausedTime = 0
-		m.radioWasPaused = false
-		m.nowPlayingFocused = false
+		m.stopPlayback()
 		return m, nil
 	}
 	return m, nil
@@ -1841,6 +2882,18 @@ func (m model) getLeftPaneLines(theme Theme) []string {
 		allLines = append(allLines, line)
 	}
 
+	// Cover of the open or highlighted album, sized to the space below the
+	// categories (cells are about twice as tall as wide).
+	if cover := m.coverFor(m.libraryBrowser.ArtSong()); cover != nil {
+		rows := min(11, m.libraryBrowser.GetViewportHeight()-len(allLines)-1)
+		if rows >= 4 {
+			allLines = append(allLines, "")
+			for _, line := range m.renderCover("lib", cover, rows*2, rows) {
+				allLines = append(allLines, " "+line)
+			}
+		}
+	}
+
 	// Apply viewport - for categories, we don't need complex viewport since there are only 3 categories
 	// Just return all lines since the category list is short
 	return allLines
@@ -1881,6 +2934,12 @@ func (m model) getRightPaneLines(theme Theme) []string {
 			icon = "🎵 "
 		case "song":
 			icon = "♪ "
+			if item.Missing {
+				icon = "⚠ "
+			}
+			if item.Song != nil && m.libraryBrowser.IsMarked(i) {
+				icon = "✓ "
+			}
 		default:
 			icon = "  "
 		}
@@ -1914,11 +2973,11 @@ func (m model) getRightPaneLines(theme Theme) []string {
 		allLines = append(allLines, mainLine)
 		
 		// Subtitle line if present
-		if item.Subtitle != "" {
+		if subtitle := m.itemSubtitle(item); subtitle != "" {
 			subtitleStyle := lipgloss.NewStyle().
 				Foreground(lipgloss.Color(theme.Muted)).
 				PaddingLeft(5)
-			subtitleLine := subtitleStyle.Render(item.Subtitle)
+			subtitleLine := subtitleStyle.Render(subtitle)
 			allLines = append(allLines, subtitleLine)
 		}
 	}
@@ -2048,6 +3107,12 @@ func (m model) renderRightPane(width int) string {
 			icon = "🎵 "
 		case "song":
 			icon = "♪ "
+			if item.Missing {
+				icon = "⚠ "
+			}
+			if item.Song != nil && m.libraryBrowser.IsMarked(i) {
+				icon = "✓ "
+			}
 		default:
 			icon = "  "
 		}
@@ -2065,8 +3130,8 @@ func (m model) renderRightPane(width int) string {
 		items = append(items, style.Render(mainText))
 		
 		// Subtitle line if present
-		if item.Subtitle != "" {
-			subtitleText := "    " + item.Subtitle
+		if subtitle := m.itemSubtitle(item); subtitle != "" {
+			subtitleText := "    " + subtitle
 			items = append(items, contentStyle.Render(subtitleText))
 		}
 	}
@@ -2182,8 +3247,15 @@ func (m model) renderSearch(background string) string {
 
 	// Header: query, blank, tabs, blank (4 lines). Footer: help (1 line).
 	// The rest of the content area is available for result rows.
+	// A term that didn't parse is reported in place of the blank line under
+	// the query, so the layout doesn't jump while typing.
+	errLine := ""
+	if m.searchErr != nil {
+		errStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Error))
+		errLine = errStyle.Render(ansi.Truncate("⚠ "+m.searchErr.Error(), innerWidth, "…"))
+	}
 	var resultLines []string
-	resultLines = append(resultLines, queryLine, "", tabStrip, "")
+	resultLines = append(resultLines, queryLine, errLine, tabStrip, "")
 	visible := contentHeight - len(resultLines) - 1 // reserve 1 line for help
 	if visible < 3 {
 		visible = 3
@@ -2194,6 +3266,7 @@ func (m model) renderSearch(background string) string {
 		mutedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
 		if len(m.searchQuery) == 0 {
 			resultLines = append(resultLines, mutedStyle.Render("Type to search "+m.searchCategory+"…"))
+			resultLines = append(resultLines, mutedStyle.Render(ansi.Truncate("Narrow with fields: "+searchSyntaxHelp, maxLineWidth, "…")))
 		} else {
 			resultLines = append(resultLines, mutedStyle.Render("No results found"))
 		}
@@ -2212,11 +3285,27 @@ func (m model) renderSearch(background string) string {
 				prefix = "> "
 			}
 
-			rowText := prefix + kindIcons[r.kind] + " " + r.title
+			// Runs are styled one by one so the matched characters can be
+			// underlined without losing the row's colors after them.
+			plain := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground))
+			hit := plain.Foreground(lipgloss.Color(theme.Primary)).Underline(true)
+			if isSelected {
+				plain = lipgloss.NewStyle().
+					Foreground(lipgloss.Color(theme.Background)).
+					Background(lipgloss.Color(theme.Primary)).
+					Bold(true)
+				hit = plain.Underline(true)
+			}
+			rowText := plain.Render(prefix+kindIcons[r.kind]+" ") + m.highlightSearchMatches(r.title, plain, hit)
 			if r.subtitle != "" {
-				rowText += "  ·  " + r.subtitle
+				rowText += plain.Render("  ·  ") + m.highlightSearchMatches(r.subtitle, plain, hit)
 			}
-			rowText = ansi.Truncate(rowText, maxLineWidth, "…")
+			if r.kind == "song" && r.song != nil {
+				if marks := m.songMarks(r.song.FilePath); marks != "" {
+					rowText += plain.Render("  ·  " + marks)
+				}
+			}
+			rowText = ansi.Truncate(rowText, maxLineWidth, plain.Render("…"))
 
 			style := createGradientStyle(isSelected, innerWidth, theme)
 			resultLines = append(resultLines, style.Render(rowText))
@@ -2235,6 +3324,42 @@ func (m model) renderSearch(background string) string {
 	return m.compositeCentered(background, box, true)
 }
 
+// highlightSearchMatches renders text with the characters the search's free
+// text matched (see fuzzyHits) in hit and the rest in plain.
+func (m model) highlightSearchMatches(text string, plain, hit lipgloss.Style) string {
+	hits := fuzzyHits(text, m.searchText, m.settingsManager.GetSettings().Transliterate)
+	if len(hits) == 0 {
+		return plain.Render(text)
+	}
+	var b strings.Builder
+	var run []rune
+	runHit := false
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		if runHit {
+			b.WriteString(hit.Render(string(run)))
+		} else {
+			b.WriteString(plain.Render(string(run)))
+		}
+		run = run[:0]
+	}
+	for i, r := range []rune(text) {
+		isHit := len(hits) > 0 && hits[0] == i
+		if isHit {
+			hits = hits[1:]
+		}
+		if isHit != runHit {
+			flush()
+			runHit = isHit
+		}
+		run = append(run, r)
+	}
+	flush()
+	return b.String()
+}
+
 // compositeCentered draws box centered over background using lipgloss layers.
 // When dim is true the background is faded first (the modal scrim); when false
 // it is left untouched (e.g. stacking a picker over the already-dimmed search).
@@ -2304,7 +3429,7 @@ func (m model) renderPlaylistPicker(background string, dim bool) string {
 			Render(m.textInputBuffer + "█")
 		lines = append(lines, field, "", mutedStyle.Italic(true).Render("enter create • esc cancel"))
 	} else {
-		names := m.playlistManager.Names()
+		names := m.playlistManager.StaticNames()
 		rows := append([]string{"＋ New playlist…"}, names...)
 		// Scroll window keeping the selection visible.
 		const visible = 8
@@ -2333,6 +3458,66 @@ func (m model) renderPlaylistPicker(background string, dim bool) string {
 	return m.compositeCentered(background, box, dim)
 }
 
+// renderTagEditor draws the edit-tags dialog over the dimmed app: one row per
+// field, the highlighted one editable in place.
+func (m model) renderTagEditor(background string) string {
+	if m.tagEditor == nil || m.width < 8 || m.height < 6 {
+		return background
+	}
+	theme := m.settingsManager.GetTheme()
+	boxWidth := clamp(m.width/2, 44, 72)
+	if boxWidth > m.width-4 {
+		boxWidth = m.width - 4
+	}
+	innerWidth := boxWidth - 4
+
+	boxStyle := lipgloss.NewStyle().
+		Width(boxWidth).
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(lipgloss.Color(theme.Primary)).
+		Padding(1, 2).
+		Background(lipgloss.Color(theme.Background))
+	titleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground)).Bold(true)
+	mutedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
+
+	lines := []string{titleStyle.Render(ansi.Truncate(m.tagEditor.Title(), innerWidth, "…"))}
+	if label := m.tagEditor.SuggestionLabel(); label != "" {
+		lines = append(lines, mutedStyle.Render(label+" from AcoustID; review before saving"))
+	}
+	lines = append(lines, "")
+	const labelWidth = 14
+	for i, f := range m.tagEditor.Fields() {
+		selected := i == m.tagEditor.Selected()
+		value := f.value
+		if f.mixed {
+			value = "(mixed)"
+		}
+		if selected {
+			value = f.value + "█"
+		}
+		label := fmt.Sprintf("%-*s", labelWidth, f.label)
+		if f.value != f.original && !f.mixed {
+			label = fmt.Sprintf("%-*s", labelWidth, f.label+" *")
+		}
+		row := ansi.Truncate(label+value, innerWidth-2, "…")
+		if selected {
+			lines = append(lines, createGradientStyle(true, innerWidth, theme).Render("> "+row))
+		} else if f.mixed {
+			lines = append(lines, mutedStyle.Render("  "+row))
+		} else {
+			lines = append(lines, "  "+row)
+		}
+	}
+	help := "↑↓ field • type to edit • enter save • esc cancel"
+	if m.tagEditor.SuggestionLabel() != "" {
+		help = "↑↓ field • ^N next match • enter save • esc cancel"
+	}
+	lines = append(lines, "", mutedStyle.Italic(true).Render(ansi.Truncate(help, innerWidth, "…")))
+
+	box := boxStyle.Render(strings.Join(lines, "\n"))
+	return m.compositeCentered(background, box, true)
+}
+
 // renderTextPrompt draws the standalone new/rename playlist name prompt (used
 // from the Library, where no picker is open) over the dimmed app.
 func (m model) renderTextPrompt(background string) string {
@@ -2347,8 +3532,22 @@ func (m model) renderTextPrompt(background string) string {
 	innerWidth := boxWidth - 4
 
 	title := "New playlist"
-	if m.textInputPurpose == "rename-playlist" {
+	hint := ""
+	switch m.textInputPurpose {
+	case "rename-playlist":
 		title = "Rename playlist"
+	case "new-smart-playlist":
+		title = "New smart playlist"
+	case "smart-rules":
+		title = "Rules for \"" + m.playlistRenameTarget + "\""
+		hint = smartRulesHelp
+	case "export-playlist":
+		title = "Export \"" + m.playlistRenameTarget + "\" to"
+		paths := "absolute"
+		if m.exportRelative {
+			paths = "relative to the playlist file"
+		}
+		hint = "Paths: " + paths + " (tab to switch)\nFormat follows the extension: .m3u, .m3u8, .pls or .xspf"
 	}
 	boxStyle := lipgloss.NewStyle().
 		Width(boxWidth).
@@ -2363,10 +3562,14 @@ func (m model) renderTextPrompt(background string) string {
 		Render(m.textInputBuffer + "█")
 	help := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted)).Italic(true).
 		Render("enter confirm • esc cancel")
-	box := boxStyle.Render(strings.Join([]string{
+	lines := []string{
 		lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground)).Bold(true).Render(title),
-		"", field, "", help,
-	}, "\n"))
+		"", field, "",
+	}
+	if hint != "" {
+		lines = append(lines, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted)).Width(innerWidth).Render(hint), "")
+	}
+	box := boxStyle.Render(strings.Join(append(lines, help), "\n"))
 	return m.compositeCentered(background, box, true)
 }
 
@@ -2479,9 +3682,102 @@ func min(a, b int) int {
 }
 
 // Playlist management functions
+// setPlaylist replaces the play queue, ending any mix.
 func (m *model) setPlaylist(songs []Song, startIndex int) {
 	m.currentPlaylist = songs
 	m.currentTrackIndex = startIndex
+	m.queueVersion++
+	m.queueIDs = m.newQueueIDs(len(songs))
+	m.mix = nil
+}
+
+// newQueueIDs numbers n new queue entries.
+func (m *model) newQueueIDs(n int) []int {
+	ids := make([]int, n)
+	for i := range ids {
+		m.lastQueueID++
+		ids[i] = m.lastQueueID
+	}
+	return ids
+}
+
+// queuePosOfID finds the queue entry with an id, or returns -1.
+func (m *model) queuePosOfID(id int) int {
+	for i, qid := range m.queueIDs {
+		if qid == id && i < len(m.currentPlaylist) {
+			return i
+		}
+	}
+	return -1
+}
+
+// startMix replaces the queue with an endless mix and starts playing it: from
+// the seed song if there is one, otherwise from the mix's first pick.
+func (m *model) startMix(mix *Mix, seed *Song) bool {
+	var queue []Song
+	if seed != nil {
+		queue = []Song{*seed}
+	}
+	m.setPlaylist(queue, 0)
+	m.mix = mix
+	m.topUpMix()
+	if len(m.currentPlaylist) == 0 {
+		m.mix = nil
+		return false
+	}
+	m.statusFlash = mix.Label()
+	return m.playCurrentTrack()
+}
+
+// topUpMix keeps mixLookahead songs queued after the current one while a mix
+// is playing.
+func (m *model) topUpMix() {
+	if m.mix == nil {
+		return
+	}
+	if ahead := len(m.currentPlaylist) - 1 - m.currentTrackIndex; ahead < mixLookahead {
+		more := m.mix.Next(m.libraryManager.GetSongs(), mixLookahead-ahead, m.libraryManager)
+		m.currentPlaylist = append(m.currentPlaylist, more...)
+		m.queueIDs = append(m.queueIDs, m.newQueueIDs(len(more))...)
+		m.queueVersion++
+	}
+}
+
+// insertSongs puts songs into the queue at pos, keeping the current song
+// current.
+func (m *model) insertSongs(pos int, songs []Song) {
+	queue := make([]Song, 0, len(m.currentPlaylist)+len(songs))
+	queue = append(queue, m.currentPlaylist[:pos]...)
+	queue = append(queue, songs...)
+	m.currentPlaylist = append(queue, m.currentPlaylist[pos:]...)
+	ids := append(m.queueIDs[:pos:pos], m.newQueueIDs(len(songs))...)
+	m.queueIDs = append(ids, m.queueIDs[pos:]...)
+	if pos <= m.currentTrackIndex && len(m.currentPlaylist) > len(songs) {
+		m.currentTrackIndex += len(songs)
+	}
+	m.queueVersion++
+}
+
+// removeSongs takes songs start to end (exclusive) out of the queue. If the
+// playing song goes, the one after it plays instead.
+func (m *model) removeSongs(start, end int) tea.Cmd {
+	m.currentPlaylist = append(m.currentPlaylist[:start:start], m.currentPlaylist[end:]...)
+	m.queueIDs = append(m.queueIDs[:start:start], m.queueIDs[end:]...)
+	m.queueVersion++
+	switch {
+	case m.currentTrackIndex >= end:
+		m.currentTrackIndex -= end - start
+	case m.currentTrackIndex >= start:
+		m.currentTrackIndex = start
+		if m.playingSong == nil {
+			break
+		}
+		if m.playCurrentTrack() {
+			return tickCmd()
+		}
+		m.stopPlayback()
+	}
+	return nil
 }
 
 func (m *model) hasNextTrack() bool {
@@ -2511,9 +3807,23 @@ func (m *model) playPreviousTrack() bool {
 func (m *model) playCurrentTrack() bool {
 	if m.currentTrackIndex >= 0 && m.currentTrackIndex < len(m.currentPlaylist) {
 		song := m.currentPlaylist[m.currentTrackIndex]
+		m.endListen(false, true)
+		if m.remote != nil {
+			// The daemon plays the queue, and keeps the history.
+			m.topUpMix()
+			m.remote.PlayQueue(m.currentPlaylist, m.currentTrackIndex, m.queueVersion)
+			m.playing = song.Title
+			m.playingSong = &song
+			m.playingStation = nil
+			return true
+		}
 		if err := m.audioPlayer.Play(song.FilePath); err == nil {
 			m.playing = song.Title
 			m.playingSong = &song
+			now := time.Now()
+			m.listen = &listenSession{song: song, started: now, lastTick: now}
+			m.scrobbler.NowPlaying(scrobbleTrackFor(song, now))
+			m.topUpMix()
 			// Reset radio variables when switching to library playback
 			m.playingStation = nil
 			m.radioStartTime = time.Time{}
@@ -2526,6 +3836,86 @@ func (m *model) playCurrentTrack() bool {
 	return false
 }
 
+// updateScrobbles scrobbles the playing song once it has been listened to
+// long enough, and follows the titles a radio stream sends: each new
+// "Artist - Title" is sent as now playing, and scrobbled as radioListen
+// describes.
+func (m *model) updateScrobbles(now time.Time) {
+	if ls := m.listen; ls != nil && !ls.scrobbled {
+		if need, ok := scrobbleThreshold(ls.song.DurationSecs); ok && ls.listened >= need {
+			ls.scrobbled = true
+			m.scrobbler.Scrobble(scrobbleTrackFor(ls.song, ls.started))
+		}
+	}
+
+	if m.playingStation == nil {
+		m.radioListen = nil
+		return
+	}
+	playing := m.audioPlayer.IsPlaying()
+	title := m.audioPlayer.StreamTitle()
+	rl := m.radioListen
+	if rl != nil && rl.station == m.playingStation.Name && rl.title == title {
+		rl.advance(playing, now)
+		if !rl.scrobbled && rl.listened >= scrobbleMaxWait {
+			m.scrobbleRadio(rl)
+		}
+		return
+	}
+	// A new title, or a new station.
+	sameStation := rl != nil && rl.station == m.playingStation.Name
+	if sameStation {
+		rl.advance(playing, now)
+		if !rl.scrobbled && rl.fromStart && rl.listened >= scrobbleMinLength {
+			m.scrobbleRadio(rl)
+		}
+	}
+	m.radioListen = &radioListen{
+		listenSession: listenSession{started: now, lastTick: now},
+		station:       m.playingStation.Name,
+		title:         title,
+		fromStart:     sameStation && rl.title != "",
+	}
+	if t, ok := m.radioListen.track(); ok {
+		m.scrobbler.NowPlaying(t)
+	}
+}
+
+func (m *model) scrobbleRadio(rl *radioListen) {
+	rl.scrobbled = true
+	if t, ok := rl.track(); ok {
+		m.scrobbler.Scrobble(t)
+	}
+}
+
+// endListen records the current listen in the play history. completed means
+// the track played to the end; skipped means something else was started
+// before it did.
+func (m *model) endListen(completed, skipped bool) {
+	ls := m.listen
+	if ls == nil {
+		return
+	}
+	m.listen = nil
+	ls.advance(m.audioPlayer.IsPlaying(), time.Now())
+	// Under a second is a mis-press or a track being skipped straight past.
+	if ls.listened < time.Second {
+		return
+	}
+	m.historyManager.Record(PlayEvent{
+		Time:      ls.started,
+		Path:      ls.song.FilePath,
+		Title:     ls.song.Title,
+		Artist:    ls.song.Artist,
+		Album:     ls.song.Album,
+		Genre:     ls.song.Genre,
+		Duration:  ls.song.DurationSecs,
+		Listened:  ls.listened.Seconds(),
+		Completed: completed,
+		Skipped:   skipped && !completed,
+	})
+}
+
 func (m *model) isTrackFinished() bool {
 	if m.playingSong == nil {
 		return false
@@ -2597,7 +3987,10 @@ func (m *model) getCurrentCategorySongs() []Song {
 				albumJ = "Unknown Album"
 			}
 			if albumI == albumJ {
-				return strings.ToLower(allSongs[i].Title) < strings.ToLower(allSongs[j].Title)
+				if artistI, artistJ := albumArtistOf(allSongs[i]), albumArtistOf(allSongs[j]); artistI != artistJ {
+					return strings.ToLower(artistI) < strings.ToLower(artistJ)
+				}
+				return trackLess(allSongs[i], allSongs[j])
 			}
 			return strings.ToLower(albumI) < strings.ToLower(albumJ)
 		})
@@ -2657,44 +4050,18 @@ func (m *model) getSongsFromCurrentContext() []Song {
 		return filteredSongs
 	} else if len(breadcrumb) == 2 {
 		// Album level
-		categoryType := m.libraryBrowser.GetCategoryType()
 		context1 := breadcrumb[0] // Artist or Genre
 		context2 := breadcrumb[1] // Album
 		
 		var filteredSongs []Song
 		for _, song := range allSongs {
-			switch categoryType {
-			case "artists":
-				artist := song.Artist
-				if artist == "" {
-					artist = "Unknown Artist"
-				}
-				album := song.Album
-				if album == "" {
-					album = "Unknown Album"
-				}
-				if artist == context1 && album == context2 {
-					filteredSongs = append(filteredSongs, song)
-				}
+			if m.libraryBrowser.albumMember(song, context2, context1) {
+				filteredSongs = append(filteredSongs, song)
 			}
 		}
 		
-		// Sort album songs by track number if available, otherwise by title
-		sort.Slice(filteredSongs, func(i, j int) bool {
-			// If both songs have track numbers, sort by track number
-			if filteredSongs[i].TrackNumber > 0 && filteredSongs[j].TrackNumber > 0 {
-				return filteredSongs[i].TrackNumber < filteredSongs[j].TrackNumber
-			}
-			// If only one has a track number, prioritize it
-			if filteredSongs[i].TrackNumber > 0 && filteredSongs[j].TrackNumber == 0 {
-				return true
-			}
-			if filteredSongs[i].TrackNumber == 0 && filteredSongs[j].TrackNumber > 0 {
-				return false
-			}
-			// If neither has track numbers, sort alphabetically by title
-			return strings.ToLower(filteredSongs[i].Title) < strings.ToLower(filteredSongs[j].Title)
-		})
+		// Sort album songs by disc and track number, otherwise by title
+		sortByTrack(filteredSongs)
 		
 		return filteredSongs
 	}
@@ -2743,8 +4110,8 @@ var searchCategoryLabels = map[string]string{
 
 // groupKey returns the bucket key for a song under the given grouping ("artist",
 // "album", "genre"). The album key mirrors LibraryBrowser.getAlbums
-// ("Album - Artist"), and empty tags fall back to the same "Unknown …" labels
-// the library browser shows.
+// ("Album - AlbumArtist"), and empty tags fall back to the same "Unknown …"
+// labels the library browser shows.
 func groupKey(s Song, kind string) string {
 	switch kind {
 	case "artist":
@@ -2757,7 +4124,7 @@ func groupKey(s Song, kind string) string {
 		if album == "" {
 			album = "Unknown Album"
 		}
-		return album + " - " + s.Artist
+		return album + " - " + albumArtistOf(s)
 	case "genre":
 		if s.Genre == "" {
 			return "Unknown Genre"
@@ -2896,50 +4263,280 @@ func (m *model) openPlaylistPicker() bool {
 		return false
 	}
 
-	m.playlistPickerSongs = songs
-	m.playlistPickerLabel = label
-	m.playlistPickerSource = source
-	m.playlistPicker = true
-	m.playlistPickerIndex = 0
-	return true
+	m.playlistPickerSongs = songs
+	m.playlistPickerLabel = label
+	m.playlistPickerSource = source
+	m.playlistPicker = true
+	m.playlistPickerIndex = 0
+	return true
+}
+
+// handlePlaylistPickerKey drives the modal add-to-playlist picker.
+func (m model) handlePlaylistPickerKey(keyStr string) (tea.Model, tea.Cmd) {
+	names := m.playlistManager.StaticNames()
+	total := len(names) + 1 // +1 for "＋ New playlist…"
+	switch keyStr {
+	case "esc":
+		m.playlistPicker = false
+	case "up":
+		if m.playlistPickerIndex > 0 {
+			m.playlistPickerIndex--
+		}
+	case "down":
+		if m.playlistPickerIndex < total-1 {
+			m.playlistPickerIndex++
+		}
+	case "tab":
+		if m.playlistPickerSource == "search" {
+			if m.playlistPickerScope == "all" {
+				m.playlistPickerScope = "selected"
+			} else {
+				m.playlistPickerScope = "all"
+			}
+			m.playlistPickerSongs = m.songsToAdd()
+		}
+	case "enter":
+		if m.playlistPickerIndex == 0 {
+			m.startTextInput("new-playlist-add", "")
+		} else {
+			name := names[m.playlistPickerIndex-1]
+			added, _ := m.playlistManager.AddSongs(name, m.playlistPickerSongs)
+			m.statusFlash = fmt.Sprintf("Added %d to \"%s\"", added, name)
+			m.playlistPicker = false
+			m.refreshPlaylistListIfShown()
+		}
+	}
+	return m, nil
+}
+
+// handleTagEditorKey drives the modal edit-tags dialog.
+func (m model) handleTagEditorKey(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.tagEditor = nil
+	case "up", "shift+tab":
+		m.tagEditor.MoveUp()
+	case "down", "tab":
+		m.tagEditor.MoveDown()
+	case "backspace":
+		m.tagEditor.Backspace()
+	case "ctrl+n":
+		m.tagEditor.NextSuggestion()
+	case "enter":
+		cmd := tagEditCmd(m.tagEditor)
+		m.tagEditor = nil
+		m.statusFlash = "Writing tags..."
+		return m, cmd
+	default:
+		if msg.Text != "" {
+			m.tagEditor.AddText(msg.Text)
+		}
+	}
+	return m, nil
+}
+
+// saveTagEdits takes in the files rewritten by tagEditCmd, refreshing the
+// library, the play queue and the visible list. Playlists pick up the new tags
+// from the library.
+func (m *model) saveTagEdits(msg tagEditDoneMsg) {
+	m.statusFlash = ""
+	if len(msg.songs) > 0 {
+		m.statusFlash = "Updated tags on " + playlistCountLabel(len(msg.songs))
+		if err := m.updateSongs(msg.songs); err != nil {
+			m.statusFlash = "Tags written, but couldn't save the library: " + err.Error()
+		}
+	}
+	if msg.err != nil {
+		m.statusFlash = "Couldn't write tags: " + msg.err.Error()
+	}
+}
+
+// updateSongs stores changed songs in the library and refreshes the play
+// queue, the playing song and the visible list to match. The error is from
+// saving the library; the in-memory state is updated regardless.
+func (m *model) updateSongs(updated []Song) error {
+	err := m.libraryManager.UpdateSongs(updated)
+	byPath := make(map[string]Song, len(updated))
+	for _, s := range updated {
+		byPath[s.FilePath] = s
+	}
+	for i, s := range m.currentPlaylist {
+		if u, ok := byPath[s.FilePath]; ok {
+			m.currentPlaylist[i] = u
+		}
+	}
+	if m.playingSong != nil {
+		if u, ok := byPath[m.playingSong.FilePath]; ok {
+			m.playingSong = &u
+			m.playing = u.Title
+		}
+	}
+	m.libraryBrowser.Reload()
+	return err
+}
+
+// tempoKeyTargets returns the songs B analyzes and tags: those of the
+// highlighted library row, or the playing song.
+func (m *model) tempoKeyTargets() []Song {
+	if m.currentView == "library" && !m.nowPlayingFocused {
+		return m.libraryBrowser.SongsForSelected()
+	}
+	if m.playingSong != nil && m.playingSong.FilePath != "" {
+		return []Song{*m.playingSong}
+	}
+	return nil
+}
+
+// resolveDuplicates removes the extra copies in the highlighted duplicate
+// group, or in every group when all is set, and points playlists and the play
+// queue at the copy being kept. Extras are hidden from the library (so rescans
+// skip them), or deleted from disk when deleteFiles is set.
+func (m *model) resolveDuplicates(all, deleteFiles bool) {
+	groups := m.settingsBrowser.GetDuplicateGroups()
+	if !all {
+		g, _ := m.settingsBrowser.SelectedDuplicate()
+		if g < 0 {
+			return
+		}
+		groups = groups[g : g+1]
+	}
+
+	replace := make(map[string]Song)
+	var paths []string
+	var failed error
+	for _, g := range groups {
+		keep := g.Songs[g.Keep]
+		for _, s := range g.Extras() {
+			if deleteFiles {
+				if err := os.Remove(s.FilePath); err != nil && !os.IsNotExist(err) {
+					failed = err
+					continue
+				}
+			}
+			replace[s.FilePath] = keep
+			paths = append(paths, s.FilePath)
+		}
+	}
+	if len(paths) == 0 && failed == nil {
+		return
+	}
+
+	if deleteFiles {
+		m.libraryManager.RemoveSongs(paths)
+	} else {
+		m.libraryManager.HideSongs(paths)
+	}
+	m.playlistManager.ReplaceSongs(replace)
+	for i, s := range m.currentPlaylist {
+		if keep, ok := replace[s.FilePath]; ok {
+			m.currentPlaylist[i] = keep
+		}
+	}
+	m.libraryBrowser.Reload()
+	m.settingsBrowser.RefreshDuplicates()
+
+	verb := "Hid"
+	if deleteFiles {
+		verb = "Deleted"
+	}
+	m.statusFlash = fmt.Sprintf("%s %d duplicate copies", verb, len(paths))
+	if failed != nil {
+		m.statusFlash = "Couldn't delete: " + failed.Error()
+	}
+}
+
+// identifySelected starts an AcoustID lookup for the highlighted song.
+func (m *model) identifySelected() tea.Cmd {
+	contents := m.libraryBrowser.GetContents()
+	idx := m.libraryBrowser.GetContentIndex()
+	if m.libraryBrowser.GetCurrentPane() != "contents" || idx >= len(contents) || contents[idx].Song == nil || contents[idx].Song.FilePath == "" {
+		m.statusFlash = "Highlight a song to identify it"
+		return nil
+	}
+	settings := m.settingsManager.GetSettings()
+	if settings.AcoustIDKey == "" {
+		m.statusFlash = "Set acoustid_key in ~/.resona/settings.json to look up songs"
+		return nil
+	}
+	endpoint := settings.AcoustIDURL
+	if endpoint == "" {
+		endpoint = defaultAcoustIDURL
+	}
+	song := *contents[idx].Song
+	m.statusFlash = "Identifying " + song.Title + "…"
+	return identifyCmd(song, m.libraryManager.Fingerprints(), endpoint, settings.AcoustIDKey)
+}
+
+// startRescan rescans all library folders on a background goroutine, unless
+// a scan is already running.
+func (m *model) startRescan() tea.Cmd {
+	folders := m.libraryManager.GetFolders()
+	if m.scanning || len(folders) == 0 {
+		return nil
+	}
+	m.scanning = true
+	m.scanState = &scanState{}
+	m.scanPercent = 0
+	m.scanDone, m.scanTotal = 0, 0
+	m.scanLabel = "Rescanning library…"
+	return tea.Batch(startScanCmd(folders, "rescan", "", m.scanState, m.scanOptions()), scanTickCmd())
 }
 
-// handlePlaylistPickerKey drives the modal add-to-playlist picker.
-func (m model) handlePlaylistPickerKey(keyStr string) (tea.Model, tea.Cmd) {
-	names := m.playlistManager.Names()
-	total := len(names) + 1 // +1 for "＋ New playlist…"
-	switch keyStr {
-	case "esc":
-		m.playlistPicker = false
-	case "up":
-		if m.playlistPickerIndex > 0 {
-			m.playlistPickerIndex--
-		}
-	case "down":
-		if m.playlistPickerIndex < total-1 {
-			m.playlistPickerIndex++
-		}
-	case "tab":
-		if m.playlistPickerSource == "search" {
-			if m.playlistPickerScope == "all" {
-				m.playlistPickerScope = "selected"
-			} else {
-				m.playlistPickerScope = "all"
-			}
-			m.playlistPickerSongs = m.songsToAdd()
-		}
-	case "enter":
-		if m.playlistPickerIndex == 0 {
-			m.startTextInput("new-playlist-add", "")
-		} else {
-			name := names[m.playlistPickerIndex-1]
-			added, _ := m.playlistManager.AddSongs(name, m.playlistPickerSongs)
-			m.statusFlash = fmt.Sprintf("Added %d to \"%s\"", added, name)
-			m.playlistPicker = false
-			m.refreshPlaylistListIfShown()
+// scanOptions returns the extras a scan should do, per the settings. The
+// analysis of songs already in the library is handed over so only new audio is
+// analyzed.
+func (m *model) scanOptions() scanOptions {
+	return scanOptionsFor(m.settingsManager.GetSettings(), m.libraryManager)
+}
+
+// scanOptionsFor is scanOptions outside the TUI, for `resona library rescan`.
+func scanOptionsFor(settings Settings, library *LibraryManager) scanOptions {
+	opts := scanOptions{analyze: settings.Analyze}
+	if settings.Fingerprints {
+		opts.fingerprints = library.Fingerprints()
+	}
+	opts.known = make(map[string]Song)
+	for _, s := range library.GetSongs() {
+		if s.Analyzed && s.ContentHash != "" {
+			opts.known[s.ContentHash] = s
 		}
 	}
-	return m, nil
+	return opts
+}
+
+// editablePlaylist returns the open playlist's name if its songs can be
+// edited by hand. For a smart or built-in playlist it explains why not and
+// returns "".
+func (m *model) editablePlaylist() string {
+	if m.currentView != "library" || m.nowPlayingFocused {
+		return ""
+	}
+	name := m.libraryBrowser.CurrentPlaylistName()
+	switch {
+	case name == "":
+	case IsBuiltin(name):
+		m.statusFlash = fmt.Sprintf("\"%s\" is built in; change the song's rating or favorite instead", name)
+		name = ""
+	case m.playlistManager.IsSmart(name):
+		m.statusFlash = "Smart playlists follow their rules; press R to edit them"
+		name = ""
+	}
+	return name
+}
+
+// movePlaylistSong moves a song within the open playlist and keeps it
+// highlighted. Used by K/J and by dragging rows with the mouse.
+func (m *model) movePlaylistSong(name string, from, to int) {
+	if to < 0 || to >= len(m.libraryBrowser.GetContents()) || from == to {
+		return
+	}
+	if err := m.playlistManager.MoveSong(name, from, to); err != nil {
+		m.statusFlash = err.Error()
+		return
+	}
+	m.libraryBrowser.MoveMarked(from, to)
+	m.libraryBrowser.Reload()
+	m.libraryBrowser.SetContentIndex(to)
 }
 
 // refreshPlaylistListIfShown rebuilds the library's playlist list, but only when
@@ -2998,7 +4595,70 @@ func (m *model) commitTextInput() {
 			m.statusFlash = fmt.Sprintf("Renamed to \"%s\"", name)
 		}
 		m.refreshPlaylistListIfShown()
+	case "new-smart-playlist":
+		if m.playlistManager.Get(name) != nil {
+			m.statusFlash = fmt.Sprintf("\"%s\" already exists", name)
+			return
+		}
+		m.playlistRenameTarget = name
+		m.startTextInput("smart-rules", "")
+	case "smart-rules":
+		// name holds the rules here. Bad rules reopen the prompt so they can
+		// be fixed rather than retyped.
+		spec, err := parseSmartSpec(name)
+		if err != nil {
+			m.statusFlash = "Rules: " + err.Error()
+			m.startTextInput("smart-rules", name)
+			return
+		}
+		if err := m.playlistManager.SetSmart(m.playlistRenameTarget, spec); err != nil {
+			m.statusFlash = err.Error()
+			return
+		}
+		m.statusFlash = fmt.Sprintf("\"%s\" matches %s", m.playlistRenameTarget,
+			playlistCountLabel(len(m.playlistManager.SongsOf(m.playlistRenameTarget))))
+		if m.libraryBrowser.CurrentPlaylistName() == m.playlistRenameTarget {
+			m.libraryBrowser.drillDownToPlaylist(m.playlistRenameTarget)
+		} else {
+			m.refreshPlaylistListIfShown()
+		}
+	case "export-playlist":
+		// name holds the destination path here.
+		path := name
+		if strings.HasPrefix(path, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, path[2:])
+			}
+		}
+		songs := m.playlistManager.SongsOf(m.playlistRenameTarget)
+		if err := exportPlaylist(m.playlistRenameTarget, songs, path, m.exportRelative); err != nil {
+			m.statusFlash = "Export failed: " + err.Error()
+			return
+		}
+		m.statusFlash = fmt.Sprintf("Exported %s to %s", playlistCountLabel(len(songs)), path)
+	}
+}
+
+// importPlaylist reads a playlist file into a new playlist named after the
+// file, matching its entries against the library.
+func (m *model) importPlaylist(path string) {
+	songs, missing, err := importPlaylistFile(path, m.libraryManager.GetSongs())
+	if err != nil {
+		m.statusFlash = "Import failed: " + err.Error()
+		return
+	}
+	if len(songs) == 0 {
+		m.statusFlash = fmt.Sprintf("No tracks in %s could be found", filepath.Base(path))
+		return
+	}
+	name := m.playlistManager.UniqueName(getFilenameWithoutExt(path))
+	added, err := m.playlistManager.AddSongs(name, songs)
+	if err != nil {
+		m.statusFlash = "Import failed: " + err.Error()
+		return
 	}
+	m.statusFlash = fmt.Sprintf("Imported %d of %d tracks into \"%s\"", added, len(songs)+missing, name)
+	m.refreshPlaylistListIfShown()
 }
 
 // performSearch scores every song against the query (across title/artist/album)
@@ -3006,26 +4666,46 @@ func (m *model) commitTextInput() {
 // query filters songs and we group the survivors by that dimension — so typing
 // an artist name and Tab-ing to Albums lists that artist's albums, not albums
 // whose title happens to match. A group also appears if its own label matches
-// (e.g. "jazz" in Genres), in which case the whole group is queued.
+// (e.g. "jazz" in Genres), in which case the whole group is queued. Field
+// terms (artist:radiohead, year:>2000, -live; see parseSearchTerms) filter
+// before the free text is ranked; a term that doesn't parse is left out and
+// its error kept in searchErr for renderSearch.
 func (m *model) performSearch(query string) {
 	m.searchSelected = 0
 	m.searchResults = nil
+	m.searchErr = nil
+	m.searchText = ""
 	if len(query) == 0 {
 		return
 	}
 
-	q := strings.ToLower(query)
+	translit := m.settingsManager.GetSettings().Transliterate
+	terms, err := parseSearchTerms(query, translit)
+	m.searchErr = err
+	q := terms.text
+	m.searchText = q
+	if q == "" && !terms.active() {
+		return
+	}
 	allSongs := m.libraryManager.GetSongs()
+	now := time.Now()
 
 	// The genre-scoped categories match a song's genre; the rest match
 	// title/artist/album. Score each song once with the right target.
+	// Field terms only filter; on their own every song they let through
+	// matches.
 	byGenre := m.searchCategory == "genre-songs" || m.searchCategory == "genre-albums"
 	scores := make([]int, len(allSongs))
 	for i := range allSongs {
-		if byGenre {
-			scores[i] = fuzzyScore(allSongs[i].Genre, q)
+		if terms.active() && !terms.matches(allSongs[i], m.libraryManager, m.historyManager, now) {
+			continue
+		}
+		if q == "" {
+			scores[i] = 1
+		} else if byGenre {
+			scores[i] = fuzzyScore(allSongs[i].Genre, q, translit)
 		} else {
-			scores[i] = scoreSong(allSongs[i], q)
+			scores[i] = scoreSong(allSongs[i], q, translit)
 		}
 	}
 
@@ -3087,9 +4767,10 @@ func (m *model) performSearch(query string) {
 	for key, b := range buckets {
 		score := b.best
 		// A group can also surface if its own label matches (e.g. "jazz" in
-		// Genres). Genre-albums are matched purely on the genre field above.
-		if !byGenre {
-			if ls := fuzzyScore(groupLabel(kind, key), q); ls > score {
+		// Genres). Genre-albums are matched purely on the genre field above,
+		// and with field terms only the songs they let through count.
+		if !byGenre && q != "" && !terms.active() {
+			if ls := fuzzyScore(groupLabel(kind, key), q, translit); ls > score {
 				score = ls
 			}
 		}
@@ -3138,37 +4819,73 @@ func sortTrim(results []searchResult) []searchResult {
 
 // scoreSong ranks a song against the query across its fields, weighting the
 // title above the artist above the album. Returns 0 when nothing matches.
-func scoreSong(s Song, query string) int {
+func scoreSong(s Song, query string, translit bool) int {
 	best := 0
-	if v := fuzzyScore(s.Title, query); v > 0 {
+	if v := fuzzyScore(s.Title, query, translit); v > 0 {
 		best = max(best, v+50)
 	}
-	if v := fuzzyScore(s.Artist, query); v > 0 {
+	if v := fuzzyScore(s.Artist, query, translit); v > 0 {
 		best = max(best, v+20)
 	}
-	if v := fuzzyScore(s.Album, query); v > 0 {
+	if v := fuzzyScore(s.Album, query, translit); v > 0 {
 		best = max(best, v)
 	}
 	return best
 }
 
-// fuzzyScore ranks how well query (already lowercased by the caller) matches
-// text. It returns 0 when query is not a subsequence of text. Higher is better:
-// exact and prefix matches dominate, then contiguous substrings, then
-// word-boundary starts, then loose subsequence matches with an adjacency bonus.
-func fuzzyScore(text, query string) int {
+// fuzzyScore ranks how well query (already folded by the caller, see
+// foldString) matches text. It returns 0 when query is not a subsequence of
+// text. Higher is better: exact and prefix matches dominate, then contiguous
+// substrings, then word-boundary starts, then loose subsequence matches with
+// an adjacency bonus. translit says whether text is folded with
+// transliteration, as the query was.
+func fuzzyScore(text, query string, translit bool) int {
 	if query == "" {
 		return 0
 	}
-	lt := strings.ToLower(text)
+	return fuzzyMatch(foldText(text, translit), []rune(query), nil)
+}
 
-	if lt == query {
-		return 1000
+// fuzzyHits returns which runes of text (indexes into []rune(text)) the query
+// matched, for highlighting; nil when it doesn't match.
+func fuzzyHits(text, query string, translit bool) []int {
+	if query == "" {
+		return nil
+	}
+	ft := foldText(text, translit)
+	var matched []int
+	if fuzzyMatch(ft, []rune(query), &matched) == 0 {
+		return nil
+	}
+	var hits []int
+	for _, i := range matched {
+		if n := len(hits); n == 0 || hits[n-1] != ft.src[i] {
+			hits = append(hits, ft.src[i])
+		}
 	}
-	if strings.HasPrefix(lt, query) {
-		return 800 + lengthBonus(lt)
+	return hits
+}
+
+// fuzzyMatch scores folded text against query for fuzzyScore. When matched
+// isn't nil it also collects the positions in ft.runes that matched.
+func fuzzyMatch(ft foldedText, query []rune, matched *[]int) int {
+	lt := ft.runes
+	span := func(start int) {
+		if matched != nil {
+			for i := start; i < start+len(query); i++ {
+				*matched = append(*matched, i)
+			}
+		}
 	}
-	if idx := strings.Index(lt, query); idx >= 0 {
+
+	if idx := runeIndex(lt, query); idx >= 0 {
+		span(idx)
+		switch {
+		case idx == 0 && len(lt) == len(query):
+			return 1000
+		case idx == 0:
+			return 800 + lengthBonus(lt)
+		}
 		score := 500
 		if isBoundary(lt, idx) {
 			score += 100
@@ -3188,6 +4905,9 @@ func fuzzyScore(text, query string) int {
 			if isBoundary(lt, ti) {
 				score += 3
 			}
+			if matched != nil {
+				*matched = append(*matched, ti)
+			}
 			qi++
 			prevMatched = true
 		} else {
@@ -3201,13 +4921,23 @@ func fuzzyScore(text, query string) int {
 	return score
 }
 
+// runeIndex returns the index of the first occurrence of sub in text, or -1.
+func runeIndex(text, sub []rune) int {
+	for i := 0; i+len(sub) <= len(text); i++ {
+		if slices.Equal(text[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
 // isBoundary reports whether position i in text begins a word.
-func isBoundary(text string, i int) bool {
-	return i == 0 || text[i-1] == ' ' || text[i-1] == '-' || text[i-1] == '_'
+func isBoundary(text []rune, i int) bool {
+	return i == 0 || unicode.IsSpace(text[i-1]) || text[i-1] == '-' || text[i-1] == '_'
 }
 
 // lengthBonus favours shorter (usually more relevant) matches.
-func lengthBonus(text string) int {
+func lengthBonus(text []rune) int {
 	if len(text) >= 40 {
 		return 0
 	}
@@ -3222,6 +4952,9 @@ func songSubtitle(s Song) string {
 		}
 		subtitle += s.Album
 	}
+	if tk := tempoKeyLabel(s); tk != "" {
+		subtitle += " • " + tk
+	}
 	return subtitle
 }
 
@@ -3266,9 +4999,10 @@ func splitAlbumKey(key string) (album, artist string) {
 	return album, artist
 }
 
+// sortByTrack orders an album's songs by disc, then track (see trackLess).
 func sortByTrack(songs []Song) {
 	sort.SliceStable(songs, func(i, j int) bool {
-		return songs[i].TrackNumber < songs[j].TrackNumber
+		return trackLess(songs[i], songs[j])
 	})
 }
 
@@ -3548,17 +5282,7 @@ func (m model) handleRadioEnter() (model, tea.Cmd) {
 	} else {
 		// Playing a radio station
 		if station := m.radioBrowser.EnterSelected(); station != nil {
-			playURL := station.StreamURL
-			if playURL == "" {
-				playURL = station.URL
-			}
-			if err := m.audioPlayer.Play(playURL); err == nil {
-				m.playing = station.Name
-				m.playingSong = nil
-				m.playingStation = station
-				m.radioStartTime = time.Now()
-				m.radioPausedTime = 0
-				m.radioWasPaused = false
+			if m.playStation(station) {
 				return m, tickCmd()
 			}
 		}
@@ -3576,6 +5300,14 @@ func (m model) renderSettings() string {
 		return m.renderSettingsConfirmClearMusic()
 	case "confirm_clear_radio":
 		return m.renderSettingsConfirmClearRadio()
+	case "duplicates":
+		return m.renderSettingsDuplicates()
+	case "health":
+		return m.renderSettingsHealth()
+	case "stats":
+		return m.renderSettingsStats()
+	case "confirm_delete_duplicates":
+		return m.renderSettingsConfirmDeleteDuplicates()
 	default:
 		return "Unknown settings view"
 	}
@@ -3603,10 +5335,53 @@ func (m model) renderSettingsMain() string {
 	items = append(items, headerStyle.Render("Settings"))
 	items = append(items, "")
 	
+	artLabel := map[string]string{"auto": "Auto", "blocks": "Half-blocks", "off": "Off"}[m.settingsManager.AlbumArtMode()]
+	if m.settingsManager.AlbumArtMode() == "auto" {
+		artLabel += " (" + m.artProtocol + ")"
+	}
+	coverColors := "Off"
+	if m.settingsManager.GetSettings().CoverColors {
+		coverColors = "On"
+	}
+	fingerprints := "Off"
+	if m.settingsManager.GetSettings().Fingerprints {
+		fingerprints = "On"
+	}
+	analyze := "Off"
+	if m.settingsManager.GetSettings().Analyze {
+		analyze = "On"
+	}
+	writeRatings := "Off"
+	if m.settingsManager.GetSettings().WriteRatings {
+		writeRatings = "On"
+	}
+	transliterate := "Off"
+	if m.settingsManager.GetSettings().Transliterate {
+		transliterate = "On"
+	}
+	scrobbling := "Off"
+	if m.settingsManager.GetSettings().Scrobble {
+		scrobbling = "On (" + m.scrobbler.Status() + ")"
+	}
+	mpdServer := "Off"
+	if m.settingsManager.GetSettings().MPD {
+		mpdServer = "On (" + m.mpdStatus() + ")"
+	}
 	menuItems := []string{
 		"Clear Music Library",
 		"Clear Radio Library", 
 		"Color Themes",
+		"Album Art: " + artLabel,
+		"Cover Colors: " + coverColors,
+		"Fingerprint on Scan: " + fingerprints,
+		"Analyze BPM/Key on Scan: " + analyze,
+		"Write Ratings to Tags: " + writeRatings,
+		"Transliterate in Search: " + transliterate,
+		"Scrobbling: " + scrobbling,
+		"MPD Server: " + mpdServer,
+		"Find Duplicates",
+		"Library Health",
+		"Listening Stats",
 	}
 	
 	for i, item := range menuItems {
@@ -3750,6 +5525,272 @@ func (m model) renderSettingsConfirmClearRadio() string {
 	return strings.Join(items, "\n")
 }
 
+// renderSettingsDuplicates lists each group of likely duplicates with one row
+// per copy; ★ marks the copy that survives a cleanup. The list scrolls to keep
+// the highlighted copy on screen.
+func (m model) renderSettingsDuplicates() string {
+	theme := m.settingsManager.GetTheme()
+
+	headerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Primary)).
+		Bold(true).
+		Padding(1, 0)
+	groupStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Secondary)).
+		Bold(true).
+		PaddingLeft(2)
+	mutedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
+
+	groups := m.settingsBrowser.GetDuplicateGroups()
+	extras := 0
+	for _, g := range groups {
+		extras += len(g.Songs) - 1
+	}
+
+	items := []string{headerStyle.Render("Duplicates")}
+	summary := fmt.Sprintf("%d groups, %d extra copies", len(groups), extras)
+	if hidden := m.libraryManager.GetHiddenCount(); hidden > 0 {
+		summary += fmt.Sprintf(" · %d files hidden", hidden)
+	}
+	items = append(items, mutedStyle.Render(summary), "")
+
+	help := mutedStyle.Render("enter keep this copy, x hide others, X hide others in every group, d delete others, u un-hide all, esc back")
+	if len(groups) == 0 {
+		items = append(items, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground)).PaddingLeft(2).Render("No duplicates found."))
+		return strings.Join(append(items, "", help), "\n")
+	}
+
+	selGroup, selCopy := m.settingsBrowser.SelectedDuplicate()
+	width := m.width - 4
+	var rows []string
+	selRow := 0
+	for gi, g := range groups {
+		first := g.Songs[0]
+		rows = append(rows, groupStyle.Render(ansi.Truncate(first.Artist+" — "+first.Title, width-2, "…")))
+		for ci, s := range g.Songs {
+			mark := " "
+			if ci == g.Keep {
+				mark = "★"
+			}
+			format := strings.ToUpper(strings.TrimPrefix(filepath.Ext(s.FilePath), "."))
+			size := fmt.Sprintf("%.1f MB", float64(g.Sizes[ci])/(1<<20))
+			line := fmt.Sprintf("  %s %-4s %8s %6s  %s", mark, format, size, s.Duration, s.FilePath)
+			selected := gi == selGroup && ci == selCopy
+			if selected {
+				selRow = len(rows)
+			}
+			rows = append(rows, createGradientStyle(selected, width, theme).Render(ansi.Truncate(line, width-1, "…")))
+		}
+	}
+
+	// Header (3 lines), summary, blank, and blank + help below the list.
+	visible := max(3, m.libraryBrowser.GetViewportHeight()-7)
+	top := clamp(selRow-visible/2, 0, max(0, len(rows)-visible))
+	rows = rows[top:min(len(rows), top+visible)]
+
+	items = append(items, rows...)
+	items = append(items, "", help)
+	return strings.Join(items, "\n")
+}
+
+// renderSettingsHealth lists library files with problems found during the
+// last scan, under the current filter, with details for the highlighted file.
+func (m model) renderSettingsHealth() string {
+	theme := m.settingsManager.GetTheme()
+
+	headerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Primary)).
+		Bold(true).
+		Padding(1, 0)
+	mutedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
+	errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Error))
+	warnStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Warning))
+
+	rows := m.settingsBrowser.GetHealthRows()
+	filter := m.settingsBrowser.HealthFilter()
+	var tabs []string
+	for _, f := range healthFilters {
+		label := healthFilterLabels[f]
+		if f == filter {
+			tabs = append(tabs, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Primary)).Bold(true).Render("["+label+"]"))
+		} else {
+			tabs = append(tabs, mutedStyle.Render(" "+label+" "))
+		}
+	}
+
+	items := []string{headerStyle.Render("Library Health"), strings.Join(tabs, " "), ""}
+	help := mutedStyle.Render("←/→ filter, e export CSV + JSON, esc back (rescan to refresh)")
+	if len(rows) == 0 {
+		msg := "No problems found."
+		if len(m.libraryManager.GetDiagnostics()) == 0 {
+			msg = "No scan diagnostics yet; rescan the library (r) to check your files."
+		}
+		items = append(items, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground)).PaddingLeft(2).Render(msg))
+		return strings.Join(append(items, "", help), "\n")
+	}
+
+	selected := m.settingsBrowser.GetHealthSelected()
+	width := m.width - 4
+	var lines []string
+	for i, r := range rows {
+		icon := warnStyle.Render("!")
+		if r.Diag.Unplayable() || r.Diag.NoDuration() {
+			icon = errorStyle.Render("✗")
+		}
+		name := filepath.Base(r.Path)
+		line := ansi.Truncate(name+"  "+mutedStyle.Render(r.Diag.Summary()), width-4, "…")
+		lines = append(lines, icon+" "+createGradientStyle(i == selected, width-2, theme).Render(line))
+	}
+
+	// Header (3 lines), filter tabs, blank, then blank + 2 detail lines + blank + help.
+	visible := max(3, m.libraryBrowser.GetViewportHeight()-9)
+	top := clamp(selected-visible/2, 0, max(0, len(lines)-visible))
+	items = append(items, lines[top:min(len(lines), top+visible)]...)
+
+	if selected < len(rows) {
+		r := rows[selected]
+		duration := "unknown"
+		if !r.Diag.NoDuration() {
+			duration = formatDuration(time.Duration(r.Diag.Duration*float64(time.Second))) + " via " + r.Diag.DurationMethod
+		}
+		items = append(items, "",
+			mutedStyle.Render(ansi.Truncate(r.Path, width, "…")),
+			mutedStyle.Render(fmt.Sprintf("%d of %d · %s · %.1f MB · duration %s", selected+1, len(rows),
+				strings.ToUpper(r.Diag.Format), float64(r.Diag.Size)/(1<<20), duration)))
+	}
+	items = append(items, "", help)
+	return strings.Join(items, "\n")
+}
+
+func (m model) renderSettingsStats() string {
+	theme := m.settingsManager.GetTheme()
+
+	headerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Primary)).
+		Bold(true).
+		Padding(1, 0)
+	mutedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
+	normalStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground))
+	titleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Secondary)).Bold(true)
+
+	stats := m.settingsBrowser.GetStats()
+	window := m.settingsBrowser.StatsWindow()
+	var tabs []string
+	for _, w := range statsWindows {
+		label := statsWindowLabels[w]
+		if w == window {
+			tabs = append(tabs, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Primary)).Bold(true).Render("["+label+"]"))
+		} else {
+			tabs = append(tabs, mutedStyle.Render(" "+label+" "))
+		}
+	}
+
+	items := []string{headerStyle.Render("Listening Stats"), strings.Join(tabs, " "), ""}
+	help := mutedStyle.Render("←/→ time window, esc back")
+	if stats.Seconds == 0 {
+		items = append(items, normalStyle.PaddingLeft(2).Render("Nothing played in this period yet."))
+		return strings.Join(append(items, "", help), "\n")
+	}
+
+	items = append(items, normalStyle.PaddingLeft(2).Render(fmt.Sprintf("%s listened · %d plays · %d skipped",
+		formatListeningTime(stats.Seconds), stats.Plays, stats.Skips)), "")
+
+	// Top artists, albums and genres side by side.
+	const topN = 5
+	colWidth := max(20, (m.width-8)/3)
+	column := func(title string, entries []StatEntry) string {
+		lines := []string{titleStyle.Render(title)}
+		for i, e := range entries[:min(len(entries), topN)] {
+			count := mutedStyle.Render(fmt.Sprintf(" %d", e.Plays))
+			name := ansi.Truncate(fmt.Sprintf("%d. %s", i+1, e.Name), colWidth-lipgloss.Width(count)-2, "…")
+			lines = append(lines, normalStyle.Render(name)+count)
+		}
+		return lipgloss.NewStyle().Width(colWidth).PaddingLeft(2).Render(strings.Join(lines, "\n"))
+	}
+	items = append(items, lipgloss.JoinHorizontal(lipgloss.Top,
+		column("Top Artists", stats.Artists),
+		column("Top Albums", stats.Albums),
+		column("Top Genres", stats.Genres),
+	), "")
+
+	// Listening by hour of day, in minutes. The chart takes whatever height
+	// is left: header (3), tabs, blank, totals, blank, top lists (topN+1),
+	// blank, chart title, then blank + help.
+	chartHeight := max(5, m.libraryBrowser.GetViewportHeight()-topN-12)
+	chartWidth := min(m.width-4, 24*3+23)
+	var bars []barchart.BarData
+	for h, secs := range stats.ByHour {
+		style := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Primary))
+		if h >= 6 && h < 18 {
+			style = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Secondary))
+		}
+		bars = append(bars, barchart.BarData{
+			Label:  fmt.Sprintf("%02d", h),
+			Values: []barchart.BarValue{{Name: "minutes", Value: secs / 60, Style: style}},
+		})
+	}
+	bc := barchart.New(chartWidth, chartHeight,
+		barchart.WithBarGap(1),
+		barchart.WithStyles(mutedStyle, mutedStyle))
+	bc.PushAll(bars)
+	bc.Draw()
+	items = append(items, titleStyle.PaddingLeft(2).Render("Listening by hour"),
+		lipgloss.NewStyle().PaddingLeft(2).Render(bc.View()))
+
+	items = append(items, "", help)
+	return strings.Join(items, "\n")
+}
+
+func (m model) renderSettingsConfirmDeleteDuplicates() string {
+	var items []string
+
+	theme := m.settingsManager.GetTheme()
+
+	headerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Error)).
+		Bold(true).
+		Padding(1, 0)
+
+	normalStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Foreground)).
+		PaddingLeft(2)
+
+	selectedStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Background)).
+		Background(lipgloss.Color(theme.Error)).
+		PaddingLeft(2)
+
+	extras := 0
+	if g, _ := m.settingsBrowser.SelectedDuplicate(); g >= 0 {
+		extras = len(m.settingsBrowser.GetDuplicateGroups()[g].Songs) - 1
+	}
+
+	items = append(items, headerStyle.Render("⚠  Delete Duplicate Copies"))
+	items = append(items, "")
+	copies := fmt.Sprintf("%d extra copies", extras)
+	if extras == 1 {
+		copies = "the extra copy"
+	}
+	items = append(items, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground)).Render("Delete "+copies+" of this song from disk?"))
+	items = append(items, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted)).Render("Playlists will point at the copy marked ★. This cannot be undone."))
+	items = append(items, "")
+
+	buttons := []string{"Cancel", "Confirm"}
+
+	for i, button := range buttons {
+		style := normalStyle
+		if i == m.settingsBrowser.GetConfirmSelected() {
+			style = selectedStyle
+		}
+		items = append(items, style.Render(button))
+	}
+
+	items = append(items, "")
+	items = append(items, lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted)).Render("Use arrow keys to navigate, Enter to confirm, Escape to cancel"))
+
+	return strings.Join(items, "\n")
+}
+
 // createVisualizer creates a new music visualizer (placeholder function)
 func createVisualizer(theme Theme) barchart.Model {
 	// We're now using a custom horizontal visualizer instead of ntcharts
@@ -3861,6 +5902,100 @@ func (m *model) renderVisualizer(width int) string {
 	return strings.Join(bars, "")
 }
 
+// playingLyrics returns the playing track's lyrics, or nil if there are none
+// (or they haven't been read yet).
+func (m model) playingLyrics() *Lyrics {
+	if m.playingSong == nil || m.playingStation != nil {
+		return nil
+	}
+	return m.lyrics.Lyrics(m.playingSong.FilePath)
+}
+
+// lyricsWindow lays out rows lines of lyrics, each width cells wide and
+// zone-marked as lyric_<slot>_<line> for click-to-seek. Synced lyrics keep
+// the line being sung in the middle, highlighted, with the lines already sung
+// dimmed; the rest start at lyricsScroll.
+func (m model) lyricsWindow(l *Lyrics, slot string, width, rows int, center bool) []string {
+	theme := m.settingsManager.GetTheme()
+	current := l.Current(m.audioPlayer.GetPosition())
+	start := m.lyricsScroll
+	if l.Synced {
+		start = max(current, 0) - rows/2
+	}
+	start = clamp(start, 0, max(len(l.Lines)-rows, 0))
+
+	sung := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
+	ahead := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Foreground))
+	now := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Primary)).Bold(true)
+	lines := make([]string, rows)
+	for row := range lines {
+		i := start + row
+		if i >= len(l.Lines) {
+			lines[row] = strings.Repeat(" ", width)
+			continue
+		}
+		text := l.Lines[i].Text
+		style := ahead
+		switch {
+		case i == current:
+			style = now
+			if text == "" {
+				text = "♪" // an instrumental break
+			}
+		case i < current:
+			style = sung
+		}
+		text = ansi.Truncate(text, width, "…")
+		if center {
+			text = lipgloss.PlaceHorizontal(width, lipgloss.Center, text)
+		} else {
+			text = padToWidth(text, width)
+		}
+		lines[row] = zone.Mark(fmt.Sprintf("lyric_%s_%d", slot, i), style.Render(text))
+	}
+	return lines
+}
+
+// renderLyricsView shows the playing track's lyrics full screen.
+func (m model) renderLyricsView(availableHeight int) string {
+	theme := m.settingsManager.GetTheme()
+	width := max(m.width-4, 10)
+	headerStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color(theme.Primary)).
+		Bold(true)
+	mutedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Muted))
+	centered := func(s string) string {
+		return lipgloss.PlaceHorizontal(width, lipgloss.Center, ansi.Truncate(s, width, "…"))
+	}
+
+	var content []string
+	switch {
+	case m.playingStation != nil:
+		content = append(content, centered(headerStyle.Render("📻 "+m.playingStation.Name)), "",
+			centered(mutedStyle.Render("Radio streams don't have lyrics")))
+	case m.playingSong == nil:
+		content = append(content, centered(headerStyle.Render("Lyrics")), "",
+			centered(mutedStyle.Render("Nothing is playing")))
+	default:
+		song := m.playingSong
+		content = append(content, centered(headerStyle.Render("♪ "+song.Title+" - "+song.Artist)))
+		l := m.playingLyrics()
+		if l == nil {
+			content = append(content, "", centered(mutedStyle.Render(
+				"No lyrics found: looked for a .lrc or .txt file beside the track and a lyrics tag in it")))
+			break
+		}
+		source := map[string]string{"lrc": ".lrc file", "tag": "lyrics tag", "txt": ".txt file"}[l.Source]
+		if l.Synced {
+			source = "synced · " + source
+		}
+		content = append(content, centered(mutedStyle.Render(source)), "")
+		rows := max(availableHeight-len(content), 1)
+		content = append(content, m.lyricsWindow(l, "full", width, rows, true)...)
+	}
+	return lipgloss.NewStyle().PaddingLeft(2).Render(strings.Join(content, "\n"))
+}
+
 // renderFullScreenVisualizer renders the full-screen music visualizer
 func (m model) renderFullScreenVisualizer(availableHeight int) string {
 	theme := m.settingsManager.GetTheme()
@@ -3955,7 +6090,7 @@ func (m model) renderFullScreenVisualizer(availableHeight int) string {
 	
 	var controlsText string
 	if isPlaying {
-		controlsText = "Press 'space' to pause • Press 'f' to switch tabs • Use ← → to switch chart types"
+		controlsText = "Press 'space' to pause • , . < > to seek • [ ] to loop A-B • - + for volume • Press 'f' to switch tabs • Use ← → to switch chart types"
 	} else {
 		controlsText = "No audio playing • Press 'f' to switch tabs • Use ← → to switch chart types"
 	}
@@ -4350,11 +6485,42 @@ func (m model) centerChartContent(chartView string, chartWidth int) string {
 
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "resona: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	zone.NewGlobal() // initialize the mouse-zone manager for clickable elements
-	m := initialModel()
+	// Attach to a running daemon if there is one; otherwise play here.
+	var player Player
+	if remote, err := dialDaemon(); err == nil {
+		player = remote
+	} else {
+		audioPlayer, err := NewAudioPlayer()
+		if err != nil {
+			fmt.Printf("Error initializing audio player: %v\n", err)
+			os.Exit(1)
+		}
+		player = audioPlayer
+	}
+	m := initialModel(player)
 	defer m.audioPlayer.Close()
+	defer m.mpris.Close()
+	defer m.mpd.Close()
 
 	p := tea.NewProgram(m)
+	if m.remote == nil {
+		// Take requests from the command line while playing here.
+		if ln, err := listenControl(); err == nil {
+			defer ln.Close()
+			serveControl(ln, p)
+		} else {
+			log.Printf("DEBUG: no control socket: %v", err)
+		}
+	}
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error: %v", err)
 		os.Exit(1)
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// mixLookahead is how many songs a mix keeps queued after the one playing;
+// the queue is topped up whenever a track starts.
+const mixLookahead = 5
+
+// mixPool is how many of the best-scoring songs each pick is drawn from, so
+// two mixes from the same seed don't come out the same.
+const mixPool = 12
+
+// Mix is an endless queue built from the library around a seed song or
+// artist. Songs are picked for what they have in common with the seed —
+// genre, artist, album, era and, when analyzed, tempo and key — with a nudge
+// towards rated and favorite songs. A song is only queued once per mix, and artists are spread out
+// (except the artist of an artist mix, who is the point).
+type Mix struct {
+	Seed   Song   // the seed's tags; for an artist mix, the artist's usual genre and year
+	Artist string // set for an artist mix, "" for a song mix
+	used   map[string]bool
+	recent []string // artists of the last few picks, most recent last
+}
+
+// NewSongMix starts a mix from a song.
+func NewSongMix(seed Song) *Mix {
+	return &Mix{Seed: seed, used: map[string]bool{seed.FilePath: true}, recent: []string{seed.Artist}}
+}
+
+// NewArtistMix starts a mix from an artist, given their songs. The seed gets
+// the genre most of them share and their median year, so the mix wanders off
+// to similar artists rather than only playing this one.
+func NewArtistMix(artist string, songs []Song) *Mix {
+	seed := Song{Artist: artist}
+	genres := make(map[string]int)
+	var years []int
+	for _, s := range songs {
+		if g := normalizeForMatch(s.Genre); g != "" && g != "unknown genre" {
+			genres[s.Genre]++
+		}
+		if s.Year > 0 {
+			years = append(years, s.Year)
+		}
+	}
+	for g, n := range genres {
+		if n > genres[seed.Genre] || (n == genres[seed.Genre] && g < seed.Genre) {
+			seed.Genre = g
+		}
+	}
+	if len(years) > 0 {
+		sort.Ints(years)
+		seed.Year = years[len(years)/2]
+	}
+	return &Mix{Seed: seed, Artist: artist, used: map[string]bool{}}
+}
+
+// Label describes the mix for the now playing bar.
+func (mx *Mix) Label() string {
+	if mx.Artist != "" {
+		return "Mix from " + mx.Artist
+	}
+	return "Mix from \"" + mx.Seed.Title + "\""
+}
+
+// Next picks up to n more songs from the library. Once every song has been
+// played the mix starts over rather than running dry.
+func (mx *Mix) Next(library []Song, n int, lm *LibraryManager) []Song {
+	var picked []Song
+	for len(picked) < n {
+		s, ok := mx.pick(library, lm)
+		if !ok {
+			if len(mx.used) <= 1 {
+				break // nothing in the library but the seed
+			}
+			mx.used = map[string]bool{}
+			continue
+		}
+		mx.used[s.FilePath] = true
+		mx.recent = append(mx.recent, s.Artist)
+		if len(mx.recent) > 3 {
+			mx.recent = mx.recent[1:]
+		}
+		picked = append(picked, s)
+	}
+	return picked
+}
+
+// pick chooses one unused song, weighted by score among the best few.
+func (mx *Mix) pick(library []Song, lm *LibraryManager) (Song, bool) {
+	type candidate struct {
+		song  Song
+		score float64
+	}
+	var candidates []candidate
+	for _, s := range library {
+		if s.FilePath == "" || mx.used[s.FilePath] {
+			continue
+		}
+		candidates = append(candidates, candidate{s, mx.score(s, lm)})
+	}
+	if len(candidates) == 0 {
+		return Song{}, false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	if len(candidates) > mixPool {
+		candidates = candidates[:mixPool]
+	}
+
+	// Weights are shifted so the weakest of the pool still has a chance.
+	floor := candidates[len(candidates)-1].score
+	total := 0.0
+	for _, c := range candidates {
+		total += c.score - floor + 0.5
+	}
+	r := rand.Float64() * total
+	for _, c := range candidates {
+		r -= c.score - floor + 0.5
+		if r <= 0 {
+			return c.song, true
+		}
+	}
+	return candidates[0].song, true
+}
+
+// score rates how well a song follows the seed; higher is better.
+func (mx *Mix) score(s Song, lm *LibraryManager) float64 {
+	seed := mx.Seed
+	score := 0.0
+	if g := normalizeForMatch(seed.Genre); g != "" && g != "unknown genre" && g == normalizeForMatch(s.Genre) {
+		score += 3
+	}
+	artist := normalizeForMatch(seed.Artist)
+	sameArtist := artist != "" && artist != "unknown artist" && normalizeForMatch(s.Artist) == artist
+	if sameArtist {
+		if mx.Artist != "" {
+			score += 4
+		} else {
+			score += 2
+		}
+	}
+	if mx.Artist == "" && s.Album != "" && s.Album == seed.Album && albumArtistOf(s) == albumArtistOf(seed) {
+		score++
+	}
+	if seed.Year > 0 && s.Year > 0 {
+		score += 2 * math.Max(0, 1-math.Abs(float64(s.Year-seed.Year))/10)
+	}
+	if seed.BPM > 0 && s.BPM > 0 {
+		score += math.Max(0, 1-math.Abs(s.BPM-seed.BPM)/10)
+	}
+	if keysCompatible(seed.Key, s.Key) {
+		score += 0.5
+	}
+	score += float64(lm.Rating(s.FilePath)) / 5
+	if lm.IsFavorite(s.FilePath) {
+		score += 0.5
+	}
+
+	// Spread artists out: in a song mix, not within three songs of each
+	// other; in an artist mix only other artists are held back this way.
+	window := mx.recent
+	if mx.Artist != "" && len(window) > 1 {
+		window = window[len(window)-1:]
+	}
+	if !(mx.Artist != "" && sameArtist) {
+		for _, a := range window {
+			if normalizeForMatch(a) == normalizeForMatch(s.Artist) {
+				score -= 5
+				break
+			}
+		}
+	}
+	return score
+}
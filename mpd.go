@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// An MPD protocol server, so MPD clients (ncmpcpp, mpc, phone apps on the
+// LAN) can browse the library and drive the player. It speaks the core of
+// the protocol (https://mpd.readthedocs.io/en/latest/protocol.html): status
+// and idle, playback, the queue, the database and stored playlists; the
+// commands are in mpd_commands.go. Like MPRIS it runs beside the model in
+// the process that plays: each request arrives as an mpdMsg and runs on the
+// model's goroutine, and the model reports changes with publishMPD on each
+// art tick, which is what wakes idling clients.
+
+const (
+	defaultMPDAddress = "localhost:6600"
+	mpdVersion        = "0.23.5" // the protocol version announced
+)
+
+// mpdMsg runs an MPD request on the model.
+type mpdMsg controlMsg
+
+// MPDServer is the MPD server. A nil *MPDServer is one that's off.
+type MPDServer struct {
+	ln       net.Listener
+	addr     string
+	password string
+	started  time.Time
+	msgs     chan mpdMsg
+	done     chan struct{} // closed by Close
+
+	// Whether the client whose command is running is on this machine, and
+	// so may name files outside the library. Only the model's goroutine
+	// uses it.
+	local bool
+
+	mu      sync.Mutex
+	state   mpdState
+	clients map[*mpdClient]bool
+}
+
+// mpdClient is a connection.
+type mpdClient struct {
+	conn   net.Conn
+	w      *bufio.Writer
+	authed bool // has sent the password, or there isn't one
+	local  bool // connected from this machine
+
+	pending map[string]bool // subsystems changed since the last idle; guarded by the server's mu
+	wake    chan struct{}   // signalled when pending grows
+}
+
+// mpdState is what the server last heard of the player, to tell idling
+// clients what changed.
+type mpdState struct {
+	state   string // "play", "pause" or "stop"
+	song    string // path or stream of what's playing
+	index   int    // of the song in the queue
+	queue   int    // queue version
+	volume  int
+	elapsed float64
+	at      time.Time // when elapsed was read
+	library int       // library generation
+}
+
+// mpdError is an error as the protocol reports it: ACK [code@index] {command} message.
+type mpdError struct {
+	code int
+	msg  string
+}
+
+func (e *mpdError) Error() string { return e.msg }
+
+// The protocol's error codes.
+const (
+	mpdErrArg        = 2
+	mpdErrPassword   = 3
+	mpdErrPermission = 4
+	mpdErrUnknown    = 5
+	mpdErrNoExist    = 50
+	mpdErrSystem     = 52
+)
+
+func mpdErrorf(code int, format string, a ...any) error {
+	return &mpdError{code: code, msg: fmt.Sprintf(format, a...)}
+}
+
+// StartMPD starts listening for MPD clients on addr.
+func StartMPD(addr, password string) (*MPDServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &MPDServer{
+		ln:       ln,
+		addr:     addr,
+		password: password,
+		started:  time.Now(),
+		msgs:     make(chan mpdMsg, 16),
+		done:     make(chan struct{}),
+		clients:  map[*mpdClient]bool{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+// Listen returns a command that waits for the next request from an MPD
+// client. The model issues it again after handling each one.
+func (s *MPDServer) Listen() tea.Cmd {
+	if s == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		select {
+		case msg := <-s.msgs:
+			return msg
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+// Close stops listening and hangs up on the clients.
+func (s *MPDServer) Close() {
+	if s == nil {
+		return
+	}
+	close(s.done)
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// Update takes the player's state, noting for each client which subsystems
+// changed. A position that doesn't follow from the last one is a seek, which
+// counts as a change of the player.
+func (s *MPDServer) Update(st mpdState) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.state
+	s.state = st
+	if old.at.IsZero() {
+		return
+	}
+
+	var changed []string
+	drift := st.elapsed - old.elapsed
+	if old.state == "play" {
+		drift -= st.at.Sub(old.at).Seconds()
+	}
+	if st.state != old.state || st.song != old.song || st.index != old.index || st.state != "stop" && math.Abs(drift) > 1.5 {
+		changed = append(changed, "player")
+	}
+	if st.queue != old.queue {
+		changed = append(changed, "playlist")
+	}
+	if st.volume != old.volume {
+		changed = append(changed, "mixer")
+	}
+	if st.library != old.library {
+		changed = append(changed, "database")
+	}
+	if len(changed) == 0 {
+		return
+	}
+	for c := range s.clients {
+		for _, sub := range changed {
+			c.pending[sub] = true
+		}
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// do runs fn on the model. A request the model doesn't get to in time isn't
+// run at all, so what fn leaves behind can be read once do returns nil.
+func (s *MPDServer) do(fn func(m *model) tea.Cmd) error {
+	msg := newControlMsg(func(m *model) tea.Cmd {
+		cmd := fn(m)
+		s.Update(m.mpdState())
+		return cmd
+	})
+	timeout := time.After(5 * time.Second)
+	select {
+	case s.msgs <- mpdMsg(msg):
+	case <-s.done:
+		return errors.New("the server is shutting down")
+	case <-timeout:
+		return errors.New("the player is busy")
+	}
+	if !msg.wait(timeout) {
+		return errors.New("the player didn't answer")
+	}
+	return nil
+}
+
+// serve talks to one client until it hangs up.
+func (s *MPDServer) serve(conn net.Conn) {
+	c := &mpdClient{
+		conn:    conn,
+		w:       bufio.NewWriter(conn),
+		authed:  s.password == "",
+		local:   isLoopback(conn.RemoteAddr()),
+		pending: map[string]bool{},
+		wake:    make(chan struct{}, 1),
+	}
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Lines are read on their own goroutine so that an idling client can
+	// be woken by either a change or its noidle.
+	lines := make(chan string)
+	hungUp := make(chan struct{})
+	defer close(hungUp)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(conn)
+		sc.Buffer(make([]byte, 4096), 1<<20)
+		for sc.Scan() {
+			select {
+			case lines <- sc.Text():
+			case <-hungUp:
+				return
+			}
+		}
+	}()
+
+	fmt.Fprintf(c.w, "OK MPD %s\n", mpdVersion)
+	c.w.Flush()
+	var list [][]string
+	inList, listOK := false, false
+	for line := range lines {
+		args, err := splitMPDArgs(line)
+		switch {
+		case err != nil:
+			fmt.Fprintf(c.w, "ACK [%d@0] {} %s\n", mpdErrArg, err)
+		case len(args) == 0:
+			fmt.Fprintf(c.w, "ACK [%d@0] {} No command given\n", mpdErrUnknown)
+		case inList && args[0] == "command_list_end":
+			s.run(c, list, listOK)
+			list, inList = nil, false
+		case inList:
+			list = append(list, args)
+		case args[0] == "command_list_begin" || args[0] == "command_list_ok_begin":
+			inList, listOK = true, args[0] == "command_list_ok_begin"
+		case args[0] == "close":
+			return
+		case args[0] == "idle" && c.authed:
+			if !s.idle(c, args[1:], lines) {
+				return
+			}
+		case args[0] == "noidle":
+			// Not idling: nothing to stop.
+		default:
+			s.run(c, [][]string{args}, false)
+		}
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// idle waits for one of the subsystems (any, if none are given) to change,
+// or for the client to send noidle, and reports what changed. It returns
+// false if the client hung up, or sent something else.
+func (s *MPDServer) idle(c *mpdClient, subsystems []string, lines <-chan string) bool {
+	wanted := func(sub string) bool {
+		if len(subsystems) == 0 {
+			return true
+		}
+		for _, w := range subsystems {
+			if w == sub {
+				return true
+			}
+		}
+		return false
+	}
+	// take reports and forgets the pending changes the client wants.
+	take := func() bool {
+		s.mu.Lock()
+		var changed []string
+		for sub := range c.pending {
+			if wanted(sub) {
+				changed = append(changed, sub)
+				delete(c.pending, sub)
+			}
+		}
+		s.mu.Unlock()
+		sort.Strings(changed)
+		for _, sub := range changed {
+			fmt.Fprintf(c.w, "changed: %s\n", sub)
+		}
+		return len(changed) > 0
+	}
+
+	for {
+		if take() {
+			fmt.Fprintln(c.w, "OK")
+			return true
+		}
+		select {
+		case <-c.wake:
+		case line, ok := <-lines:
+			if !ok || strings.TrimSpace(line) != "noidle" {
+				return false
+			}
+			take()
+			fmt.Fprintln(c.w, "OK")
+			return true
+		}
+	}
+}
+
+// run carries out a command, or a command list, on the model and writes the
+// reply. A list stops at the first command that fails. The reply is built on
+// the model's goroutine, and only read here once it's done.
+func (s *MPDServer) run(c *mpdClient, list [][]string, listOK bool) {
+	var reply mpdReply
+	var failed error
+	failedAt := 0
+	err := s.do(func(m *model) tea.Cmd {
+		var cmds []tea.Cmd
+		for i, args := range list {
+			cmd, err := s.execute(m, c, args, &reply)
+			cmds = append(cmds, cmd)
+			if err != nil {
+				failed, failedAt = err, i
+				break
+			}
+			if listOK {
+				reply.WriteString("list_OK\n")
+			}
+		}
+		return tea.Batch(cmds...)
+	})
+	if err != nil {
+		failed = mpdErrorf(mpdErrSystem, "%v", err)
+	}
+	if failed != nil {
+		code := mpdErrUnknown
+		var e *mpdError
+		if errors.As(failed, &e) {
+			code = e.code
+		}
+		name := "" // none for an empty command list
+		if failedAt < len(list) && len(list[failedAt]) > 0 {
+			name = list[failedAt][0]
+		}
+		fmt.Fprintf(c.w, "%sACK [%d@%d] {%s} %s\n", reply.String(), code, failedAt, name, failed)
+		return
+	}
+	fmt.Fprintf(c.w, "%sOK\n", reply.String())
+}
+
+// execute runs one command.
+func (s *MPDServer) execute(m *model, c *mpdClient, args []string, reply *mpdReply) (tea.Cmd, error) {
+	if len(args) == 0 {
+		return nil, mpdErrorf(mpdErrUnknown, "No command given")
+	}
+	s.local = c.local
+	name := args[0]
+	switch {
+	case name == "password":
+		if len(args) != 2 || args[1] != s.password {
+			return nil, mpdErrorf(mpdErrPassword, "incorrect password")
+		}
+		c.authed = true
+		return nil, nil
+	case !c.authed && name != "ping" && name != "commands" && name != "notcommands":
+		return nil, mpdErrorf(mpdErrPermission, "you don't have permission for %q", name)
+	}
+	if name == "commands" || name == "notcommands" {
+		var names []string
+		for command := range mpdCommands {
+			if name == "commands" {
+				names = append(names, command)
+			}
+		}
+		if name == "commands" {
+			names = append(names, "close", "command_list_begin", "command_list_ok_begin", "commands", "idle", "noidle", "notcommands", "password")
+		}
+		sort.Strings(names)
+		for _, command := range names {
+			reply.field("command", command)
+		}
+		return nil, nil
+	}
+	command, ok := mpdCommands[name]
+	if !ok {
+		return nil, mpdErrorf(mpdErrUnknown, "unknown command %q", name)
+	}
+	return command(m, args[1:], reply)
+}
+
+// isLoopback reports whether a client's address is on this machine.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// splitMPDArgs splits a command line into its arguments: words, or strings
+// in double quotes with backslash escapes.
+func splitMPDArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == ' ' || line[i] == '\t':
+			i++
+		case line[i] == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, errors.New("missing closing '\"'")
+			}
+			args = append(args, b.String())
+			i++
+		default:
+			j := i
+			for j < len(line) && line[j] != ' ' && line[j] != '\t' {
+				j++
+			}
+			args = append(args, line[i:j])
+			i = j
+		}
+	}
+	return args, nil
+}
+
+// mpdReply is the body of a reply: key: value lines.
+type mpdReply struct {
+	strings.Builder
+}
+
+// mpdLineBreaks turns line breaks in a value into spaces: a multi-line tag (a
+// comment, say) would otherwise end its line early and the rest would be read
+// as another field.
+var mpdLineBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+func (r *mpdReply) field(key string, value any) {
+	fmt.Fprintf(r, "%s: %s\n", key, mpdLineBreaks.Replace(fmt.Sprint(value)))
+}
+
+// mpdState describes the player for the MPD server.
+func (m *model) mpdState() mpdState {
+	st := mpdState{
+		state:   "stop",
+		index:   m.currentTrackIndex,
+		queue:   m.queueVersion,
+		volume:  int(math.Round(m.audioPlayer.Volume() * 100)),
+		at:      time.Now(),
+		library: m.libraryManager.Generation(),
+	}
+	switch {
+	case m.playingSong != nil:
+		st.song = m.playingSong.FilePath
+	case m.playingStation != nil:
+		st.song = m.playingStation.StreamURL
+	default:
+		return st
+	}
+	st.state = "play"
+	if m.audioPlayer.IsPaused() {
+		st.state = "pause"
+	}
+	st.elapsed = m.audioPlayer.GetPosition()
+	return st
+}
+
+// publishMPD brings the MPD server up to date.
+func (m *model) publishMPD() {
+	m.mpd.Update(m.mpdState())
+}
+
+// configureMPD starts or stops the MPD server to match the settings, and
+// returns the command that listens for its requests when it starts. A TUI
+// attached to a daemon leaves serving to the daemon.
+func (m *model) configureMPD() tea.Cmd {
+	if m.remote != nil {
+		return nil
+	}
+	settings := m.settingsManager.GetSettings()
+	addr := settings.MPDAddress
+	if addr == "" {
+		addr = defaultMPDAddress
+	}
+	if m.mpd != nil && (!settings.MPD || m.mpd.addr != addr || m.mpd.password != settings.MPDPassword) {
+		m.mpd.Close()
+		m.mpd = nil
+	}
+	if !settings.MPD || m.mpd != nil {
+		return nil
+	}
+	m.mpd, m.mpdErr = StartMPD(addr, settings.MPDPassword)
+	if m.mpdErr != nil {
+		log.Printf("DEBUG: MPD server unavailable: %v", m.mpdErr)
+		m.statusFlash = "MPD server: " + m.mpdErr.Error()
+		return nil
+	}
+	m.mpd.Update(m.mpdState())
+	return m.mpd.Listen()
+}
+
+// mpdStatus describes the MPD server for the settings menu.
+func (m *model) mpdStatus() string {
+	switch {
+	case m.mpd != nil:
+		return m.mpd.addr
+	case m.remote != nil:
+		return "by the running player"
+	case m.mpdErr != nil:
+		return m.mpdErr.Error()
+	}
+	return "not listening"
+}
@@ -0,0 +1,1193 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// The MPD commands, on the model. Songs are named by URIs relative to the
+// library folders, each of which is a top-level directory of the database
+// named after it; a song in the queue has the id the model gave its entry,
+// which it keeps while it's queued.
+
+type mpdCommand func(m *model, args []string, r *mpdReply) (tea.Cmd, error)
+
+var mpdCommands = map[string]mpdCommand{
+	// Status
+	"ping":        func(m *model, args []string, r *mpdReply) (tea.Cmd, error) { return nil, nil },
+	"clearerror":  func(m *model, args []string, r *mpdReply) (tea.Cmd, error) { return nil, nil },
+	"status":      mpdStatusCommand,
+	"currentsong": mpdCurrentSong,
+	"stats":       mpdStats,
+	"tagtypes":    mpdTagTypesCommand,
+	"urlhandlers": func(m *model, args []string, r *mpdReply) (tea.Cmd, error) { return nil, nil },
+	"outputs": func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		r.field("outputid", 0)
+		r.field("outputname", "Resona")
+		r.field("plugin", "resona")
+		r.field("outputenabled", 1)
+		return nil, nil
+	},
+	"replay_gain_status": func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		r.field("replay_gain_mode", "off")
+		return nil, nil
+	},
+
+	// Playback
+	"play":     mpdPlay(false),
+	"playid":   mpdPlay(true),
+	"pause":    mpdPause,
+	"stop":     mpdTransport("stop"),
+	"next":     mpdTransport("next"),
+	"previous": mpdTransport("previous"),
+	"seek":     mpdSeek(false),
+	"seekid":   mpdSeek(true),
+	"seekcur":  mpdSeek(false),
+	"setvol":   mpdSetVol(false),
+	"volume":   mpdSetVol(true),
+	"getvol": func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		r.field("volume", int(math.Round(m.audioPlayer.Volume()*100)))
+		return nil, nil
+	},
+	// There's no random, repeat, single or consume mode to turn on.
+	"random":  mpdModeOff,
+	"repeat":  mpdModeOff,
+	"single":  mpdModeOff,
+	"consume": mpdModeOff,
+
+	// The queue
+	"playlistinfo":   mpdPlaylistInfo(false),
+	"playlistid":     mpdPlaylistInfo(true),
+	"plchanges":      mpdPlChanges(false),
+	"plchangesposid": mpdPlChanges(true),
+	"add":            mpdAdd(false),
+	"addid":          mpdAdd(true),
+	"clear":          mpdClear,
+	"delete":         mpdDelete(false),
+	"deleteid":       mpdDelete(true),
+
+	// The database
+	"find":        mpdFind(false, false),
+	"search":      mpdFind(true, false),
+	"findadd":     mpdFind(false, true),
+	"searchadd":   mpdFind(true, true),
+	"count":       mpdCount,
+	"list":        mpdList,
+	"lsinfo":      mpdLsInfo,
+	"listall":     mpdListAll(false),
+	"listallinfo": mpdListAll(true),
+	"update":      mpdUpdate,
+	"rescan":      mpdUpdate,
+
+	// Stored playlists
+	"listplaylists":    mpdListPlaylists,
+	"listplaylist":     mpdListPlaylist(false),
+	"listplaylistinfo": mpdListPlaylist(true),
+	"load":             mpdLoad,
+	"playlistadd":      mpdPlaylistAdd,
+	"save":             mpdSave,
+	"rm":               mpdRm,
+}
+
+// mpdTagNames are the tags songs are described with, as MPD spells them.
+var mpdTagNames = []string{"Artist", "AlbumArtist", "Title", "Album", "Track", "Disc", "Date", "Genre", "Composer", "Comment"}
+
+// mpdTag is a song's value for a tag (in any case), or "" if it has none.
+// A song without an album artist is filed under its artist, as the library
+// browser files it.
+func mpdTag(s Song, tag string) string {
+	switch strings.ToLower(tag) {
+	case "artist":
+		return s.Artist
+	case "albumartist":
+		return albumArtistOf(s)
+	case "title":
+		return s.Title
+	case "album":
+		return s.Album
+	case "genre":
+		return s.Genre
+	case "composer":
+		return s.Composer
+	case "comment":
+		return s.Comment
+	case "track":
+		if s.TrackNumber > 0 {
+			return strconv.Itoa(s.TrackNumber)
+		}
+	case "disc":
+		if s.DiscNumber > 0 {
+			return strconv.Itoa(s.DiscNumber)
+		}
+	case "date":
+		if s.Year > 0 {
+			return strconv.Itoa(s.Year)
+		}
+	}
+	return ""
+}
+
+// mpdTagName spells a tag as MPD does, or returns "" for one it doesn't
+// know.
+func mpdTagName(tag string) string {
+	for _, name := range mpdTagNames {
+		if strings.EqualFold(name, tag) {
+			return name
+		}
+	}
+	return ""
+}
+
+// mpdLibrary names the library's songs and directories for MPD.
+type mpdLibrary struct {
+	roots map[string]string // top-level directory name to library folder
+	songs []Song
+	local bool // the client is on this machine, and may name any file
+}
+
+// mpdLibraryOf names the library folders: each by its base name, numbered
+// when two share one.
+func mpdLibraryOf(m *model) *mpdLibrary {
+	l := &mpdLibrary{roots: map[string]string{}, local: m.mpd != nil && m.mpd.local}
+	for _, folder := range m.libraryManager.GetFolders() {
+		name := filepath.Base(folder)
+		for n := 2; l.roots[name] != ""; n++ {
+			name = fmt.Sprintf("%s (%d)", filepath.Base(folder), n)
+		}
+		l.roots[name] = folder
+	}
+	for _, s := range m.libraryManager.GetSongs() {
+		if s.FilePath != "" {
+			l.songs = append(l.songs, s)
+		}
+	}
+	return l
+}
+
+// uri names a file; one outside the library folders keeps its path.
+func (l *mpdLibrary) uri(path string) string {
+	best, bestFolder := "", ""
+	for name, folder := range l.roots {
+		if strings.HasPrefix(path, folder+string(filepath.Separator)) && len(folder) > len(bestFolder) {
+			best, bestFolder = name, folder
+		}
+	}
+	if bestFolder == "" {
+		return path
+	}
+	return best + "/" + filepath.ToSlash(path[len(bestFolder)+1:])
+}
+
+// path finds the file or directory a URI names, which is under one of the
+// library folders. As with MPD, only a client on this machine may name a
+// file anywhere else, by its absolute path or a file:// URI.
+func (l *mpdLibrary) path(uri string) (string, bool) {
+	if abs, isFile := strings.CutPrefix(uri, "file://"); isFile || filepath.IsAbs(uri) {
+		if !l.local || !filepath.IsAbs(abs) {
+			return "", false
+		}
+		return filepath.Clean(abs), true
+	}
+	root, rest, _ := strings.Cut(strings.Trim(uri, "/"), "/")
+	folder, ok := l.roots[root]
+	if !ok {
+		return "", false
+	}
+	for _, part := range strings.FieldsFunc(rest, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", false
+		}
+	}
+	return filepath.Join(folder, filepath.FromSlash(rest)), true
+}
+
+// songsAt returns the songs a URI names: a file, or the library songs
+// under a directory ("" being the whole library). A file that isn't in the
+// library is read from its tags.
+func (l *mpdLibrary) songsAt(uri string) ([]Song, error) {
+	if strings.Trim(uri, "/") == "" {
+		return l.songs, nil
+	}
+	path, ok := l.path(uri)
+	if !ok {
+		return nil, mpdErrorf(mpdErrNoExist, "No such directory")
+	}
+	var songs []Song
+	for _, s := range l.songs {
+		if s.FilePath == path {
+			return []Song{s}, nil
+		}
+		if strings.HasPrefix(s.FilePath, path+string(filepath.Separator)) {
+			songs = append(songs, s)
+		}
+	}
+	if len(songs) == 0 {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && isSupportedAudio(path) {
+			return []Song{extractMetadata(path)}, nil
+		}
+		return nil, mpdErrorf(mpdErrNoExist, "No such song")
+	}
+	sortByFolder(songs)
+	return songs, nil
+}
+
+// writeSong describes a song.
+func (l *mpdLibrary) writeSong(r *mpdReply, s Song) {
+	r.field("file", l.uri(s.FilePath))
+	if s.DurationSecs > 0 {
+		r.field("Time", int(math.Round(s.DurationSecs)))
+		r.field("duration", fmt.Sprintf("%.3f", s.DurationSecs))
+	}
+	for _, tag := range mpdTagNames {
+		if v := mpdTag(s, tag); v != "" {
+			r.field(tag, v)
+		}
+	}
+}
+
+// writeQueueSong describes the song at pos in the queue.
+func (l *mpdLibrary) writeQueueSong(r *mpdReply, m *model, pos int) {
+	l.writeSong(r, m.currentPlaylist[pos])
+	r.field("Pos", pos)
+	r.field("Id", m.queueIDs[pos])
+}
+
+// mpdInt parses an integer argument.
+func mpdInt(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, mpdErrorf(mpdErrArg, "Integer expected: %s", arg)
+	}
+	return n, nil
+}
+
+// mpdRange parses a position, or a START:END range (END may be left off),
+// of a list n long. As in MPD, a range's end is clipped to the list, so
+// "0:" of an empty list is empty rather than an error.
+func mpdRange(arg string, n int) (start, end int, err error) {
+	from, to, isRange := strings.Cut(arg, ":")
+	if start, err = mpdInt(from); err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		if start < 0 || start >= n {
+			return 0, 0, mpdErrorf(mpdErrArg, "Bad song index")
+		}
+		return start, start + 1, nil
+	}
+	end = n
+	if to != "" {
+		if end, err = mpdInt(to); err != nil {
+			return 0, 0, err
+		}
+		end = min(end, n)
+	}
+	if start < 0 || start > n || end < start {
+		return 0, 0, mpdErrorf(mpdErrArg, "Bad song index")
+	}
+	return start, end, nil
+}
+
+// mpdQueuePos parses a queue position, or for the *id commands an id.
+func mpdQueuePos(m *model, arg string, isID bool) (int, error) {
+	pos, err := mpdInt(arg)
+	if err != nil {
+		return 0, err
+	}
+	if isID {
+		pos = m.queuePosOfID(pos)
+	}
+	if pos < 0 || pos >= len(m.currentPlaylist) {
+		return 0, mpdErrorf(mpdErrNoExist, "No such song")
+	}
+	return pos, nil
+}
+
+func mpdStatusCommand(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	st := m.mpdState()
+	r.field("volume", st.volume)
+	for _, mode := range []string{"repeat", "random", "single", "consume"} {
+		r.field(mode, 0)
+	}
+	r.field("playlist", m.queueVersion+1)
+	r.field("playlistlength", len(m.currentPlaylist))
+	r.field("state", st.state)
+	if m.playingSong != nil && m.currentTrackIndex < len(m.currentPlaylist) {
+		r.field("song", m.currentTrackIndex)
+		r.field("songid", m.queueIDs[m.currentTrackIndex])
+		if m.hasNextTrack() {
+			r.field("nextsong", m.currentTrackIndex+1)
+			r.field("nextsongid", m.queueIDs[m.currentTrackIndex+1])
+		}
+	}
+	if st.state != "stop" {
+		duration := m.audioPlayer.GetDuration()
+		r.field("time", fmt.Sprintf("%d:%d", int(st.elapsed), int(math.Round(duration))))
+		r.field("elapsed", fmt.Sprintf("%.3f", st.elapsed))
+		if duration > 0 {
+			r.field("duration", fmt.Sprintf("%.3f", duration))
+		}
+	}
+	return nil, nil
+}
+
+func mpdCurrentSong(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	switch {
+	case m.playingSong != nil && m.currentTrackIndex < len(m.currentPlaylist):
+		mpdLibraryOf(m).writeQueueSong(r, m, m.currentTrackIndex)
+	case m.playingStation != nil:
+		r.field("file", m.playingStation.StreamURL)
+		r.field("Name", m.playingStation.Name)
+		if title := m.audioPlayer.StreamTitle(); title != "" {
+			r.field("Title", title)
+		}
+	}
+	return nil, nil
+}
+
+func mpdStats(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	artists, albums := map[string]bool{}, map[string]bool{}
+	var playtime float64
+	songs := mpdLibraryOf(m).songs
+	for _, s := range songs {
+		artists[s.Artist] = true
+		albums[albumArtistOf(s)+"\x00"+s.Album] = true
+		playtime += s.DurationSecs
+	}
+	r.field("artists", len(artists))
+	r.field("albums", len(albums))
+	r.field("songs", len(songs))
+	r.field("uptime", int(time.Since(m.mpd.started).Seconds()))
+	r.field("db_playtime", int(playtime))
+	r.field("db_update", m.mpd.started.Unix())
+	r.field("playtime", 0)
+	return nil, nil
+}
+
+// mpdTagTypesCommand lists the tags songs are described with. Turning tags
+// on and off is accepted, but every song is described in full.
+func mpdTagTypesCommand(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	if len(args) == 0 {
+		for _, tag := range mpdTagNames {
+			r.field("tagtype", tag)
+		}
+	}
+	return nil, nil
+}
+
+// mpdTransport makes a command of a transport command (see transport).
+func mpdTransport(command string) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		return m.transport(command, 0), nil
+	}
+}
+
+// mpdPlay plays the song at a position (play) or with an id (playid), or
+// carries on with the current one.
+func mpdPlay(byID bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		if len(args) == 0 || args[0] == "-1" {
+			return m.transport("play", 0), nil
+		}
+		pos, err := mpdQueuePos(m, args[0], byID)
+		if err != nil {
+			return nil, err
+		}
+		return m.playQueueIndex(pos)
+	}
+}
+
+func mpdPause(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	switch {
+	case len(args) == 0:
+		return m.transport("playpause", 0), nil
+	case args[0] == "1":
+		return m.transport("pause", 0), nil
+	case args[0] == "0":
+		return m.transport("play", 0), nil
+	}
+	return nil, mpdErrorf(mpdErrArg, "Boolean (0/1) expected: %s", args[0])
+}
+
+// mpdSeek seeks within the song at a position (seek POS TIME), with an id
+// (seekid ID TIME), or the current one (seekcur TIME, or +TIME and -TIME to
+// seek by).
+func mpdSeek(byID bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		if len(args) == 0 || len(args) > 2 {
+			return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+		}
+		var cmd tea.Cmd
+		if len(args) == 2 {
+			pos, err := mpdQueuePos(m, args[0], byID)
+			if err != nil {
+				return nil, err
+			}
+			if m.playingSong == nil || pos != m.currentTrackIndex {
+				if cmd, err = m.playQueueIndex(pos); err != nil {
+					return nil, err
+				}
+			}
+			args = args[1:]
+		}
+		seconds, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, mpdErrorf(mpdErrArg, "Float expected: %s", args[0])
+		}
+		if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+			return tea.Batch(cmd, m.transport("seek", seconds)), nil
+		}
+		return tea.Batch(cmd, m.transport("position", seconds)), nil
+	}
+}
+
+// mpdSetVol sets the volume in percent (setvol), or changes it by some
+// (volume).
+func mpdSetVol(relative bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		if len(args) != 1 {
+			return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+		}
+		percent, err := mpdInt(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if relative {
+			percent += int(math.Round(m.audioPlayer.Volume() * 100))
+		}
+		return m.transport("volume", math.Max(0, math.Min(float64(percent)/100, 1))), nil
+	}
+}
+
+func mpdModeOff(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	if len(args) != 1 || args[0] != "0" {
+		return nil, mpdErrorf(mpdErrArg, "not supported")
+	}
+	return nil, nil
+}
+
+// mpdPlaylistInfo describes the queue, a position or range of it
+// (playlistinfo), or the song with an id (playlistid).
+func mpdPlaylistInfo(byID bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		start, end := 0, len(m.currentPlaylist)
+		if len(args) > 0 {
+			var err error
+			if byID {
+				if start, err = mpdQueuePos(m, args[0], true); err != nil {
+					return nil, err
+				}
+				end = start + 1
+			} else if start, end, err = mpdRange(args[0], len(m.currentPlaylist)); err != nil {
+				return nil, err
+			}
+		}
+		l := mpdLibraryOf(m)
+		for i := start; i < end; i++ {
+			l.writeQueueSong(r, m, i)
+		}
+		return nil, nil
+	}
+}
+
+// mpdPlChanges lists the queue if it changed since the client's version:
+// the queue isn't edited in place, so any change is the whole of it.
+func mpdPlChanges(posID bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		if len(args) == 0 {
+			return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+		}
+		version, err := mpdInt(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if version == m.queueVersion+1 {
+			return nil, nil
+		}
+		if posID {
+			for i := range m.currentPlaylist {
+				r.field("cpos", i)
+				r.field("Id", m.queueIDs[i])
+			}
+			return nil, nil
+		}
+		return mpdPlaylistInfo(false)(m, args[1:], r)
+	}
+}
+
+// mpdAdd adds a song or directory to the queue (add), or a song, replying
+// with its id (addid); both at a position if one is given.
+func mpdAdd(withID bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		if len(args) == 0 || len(args) > 2 {
+			return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+		}
+		songs, err := mpdLibraryOf(m).songsAt(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pos := len(m.currentPlaylist)
+		if len(args) == 2 {
+			if pos, err = mpdInt(args[1]); err != nil {
+				return nil, err
+			}
+			if pos < 0 || pos > len(m.currentPlaylist) {
+				return nil, mpdErrorf(mpdErrArg, "Bad song index")
+			}
+		}
+		if withID && len(songs) != 1 {
+			return nil, mpdErrorf(mpdErrArg, "addid takes a song, not a directory")
+		}
+		m.insertSongs(pos, songs)
+		if withID {
+			r.field("Id", m.queueIDs[pos])
+		}
+		return nil, nil
+	}
+}
+
+func mpdClear(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	if m.playingSong != nil {
+		m.stopPlayback()
+	}
+	m.setPlaylist(nil, 0)
+	return nil, nil
+}
+
+// mpdDelete takes a position or range (delete), or the song with an id
+// (deleteid), out of the queue.
+func mpdDelete(byID bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		if len(args) != 1 {
+			return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+		}
+		if byID {
+			pos, err := mpdQueuePos(m, args[0], true)
+			if err != nil {
+				return nil, err
+			}
+			return m.removeSongs(pos, pos+1), nil
+		}
+		start, end, err := mpdRange(args[0], len(m.currentPlaylist))
+		if err != nil || start == end {
+			return nil, err
+		}
+		return m.removeSongs(start, end), nil
+	}
+}
+
+// mpdFind lists (find, search) or queues (findadd, searchadd) the songs a
+// filter matches. search compares ignoring case and accents; find exactly.
+// The results can be sorted by a tag and windowed as MPD's are.
+func mpdFind(fold, add bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		l := mpdLibraryOf(m)
+		match, rest, err := l.parseFilter(args, fold)
+		if err != nil {
+			return nil, err
+		}
+		var songs []Song
+		for _, s := range l.songs {
+			if match(s) {
+				songs = append(songs, s)
+			}
+		}
+		for len(rest) >= 2 {
+			switch rest[0] {
+			case "sort":
+				desc := strings.HasPrefix(rest[1], "-")
+				tag := strings.TrimPrefix(rest[1], "-")
+				sort.SliceStable(songs, func(i, j int) bool {
+					if desc {
+						return mpdTag(songs[j], tag) < mpdTag(songs[i], tag)
+					}
+					return mpdTag(songs[i], tag) < mpdTag(songs[j], tag)
+				})
+			case "window":
+				start, end, err := mpdRange(rest[1], len(songs))
+				if err != nil {
+					return nil, err
+				}
+				songs = songs[start:end]
+			default:
+				return nil, mpdErrorf(mpdErrArg, "unknown argument %q", rest[0])
+			}
+			rest = rest[2:]
+		}
+
+		if add {
+			m.insertSongs(len(m.currentPlaylist), songs)
+			return nil, nil
+		}
+		for _, s := range songs {
+			l.writeSong(r, s)
+		}
+		return nil, nil
+	}
+}
+
+// mpdCount counts the songs a filter matches and their playing time, in
+// all or per value of a group tag.
+func mpdCount(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	l := mpdLibraryOf(m)
+	match, rest, err := l.parseFilter(args, false)
+	if err != nil {
+		return nil, err
+	}
+	group := ""
+	if len(rest) == 2 && rest[0] == "group" {
+		if group = mpdTagName(rest[1]); group == "" {
+			return nil, mpdErrorf(mpdErrArg, "Unknown tag type: %s", rest[1])
+		}
+	}
+	type tally struct {
+		songs    int
+		playtime float64
+	}
+	tallies := map[string]*tally{}
+	var keys []string
+	for _, s := range l.songs {
+		if !match(s) {
+			continue
+		}
+		key := mpdTag(s, group)
+		if tallies[key] == nil {
+			tallies[key] = &tally{}
+			keys = append(keys, key)
+		}
+		tallies[key].songs++
+		tallies[key].playtime += s.DurationSecs
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 && group == "" {
+		keys, tallies[""] = []string{""}, &tally{}
+	}
+	for _, key := range keys {
+		if group != "" {
+			r.field(group, key)
+		}
+		r.field("songs", tallies[key].songs)
+		r.field("playtime", int(tallies[key].playtime))
+	}
+	return nil, nil
+}
+
+// mpdList lists the values of a tag among the songs a filter matches,
+// grouped by other tags if asked to. `list album ARTIST`, from before
+// filters, lists an artist's albums.
+func mpdList(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	if len(args) == 0 {
+		return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+	}
+	tag := mpdTagName(args[0])
+	if strings.EqualFold(args[0], "file") {
+		tag = "file"
+	} else if tag == "" {
+		return nil, mpdErrorf(mpdErrArg, "Unknown tag type: %s", args[0])
+	}
+	args = args[1:]
+	if tag == "Album" && len(args) == 1 {
+		args = []string{"artist", args[0]}
+	}
+	l := mpdLibraryOf(m)
+	match, rest, err := l.parseFilter(args, false)
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for len(rest) >= 2 && rest[0] == "group" {
+		group := mpdTagName(rest[1])
+		if group == "" {
+			return nil, mpdErrorf(mpdErrArg, "Unknown tag type: %s", rest[1])
+		}
+		groups = append(groups, group)
+		rest = rest[2:]
+	}
+
+	// Each row is the group values and then the value, joined.
+	seen := map[string]bool{}
+	var rows [][]string
+	for _, s := range l.songs {
+		if !match(s) {
+			continue
+		}
+		var row []string
+		for _, g := range groups {
+			row = append(row, mpdTag(s, g))
+		}
+		if tag == "file" {
+			row = append(row, l.uri(s.FilePath))
+		} else {
+			row = append(row, mpdTag(s, tag))
+		}
+		key := strings.Join(row, "\x00")
+		if row[len(row)-1] != "" && !seen[key] {
+			seen[key] = true
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return strings.Join(rows[i], "\x00") < strings.Join(rows[j], "\x00")
+	})
+	var last []string
+	for _, row := range rows {
+		for i, g := range groups {
+			if last == nil || row[i] != last[i] {
+				r.field(g, row[i])
+			}
+		}
+		r.field(tag, row[len(row)-1])
+		last = row
+	}
+	return nil, nil
+}
+
+// mpdLsInfo lists a directory: at the top, the library folders and the
+// stored playlists; below, its subdirectories and songs. Given a song, it
+// describes the song.
+func mpdLsInfo(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	l := mpdLibraryOf(m)
+	uri := ""
+	if len(args) > 0 {
+		uri = strings.Trim(args[0], "/")
+	}
+	if uri == "" {
+		var names []string
+		for name := range l.roots {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r.field("directory", name)
+		}
+		for _, p := range m.playlistManager.GetPlaylists() {
+			r.field("playlist", p.Name)
+			r.field("Last-Modified", m.mpd.started.UTC().Format(time.RFC3339))
+		}
+		return nil, nil
+	}
+
+	dir, ok := l.path(uri)
+	if !ok {
+		return nil, mpdErrorf(mpdErrNoExist, "No such directory")
+	}
+	subdirs := map[string]bool{}
+	var songs []Song
+	for _, s := range l.songs {
+		if s.FilePath == dir {
+			l.writeSong(r, s)
+			return nil, nil
+		}
+		rest, ok := strings.CutPrefix(s.FilePath, dir+string(filepath.Separator))
+		if !ok {
+			continue
+		}
+		if sub, _, nested := strings.Cut(rest, string(filepath.Separator)); nested {
+			subdirs[sub] = true
+		} else {
+			songs = append(songs, s)
+		}
+	}
+	if len(subdirs) == 0 && len(songs) == 0 {
+		if _, ok := l.roots[uri]; !ok {
+			return nil, mpdErrorf(mpdErrNoExist, "No such directory")
+		}
+	}
+	var names []string
+	for sub := range subdirs {
+		names = append(names, sub)
+	}
+	sort.Strings(names)
+	for _, sub := range names {
+		r.field("directory", uri+"/"+filepath.ToSlash(sub))
+	}
+	sortByFolder(songs)
+	for _, s := range songs {
+		l.writeSong(r, s)
+	}
+	return nil, nil
+}
+
+// mpdListAll lists everything under a directory: the files (listall) or
+// the songs described (listallinfo), each folder's after the folder.
+func mpdListAll(info bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		l := mpdLibraryOf(m)
+		uri := ""
+		if len(args) > 0 {
+			uri = args[0]
+		}
+		songs, err := l.songsAt(uri)
+		if err != nil {
+			return nil, err
+		}
+		songs = append([]Song(nil), songs...)
+		sortByFolder(songs)
+		info := info
+		lastDir := ""
+		for _, s := range songs {
+			if dir := pathDir(l.uri(s.FilePath)); dir != lastDir && dir != "." {
+				r.field("directory", dir)
+				lastDir = dir
+			}
+			if info {
+				l.writeSong(r, s)
+			} else {
+				r.field("file", l.uri(s.FilePath))
+			}
+		}
+		return nil, nil
+	}
+}
+
+// pathDir is the directory part of a URI.
+func pathDir(uri string) string {
+	if i := strings.LastIndex(uri, "/"); i >= 0 {
+		return uri[:i]
+	}
+	return "."
+}
+
+// mpdUpdate rescans the library, as r does in the library view.
+func mpdUpdate(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	cmd := m.startRescan()
+	r.field("updating_db", 1)
+	return cmd, nil
+}
+
+func mpdListPlaylists(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	for _, p := range m.playlistManager.GetPlaylists() {
+		r.field("playlist", p.Name)
+		r.field("Last-Modified", m.mpd.started.UTC().Format(time.RFC3339))
+	}
+	return nil, nil
+}
+
+// mpdListPlaylist lists a stored playlist's files (listplaylist), or
+// describes its songs (listplaylistinfo).
+func mpdListPlaylist(info bool) mpdCommand {
+	return func(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+		songs, err := mpdStoredPlaylist(m, args)
+		if err != nil {
+			return nil, err
+		}
+		l := mpdLibraryOf(m)
+		for _, s := range songs {
+			if info {
+				l.writeSong(r, s)
+			} else {
+				r.field("file", l.uri(s.FilePath))
+			}
+		}
+		return nil, nil
+	}
+}
+
+// mpdLoad adds a stored playlist, or a range of it, to the queue.
+func mpdLoad(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	songs, err := mpdStoredPlaylist(m, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 1 {
+		start, end, err := mpdRange(args[1], len(songs))
+		if err != nil {
+			return nil, err
+		}
+		songs = songs[start:end]
+	}
+	m.insertSongs(len(m.currentPlaylist), songs)
+	return nil, nil
+}
+
+// mpdStoredPlaylist returns the songs of the playlist named by the first
+// argument.
+func mpdStoredPlaylist(m *model, args []string) ([]Song, error) {
+	if len(args) == 0 {
+		return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+	}
+	if m.playlistManager.Get(args[0]) == nil {
+		return nil, mpdErrorf(mpdErrNoExist, "No such playlist")
+	}
+	return m.playlistManager.SongsOf(args[0]), nil
+}
+
+// mpdPlaylistAdd adds a song or directory to a stored playlist, which is
+// made if there isn't one by that name.
+func mpdPlaylistAdd(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	if len(args) != 2 {
+		return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+	}
+	songs, err := mpdLibraryOf(m).songsAt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.playlistManager.AddSongs(args[0], songs); err != nil {
+		return nil, mpdErrorf(mpdErrArg, "%v", err)
+	}
+	m.refreshPlaylistListIfShown()
+	return nil, nil
+}
+
+// mpdSave saves the queue as a new stored playlist.
+func mpdSave(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+	}
+	if err := m.playlistManager.Create(args[0]); err != nil {
+		return nil, mpdErrorf(mpdErrArg, "%v", err)
+	}
+	if _, err := m.playlistManager.AddSongs(args[0], m.currentPlaylist); err != nil {
+		return nil, mpdErrorf(mpdErrArg, "%v", err)
+	}
+	m.refreshPlaylistListIfShown()
+	return nil, nil
+}
+
+func mpdRm(m *model, args []string, r *mpdReply) (tea.Cmd, error) {
+	if len(args) != 1 {
+		return nil, mpdErrorf(mpdErrArg, "wrong number of arguments")
+	}
+	if IsBuiltin(args[0]) {
+		return nil, mpdErrorf(mpdErrArg, "%q is built in", args[0])
+	}
+	if err := m.playlistManager.Delete(args[0]); err != nil {
+		return nil, mpdErrorf(mpdErrNoExist, "No such playlist")
+	}
+	m.refreshPlaylistListIfShown()
+	return nil, nil
+}
+
+// mpdFilter says whether a song matches a filter.
+type mpdFilter func(s Song) bool
+
+// parseFilter parses the filter at the start of args, returning what
+// follows it (sort, window and group arguments). The filter is either an
+// expression, such as
+//
+//	((artist == "Nina Simone") AND (!(album contains 'live')))
+//
+// with ==, !=, contains, starts_with, =~ and !~ comparisons of tags, "any"
+// or "file", and (base "dir"); or, from before expressions, tag value
+// pairs. fold compares ignoring case and accents, and has the pairs match
+// substrings, as search does.
+func (l *mpdLibrary) parseFilter(args []string, fold bool) (mpdFilter, []string, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "(") {
+		p := &mpdExprParser{s: args[0], l: l, fold: fold}
+		match, err := p.expr()
+		if err == nil && strings.TrimSpace(p.s[p.i:]) != "" {
+			err = fmt.Errorf("unparsed garbage after expression")
+		}
+		if err != nil {
+			return nil, nil, mpdErrorf(mpdErrArg, "%v", err)
+		}
+		return match, args[1:], nil
+	}
+
+	var filters []mpdFilter
+	for len(args) >= 2 && args[0] != "sort" && args[0] != "window" && args[0] != "group" {
+		op := "=="
+		if fold {
+			op = "contains"
+		}
+		f, err := l.compare(args[0], op, args[1], fold)
+		if err != nil {
+			return nil, nil, err
+		}
+		filters = append(filters, f)
+		args = args[2:]
+	}
+	return mpdAll(filters), args, nil
+}
+
+// mpdAll matches the songs all the filters match.
+func mpdAll(filters []mpdFilter) mpdFilter {
+	return func(s Song) bool {
+		for _, f := range filters {
+			if !f(s) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// compare makes a filter of one comparison of a tag with a value.
+func (l *mpdLibrary) compare(tag, op, value string, fold bool) (mpdFilter, error) {
+	if strings.EqualFold(tag, "base") {
+		dir, ok := l.path(value)
+		if !ok {
+			return nil, mpdErrorf(mpdErrNoExist, "No such directory")
+		}
+		return func(s Song) bool { return strings.HasPrefix(s.FilePath, dir+string(filepath.Separator)) }, nil
+	}
+
+	var values func(s Song) []string
+	switch {
+	case strings.EqualFold(tag, "any"):
+		values = func(s Song) []string {
+			return []string{s.Title, s.Artist, albumArtistOf(s), s.Album, s.Genre, s.Composer, l.uri(s.FilePath)}
+		}
+	case strings.EqualFold(tag, "file"):
+		values = func(s Song) []string { return []string{l.uri(s.FilePath)} }
+	case mpdTagName(tag) != "":
+		values = func(s Song) []string { return []string{mpdTag(s, tag)} }
+	default:
+		return nil, mpdErrorf(mpdErrArg, "Unknown filter type: %s", tag)
+	}
+
+	if fold {
+		value = foldString(value, false)
+	}
+	var matches func(v string) bool
+	switch op {
+	case "==", "!=":
+		matches = func(v string) bool { return v == value }
+	case "contains":
+		matches = func(v string) bool { return strings.Contains(v, value) }
+	case "starts_with":
+		matches = func(v string) bool { return strings.HasPrefix(v, value) }
+	case "=~", "!~":
+		pattern := value
+		if fold {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, mpdErrorf(mpdErrArg, "%v", err)
+		}
+		matches = re.MatchString
+	default:
+		return nil, mpdErrorf(mpdErrArg, "Unknown filter operator: %s", op)
+	}
+	negate := op == "!=" || op == "!~"
+	return func(s Song) bool {
+		for _, v := range values(s) {
+			if fold {
+				v = foldString(v, false)
+			}
+			if matches(v) {
+				return !negate
+			}
+		}
+		return negate
+	}, nil
+}
+
+// mpdExprParser parses a filter expression.
+type mpdExprParser struct {
+	s    string
+	i    int
+	l    *mpdLibrary
+	fold bool
+}
+
+func (p *mpdExprParser) skipSpace() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+// expect consumes c, after any space.
+func (p *mpdExprParser) expect(c byte) error {
+	p.skipSpace()
+	if p.i >= len(p.s) || p.s[p.i] != c {
+		return fmt.Errorf("'%c' expected", c)
+	}
+	p.i++
+	return nil
+}
+
+// word reads up to the next space or parenthesis.
+func (p *mpdExprParser) word() string {
+	p.skipSpace()
+	start := p.i
+	for p.i < len(p.s) && !strings.ContainsRune(" ()", rune(p.s[p.i])) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// quoted reads a string in single or double quotes, with backslash escapes.
+func (p *mpdExprParser) quoted() (string, error) {
+	p.skipSpace()
+	if p.i >= len(p.s) || (p.s[p.i] != '"' && p.s[p.i] != '\'') {
+		return "", fmt.Errorf("quoted string expected")
+	}
+	quote := p.s[p.i]
+	var b strings.Builder
+	for p.i++; p.i < len(p.s) && p.s[p.i] != quote; p.i++ {
+		if p.s[p.i] == '\\' && p.i+1 < len(p.s) {
+			p.i++
+		}
+		b.WriteByte(p.s[p.i])
+	}
+	if p.i >= len(p.s) {
+		return "", fmt.Errorf("closing quote expected")
+	}
+	p.i++
+	return b.String(), nil
+}
+
+// expr parses one parenthesized expression: a negation, an AND of
+// expressions, or a comparison.
+func (p *mpdExprParser) expr() (mpdFilter, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.i >= len(p.s) {
+		return nil, fmt.Errorf("expression expected")
+	}
+
+	switch p.s[p.i] {
+	case '!':
+		p.i++
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return func(s Song) bool { return !inner(s) }, p.expect(')')
+	case '(':
+		var filters []mpdFilter
+		for {
+			f, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+			p.skipSpace()
+			if p.i < len(p.s) && p.s[p.i] == ')' {
+				p.i++
+				return mpdAll(filters), nil
+			}
+			if w := p.word(); w != "AND" {
+				return nil, fmt.Errorf("'AND' expected")
+			}
+		}
+	}
+
+	tag := p.word()
+	op := "=="
+	if !strings.EqualFold(tag, "base") {
+		op = p.word()
+	}
+	value, err := p.quoted()
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.l.compare(tag, op, value, p.fold)
+	if err != nil {
+		return nil, err
+	}
+	return f, p.expect(')')
+}
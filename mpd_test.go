@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMPDArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"status", []string{"status"}},
+		{"  play\t3 ", []string{"play", "3"}},
+		{`add "music/Nina/01 - Blue Song.wav"`, []string{"add", "music/Nina/01 - Blue Song.wav"}},
+		{`find "(artist == \"Nina \\\"N\\\" Simone\")"`, []string{"find", `(artist == "Nina \"N\" Simone")`}},
+		{`search any ""`, []string{"search", "any", ""}},
+	}
+	for _, tt := range tests {
+		got, err := splitMPDArgs(tt.line)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitMPDArgs(%q) = %q, %v; want %q", tt.line, got, err, tt.want)
+		}
+	}
+	if _, err := splitMPDArgs(`add "unterminated`); err == nil {
+		t.Errorf("unterminated quote wasn't an error")
+	}
+}
+
+func TestMPDRange(t *testing.T) {
+	tests := []struct {
+		arg        string
+		n          int
+		start, end int
+		ok         bool
+	}{
+		{"2", 5, 2, 3, true},
+		{"5", 5, 0, 0, false},
+		{"-1", 5, 0, 0, false},
+		{"1:3", 5, 1, 3, true},
+		{"1:", 5, 1, 5, true},
+		{"3:99", 5, 3, 5, true}, // end clipped to the list
+		{"0:", 0, 0, 0, true},   // empty list, empty range
+		{"5:", 5, 5, 5, true},
+		{"6:", 5, 0, 0, false},
+		{"3:1", 5, 0, 0, false},
+		{"x", 5, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, err := mpdRange(tt.arg, tt.n)
+		if ok := err == nil; ok != tt.ok || (ok && (start != tt.start || end != tt.end)) {
+			t.Errorf("mpdRange(%q, %d) = %d, %d, %v", tt.arg, tt.n, start, end, err)
+		}
+	}
+}
+
+func TestMPDReplyField(t *testing.T) {
+	var r mpdReply
+	r.field("Comment", "line one\r\nline two\nOK")
+	r.field("Pos", 3)
+	if got, want := r.String(), "Comment: line one  line two OK\nPos: 3\n"; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+}
+
+func testMPDLibrary() *mpdLibrary {
+	root := filepath.FromSlash("/lib/music")
+	song := func(rel, title, artist, albumArtist, album string) Song {
+		return Song{FilePath: filepath.Join(root, filepath.FromSlash(rel)), Title: title, Artist: artist, AlbumArtist: albumArtist, Album: album}
+	}
+	return &mpdLibrary{
+		roots: map[string]string{"music": root},
+		songs: []Song{
+			song("Nina/Pastel/01.mp3", "Blue Song", "Nina", "", "Pastel"),
+			song("Nina/Pastel/02.mp3", "Red Song", "Nina feat. Ola", "Nina", "Pastel"),
+			song("Various/Live/01.mp3", "Café Tune", "Ola", "Various Artists", "Live at Home"),
+		},
+	}
+}
+
+func TestMPDParseFilter(t *testing.T) {
+	l := testMPDLibrary()
+	tests := []struct {
+		args []string
+		fold bool
+		want string // titles matched, comma separated
+		rest []string
+	}{
+		{[]string{"artist", "Nina"}, false, "Blue Song", nil},
+		{[]string{"albumartist", "Nina"}, false, "Blue Song,Red Song", nil},
+		{[]string{"artist", "nina"}, true, "Blue Song,Red Song", nil},
+		{[]string{"title", "cafe"}, true, "Café Tune", nil},
+		{[]string{"album", "Pastel", "title", "Red Song", "window", "0:1"}, false, "Red Song", []string{"window", "0:1"}},
+		{[]string{`(artist == "Nina")`}, false, "Blue Song", nil},
+		{[]string{`((album == "Pastel") AND (!(title contains 'Red')))`}, false, "Blue Song", nil},
+		{[]string{`(title =~ "^(Blue|Café)")`}, false, "Blue Song,Café Tune", nil},
+		{[]string{`(base "music/Various")`}, false, "Café Tune", nil},
+		{[]string{`(file starts_with "music/Nina/")`, "sort", "Title"}, false, "Blue Song,Red Song", []string{"sort", "Title"}},
+	}
+	for _, tt := range tests {
+		match, rest, err := l.parseFilter(tt.args, tt.fold)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tt.args, err)
+			continue
+		}
+		var titles []string
+		for _, s := range l.songs {
+			if match(s) {
+				titles = append(titles, s.Title)
+			}
+		}
+		if got := strings.Join(titles, ","); got != tt.want || strings.Join(rest, " ") != strings.Join(tt.rest, " ") {
+			t.Errorf("parseFilter(%q, %v) matched %q, rest %q; want %q, rest %q", tt.args, tt.fold, got, rest, tt.want, tt.rest)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"(artist == \"Nina\""},
+		{"(artist === \"Nina\")"},
+		{"(artist == \"Nina\") extra"},
+		{"(nosuchtag == \"x\")"},
+		{"(base \"music/../etc\")"},
+	} {
+		if _, _, err := l.parseFilter(args, false); err == nil {
+			t.Errorf("parseFilter(%q) wasn't an error", args)
+		}
+	}
+}
+
+// TestMPDWriteSongAlbumArtist checks a song is described with the album
+// artist that filters and list use, falling back on the artist.
+func TestMPDWriteSongAlbumArtist(t *testing.T) {
+	l := testMPDLibrary()
+	var r mpdReply
+	l.writeSong(&r, l.songs[0])
+	if !strings.Contains(r.String(), "\nAlbumArtist: Nina\n") {
+		t.Errorf("reply lacks the fallback album artist:\n%s", r.String())
+	}
+	if !strings.HasPrefix(r.String(), "file: music/Nina/Pastel/01.mp3\n") {
+		t.Errorf("reply doesn't start with the song's URI:\n%s", r.String())
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// Desktop media controls. On Linux, Resona is an MPRIS2 player on the
+// session bus (see mpris_linux.go), so media keys, the GNOME and KDE panels
+// and playerctl can drive it. Their requests arrive as mprisMsg, and the
+// model reports what's playing back with publishMPRIS on each art tick.
+
+// mprisMsg is a request from an MPRIS client.
+type mprisMsg struct {
+	command string  // see transport
+	value   float64 // seconds for seek (relative) and position (absolute); the level for volume
+}
+
+// mprisState is what the MPRIS service shows of the player.
+type mprisState struct {
+	status      string // "Playing", "Paused" or "Stopped"
+	song        *Song
+	station     *RadioStation
+	streamTitle string  // what the station says is playing
+	artPath     string  // the playing song's cover, as an image file
+	position    float64 // seconds into the track
+	volume      float64
+	canSeek     bool
+	canNext     bool
+	canPrevious bool
+}
+
+// mprisState describes the player for the MPRIS service.
+func (m *model) mprisState() mprisState {
+	st := mprisState{
+		status:      "Stopped",
+		song:        m.playingSong,
+		station:     m.playingStation,
+		volume:      m.audioPlayer.Volume(),
+		canNext:     m.hasNextTrack(),
+		canPrevious: m.hasPreviousTrack(),
+	}
+	if m.playingSong == nil && m.playingStation == nil {
+		return st
+	}
+	st.status = "Playing"
+	if m.audioPlayer.IsPaused() {
+		st.status = "Paused"
+	}
+	if m.playingStation != nil {
+		st.streamTitle = m.audioPlayer.StreamTitle()
+		return st
+	}
+	st.position = m.audioPlayer.GetPosition()
+	st.canSeek = m.audioPlayer.CanSeek()
+	if cover, ok := m.artCache.Cover(*m.playingSong); ok && cover != nil {
+		st.artPath = cover.Path
+	}
+	return st
+}
+
+// publishMPRIS brings the MPRIS service up to date.
+func (m *model) publishMPRIS() {
+	m.mpris.Update(m.mprisState())
+}
+
+// handleMPRIS carries out a request from an MPRIS client.
+func (m model) handleMPRIS(msg mprisMsg) (model, tea.Cmd) {
+	cmd := m.transport(msg.command, msg.value)
+	m.publishMPRIS()
+	return m, tea.Batch(cmd, m.mpris.Listen())
+}
+
+// transport carries out a transport command, from MPRIS or the control
+// socket: "play", "pause", "playpause", "stop", "next", "previous", "seek"
+// (value seconds, relative), "position" (value seconds, absolute), "volume"
+// (value 0..1) or "quit".
+func (m *model) transport(command string, value float64) tea.Cmd {
+	var cmd tea.Cmd
+	paused := m.audioPlayer.IsPaused()
+	switch command {
+	case "playpause", "play":
+		switch {
+		case m.playingSong == nil && m.playingStation == nil:
+			// Stopped: start the current playlist again.
+			if m.playCurrentTrack() {
+				cmd = tickCmd()
+			}
+		case paused || command == "playpause":
+			cmd = m.togglePause()
+		}
+	case "pause":
+		if m.audioPlayer.IsPlaying() {
+			cmd = m.togglePause()
+		}
+	case "stop":
+		m.stopPlayback()
+	case "next":
+		if m.playNextTrack() {
+			cmd = tickCmd()
+		}
+	case "previous":
+		if m.playPreviousTrack() {
+			cmd = tickCmd()
+		}
+	case "seek":
+		if m.playingSong != nil && m.audioPlayer.CanSeek() {
+			m.audioPlayer.SeekBy(value)
+		}
+	case "position":
+		if m.playingSong != nil && m.audioPlayer.CanSeek() {
+			m.audioPlayer.SeekTo(value)
+		}
+	case "volume":
+		m.setVolume(value)
+	case "quit":
+		m.endListen(false, false)
+		m.audioPlayer.Stop()
+		return tea.Quit
+	}
+	return cmd
+}
+
+// setVolume sets the output volume (0..1) and remembers it, unless attached
+// to a daemon, which remembers it itself.
+func (m *model) setVolume(level float64) {
+	m.audioPlayer.SetVolume(level)
+	percent := int(math.Round(m.audioPlayer.Volume() * 100))
+	if m.remote == nil {
+		m.settingsManager.SetVolume(percent)
+	}
+	m.statusFlash = fmt.Sprintf("Volume %d%%", percent)
+}
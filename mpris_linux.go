@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// The MPRIS2 service: org.mpris.MediaPlayer2 and its Player interface on
+// the session bus. Method calls are passed to the model as mprisMsg; the
+// properties are kept up to date by Update. The bus is the one in
+// DBUS_SESSION_BUS_ADDRESS, so a private dbus-daemon works for testing:
+//
+//	dbus-daemon --session --print-address --fork
+//	DBUS_SESSION_BUS_ADDRESS=<address> resona
+//	DBUS_SESSION_BUS_ADDRESS=<address> playerctl -p resona play-pause
+
+const (
+	mprisPath        = "/org/mpris/MediaPlayer2"
+	mprisBusName     = "org.mpris.MediaPlayer2.resona"
+	mprisRootIface   = "org.mpris.MediaPlayer2"
+	mprisPlayerIface = "org.mpris.MediaPlayer2.Player"
+	mprisNoTrack     = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+)
+
+// MPRIS is the running service. A nil *MPRIS (no session bus) does nothing.
+type MPRIS struct {
+	conn  *dbus.Conn
+	props *prop.Properties
+	msgs  chan mprisMsg
+
+	mu       sync.Mutex
+	track    dbus.ObjectPath // track last published
+	position float64         // its position then, in seconds
+	at       time.Time       // when that was
+	playing  bool
+}
+
+// StartMPRIS connects to the session bus and takes the player's name there,
+// or a per-process one if another Resona already has it.
+func StartMPRIS() (*MPRIS, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	mp := &MPRIS{conn: conn, msgs: make(chan mprisMsg, 16)}
+
+	mp.props, err = prop.Export(conn, mprisPath, prop.Map{
+		mprisRootIface: {
+			"CanQuit":             {Value: true, Emit: prop.EmitConst},
+			"CanRaise":            {Value: false, Emit: prop.EmitConst},
+			"HasTrackList":        {Value: false, Emit: prop.EmitConst},
+			"Identity":            {Value: "Resona", Emit: prop.EmitConst},
+			"SupportedUriSchemes": {Value: []string{}, Emit: prop.EmitConst},
+			"SupportedMimeTypes":  {Value: []string{}, Emit: prop.EmitConst},
+		},
+		mprisPlayerIface: {
+			"PlaybackStatus": {Value: "Stopped", Emit: prop.EmitTrue},
+			"Rate":           {Value: 1.0, Emit: prop.EmitConst},
+			"MinimumRate":    {Value: 1.0, Emit: prop.EmitConst},
+			"MaximumRate":    {Value: 1.0, Emit: prop.EmitConst},
+			"Metadata":       {Value: map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(mprisNoTrack)}, Emit: prop.EmitTrue},
+			"Volume":         {Value: 1.0, Writable: true, Emit: prop.EmitTrue, Callback: mp.setVolume},
+			"Position":       {Value: int64(0), Emit: prop.EmitFalse},
+			"CanGoNext":      {Value: false, Emit: prop.EmitTrue},
+			"CanGoPrevious":  {Value: false, Emit: prop.EmitTrue},
+			"CanPlay":        {Value: true, Emit: prop.EmitTrue},
+			"CanPause":       {Value: true, Emit: prop.EmitTrue},
+			"CanSeek":        {Value: false, Emit: prop.EmitTrue},
+			"CanControl":     {Value: true, Emit: prop.EmitConst},
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Export(mprisRoot{mp}, mprisPath, mprisRootIface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.ExportWithMap(mprisPlayer{mp}, mprisPlayerMethods, mprisPath, mprisPlayerIface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	node := &introspect.Node{
+		Name: mprisPath,
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{Name: mprisRootIface, Methods: introspect.Methods(mprisRoot{}), Properties: mp.props.Introspection(mprisRootIface)},
+			{
+				Name:       mprisPlayerIface,
+				Methods:    mprisPlayerIntrospection(),
+				Properties: mp.props.Introspection(mprisPlayerIface),
+				Signals:    []introspect.Signal{{Name: "Seeked", Args: []introspect.Arg{{Name: "Position", Type: "x"}}}},
+			},
+		},
+	}
+	if err := conn.Export(introspect.NewIntrospectable(node), mprisPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for _, name := range []string{mprisBusName, fmt.Sprintf("%s.instance%d", mprisBusName, os.Getpid())} {
+		reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if reply == dbus.RequestNameReplyPrimaryOwner {
+			return mp, nil
+		}
+	}
+	conn.Close()
+	return nil, fmt.Errorf("couldn't take an MPRIS bus name")
+}
+
+// Listen returns a command that waits for the next request from an MPRIS
+// client. The model issues it again after handling each one.
+func (mp *MPRIS) Listen() tea.Cmd {
+	if mp == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return <-mp.msgs
+	}
+}
+
+// Close leaves the bus.
+func (mp *MPRIS) Close() {
+	if mp != nil {
+		mp.conn.Close()
+	}
+}
+
+// send queues a request for the model. Requests beyond what the queue holds
+// are dropped rather than holding up the bus.
+func (mp *MPRIS) send(msg mprisMsg) {
+	select {
+	case mp.msgs <- msg:
+	default:
+		log.Printf("DEBUG: MPRIS request %q dropped", msg.command)
+	}
+}
+
+// Update publishes the player's state, signalling the properties that
+// changed. A position that doesn't follow from the last one (a seek, from
+// here or from the keyboard) is signalled with Seeked.
+func (mp *MPRIS) Update(st mprisState) {
+	if mp == nil {
+		return
+	}
+	metadata := mprisMetadata(st)
+	track, _ := metadata["mpris:trackid"].Value().(dbus.ObjectPath)
+	mp.set(mprisPlayerIface, "PlaybackStatus", st.status)
+	mp.set(mprisPlayerIface, "Metadata", metadata)
+	mp.set(mprisPlayerIface, "Volume", st.volume)
+	mp.set(mprisPlayerIface, "CanGoNext", st.canNext)
+	mp.set(mprisPlayerIface, "CanGoPrevious", st.canPrevious)
+	mp.set(mprisPlayerIface, "CanPlay", st.song != nil || st.station != nil || st.canNext)
+	mp.set(mprisPlayerIface, "CanPause", st.status != "Stopped")
+	mp.set(mprisPlayerIface, "CanSeek", st.canSeek)
+	mp.set(mprisPlayerIface, "Position", mprisMicros(st.position))
+
+	mp.mu.Lock()
+	expected := mp.position
+	if mp.playing {
+		expected += time.Since(mp.at).Seconds()
+	}
+	jumped := st.canSeek && track == mp.track && math.Abs(st.position-expected) > 1
+	mp.track, mp.position, mp.at, mp.playing = track, st.position, time.Now(), st.status == "Playing"
+	mp.mu.Unlock()
+	if jumped {
+		mp.conn.Emit(mprisPath, mprisPlayerIface+".Seeked", mprisMicros(st.position))
+	}
+}
+
+// set changes a property if its value differs, which signals the change.
+func (mp *MPRIS) set(iface, name string, value any) {
+	if !reflect.DeepEqual(mp.props.GetMust(iface, name), value) {
+		mp.props.SetMust(iface, name, value)
+	}
+}
+
+// setVolume passes a client's change of the Volume property to the model.
+func (mp *MPRIS) setVolume(c *prop.Change) *dbus.Error {
+	level, ok := c.Value.(float64)
+	if !ok {
+		return prop.ErrInvalidArg
+	}
+	mp.send(mprisMsg{command: "volume", value: level})
+	return nil
+}
+
+// mprisMetadata describes what's playing in MPRIS (xesam) terms. A radio
+// station's track comes from its stream title, with the station as the
+// album.
+func mprisMetadata(st mprisState) map[string]dbus.Variant {
+	md := map[string]dbus.Variant{}
+	switch {
+	case st.song != nil:
+		s := st.song
+		md["mpris:trackid"] = dbus.MakeVariant(mprisTrackID(s.FilePath))
+		md["xesam:url"] = dbus.MakeVariant((&url.URL{Scheme: "file", Path: s.FilePath}).String())
+		md["xesam:title"] = dbus.MakeVariant(s.Title)
+		md["xesam:artist"] = dbus.MakeVariant([]string{s.Artist})
+		md["xesam:album"] = dbus.MakeVariant(s.Album)
+		if s.AlbumArtist != "" {
+			md["xesam:albumArtist"] = dbus.MakeVariant([]string{s.AlbumArtist})
+		}
+		if s.Genre != "" {
+			md["xesam:genre"] = dbus.MakeVariant([]string{s.Genre})
+		}
+		if s.TrackNumber > 0 {
+			md["xesam:trackNumber"] = dbus.MakeVariant(int32(s.TrackNumber))
+		}
+		if s.DiscNumber > 0 {
+			md["xesam:discNumber"] = dbus.MakeVariant(int32(s.DiscNumber))
+		}
+		if s.DurationSecs > 0 {
+			md["mpris:length"] = dbus.MakeVariant(mprisMicros(s.DurationSecs))
+		}
+		if st.artPath != "" {
+			md["mpris:artUrl"] = dbus.MakeVariant((&url.URL{Scheme: "file", Path: st.artPath}).String())
+		}
+	case st.station != nil:
+		md["mpris:trackid"] = dbus.MakeVariant(mprisTrackID(st.station.StreamURL))
+		md["xesam:url"] = dbus.MakeVariant(st.station.StreamURL)
+		md["xesam:album"] = dbus.MakeVariant(st.station.Name)
+		if artist, title, ok := splitStreamTitle(st.streamTitle); ok {
+			md["xesam:artist"] = dbus.MakeVariant([]string{artist})
+			md["xesam:title"] = dbus.MakeVariant(title)
+		} else if st.streamTitle != "" {
+			md["xesam:title"] = dbus.MakeVariant(st.streamTitle)
+		} else {
+			md["xesam:title"] = dbus.MakeVariant(st.station.Name)
+		}
+		if st.station.Genre != "" {
+			md["xesam:genre"] = dbus.MakeVariant([]string{st.station.Genre})
+		}
+	default:
+		md["mpris:trackid"] = dbus.MakeVariant(mprisNoTrack)
+	}
+	return md
+}
+
+// mprisTrackID makes a D-Bus object path for a track from its path or URL.
+func mprisTrackID(key string) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("/org/resona/track/%08x", crc32.ChecksumIEEE([]byte(key))))
+}
+
+// mprisMicros converts seconds to the microseconds MPRIS counts in.
+func mprisMicros(seconds float64) int64 {
+	return int64(seconds * 1e6)
+}
+
+// mprisRoot implements org.mpris.MediaPlayer2.
+type mprisRoot struct{ mp *MPRIS }
+
+func (r mprisRoot) Raise() *dbus.Error { return nil }
+
+func (r mprisRoot) Quit() *dbus.Error {
+	r.mp.send(mprisMsg{command: "quit"})
+	return nil
+}
+
+// mprisPlayer implements org.mpris.MediaPlayer2.Player.
+type mprisPlayer struct{ mp *MPRIS }
+
+func (p mprisPlayer) Next() *dbus.Error      { return p.command("next") }
+func (p mprisPlayer) Previous() *dbus.Error  { return p.command("previous") }
+func (p mprisPlayer) Pause() *dbus.Error     { return p.command("pause") }
+func (p mprisPlayer) PlayPause() *dbus.Error { return p.command("playpause") }
+func (p mprisPlayer) Stop() *dbus.Error      { return p.command("stop") }
+func (p mprisPlayer) Play() *dbus.Error      { return p.command("play") }
+
+// mprisPlayerMethods maps Go method names to the D-Bus ones where they
+// differ: a method called Seek would look like io.Seeker's to go vet.
+var mprisPlayerMethods = map[string]string{"SeekBy": "Seek"}
+
+// mprisPlayerIntrospection describes the Player methods under their D-Bus
+// names.
+func mprisPlayerIntrospection() []introspect.Method {
+	methods := introspect.Methods(mprisPlayer{})
+	for i, m := range methods {
+		if name, ok := mprisPlayerMethods[m.Name]; ok {
+			methods[i].Name = name
+		}
+	}
+	return methods
+}
+
+// SeekBy (Seek on the bus) moves the position by offset microseconds.
+func (p mprisPlayer) SeekBy(offset int64) *dbus.Error {
+	p.mp.send(mprisMsg{command: "seek", value: float64(offset) / 1e6})
+	return nil
+}
+
+// SetPosition moves to position microseconds into the track, if trackID is
+// still the playing track.
+func (p mprisPlayer) SetPosition(trackID dbus.ObjectPath, position int64) *dbus.Error {
+	md, _ := p.mp.props.GetMust(mprisPlayerIface, "Metadata").(map[string]dbus.Variant)
+	if current, _ := md["mpris:trackid"].Value().(dbus.ObjectPath); current != trackID || position < 0 {
+		return nil // stale or invalid: the spec says to ignore it
+	}
+	p.mp.send(mprisMsg{command: "position", value: float64(position) / 1e6})
+	return nil
+}
+
+func (p mprisPlayer) OpenUri(uri string) *dbus.Error {
+	return dbus.MakeFailedError(fmt.Errorf("opening URIs is not supported"))
+}
+
+func (p mprisPlayer) command(name string) *dbus.Error {
+	p.mp.send(mprisMsg{command: name})
+	return nil
+}
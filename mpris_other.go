@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import tea "charm.land/bubbletea/v2"
+
+// MPRIS is D-Bus-only, so elsewhere there's no service: a nil *MPRIS does
+// nothing.
+type MPRIS struct{}
+
+func StartMPRIS() (*MPRIS, error) { return nil, nil }
+
+func (mp *MPRIS) Listen() tea.Cmd      { return nil }
+func (mp *MPRIS) Close()               {}
+func (mp *MPRIS) Update(st mprisState) {}
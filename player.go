@@ -0,0 +1,31 @@
+package main
+
+// Player is what the model plays through: the AudioPlayer itself, or, when
+// the TUI is attached to a daemon, a remotePlayer that forwards to the
+// daemon's AudioPlayer (see daemon.go).
+type Player interface {
+	Play(filePath string) error
+	Pause()
+	Resume()
+	TogglePause()
+	Stop()
+	IsPlaying() bool
+	IsPaused() bool
+	StreamTitle() string
+
+	SetDuration(duration float64)
+	GetPosition() float64
+	GetDuration() float64
+	GetProgress() float64
+	CanSeek() bool
+	Seek(fraction float64) error
+	SeekTo(seconds float64) error
+	SeekBy(delta float64) error
+	SetLoop(a, b float64) error
+	Loop() (a, b float64)
+
+	Volume() float64
+	SetVolume(level float64)
+	GetAudioSamples() []float64
+	Close()
+}
@@ -3,16 +3,85 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// Playlist is a named, ordered collection of songs. Full Song structs are stored
-// so a playlist is self-contained (no library lookup needed to play it).
+// Playlist is a named, ordered collection of songs. Songs are stored as
+// references that are looked up in the library, so rescans and tag edits show
+// through and moved files are found again. A smart playlist (Type "smart")
+// stores rules instead of songs, and its songs are whatever in the library
+// currently matches them.
 type Playlist struct {
-	Name  string `json:"name"`
-	Songs []Song `json:"songs"`
+	Name    string     `json:"name"`
+	Type    string     `json:"type,omitempty"` // "" for a normal playlist, "smart"
+	Entries []SongRef  `json:"entries,omitempty"`
+	Songs   []Song     `json:"songs,omitempty"` // full copies, as saved before entries; converted on load
+	Smart   *SmartSpec `json:"smart,omitempty"`
+}
+
+// SongRef is a playlist's reference to a song: its file path and content hash
+// (see contentHash), plus the tags it had when last seen. The tags are shown
+// if the file goes missing, and used to find it again if the hash isn't known.
+type SongRef struct {
+	Path     string  `json:"path"`
+	Hash     string  `json:"hash,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// refTo makes a reference to a song.
+func refTo(s Song) SongRef {
+	return SongRef{
+		Path:     s.FilePath,
+		Hash:     s.ContentHash,
+		Title:    s.Title,
+		Artist:   s.Artist,
+		Album:    s.Album,
+		Duration: s.DurationSecs,
+	}
+}
+
+// PlaylistItem is one entry of a playlist, resolved to a song.
+type PlaylistItem struct {
+	Song    Song
+	Missing bool // the file can't be found; Song holds the last known tags
+}
+
+// builtinPlaylists are smart playlists every library has. They aren't saved
+// to playlists.json and can't be renamed, edited or deleted.
+var builtinPlaylists = []Playlist{
+	{Name: "Favorites", Type: "smart", Smart: &SmartSpec{
+		Rules: []SmartRule{{Field: "favorite", Op: ":", Value: "yes"}},
+		Sort:  "artist",
+	}},
+	{Name: "Top rated", Type: "smart", Smart: &SmartSpec{
+		Rules: []SmartRule{{Field: "rating", Op: ">=", Value: "4"}},
+		Sort:  "rating",
+	}},
+}
+
+// IsBuiltin reports whether name is one of the built-in playlists.
+func IsBuiltin(name string) bool {
+	for _, p := range builtinPlaylists {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSmart reports whether the playlist is defined by rules.
+func (p Playlist) IsSmart() bool {
+	return p.Type == "smart" && p.Smart != nil
 }
 
 // PlaylistData is the on-disk shape of playlists.json.
@@ -25,9 +94,22 @@ type PlaylistData struct {
 type PlaylistManager struct {
 	playlists    []Playlist
 	playlistFile string
+	// Smart playlists are evaluated against the library and play history,
+	// and the results cached until either changes.
+	library    *LibraryManager
+	history    *HistoryManager
+	smartCache map[string][]Song
+	smartKey   [2]int // library generation and history length the cache is for
+	// Entries are resolved against the library by path. After each library
+	// change they are relinked (see link), which is when missing files are
+	// found again or marked.
+	byPath   map[string]Song
+	missing  map[string]bool // entry paths whose files can't be found
+	linkedAt int             // library generation entries were last linked at
+	stamp    fileStamp       // of playlists.json as last read or written
 }
 
-func NewPlaylistManager() (*PlaylistManager, error) {
+func NewPlaylistManager(library *LibraryManager, history *HistoryManager) (*PlaylistManager, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		homeDir = "."
@@ -41,10 +123,15 @@ func NewPlaylistManager() (*PlaylistManager, error) {
 	pm := &PlaylistManager{
 		playlists:    []Playlist{},
 		playlistFile: filepath.Join(configDir, "playlists.json"),
+		library:      library,
+		history:      history,
+		smartCache:   map[string][]Song{},
+		linkedAt:     -1,
 	}
 
 	// A missing or unreadable file just means "no playlists yet".
 	_ = pm.Load()
+	pm.link()
 	return pm, nil
 }
 
@@ -62,29 +149,160 @@ func (pm *PlaylistManager) Load() error {
 	if err := json.Unmarshal(data, &pd); err != nil {
 		return err
 	}
+	pm.stamp = stampOf(pm.playlistFile)
 	pm.playlists = pd.Playlists
+	for i, p := range pm.playlists {
+		// Playlists saved as full song copies become references; the next
+		// save writes them in the new form.
+		for _, s := range p.Songs {
+			pm.playlists[i].Entries = append(pm.playlists[i].Entries, refTo(s))
+		}
+		pm.playlists[i].Songs = nil
+	}
+	pm.linkedAt = -1
 	return nil
 }
 
+// link resolves every entry against the library, if it has changed since the
+// last time. An entry whose file is gone is relinked to the library song
+// with the same content hash, or failing that the same artist, title and
+// (near enough) length, which is how moved and renamed files are followed;
+// one that can't be is marked missing. An entry for a file that still exists
+// outside the library is kept as it is.
+// Relinked paths and refreshed tags are saved.
+func (pm *PlaylistManager) link() {
+	gen := 0
+	var songs []Song
+	if pm.library != nil {
+		gen = pm.library.Generation()
+		songs = pm.library.GetSongs()
+	}
+	if gen == pm.linkedAt {
+		return
+	}
+	pm.linkedAt = gen
+
+	pm.byPath = make(map[string]Song, len(songs))
+	byHash := make(map[string]Song)
+	byName := make(map[string][]Song)
+	for _, s := range songs {
+		if s.FilePath == "" {
+			continue
+		}
+		pm.byPath[s.FilePath] = s
+		if s.ContentHash != "" {
+			byHash[s.ContentHash] = s
+		}
+		key := normalizeForMatch(s.Artist) + "\x00" + normalizeForMatch(s.Title)
+		byName[key] = append(byName[key], s)
+	}
+
+	pm.missing = make(map[string]bool)
+	changed := false
+	for i := range pm.playlists {
+		for j, ref := range pm.playlists[i].Entries {
+			s, ok := pm.byPath[ref.Path]
+			if !ok {
+				// A file outside the library that's still there is kept as
+				// it is; only one that's gone is looked for elsewhere.
+				if _, err := os.Stat(ref.Path); err == nil {
+					continue
+				}
+				if ref.Hash != "" {
+					s, ok = byHash[ref.Hash]
+				}
+				if !ok {
+					s, ok = matchByName(ref, byName[normalizeForMatch(ref.Artist)+"\x00"+normalizeForMatch(ref.Title)])
+				}
+				if !ok {
+					pm.missing[ref.Path] = true
+					continue
+				}
+			}
+			if updated := refTo(s); updated != ref {
+				pm.playlists[i].Entries[j] = updated
+				changed = true
+			}
+		}
+	}
+	if changed {
+		pm.Save()
+	}
+}
+
+// matchByName picks the candidate whose length is within two seconds of the
+// reference's, so a live take or remaster with the same title isn't
+// mistaken for the missing file. Lengths that aren't known always match.
+func matchByName(ref SongRef, candidates []Song) (Song, bool) {
+	if ref.Title == "" {
+		return Song{}, false
+	}
+	for _, s := range candidates {
+		if ref.Duration <= 0 || s.DurationSecs <= 0 || math.Abs(ref.Duration-s.DurationSecs) <= 2 {
+			return s, true
+		}
+	}
+	return Song{}, false
+}
+
+// resolve returns the song an entry refers to: the library's copy, or one
+// built from the entry's own tags for a file outside the library (or a
+// missing one, which is also reported).
+func (pm *PlaylistManager) resolve(ref SongRef) (Song, bool) {
+	if s, ok := pm.byPath[ref.Path]; ok {
+		return s, false
+	}
+	s := Song{
+		Title:        ref.Title,
+		Artist:       ref.Artist,
+		Album:        ref.Album,
+		FilePath:     ref.Path,
+		DurationSecs: ref.Duration,
+		Duration:     formatDuration(time.Duration(ref.Duration * float64(time.Second))),
+		ContentHash:  ref.Hash,
+	}
+	if s.Title == "" {
+		s.Title = getFilenameWithoutExt(ref.Path)
+	}
+	return s, pm.missing[ref.Path]
+}
+
 // Save writes all playlists to disk.
 func (pm *PlaylistManager) Save() error {
 	data, err := json.MarshalIndent(PlaylistData{Playlists: pm.playlists}, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(pm.playlistFile, data, 0644)
+	if err := os.WriteFile(pm.playlistFile, data, 0644); err != nil {
+		return err
+	}
+	pm.stamp = stampOf(pm.playlistFile)
+	return nil
 }
 
-// GetPlaylists returns all playlists.
+// ReloadIfChanged rereads playlists.json if another process has written it
+// since this one last read or wrote it, reporting whether it did.
+func (pm *PlaylistManager) ReloadIfChanged() bool {
+	if !pm.stamp.changed(pm.playlistFile) || pm.Load() != nil {
+		return false
+	}
+	pm.smartCache = map[string][]Song{}
+	return true
+}
+
+// GetPlaylists returns all playlists, the built-in ones first.
 func (pm *PlaylistManager) GetPlaylists() []Playlist {
-	return pm.playlists
+	return append(append([]Playlist{}, builtinPlaylists...), pm.playlists...)
 }
 
-// Names returns the playlist names in order.
-func (pm *PlaylistManager) Names() []string {
-	names := make([]string, len(pm.playlists))
-	for i, p := range pm.playlists {
-		names[i] = p.Name
+// StaticNames returns the names of the playlists songs can be added to, in
+// order. Smart playlists are left out since their rules pick their songs.
+func (pm *PlaylistManager) StaticNames() []string {
+	var names []string
+	for _, p := range pm.playlists {
+		if !p.IsSmart() {
+			names = append(names, p.Name)
+		}
 	}
 	return names
 }
@@ -104,6 +322,11 @@ func (pm *PlaylistManager) Get(name string) *Playlist {
 	if i := pm.index(name); i >= 0 {
 		return &pm.playlists[i]
 	}
+	for i := range builtinPlaylists {
+		if builtinPlaylists[i].Name == name {
+			return &builtinPlaylists[i]
+		}
+	}
 	return nil
 }
 
@@ -112,13 +335,50 @@ func (pm *PlaylistManager) Create(name string) error {
 	if name == "" {
 		return fmt.Errorf("playlist name cannot be empty")
 	}
-	if pm.index(name) >= 0 {
+	if pm.index(name) >= 0 || IsBuiltin(name) {
 		return fmt.Errorf("playlist %q already exists", name)
 	}
 	pm.playlists = append(pm.playlists, Playlist{Name: name})
 	return pm.Save()
 }
 
+// UniqueName returns name, or name with " (2)", " (3)"… appended if a
+// playlist by that name already exists.
+func (pm *PlaylistManager) UniqueName(name string) string {
+	candidate := name
+	for n := 2; pm.Get(candidate) != nil; n++ {
+		candidate = fmt.Sprintf("%s (%d)", name, n)
+	}
+	return candidate
+}
+
+// SetSmart creates a smart playlist, or replaces the rules of an existing
+// one. It errors if name belongs to a normal playlist.
+func (pm *PlaylistManager) SetSmart(name string, spec SmartSpec) error {
+	if name == "" {
+		return fmt.Errorf("playlist name cannot be empty")
+	}
+	if IsBuiltin(name) {
+		return fmt.Errorf("%q is built in and can't be changed", name)
+	}
+	if i := pm.index(name); i >= 0 {
+		if !pm.playlists[i].IsSmart() {
+			return fmt.Errorf("playlist %q already exists", name)
+		}
+		pm.playlists[i].Smart = &spec
+	} else {
+		pm.playlists = append(pm.playlists, Playlist{Name: name, Type: "smart", Smart: &spec})
+	}
+	delete(pm.smartCache, name)
+	return pm.Save()
+}
+
+// IsSmart reports whether the named playlist is a smart playlist.
+func (pm *PlaylistManager) IsSmart(name string) bool {
+	p := pm.Get(name)
+	return p != nil && p.IsSmart()
+}
+
 // Delete removes the named playlist.
 func (pm *PlaylistManager) Delete(name string) error {
 	i := pm.index(name)
@@ -126,6 +386,7 @@ func (pm *PlaylistManager) Delete(name string) error {
 		return fmt.Errorf("playlist %q not found", name)
 	}
 	pm.playlists = append(pm.playlists[:i], pm.playlists[i+1:]...)
+	delete(pm.smartCache, name)
 	return pm.Save()
 }
 
@@ -138,10 +399,11 @@ func (pm *PlaylistManager) Rename(oldName, newName string) error {
 	if i < 0 {
 		return fmt.Errorf("playlist %q not found", oldName)
 	}
-	if newName != oldName && pm.index(newName) >= 0 {
+	if newName != oldName && (pm.index(newName) >= 0 || IsBuiltin(newName)) {
 		return fmt.Errorf("playlist %q already exists", newName)
 	}
 	pm.playlists[i].Name = newName
+	delete(pm.smartCache, oldName)
 	return pm.Save()
 }
 
@@ -151,15 +413,21 @@ func (pm *PlaylistManager) AddSongs(name string, songs []Song) (int, error) {
 	if name == "" {
 		return 0, fmt.Errorf("playlist name cannot be empty")
 	}
+	if IsBuiltin(name) {
+		return 0, fmt.Errorf("%q is built in; its songs can't be picked by hand", name)
+	}
 	i := pm.index(name)
 	if i < 0 {
 		pm.playlists = append(pm.playlists, Playlist{Name: name})
 		i = len(pm.playlists) - 1
 	}
+	if pm.playlists[i].IsSmart() {
+		return 0, fmt.Errorf("%q is a smart playlist; its rules pick its songs", name)
+	}
 
 	existing := make(map[string]bool)
-	for _, s := range pm.playlists[i].Songs {
-		existing[s.FilePath] = true
+	for _, ref := range pm.playlists[i].Entries {
+		existing[ref.Path] = true
 	}
 
 	added := 0
@@ -168,7 +436,7 @@ func (pm *PlaylistManager) AddSongs(name string, songs []Song) (int, error) {
 			continue
 		}
 		existing[s.FilePath] = true
-		pm.playlists[i].Songs = append(pm.playlists[i].Songs, s)
+		pm.playlists[i].Entries = append(pm.playlists[i].Entries, refTo(s))
 		added++
 	}
 
@@ -178,30 +446,271 @@ func (pm *PlaylistManager) AddSongs(name string, songs []Song) (int, error) {
 	return added, pm.Save()
 }
 
-// RemoveSong removes the song with the given FilePath from a playlist.
-func (pm *PlaylistManager) RemoveSong(name, filePath string) error {
+// editable returns the index of a normal (hand-picked) playlist, erroring if
+// it doesn't exist or its songs are chosen by rules.
+func (pm *PlaylistManager) editable(name string) (int, error) {
+	if IsBuiltin(name) {
+		return -1, fmt.Errorf("%q is built in; its songs can't be picked by hand", name)
+	}
 	i := pm.index(name)
 	if i < 0 {
+		return -1, fmt.Errorf("playlist %q not found", name)
+	}
+	if pm.playlists[i].IsSmart() {
+		return -1, fmt.Errorf("%q is a smart playlist; edit its rules instead", name)
+	}
+	return i, nil
+}
+
+// RemoveSong removes every entry with the given FilePath from a playlist.
+func (pm *PlaylistManager) RemoveSong(name, filePath string) error {
+	p := pm.Get(name)
+	if p == nil {
 		return fmt.Errorf("playlist %q not found", name)
 	}
-	songs := pm.playlists[i].Songs
-	for j, s := range songs {
-		if s.FilePath == filePath {
-			pm.playlists[i].Songs = append(songs[:j], songs[j+1:]...)
-			return pm.Save()
+	indexes := make(map[int]bool)
+	for j, ref := range p.Entries {
+		if ref.Path == filePath {
+			indexes[j] = true
 		}
 	}
-	return nil
+	_, err := pm.RemoveSongs(name, indexes)
+	return err
+}
+
+// RemoveSongs removes the entries at the given positions from a playlist,
+// so one copy of a song listed twice can be removed and the other kept.
+// Returns how many were removed.
+func (pm *PlaylistManager) RemoveSongs(name string, indexes map[int]bool) (int, error) {
+	i, err := pm.editable(name)
+	if err != nil {
+		return 0, err
+	}
+	var kept []SongRef
+	for j, ref := range pm.playlists[i].Entries {
+		if !indexes[j] {
+			kept = append(kept, ref)
+		}
+	}
+	removed := len(pm.playlists[i].Entries) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	pm.playlists[i].Entries = kept
+	return removed, pm.Save()
+}
+
+// MoveSong moves the song at position from to position to, shifting the
+// songs in between. Moving up or down one place is MoveSong(i, i∓1).
+func (pm *PlaylistManager) MoveSong(name string, from, to int) error {
+	i, err := pm.editable(name)
+	if err != nil {
+		return err
+	}
+	entries := pm.playlists[i].Entries
+	if from < 0 || from >= len(entries) || to < 0 || to >= len(entries) || from == to {
+		return nil
+	}
+	ref := entries[from]
+	if from < to {
+		copy(entries[from:to], entries[from+1:to+1])
+	} else {
+		copy(entries[to+1:from+1], entries[to:from])
+	}
+	entries[to] = ref
+	return pm.Save()
+}
+
+// playlistSortKeys are the orders a playlist can be sorted into, in the order
+// the sort key cycles through them.
+var playlistSortKeys = []string{"artist", "album", "track", "duration", "bpm", "key"}
+
+// SortSongs reorders a playlist permanently by one of playlistSortKeys:
+// artist (then album and track), album (then track), track (disc and track
+// number) or duration (shortest first). The sort is stable, so songs that
+// tie keep their current order.
+func (pm *PlaylistManager) SortSongs(name, by string) error {
+	i, err := pm.editable(name)
+	if err != nil {
+		return err
+	}
+	var less func(a, b Song) bool
+	switch by {
+	case "artist":
+		less = func(a, b Song) bool {
+			if x, y := strings.ToLower(a.Artist), strings.ToLower(b.Artist); x != y {
+				return x < y
+			}
+			if x, y := strings.ToLower(a.Album), strings.ToLower(b.Album); x != y {
+				return x < y
+			}
+			return trackLess(a, b)
+		}
+	case "album":
+		less = func(a, b Song) bool {
+			if x, y := strings.ToLower(a.Album), strings.ToLower(b.Album); x != y {
+				return x < y
+			}
+			return trackLess(a, b)
+		}
+	case "track":
+		less = trackLess
+	case "duration":
+		less = func(a, b Song) bool { return a.DurationSecs < b.DurationSecs }
+	case "bpm":
+		less = tempoLess
+	case "key":
+		less = keyLess
+	default:
+		return fmt.Errorf("unknown sort %q", by)
+	}
+	pm.link()
+	entries := pm.playlists[i].Entries
+	sort.SliceStable(entries, func(a, b int) bool {
+		sa, _ := pm.resolve(entries[a])
+		sb, _ := pm.resolve(entries[b])
+		return less(sa, sb)
+	})
+	return pm.Save()
+}
+
+// Shuffle puts a playlist's songs in a new random order, permanently.
+func (pm *PlaylistManager) Shuffle(name string) error {
+	i, err := pm.editable(name)
+	if err != nil {
+		return err
+	}
+	entries := pm.playlists[i].Entries
+	rand.Shuffle(len(entries), func(a, b int) { entries[a], entries[b] = entries[b], entries[a] })
+	return pm.Save()
+}
+
+// Dedup removes repeats of the same song from a playlist, keeping the first.
+// Songs are the same if they have the same artist and title once case and
+// punctuation are folded, as in the duplicate finder, so two copies of a
+// track from different files count. Returns how many were removed.
+func (pm *PlaylistManager) Dedup(name string) (int, error) {
+	i, err := pm.editable(name)
+	if err != nil {
+		return 0, err
+	}
+	pm.link()
+	seen := make(map[string]bool)
+	var kept []SongRef
+	for _, ref := range pm.playlists[i].Entries {
+		s, _ := pm.resolve(ref)
+		key := normalizeForMatch(s.Artist) + "\x00" + normalizeForMatch(s.Title)
+		if normalizeForMatch(s.Title) == "" {
+			key = s.FilePath
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, ref)
+	}
+	removed := len(pm.playlists[i].Entries) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	pm.playlists[i].Entries = kept
+	return removed, pm.Save()
+}
+
+// ReplaceSongs swaps songs in every playlist for a substitute, keyed by the
+// replaced song's file path (e.g. a duplicate copy for the one being kept).
+// If the substitute is already in a playlist the replaced entry is dropped
+// instead, so a playlist never holds the same file twice.
+func (pm *PlaylistManager) ReplaceSongs(replace map[string]Song) error {
+	changed := false
+	for i := range pm.playlists {
+		present := make(map[string]bool)
+		for _, ref := range pm.playlists[i].Entries {
+			present[ref.Path] = true
+		}
+		var entries []SongRef
+		for _, ref := range pm.playlists[i].Entries {
+			sub, ok := replace[ref.Path]
+			if !ok {
+				entries = append(entries, ref)
+				continue
+			}
+			changed = true
+			if !present[sub.FilePath] {
+				present[sub.FilePath] = true
+				entries = append(entries, refTo(sub))
+			}
+		}
+		pm.playlists[i].Entries = entries
+	}
+	if !changed {
+		return nil
+	}
+	return pm.Save()
 }
 
-// SongsOf returns a copy of a playlist's songs (nil if it doesn't exist).
+// SongsOf returns the songs of a playlist that can be played, in order (nil
+// if it doesn't exist); missing files are left out. A smart playlist's songs
+// are its rules evaluated against the library.
 func (pm *PlaylistManager) SongsOf(name string) []Song {
-	if p := pm.Get(name); p != nil {
-		out := make([]Song, len(p.Songs))
-		copy(out, p.Songs)
-		return out
+	var songs []Song
+	for _, item := range pm.ItemsOf(name) {
+		if !item.Missing {
+			songs = append(songs, item.Song)
+		}
 	}
-	return nil
+	return songs
+}
+
+// ItemsOf returns every entry of a playlist resolved against the library,
+// missing files included (and marked).
+func (pm *PlaylistManager) ItemsOf(name string) []PlaylistItem {
+	p := pm.Get(name)
+	if p == nil {
+		return nil
+	}
+	if p.IsSmart() {
+		songs := pm.evaluateSmart(*p)
+		items := make([]PlaylistItem, len(songs))
+		for i, s := range songs {
+			items[i] = PlaylistItem{Song: s}
+		}
+		return items
+	}
+	pm.link()
+	items := make([]PlaylistItem, len(p.Entries))
+	for i, ref := range p.Entries {
+		s, missing := pm.resolve(ref)
+		items[i] = PlaylistItem{Song: s, Missing: missing}
+	}
+	return items
+}
+
+// MissingCount returns how many entries of a playlist can't be found.
+func (pm *PlaylistManager) MissingCount(name string) int {
+	n := 0
+	for _, item := range pm.ItemsOf(name) {
+		if item.Missing {
+			n++
+		}
+	}
+	return n
+}
+
+// evaluateSmart returns a smart playlist's current songs, re-evaluating its
+// rules only when the library or play history has changed since last time.
+func (pm *PlaylistManager) evaluateSmart(p Playlist) []Song {
+	key := [2]int{pm.library.Generation(), pm.history.EventCount()}
+	if key != pm.smartKey {
+		pm.smartCache = map[string][]Song{}
+		pm.smartKey = key
+	}
+	songs, ok := pm.smartCache[p.Name]
+	if !ok {
+		songs = p.Smart.Evaluate(pm.library, pm.history, time.Now())
+		pm.smartCache[p.Name] = songs
+	}
+	return songs
 }
 
 // playlistCountLabel renders "1 song" / "N songs".
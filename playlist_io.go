@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Playlist files shared with other players. Imports read M3U/M3U8, PLS and
+// XSPF; exports write whichever format the file name's extension asks for.
+
+// playlistFileExts are the playlist formats that can be imported and exported.
+var playlistFileExts = map[string]bool{".m3u": true, ".m3u8": true, ".pls": true, ".xspf": true}
+
+// isPlaylistFile reports whether path has a playlist file extension.
+func isPlaylistFile(path string) bool {
+	return playlistFileExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// playlistEntry is one track as listed in a playlist file: where it is and,
+// when the format says, what it is.
+type playlistEntry struct {
+	Location string // path (absolute, or relative to the playlist) or URL
+	Title    string
+	Artist   string
+}
+
+// importPlaylistFile reads a playlist file and matches its entries to songs.
+// Relative paths are resolved against the playlist's folder. An entry matches
+// a library song with the same path, or failing that one with the same artist
+// and title (so playlists made on another machine still work); a file that
+// isn't in the library but exists is read directly. It returns the matched
+// songs in playlist order and how many entries couldn't be matched.
+func importPlaylistFile(path string, library []Song) ([]Song, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var entries []playlistEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pls":
+		entries = parsePLSEntries(data)
+	case ".xspf":
+		if entries, err = parseXSPFEntries(data); err != nil {
+			return nil, 0, err
+		}
+	default:
+		entries = parseM3UEntries(data)
+	}
+
+	byPath := make(map[string]Song, len(library))
+	byName := make(map[string]Song, len(library))
+	for _, s := range library {
+		if s.FilePath == "" {
+			continue
+		}
+		byPath[s.FilePath] = s
+		key := normalizeForMatch(s.Artist) + "\x00" + normalizeForMatch(s.Title)
+		if _, ok := byName[key]; !ok {
+			byName[key] = s
+		}
+	}
+
+	dir := filepath.Dir(path)
+	var songs []Song
+	missing := 0
+	for _, e := range entries {
+		loc := resolvePlaylistLocation(e.Location, dir)
+		if s, ok := byPath[loc]; ok && loc != "" {
+			songs = append(songs, s)
+			continue
+		}
+		artist, title := e.Artist, e.Title
+		if title == "" && loc != "" {
+			// Without metadata, fall back on "Artist - Title" file names.
+			name := getFilenameWithoutExt(loc)
+			if a, t, ok := strings.Cut(name, " - "); ok {
+				artist, title = a, t
+			} else {
+				title = name
+			}
+		}
+		if s, ok := byName[normalizeForMatch(artist)+"\x00"+normalizeForMatch(title)]; ok && title != "" {
+			songs = append(songs, s)
+			continue
+		}
+		if loc != "" && isSupportedAudio(loc) {
+			if info, err := os.Stat(loc); err == nil && !info.IsDir() {
+				songs = append(songs, extractMetadata(loc))
+				continue
+			}
+		}
+		missing++
+	}
+	return songs, missing, nil
+}
+
+// resolvePlaylistLocation turns an entry's location into a clean absolute
+// path. file:// URLs are unescaped; other URLs (streams) give "".
+func resolvePlaylistLocation(loc, dir string) string {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		return ""
+	}
+	if strings.Contains(loc, "://") {
+		u, err := url.Parse(loc)
+		if err != nil || u.Scheme != "file" {
+			return ""
+		}
+		loc = u.Path
+	}
+	abs := func(p string) string {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		return filepath.Clean(p)
+	}
+	path := abs(loc)
+	// Playlists written on Windows use backslashes. Elsewhere a backslash
+	// can be part of a file name, so they're only converted when the entry
+	// looks like a Windows path or doesn't resolve as written.
+	if strings.Contains(loc, `\`) {
+		if _, err := os.Stat(path); err != nil || looksLikeWindowsPath(loc) {
+			path = abs(strings.ReplaceAll(loc, `\`, "/"))
+		}
+	}
+	return path
+}
+
+// looksLikeWindowsPath reports whether loc starts with a drive letter
+// ("C:\") or is a UNC path ("\\server\share").
+func looksLikeWindowsPath(loc string) bool {
+	if strings.HasPrefix(loc, `\\`) {
+		return true
+	}
+	if len(loc) < 3 || loc[1] != ':' || (loc[2] != '\\' && loc[2] != '/') {
+		return false
+	}
+	c := loc[0] | 0x20
+	return c >= 'a' && c <= 'z'
+}
+
+// parseM3UEntries reads a plain or extended M3U playlist; #EXTINF lines
+// supply the artist and title of the entry that follows. (parseM3U in
+// radio.go only picks out stream URLs.)
+func parseM3UEntries(data []byte) []playlistEntry {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var entries []playlistEntry
+	var pending playlistEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>,<artist> - <title>
+			if _, info, ok := strings.Cut(line, ","); ok {
+				if a, t, ok := strings.Cut(info, " - "); ok {
+					pending.Artist, pending.Title = strings.TrimSpace(a), strings.TrimSpace(t)
+				} else {
+					pending.Title = strings.TrimSpace(info)
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			entries = append(entries, pending)
+			pending = playlistEntry{}
+		}
+	}
+	return entries
+}
+
+// parsePLSEntries reads a PLS playlist's FileN/TitleN entries in N order.
+func parsePLSEntries(data []byte) []playlistEntry {
+	files := make(map[int]*playlistEntry)
+	maxN := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		lower := strings.ToLower(key)
+		var field string
+		switch {
+		case strings.HasPrefix(lower, "file"):
+			field = "file"
+		case strings.HasPrefix(lower, "title"):
+			field = "title"
+		default:
+			continue
+		}
+		n, err := strconv.Atoi(key[len(field):])
+		if err != nil || n <= 0 {
+			continue
+		}
+		e := files[n]
+		if e == nil {
+			e = &playlistEntry{}
+			files[n] = e
+		}
+		if field == "file" {
+			e.Location = value
+		} else if a, t, ok := strings.Cut(value, " - "); ok {
+			e.Artist, e.Title = strings.TrimSpace(a), strings.TrimSpace(t)
+		} else {
+			e.Title = strings.TrimSpace(value)
+		}
+		maxN = max(maxN, n)
+	}
+	var entries []playlistEntry
+	for n := 1; n <= maxN; n++ {
+		if e := files[n]; e != nil && e.Location != "" {
+			entries = append(entries, *e)
+		}
+	}
+	return entries
+}
+
+// xspfPlaylist is the subset of XSPF that's read and written.
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int64  `xml:"duration,omitempty"` // milliseconds
+}
+
+// parseXSPFEntries reads an XSPF playlist. Locations are URIs, so relative
+// ones are unescaped here and resolved like any other relative path.
+func parseXSPFEntries(data []byte) ([]playlistEntry, error) {
+	var pl xspfPlaylist
+	if err := xml.Unmarshal(data, &pl); err != nil {
+		return nil, fmt.Errorf("not a valid XSPF playlist: %v", err)
+	}
+	var entries []playlistEntry
+	for _, t := range pl.Tracks {
+		loc := strings.TrimSpace(t.Location)
+		if !strings.Contains(loc, "://") {
+			if p, err := url.PathUnescape(loc); err == nil {
+				loc = p
+			}
+		}
+		entries = append(entries, playlistEntry{Location: loc, Title: t.Title, Artist: t.Creator})
+	}
+	return entries, nil
+}
+
+// exportPlaylist writes songs to path in the format its extension names. With
+// relative, paths are written relative to the playlist's folder (for copying
+// the playlist and music together to another device); otherwise they are
+// absolute.
+func exportPlaylist(name string, songs []Song, path string, relative bool) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !playlistFileExts[ext] {
+		return fmt.Errorf("unknown playlist format %q (use .m3u, .m3u8, .pls or .xspf)", ext)
+	}
+	dir := filepath.Dir(path)
+	location := func(s Song) string {
+		if relative {
+			if rel, err := filepath.Rel(dir, s.FilePath); err == nil {
+				return rel
+			}
+		}
+		return s.FilePath
+	}
+
+	var b bytes.Buffer
+	switch ext {
+	case ".pls":
+		b.WriteString("[playlist]\n")
+		for i, s := range songs {
+			n := strconv.Itoa(i + 1)
+			b.WriteString("File" + n + "=" + location(s) + "\n")
+			b.WriteString("Title" + n + "=" + s.Artist + " - " + s.Title + "\n")
+			b.WriteString("Length" + n + "=" + strconv.Itoa(playlistSeconds(s)) + "\n")
+		}
+		b.WriteString("NumberOfEntries=" + strconv.Itoa(len(songs)) + "\nVersion=2\n")
+	case ".xspf":
+		pl := xspfPlaylist{Version: "1", Title: name}
+		for _, s := range songs {
+			loc := location(s)
+			if filepath.IsAbs(loc) {
+				loc = (&url.URL{Scheme: "file", Path: loc}).String()
+			} else {
+				loc = (&url.URL{Path: filepath.ToSlash(loc)}).String()
+			}
+			pl.Tracks = append(pl.Tracks, xspfTrack{
+				Location: loc,
+				Title:    s.Title,
+				Creator:  s.Artist,
+				Album:    s.Album,
+				Duration: int64(s.DurationSecs * 1000),
+			})
+		}
+		out, err := xml.MarshalIndent(pl, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.WriteString(xml.Header)
+		b.Write(out)
+		b.WriteString("\n")
+	default:
+		// M3U and M3U8 are written the same way, as UTF-8.
+		b.WriteString("#EXTM3U\n")
+		if name != "" {
+			b.WriteString("#PLAYLIST:" + name + "\n")
+		}
+		for _, s := range songs {
+			b.WriteString("#EXTINF:" + strconv.Itoa(playlistSeconds(s)) + "," + s.Artist + " - " + s.Title + "\n")
+			b.WriteString(location(s) + "\n")
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0644)
+}
+
+// playlistSeconds is a song's length for #EXTINF and PLS, -1 if unknown.
+func playlistSeconds(s Song) int {
+	if s.DurationSecs <= 0 {
+		return -1
+	}
+	return int(s.DurationSecs + 0.5)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// remotePlayer is the Player of a TUI attached to a daemon. Commands are
+// sent without waiting for the daemon, so a slow one never holds up the
+// model; the status each replies with is taken when it arrives. What the
+// daemon is playing is fetched in the background once a tick (syncCmd), and
+// the getters answer from the last status, so drawing a frame doesn't mean
+// a round trip per call.
+//
+// The daemon owns what playing writes: the history, the volume and the
+// Last.fm session, which the attached TUI only reads. Edits made in the TUI
+// (settings, ratings, playlists) are saved by it as usual, and each side
+// rereads a file the other has changed (reloadChanged).
+type remotePlayer struct {
+	client  *rpc.Client
+	syncing atomic.Bool // a syncCmd is waiting on the daemon
+
+	mu     sync.Mutex
+	status PlayerStatus
+	err    error // the last request that failed, until it's reported
+
+	// The client's queue as the daemon last had it: the model's queue
+	// version when it was sent, and the daemon's version for it. queueCalls
+	// counts requests on their way that change the daemon's queue.
+	sentVersion   int
+	daemonVersion int
+	queueCalls    int
+}
+
+// dialDaemon connects to the daemon's control socket.
+func dialDaemon() (*remotePlayer, error) {
+	conn, err := net.DialTimeout("unix", controlSocketPath(), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	r := &remotePlayer{client: jsonrpc.NewClient(conn), sentVersion: -1}
+	if _, err := r.Refresh(); err != nil {
+		r.client.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// send makes a request without waiting for it. The status it replies with
+// is taken when it comes, and a failure kept to be reported by the next
+// sync. after, if non-nil, is called under r.mu once the reply is in, with
+// nil if the request failed.
+func (r *remotePlayer) send(method string, args any, after func(st *PlayerStatus)) {
+	st := new(PlayerStatus)
+	call := r.client.Go("Player."+method, args, st, make(chan *rpc.Call, 1))
+	go func() {
+		<-call.Done
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if call.Error != nil {
+			log.Printf("DEBUG: daemon %s failed: %v", method, call.Error)
+			r.err = fmt.Errorf("%s: %w", method, call.Error)
+			st = nil
+		} else {
+			r.status = *st
+		}
+		if after != nil {
+			after(st)
+		}
+	}()
+}
+
+// call makes a request and waits for the status it replies with. It's for
+// the command-line controls, which have nothing else to do meanwhile.
+func (r *remotePlayer) call(method string, args any) error {
+	var st PlayerStatus
+	if err := r.client.Call("Player."+method, args, &st); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = st
+	return nil
+}
+
+// Refresh fetches what the daemon is playing, waiting for the answer.
+func (r *remotePlayer) Refresh() (PlayerStatus, error) {
+	var st PlayerStatus
+	if err := r.client.Call("Player.Status", NoArgs{}, &st); err != nil {
+		return st, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = st
+	return st, nil
+}
+
+// Queue fetches the daemon's play queue.
+func (r *remotePlayer) Queue() (QueueReply, error) {
+	var q QueueReply
+	err := r.client.Call("Player.Queue", NoArgs{}, &q)
+	return q, err
+}
+
+// remoteStatusMsg is what syncCmd found out.
+type remoteStatusMsg struct {
+	status PlayerStatus
+	queue  *QueueReply // the daemon's queue, when it changed it
+	failed error       // a request that failed since the last sync
+	err    error       // the daemon couldn't be reached
+}
+
+// syncCmd fetches what the daemon is playing, and its queue if the daemon
+// changed it (by moving on through a mix, or at another client's request).
+// It returns nil while the last one is still waiting.
+func (r *remotePlayer) syncCmd() tea.Cmd {
+	if !r.syncing.CompareAndSwap(false, true) {
+		return nil
+	}
+	return func() tea.Msg {
+		defer r.syncing.Store(false)
+		st, err := r.Refresh()
+		if err != nil {
+			return remoteStatusMsg{err: err}
+		}
+		msg := remoteStatusMsg{status: st}
+		r.mu.Lock()
+		msg.failed, r.err = r.err, nil
+		changed := st.QueueVersion != r.daemonVersion && r.queueCalls == 0
+		r.mu.Unlock()
+		if changed {
+			if q, err := r.Queue(); err == nil {
+				msg.queue = &q
+			}
+		}
+		return msg
+	}
+}
+
+// PlayQueue plays the index'th song of the queue. The songs are only sent
+// when the daemon doesn't already have this version of the queue.
+func (r *remotePlayer) PlayQueue(songs []Song, index, version int) {
+	r.mu.Lock()
+	args := PlayQueueArgs{Index: index}
+	if version != r.sentVersion || r.status.QueueVersion != r.daemonVersion {
+		args.Songs = songs
+	}
+	r.queueCalls++
+	r.mu.Unlock()
+	r.send("PlayQueue", args, r.queueSent(version))
+}
+
+// SetQueue gives the daemon the queue without changing what's playing.
+func (r *remotePlayer) SetQueue(songs []Song, index, version int) {
+	r.mu.Lock()
+	r.queueCalls++
+	r.mu.Unlock()
+	r.send("SetQueue", SetQueueArgs{Songs: songs, Index: index}, r.queueSent(version))
+}
+
+// queueSent returns the function that notes, once the daemon has replied,
+// that its queue is now the model's version.
+func (r *remotePlayer) queueSent(version int) func(st *PlayerStatus) {
+	return func(st *PlayerStatus) {
+		r.queueCalls--
+		if st != nil {
+			r.sentVersion, r.daemonVersion = version, st.QueueVersion
+		}
+	}
+}
+
+// adoptQueue notes that the model has taken the daemon's queue as its
+// version.
+func (r *remotePlayer) adoptQueue(version, daemonVersion int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sentVersion, r.daemonVersion = version, daemonVersion
+}
+
+// queueCurrent reports whether the daemon has the model's version of the
+// queue, or is being sent a queue already.
+func (r *remotePlayer) queueCurrent(version int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sentVersion == version || r.queueCalls > 0
+}
+
+func (r *remotePlayer) PlayStation(station *RadioStation) {
+	r.send("PlayStation", station, nil)
+}
+
+func (r *remotePlayer) ReloadSettings() {
+	r.send("ReloadSettings", NoArgs{}, nil)
+}
+
+// setState shows a transport command's effect straight away, ahead of the
+// daemon's reply.
+func (r *remotePlayer) setState(from, to string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if from == "" || r.status.State == from {
+		r.status.State = to
+	}
+}
+
+// The Player methods. Those that return an error report only what can be
+// told without the daemon; its failures come with the next sync.
+
+func (r *remotePlayer) Play(filePath string) error {
+	r.send("PlayQueue", PlayQueueArgs{Paths: []string{filePath}}, nil)
+	return nil
+}
+
+func (r *remotePlayer) Pause() {
+	r.setState("playing", "paused")
+	r.send("Pause", NoArgs{}, nil)
+}
+
+func (r *remotePlayer) Resume() {
+	r.setState("paused", "playing")
+	r.send("Play", NoArgs{}, nil)
+}
+
+func (r *remotePlayer) TogglePause() {
+	if r.IsPaused() {
+		r.setState("paused", "playing")
+	} else {
+		r.setState("playing", "paused")
+	}
+	r.send("Toggle", NoArgs{}, nil)
+}
+
+func (r *remotePlayer) Stop() {
+	r.setState("", "stopped")
+	r.send("Stop", NoArgs{}, nil)
+}
+
+func (r *remotePlayer) IsPlaying() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.State == "playing"
+}
+
+func (r *remotePlayer) IsPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.State == "paused"
+}
+
+func (r *remotePlayer) StreamTitle() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.StreamTitle
+}
+
+// SetDuration does nothing: the daemon sets the duration of what it plays.
+func (r *remotePlayer) SetDuration(duration float64) {}
+
+func (r *remotePlayer) GetPosition() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Position
+}
+
+func (r *remotePlayer) GetDuration() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Duration
+}
+
+func (r *remotePlayer) GetProgress() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Duration <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(r.status.Position/r.status.Duration, 1))
+}
+
+func (r *remotePlayer) CanSeek() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.CanSeek
+}
+
+func (r *remotePlayer) Seek(fraction float64) error {
+	return r.SeekTo(fraction * r.GetDuration())
+}
+
+func (r *remotePlayer) SeekTo(seconds float64) error {
+	r.send("Seek", SeekArgs{Seconds: seconds}, nil)
+	return nil
+}
+
+func (r *remotePlayer) SeekBy(delta float64) error {
+	r.send("Seek", SeekArgs{Seconds: delta, Relative: true}, nil)
+	return nil
+}
+
+func (r *remotePlayer) SetLoop(a, b float64) error {
+	r.send("SetLoop", LoopArgs{A: a, B: b}, nil)
+	return nil
+}
+
+func (r *remotePlayer) Loop() (a, b float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.LoopA, r.status.LoopB
+}
+
+func (r *remotePlayer) Volume() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Volume
+}
+
+func (r *remotePlayer) SetVolume(level float64) {
+	r.mu.Lock()
+	r.status.Volume = math.Max(0, math.Min(level, 1))
+	r.mu.Unlock()
+	r.send("SetVolume", VolumeArgs{Level: level}, nil)
+}
+
+func (r *remotePlayer) GetAudioSamples() []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Samples == nil {
+		return make([]float64, 24)
+	}
+	return append([]float64(nil), r.status.Samples...)
+}
+
+// Close detaches; the daemon plays on.
+func (r *remotePlayer) Close() {
+	r.client.Close()
+}
+
+// applyRemote brings the model up to date with what syncCmd found. A mix
+// started here is topped up from here while attached. Returns the tick
+// command while something is playing.
+func (m *model) applyRemote(msg remoteStatusMsg) tea.Cmd {
+	if msg.err != nil {
+		m.statusFlash = "Lost the daemon: " + msg.err.Error()
+		return nil
+	}
+	if msg.failed != nil {
+		m.statusFlash = "The daemon refused " + msg.failed.Error()
+	}
+	st := msg.status
+
+	if q := msg.queue; q != nil {
+		m.currentPlaylist = q.Songs
+		m.queueIDs = m.newQueueIDs(len(q.Songs))
+		m.queueVersion++
+		m.mix = nil
+		m.remote.adoptQueue(m.queueVersion, q.Version)
+	}
+	m.currentTrackIndex = st.Index
+	if m.mix != nil {
+		m.topUpMix()
+		if !m.remote.queueCurrent(m.queueVersion) {
+			m.remote.SetQueue(m.currentPlaylist, m.currentTrackIndex, m.queueVersion)
+		}
+	}
+
+	if st.Station != nil && (m.playingStation == nil || m.playingStation.Name != st.Station.Name) {
+		// Count the time listened from when the daemon tuned in.
+		m.radioStartTime = time.Now().Add(-time.Duration(st.Position * float64(time.Second)))
+		m.radioPausedTime = 0
+		m.radioWasPaused = false
+	}
+	m.playingSong, m.playingStation = st.Song, st.Station
+	switch {
+	case st.Song != nil:
+		m.playing = st.Song.Title
+	case st.Station != nil:
+		m.playing = st.Station.Name
+	default:
+		m.playing = ""
+		return nil
+	}
+	return tickCmd()
+}
+
+// reloadChanged rereads whichever of the shared files under ~/.resona the
+// daemon, or a TUI attached to it, has written since this process last read
+// them, so neither saves over the other's changes with a stale copy. It's
+// run on each art tick and before each control request.
+func (m *model) reloadChanged() {
+	m.settingsManager.ReloadIfChanged()
+	if m.libraryManager.ReloadIfChanged() {
+		m.libraryBrowser.Refresh()
+	}
+	m.playlistManager.ReloadIfChanged()
+	m.historyManager.ReloadIfChanged()
+}
+
+// fileStamp is a file's modification time and size as a process last read
+// or wrote it, to tell when another has changed it since.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+func stampOf(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{mod: info.ModTime(), size: info.Size()}
+}
+
+// changed reports whether the file at path is no longer as stamped.
+func (s fileStamp) changed(path string) bool {
+	now := stampOf(path)
+	return !now.mod.IsZero() && (!now.mod.Equal(s.mod) || now.size != s.size)
+}
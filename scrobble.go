@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// Scrobbling sends what's played to Last.fm and/or ListenBrainz: a
+// now-playing notice when a track starts, and a scrobble once it has been
+// listened to for half its length or four minutes, whichever comes first
+// (tracks under 30 seconds aren't scrobbled). Radio tracks are scrobbled from
+// the stream's ICY titles. Scrobbles go through a queue in
+// ~/.resona/scrobbles.jsonl, so any that can't be sent (offline, the service
+// down) are kept and retried; now-playing notices are only worth sending at
+// the time, so they aren't queued. Both endpoints are settings, so they can
+// point at a local mock.
+
+const (
+	defaultLastFMURL       = "https://ws.audioscrobbler.com/2.0/"
+	defaultListenBrainzURL = "https://api.listenbrainz.org"
+
+	scrobbleMinLength = 30 * time.Second // shorter tracks aren't scrobbled
+	scrobbleMaxWait   = 4 * time.Minute  // long tracks scrobble after this much
+	scrobbleRetryMin  = 30 * time.Second // first retry of a failed send
+	scrobbleRetryMax  = 15 * time.Minute // retries back off to this
+)
+
+// ScrobbleTrack is one play, as sent to the services.
+type ScrobbleTrack struct {
+	Artist      string    `json:"artist"`
+	Title       string    `json:"title"`
+	Album       string    `json:"album,omitempty"`
+	AlbumArtist string    `json:"album_artist,omitempty"`
+	TrackNumber int       `json:"track,omitempty"`
+	Duration    float64   `json:"duration,omitempty"` // seconds; 0 when unknown (radio)
+	Time        time.Time `json:"time"`               // when playback started
+}
+
+// scrobbleTrackFor describes a library song started at started.
+func scrobbleTrackFor(s Song, started time.Time) ScrobbleTrack {
+	t := ScrobbleTrack{
+		Artist:      s.Artist,
+		Title:       s.Title,
+		Album:       s.Album,
+		TrackNumber: s.TrackNumber,
+		Duration:    s.DurationSecs,
+		Time:        started,
+	}
+	if t.Album == "Unknown Album" {
+		t.Album = ""
+	}
+	if s.AlbumArtist != s.Artist {
+		t.AlbumArtist = s.AlbumArtist
+	}
+	return t
+}
+
+// scrobbleable reports whether the services would take the track at all.
+func (t ScrobbleTrack) scrobbleable() bool {
+	return t.Artist != "" && t.Artist != "Unknown Artist" && t.Title != ""
+}
+
+// scrobbleThreshold is how long a track of the given length has to be
+// listened to before it's scrobbled. ok is false for tracks too short to
+// scrobble.
+func scrobbleThreshold(duration float64) (need time.Duration, ok bool) {
+	length := time.Duration(duration * float64(time.Second))
+	if length < scrobbleMinLength {
+		return 0, false
+	}
+	if length/2 < scrobbleMaxWait {
+		return length / 2, true
+	}
+	return scrobbleMaxWait, true
+}
+
+// radioListen follows the track a radio stream says it's playing. Its length
+// is unknown, so it is scrobbled after four minutes, or when the title
+// changes if it was heard from its start for at least 30 seconds; the track
+// already playing when the station was tuned in only counts after four
+// minutes.
+type radioListen struct {
+	listenSession
+	station   string // name of the station it's playing on
+	title     string // the stream title, as sent
+	fromStart bool   // the title changed while we listened
+}
+
+// track is what the stream title describes; ok is false unless the title
+// names both an artist and a track.
+func (rl *radioListen) track() (ScrobbleTrack, bool) {
+	artist, title, ok := splitStreamTitle(rl.title)
+	return ScrobbleTrack{Artist: artist, Title: title, Time: rl.started}, ok
+}
+
+// scrobbleService is one scrobbling account.
+type scrobbleService interface {
+	Name() string
+	NowPlaying(t ScrobbleTrack) error
+	Scrobble(ts []ScrobbleTrack) error
+	MaxBatch() int // most scrobbles one request may carry
+}
+
+// permanentError marks a send that retrying won't fix (the service rejected
+// the data itself); those scrobbles are dropped rather than retried forever.
+type permanentError struct{ error }
+
+// queuedScrobble is a scrobble waiting to go to one service.
+type queuedScrobble struct {
+	Service string        `json:"service"`
+	Track   ScrobbleTrack `json:"track"`
+	id      int64         // identifies it in the queue while it's being sent
+}
+
+// Scrobbler owns the services and the queue. Scrobbles are sent from a
+// background goroutine, so playback never waits on the network; the queue
+// is shared with it under mu. A nil *Scrobbler (in a TUI attached to the
+// daemon, which scrobbles itself) does nothing.
+type Scrobbler struct {
+	mu        sync.Mutex
+	services  []scrobbleService
+	queue     []queuedScrobble
+	nextID    int64
+	queueFile string
+	lastErr   string
+	wake      chan struct{}
+}
+
+func NewScrobbler(settings Settings) *Scrobbler {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	sc := &Scrobbler{
+		queueFile: filepath.Join(homeDir, ".resona", "scrobbles.jsonl"),
+		wake:      make(chan struct{}, 1),
+	}
+	sc.load()
+	sc.Configure(settings)
+	go sc.run()
+	return sc
+}
+
+// Configure sets up the services the settings turn on: Last.fm once it has
+// an API key, secret and session key, ListenBrainz once it has a token.
+func (sc *Scrobbler) Configure(s Settings) {
+	if sc == nil {
+		return
+	}
+	var services []scrobbleService
+	if s.Scrobble {
+		if s.LastFMKey != "" && s.LastFMSecret != "" && s.LastFMSession != "" {
+			services = append(services, &lastFM{endpoint: orDefault(s.LastFMURL, defaultLastFMURL),
+				key: s.LastFMKey, secret: s.LastFMSecret, session: s.LastFMSession})
+		}
+		if s.ListenBrainzToken != "" {
+			services = append(services, &listenBrainz{endpoint: orDefault(s.ListenBrainzURL, defaultListenBrainzURL),
+				token: s.ListenBrainzToken})
+		}
+	}
+	sc.mu.Lock()
+	sc.services = services
+	sc.mu.Unlock()
+	sc.poke()
+}
+
+// NowPlaying tells the services a track has started.
+func (sc *Scrobbler) NowPlaying(t ScrobbleTrack) {
+	if sc == nil || !t.scrobbleable() {
+		return
+	}
+	for _, svc := range sc.active() {
+		go func(svc scrobbleService) {
+			if err := svc.NowPlaying(t); err != nil {
+				sc.setErr(svc, err)
+				return
+			}
+			sc.poke() // the network is back; try the queue
+		}(svc)
+	}
+}
+
+// Scrobble queues a play for every service and wakes the sender.
+func (sc *Scrobbler) Scrobble(t ScrobbleTrack) {
+	if sc == nil || !t.scrobbleable() {
+		return
+	}
+	sc.mu.Lock()
+	for _, svc := range sc.services {
+		sc.nextID++
+		sc.queue = append(sc.queue, queuedScrobble{Service: svc.Name(), Track: t, id: sc.nextID})
+	}
+	sc.saveLocked()
+	sc.mu.Unlock()
+	sc.poke()
+}
+
+// Status summarizes the scrobbler for the settings screen, e.g.
+// "Last.fm, ListenBrainz · 3 queued".
+func (sc *Scrobbler) Status() string {
+	if sc == nil {
+		return "by the daemon"
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var names []string
+	for _, svc := range sc.services {
+		names = append(names, svc.Name())
+	}
+	status := strings.Join(names, ", ")
+	if status == "" {
+		status = "no accounts set up"
+	}
+	if n := len(sc.queue); n > 0 {
+		status += fmt.Sprintf(" · %d queued", n)
+	}
+	if sc.lastErr != "" {
+		status += " · " + sc.lastErr
+	}
+	return status
+}
+
+func (sc *Scrobbler) active() []scrobbleService {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.services
+}
+
+func (sc *Scrobbler) setErr(svc scrobbleService, err error) {
+	sc.mu.Lock()
+	sc.lastErr = svc.Name() + ": " + err.Error()
+	sc.mu.Unlock()
+}
+
+// poke wakes the sender without blocking.
+func (sc *Scrobbler) poke() {
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queue whenever it's woken, and retries what failed with a
+// growing delay.
+func (sc *Scrobbler) run() {
+	delay := scrobbleRetryMin
+	for {
+		select {
+		case <-sc.wake:
+		case <-time.After(delay):
+		}
+		if sc.flush() {
+			delay = scrobbleRetryMin
+		} else {
+			delay *= 2
+			if delay > scrobbleRetryMax {
+				delay = scrobbleRetryMax
+			}
+		}
+	}
+}
+
+// flush sends the queued scrobbles, in batches, to each configured service.
+// It reports false if anything failed and is still queued. Scrobbles for a
+// service that isn't set up any more stay queued until it is.
+func (sc *Scrobbler) flush() bool {
+	sc.mu.Lock()
+	services := sc.services
+	pending := append([]queuedScrobble(nil), sc.queue...)
+	sc.mu.Unlock()
+
+	ok := true
+	for _, svc := range services {
+		var batch []queuedScrobble
+		for _, q := range pending {
+			if q.Service == svc.Name() {
+				batch = append(batch, q)
+			}
+		}
+		for len(batch) > 0 {
+			n := min(len(batch), svc.MaxBatch())
+			tracks := make([]ScrobbleTrack, n)
+			for i := range tracks {
+				tracks[i] = batch[i].Track
+			}
+			err := svc.Scrobble(tracks)
+			var perm permanentError
+			if err != nil {
+				sc.setErr(svc, err)
+				if !errors.As(err, &perm) {
+					ok = false
+					break
+				}
+			}
+			sc.remove(batch[:n])
+			batch = batch[n:]
+		}
+	}
+	if ok {
+		sc.mu.Lock()
+		sc.lastErr = ""
+		sc.mu.Unlock()
+	}
+	return ok
+}
+
+// remove takes sent scrobbles off the queue.
+func (sc *Scrobbler) remove(sent []queuedScrobble) {
+	ids := make(map[int64]bool, len(sent))
+	for _, q := range sent {
+		ids[q.id] = true
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	kept := sc.queue[:0]
+	for _, q := range sc.queue {
+		if !ids[q.id] {
+			kept = append(kept, q)
+		}
+	}
+	sc.queue = kept
+	sc.saveLocked()
+}
+
+// load reads the queue left by the last run. Lines that don't parse are
+// skipped.
+func (sc *Scrobbler) load() {
+	data, err := os.ReadFile(sc.queueFile)
+	if err != nil {
+		return
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var q queuedScrobble
+		if json.Unmarshal(line, &q) != nil || q.Service == "" {
+			continue
+		}
+		sc.nextID++
+		q.id = sc.nextID
+		sc.queue = append(sc.queue, q)
+	}
+}
+
+// saveLocked writes the queue out, replacing the file in one step so a crash
+// can't leave half of it. Call it with mu held.
+func (sc *Scrobbler) saveLocked() {
+	if len(sc.queue) == 0 {
+		os.Remove(sc.queueFile)
+		return
+	}
+	var buf bytes.Buffer
+	for _, q := range sc.queue {
+		data, err := json.Marshal(q)
+		if err != nil {
+			continue
+		}
+		buf.Write(append(data, '\n'))
+	}
+	tmp := sc.queueFile + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return
+	}
+	os.Rename(tmp, sc.queueFile)
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+var scrobbleClient = &http.Client{Timeout: 15 * time.Second}
+
+// lastFM speaks the Last.fm (Audioscrobbler 2.0) API: signed form posts
+// with the session key of an authorized user.
+type lastFM struct {
+	endpoint, key, secret, session string
+}
+
+func (lf *lastFM) Name() string  { return "Last.fm" }
+func (lf *lastFM) MaxBatch() int { return 50 }
+
+func (lf *lastFM) NowPlaying(t ScrobbleTrack) error {
+	params := url.Values{"method": {"track.updateNowPlaying"}}
+	lastFMTrackParams(params, t, "")
+	return lf.call(params, nil)
+}
+
+func (lf *lastFM) Scrobble(ts []ScrobbleTrack) error {
+	params := url.Values{"method": {"track.scrobble"}}
+	for i, t := range ts {
+		suffix := "[" + strconv.Itoa(i) + "]"
+		lastFMTrackParams(params, t, suffix)
+		params.Set("timestamp"+suffix, strconv.FormatInt(t.Time.Unix(), 10))
+	}
+	return lf.call(params, nil)
+}
+
+func lastFMTrackParams(params url.Values, t ScrobbleTrack, suffix string) {
+	params.Set("artist"+suffix, t.Artist)
+	params.Set("track"+suffix, t.Title)
+	if t.Album != "" {
+		params.Set("album"+suffix, t.Album)
+	}
+	if t.AlbumArtist != "" {
+		params.Set("albumArtist"+suffix, t.AlbumArtist)
+	}
+	if t.TrackNumber > 0 {
+		params.Set("trackNumber"+suffix, strconv.Itoa(t.TrackNumber))
+	}
+	if t.Duration > 0 {
+		params.Set("duration"+suffix, strconv.Itoa(int(t.Duration+0.5)))
+	}
+}
+
+// call signs and posts a request, decoding the response into out if it
+// isn't nil.
+func (lf *lastFM) call(params url.Values, out any) error {
+	params.Set("api_key", lf.key)
+	if lf.session != "" {
+		params.Set("sk", lf.session)
+	}
+	params.Set("api_sig", lastFMSignature(params, lf.secret))
+	params.Set("format", "json")
+
+	resp, err := scrobbleClient.PostForm(lf.endpoint, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	body := new(bytes.Buffer)
+	body.ReadFrom(resp.Body)
+	if err := json.Unmarshal(body.Bytes(), &r); err != nil {
+		return fmt.Errorf("bad response (%s)", resp.Status)
+	}
+	if r.Error != 0 {
+		err := fmt.Errorf("%s (error %d)", r.Message, r.Error)
+		if r.Error == 6 || r.Error == 7 {
+			return permanentError{err} // invalid parameters or resource
+		}
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed (%s)", resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(body.Bytes(), out)
+	}
+	return nil
+}
+
+// lastFMSignature is the api_sig of a request: the MD5 of its parameters
+// (name then value, sorted by name, leaving out format) followed by the
+// shared secret.
+func lastFMSignature(params url.Values, secret string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "format" && name != "api_sig" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + params.Get(name))
+	}
+	sum := md5.Sum([]byte(b.String() + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastFMSessionMsg carries the session key from a Last.fm sign-in.
+type lastFMSessionMsg struct {
+	key string
+	err error
+}
+
+// lastFMSessionCmd signs in to Last.fm with a username and password
+// (auth.getMobileSession) to get the session key scrobbling needs.
+func lastFMSessionCmd(s Settings) tea.Cmd {
+	lf := &lastFM{endpoint: orDefault(s.LastFMURL, defaultLastFMURL), key: s.LastFMKey, secret: s.LastFMSecret}
+	user, password := s.LastFMUser, s.LastFMPassword
+	return func() tea.Msg {
+		var r struct {
+			Session struct {
+				Key string `json:"key"`
+			} `json:"session"`
+		}
+		params := url.Values{"method": {"auth.getMobileSession"}, "username": {user}, "password": {password}}
+		if err := lf.call(params, &r); err != nil {
+			return lastFMSessionMsg{err: err}
+		}
+		if r.Session.Key == "" {
+			return lastFMSessionMsg{err: fmt.Errorf("no session key in the response")}
+		}
+		return lastFMSessionMsg{key: r.Session.Key}
+	}
+}
+
+// listenBrainz speaks the ListenBrainz submit-listens API with a user token.
+type listenBrainz struct {
+	endpoint, token string
+}
+
+func (lb *listenBrainz) Name() string  { return "ListenBrainz" }
+func (lb *listenBrainz) MaxBatch() int { return 100 }
+
+func (lb *listenBrainz) NowPlaying(t ScrobbleTrack) error {
+	return lb.submit("playing_now", []ScrobbleTrack{t})
+}
+
+func (lb *listenBrainz) Scrobble(ts []ScrobbleTrack) error {
+	kind := "single"
+	if len(ts) > 1 {
+		kind = "import"
+	}
+	return lb.submit(kind, ts)
+}
+
+type listenBrainzListen struct {
+	ListenedAt int64 `json:"listened_at,omitempty"`
+	Track      struct {
+		Artist  string         `json:"artist_name"`
+		Title   string         `json:"track_name"`
+		Release string         `json:"release_name,omitempty"`
+		Info    map[string]any `json:"additional_info,omitempty"`
+	} `json:"track_metadata"`
+}
+
+func (lb *listenBrainz) submit(kind string, ts []ScrobbleTrack) error {
+	payload := make([]listenBrainzListen, len(ts))
+	for i, t := range ts {
+		l := &payload[i]
+		if kind != "playing_now" {
+			l.ListenedAt = t.Time.Unix()
+		}
+		l.Track.Artist, l.Track.Title, l.Track.Release = t.Artist, t.Title, t.Album
+		l.Track.Info = map[string]any{"media_player": "Resona", "submission_client": "Resona"}
+		if t.Duration > 0 {
+			l.Track.Info["duration_ms"] = int(t.Duration * 1000)
+		}
+		if t.TrackNumber > 0 {
+			l.Track.Info["tracknumber"] = t.TrackNumber
+		}
+	}
+	body, err := json.Marshal(map[string]any{"listen_type": kind, "payload": payload})
+	if err != nil {
+		return permanentError{err}
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(lb.endpoint, "/")+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+lb.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := scrobbleClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var r struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&r)
+	err = fmt.Errorf("request failed (%s)", resp.Status)
+	if r.Error != "" {
+		err = fmt.Errorf("%s (%s)", r.Error, resp.Status)
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		return permanentError{err} // the listens themselves were rejected
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Search matches text folded to a plain lowercase form: compatibility
+// decomposition (NFKD) with the accents dropped, so "Bjork" finds "Björk" and
+// "ﬁ" finds "fi", a few letters NFKD leaves alone spelt out (ø, æ, ß…), and,
+// when transliteration is on, Cyrillic and kana written in Latin letters so
+// "kino" finds "Кино" and "tokyo" finds "とうきょう".
+
+// foldedText is text folded for matching. src[i] is the index of the rune in
+// the original text that runes[i] came from, so a match can be highlighted
+// in the text as written.
+type foldedText struct {
+	runes []rune
+	src   []int
+}
+
+// foldLetters spells out letters that have no decomposition.
+var foldLetters = map[rune]string{
+	'ß': "ss", 'ẞ': "ss", 'æ': "ae", 'Æ': "ae", 'œ': "oe", 'Œ': "oe",
+	'ø': "o", 'Ø': "o", 'đ': "d", 'Đ': "d", 'ð': "d", 'Ð': "d",
+	'ł': "l", 'Ł': "l", 'þ': "th", 'Þ': "th", 'ı': "i", 'ς': "σ",
+}
+
+// cyrillicLatin transliterates lowercase Cyrillic (Russian, Ukrainian,
+// Belarusian and Serbian letters) the way song titles are usually romanized.
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "i", 'є': "ye", 'ґ': "g", 'ў': "u",
+	'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+}
+
+// kanaLatin romanizes hiragana (Hepburn); katakana is looked up as the
+// matching hiragana. The small kana and the long-vowel mark are handled by
+// foldText, since they change the syllable before or after them.
+var kanaLatin = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o", 'ゎ': "wa",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo",
+}
+
+// foldText folds s for matching; see foldedText.
+func foldText(s string, translit bool) foldedText {
+	f := foldedText{runes: make([]rune, 0, len(s)), src: make([]int, 0, len(s))}
+	emit := func(text string, i int) {
+		for _, r := range text {
+			f.runes = append(f.runes, r)
+			f.src = append(f.src, i)
+		}
+	}
+	double := false // a small tsu doubles the next consonant
+	for i, r := range []rune(s) {
+		if r < 0x80 {
+			if r >= 'A' && r <= 'Z' {
+				r += 'a' - 'A'
+			}
+			f.runes, f.src = append(f.runes, r), append(f.src, i)
+			double = false
+			continue
+		}
+		if translit {
+			if r >= 'ァ' && r <= 'ヶ' {
+				r -= 'ァ' - 'ぁ' // katakana to hiragana
+			}
+			switch r {
+			case 'っ':
+				double = true
+				continue
+			case 'ー':
+				continue // "ramen" should find ラーメン
+			case 'ゃ', 'ゅ', 'ょ', 'ぁ', 'ぃ', 'ぅ', 'ぇ', 'ぉ':
+				// Small kana fuse with the syllable before them: き+ゃ is
+				// kya, し+ゃ sha, ふ+ぁ fa.
+				small := kanaLatin[r]
+				n := len(f.runes)
+				last, prev := rune(0), rune(0)
+				if n >= 2 {
+					last, prev = f.runes[n-1], f.runes[n-2]
+				}
+				youon := small[0] == 'y' && last == 'i' && !isVowel(prev)
+				if youon || (last == 'u' && (prev == 'f' || prev == 'v')) {
+					f.runes, f.src = f.runes[:n-1], f.src[:n-1]
+					sh := prev == 'h' && n >= 3 && (f.runes[n-3] == 's' || f.runes[n-3] == 'c')
+					if sh || prev == 'j' {
+						small = small[1:] // sh+a, ch+u, j+o
+					}
+					emit(small, i)
+					continue
+				}
+			}
+			latin, ok := kanaLatin[r]
+			if !ok {
+				latin, ok = cyrillicLatin[unicode.ToLower(r)]
+			}
+			if ok {
+				if double && latin != "" && !isVowel(rune(latin[0])) {
+					emit(latin[:1], i)
+				}
+				double = false
+				emit(latin, i)
+				continue
+			}
+		}
+		double = false
+		if spelt, ok := foldLetters[r]; ok {
+			emit(spelt, i)
+			continue
+		}
+		for _, d := range norm.NFKD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue // the accents NFKD split off
+			}
+			if spelt, ok := foldLetters[d]; ok {
+				emit(spelt, i)
+			} else {
+				f.runes, f.src = append(f.runes, unicode.ToLower(d)), append(f.src, i)
+			}
+		}
+	}
+	return f
+}
+
+// foldString folds s for matching, without the positions; see foldedText.
+func foldString(s string, translit bool) string {
+	if isLowerASCII(s) {
+		return s
+	}
+	return string(foldText(s, translit).runes)
+}
+
+// isLowerASCII reports whether s is already folded: plain ASCII with no
+// capitals.
+func isLowerASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 || (s[i] >= 'A' && s[i] <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isVowel(r rune) bool {
+	return strings.ContainsRune("aeiou", r)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchTerms is a search query taken apart: the free text the results are
+// fuzzy-ranked on, and the terms that only filter. For example
+// `artist:radiohead year:>2000 genre:"post rock" -live dur:<5m rating:>=4`
+// has no free text, excludes songs mentioning "live" and has four filters.
+type searchTerms struct {
+	text    string         // free words and phrases, folded, for fuzzy ranking
+	phrases []string       // quoted phrases, which have to appear as written
+	exclude []string       // -word and -"phrase": songs mentioning these are left out
+	filters []searchFilter // field terms
+
+	translit bool // whether text, phrases and exclude were folded with transliteration
+}
+
+// searchFilter is one field term. Most are a single rule; a range such as
+// year:1990-1999 is two. A negated term (-genre:rock) lets through the songs
+// its rules don't.
+type searchFilter struct {
+	rules  []SmartRule
+	negate bool
+}
+
+// searchFieldAliases are the short names the search box accepts on top of
+// the smart playlist field names.
+var searchFieldAliases = map[string]string{
+	"dur":    "duration",
+	"length": "duration",
+	"fav":    "favorite",
+	"stars":  "rating",
+}
+
+// searchSyntaxHelp is the one-line syntax summary shown under an empty query.
+const searchSyntaxHelp = `artist:radiohead year:>2000 genre:"post rock" -live dur:<5m rating:>=4 bpm:120-130 key:Am`
+
+// parseSearchTerms parses a search query. Free words are ranked as before;
+// "quoted phrases" also have to match as written; -word leaves out songs that
+// mention the word; field:value (or field:>value, field>value and so on, with
+// smart playlist field names) filters, and -field:value filters the other
+// way. A term that doesn't parse is dropped and reported in the error, so the
+// rest of the query still searches while it's being typed. Words and
+// phrases are folded (see foldText), transliterated when translit is set.
+func parseSearchTerms(query string, translit bool) (searchTerms, error) {
+	st := searchTerms{translit: translit}
+	var words []string
+	var firstErr error
+	for _, term := range splitTerms(query) {
+		negate := false
+		if len(term) > 1 && term[0] == '-' {
+			negate, term = true, term[1:]
+		}
+
+		if strings.HasPrefix(term, `"`) {
+			phrase := strings.Trim(term, `"`)
+			if unq, err := strconv.Unquote(term); err == nil {
+				phrase = unq
+			}
+			if phrase = foldString(strings.TrimSpace(phrase), translit); phrase == "" {
+				continue
+			}
+			if negate {
+				st.exclude = append(st.exclude, phrase)
+			} else {
+				st.phrases = append(st.phrases, phrase)
+				words = append(words, phrase)
+			}
+			continue
+		}
+
+		// A known field followed by an operator is a filter; so is any
+		// word:value, so a misspelt field is reported rather than searched
+		// for. Anything else (P!nk, Wham!) is a word.
+		if i := strings.IndexAny(term, ":=~<>!"); i > 0 {
+			name := strings.ToLower(term[:i])
+			if alias, ok := searchFieldAliases[name]; ok {
+				name = alias
+			}
+			if _, known := smartFieldKinds[name]; known || term[i] == ':' {
+				field, op, value, ok := splitTerm(term)
+				var f searchFilter
+				var err error
+				if !ok {
+					err = fmt.Errorf("%s needs a value", name)
+				} else {
+					f, err = parseSearchField(field, op, value)
+				}
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+				f.negate = negate
+				st.filters = append(st.filters, f)
+				continue
+			}
+		}
+
+		if negate {
+			st.exclude = append(st.exclude, foldString(term, translit))
+		} else {
+			words = append(words, term)
+		}
+	}
+	st.text = foldString(strings.Join(words, " "), translit)
+	return st, firstErr
+}
+
+// parseSearchField turns a field term into a filter.
+func parseSearchField(field, op, value string) (searchFilter, error) {
+	field = strings.ToLower(field)
+	if alias, ok := searchFieldAliases[field]; ok {
+		field = alias
+	}
+	kind, ok := smartFieldKinds[field]
+	if !ok {
+		return searchFilter{}, fmt.Errorf("unknown field %q", field)
+	}
+
+	// field:>value reads as field>value.
+	if op == ":" {
+		for _, o := range []string{">=", "<=", "!=", ">", "<", "=", "~"} {
+			if strings.HasPrefix(value, o) {
+				op, value = o, strings.TrimSpace(value[len(o):])
+				break
+			}
+		}
+		if value == "" {
+			return searchFilter{}, fmt.Errorf("%s needs a value", field)
+		}
+	}
+
+	switch kind {
+	case "text":
+		// In the search box field:value means "contains", except for keys,
+		// which are matched whole so key:A doesn't find Am.
+		if op == ":" && field != "key" {
+			op = "~"
+		}
+	case "number":
+		if op == ":" || op == "=" {
+			if lo, hi, ok := strings.Cut(value, "-"); ok && lo != "" {
+				return numberRange(field, lo, hi)
+			}
+			if field == "bpm" {
+				// Tempo estimates are never exact; allow a few BPM either way.
+				n, err := parseSmartNumber(field, value)
+				if err != nil {
+					return searchFilter{}, err
+				}
+				return numberRange(field, strconv.FormatFloat(n-3, 'f', -1, 64), strconv.FormatFloat(n+3, 'f', -1, 64))
+			}
+		}
+		if _, err := parseSmartNumber(field, value); err != nil {
+			return searchFilter{}, err
+		}
+	case "flag":
+		if _, err := parseFlag(field, value); err != nil {
+			return searchFilter{}, err
+		}
+	case "age":
+		if _, err := parseAge(value); err != nil {
+			return searchFilter{}, err
+		}
+	}
+	if !containsString(smartOps[kind], op) {
+		return searchFilter{}, fmt.Errorf("%s can't be compared with %q (use %s)", field, op, strings.Join(smartOps[kind], " "))
+	}
+	return searchFilter{rules: []SmartRule{{Field: field, Op: op, Value: value}}}, nil
+}
+
+// numberRange is the filter for field:lo-hi, both ends included.
+func numberRange(field, lo, hi string) (searchFilter, error) {
+	for _, v := range []string{lo, hi} {
+		if _, err := parseSmartNumber(field, v); err != nil {
+			return searchFilter{}, err
+		}
+	}
+	return searchFilter{rules: []SmartRule{
+		{Field: field, Op: ">=", Value: lo},
+		{Field: field, Op: "<=", Value: hi},
+	}}, nil
+}
+
+// active reports whether the query filters at all, beyond its free text.
+func (st searchTerms) active() bool {
+	return len(st.phrases) > 0 || len(st.exclude) > 0 || len(st.filters) > 0
+}
+
+// matches reports whether a song passes the query's filters. Phrases and
+// exclusions look at the title, artist, album and genre.
+func (st searchTerms) matches(s Song, library *LibraryManager, history *HistoryManager, now time.Time) bool {
+	if len(st.phrases) > 0 || len(st.exclude) > 0 {
+		text := foldString(s.Title+"\x00"+s.Artist+"\x00"+s.Album+"\x00"+s.Genre, st.translit)
+		for _, p := range st.phrases {
+			if !strings.Contains(text, p) {
+				return false
+			}
+		}
+		for _, x := range st.exclude {
+			if strings.Contains(text, x) {
+				return false
+			}
+		}
+	}
+	for _, f := range st.filters {
+		pass := true
+		for _, r := range f.rules {
+			if !r.matches(s, library, history, now) {
+				pass = false
+				break
+			}
+		}
+		if pass == f.negate {
+			return false
+		}
+	}
+	return true
+}
@@ -29,10 +29,30 @@ type Theme struct {
 
 // Settings holds all user preferences
 type Settings struct {
-	Theme     string `json:"theme"`      // Current theme name
-	Volume    int    `json:"volume"`     // Volume level (0-100)
-	AutoPlay  bool   `json:"auto_play"`  // Auto-play next track
-	Crossfade bool   `json:"crossfade"`  // Crossfade between tracks
+	Theme             string `json:"theme"`              // Current theme name
+	Volume            int    `json:"volume"`             // Volume level (0-100)
+	AutoPlay          bool   `json:"auto_play"`          // Auto-play next track
+	Crossfade         bool   `json:"crossfade"`          // Crossfade between tracks
+	AlbumArt          string `json:"album_art"`          // "auto" (image protocol if detected), "blocks", or "off"
+	CoverColors       bool   `json:"cover_colors"`       // Derive the gradient from the playing track's cover
+	Fingerprints      bool   `json:"fingerprints"`       // Compute acoustic fingerprints while scanning
+	Analyze           bool   `json:"analyze"`            // Estimate tempo and key while scanning
+	AcoustIDURL       string `json:"acoustid_url"`       // AcoustID lookup endpoint (point at a local mock for testing)
+	AcoustIDKey       string `json:"acoustid_key"`       // AcoustID application API key
+	WriteRatings      bool   `json:"write_ratings"`      // Also write star ratings to the files' tags
+	Transliterate     bool   `json:"transliterate"`      // Let Latin search text match Cyrillic and kana
+	Scrobble          bool   `json:"scrobble"`           // Send plays to the scrobbling accounts below
+	LastFMURL         string `json:"lastfm_url"`         // Last.fm API endpoint (or a compatible service, or a mock)
+	LastFMKey         string `json:"lastfm_key"`         // Last.fm application API key
+	LastFMSecret      string `json:"lastfm_secret"`      // Last.fm application shared secret
+	LastFMSession     string `json:"lastfm_session"`     // Last.fm session key, filled in by signing in
+	LastFMUser        string `json:"lastfm_user"`        // Last.fm username, to sign in with
+	LastFMPassword    string `json:"lastfm_password"`    // Last.fm password; cleared once signed in
+	ListenBrainzURL   string `json:"listenbrainz_url"`   // ListenBrainz API root
+	ListenBrainzToken string `json:"listenbrainz_token"` // ListenBrainz user token
+	MPD               bool   `json:"mpd"`                // Serve the MPD protocol to MPD clients
+	MPDAddress        string `json:"mpd_address"`        // Where the MPD server listens, host:port ("0.0.0.0:6600" for the LAN)
+	MPDPassword       string `json:"mpd_password"`       // Password MPD clients must send first; empty for none
 }
 
 // SettingsManager manages user settings and themes
@@ -41,6 +61,9 @@ type SettingsManager struct {
 	themes     map[string]Theme
 	filePath   string
 	themesPath string
+	stamp      fileStamp // of settings.json as last read or written
+	// Cover-derived gradient colors, applied by GetTheme when CoverColors is on
+	gradientOverride [2]string
 }
 
 // NewSettingsManager creates a new settings manager
@@ -60,10 +83,16 @@ func NewSettingsManager() (*SettingsManager, error) {
 	
 	sm := &SettingsManager{
 		settings: Settings{
-			Theme:     "default",
-			Volume:    80,
-			AutoPlay:  true,
-			Crossfade: false,
+			Theme:           "default",
+			Volume:          80,
+			AutoPlay:        true,
+			Crossfade:       false,
+			AlbumArt:        "auto",
+			Fingerprints:    true,
+			AcoustIDURL:     defaultAcoustIDURL,
+			LastFMURL:       defaultLastFMURL,
+			ListenBrainzURL: defaultListenBrainzURL,
+			MPDAddress:      defaultMPDAddress,
 		},
 		themes:     make(map[string]Theme),
 		filePath:   settingsPath,
@@ -242,18 +271,34 @@ func (sm *SettingsManager) LoadSettings() error {
 	if err != nil {
 		return err
 	}
+	sm.stamp = stampOf(sm.filePath)
 	
 	return json.Unmarshal(data, &sm.settings)
 }
 
-// SaveSettings saves settings to persistent storage
+// SaveSettings saves settings to persistent storage. The file holds API
+// secrets and session keys, so it's readable by the owner only; a file
+// created before that was the rule is tightened on the next save.
 func (sm *SettingsManager) SaveSettings() error {
 	data, err := json.MarshalIndent(sm.settings, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal settings: %w", err)
 	}
 	
-	return os.WriteFile(sm.filePath, data, 0644)
+	if err := os.WriteFile(sm.filePath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(sm.filePath, 0600); err != nil {
+		return err
+	}
+	sm.stamp = stampOf(sm.filePath)
+	return nil
+}
+
+// ReloadIfChanged rereads settings.json if another process has written it
+// since this one last read or wrote it, reporting whether it did.
+func (sm *SettingsManager) ReloadIfChanged() bool {
+	return sm.stamp.changed(sm.filePath) && sm.LoadSettings() == nil
 }
 
 // LoadThemes loads themes from individual JSON files
@@ -338,11 +383,90 @@ func (sm *SettingsManager) GetTheme() Theme {
 	theme, exists := sm.themes[sm.settings.Theme]
 	if !exists {
 		// Fallback to default theme
-		return sm.themes["default"]
+		theme = sm.themes["default"]
+	}
+	if sm.settings.CoverColors && sm.gradientOverride[0] != "" {
+		theme.GradientStart, theme.GradientEnd = sm.gradientOverride[0], sm.gradientOverride[1]
 	}
 	return theme
 }
 
+// SetGradientOverride sets the cover-derived gradient colors ("" clears them).
+func (sm *SettingsManager) SetGradientOverride(start, end string) {
+	sm.gradientOverride = [2]string{start, end}
+}
+
+// AlbumArtMode returns the album art setting, treating an unset value as "auto".
+func (sm *SettingsManager) AlbumArtMode() string {
+	if sm.settings.AlbumArt == "" {
+		return "auto"
+	}
+	return sm.settings.AlbumArt
+}
+
+// CycleAlbumArt steps the album art setting through auto, blocks and off.
+func (sm *SettingsManager) CycleAlbumArt() error {
+	next := map[string]string{"auto": "blocks", "blocks": "off", "off": "auto"}
+	sm.settings.AlbumArt = next[sm.AlbumArtMode()]
+	return sm.SaveSettings()
+}
+
+// ToggleCoverColors turns the cover-derived gradient on or off.
+func (sm *SettingsManager) ToggleCoverColors() error {
+	sm.settings.CoverColors = !sm.settings.CoverColors
+	return sm.SaveSettings()
+}
+
+// ToggleFingerprints turns fingerprinting during scans on or off.
+func (sm *SettingsManager) ToggleFingerprints() error {
+	sm.settings.Fingerprints = !sm.settings.Fingerprints
+	return sm.SaveSettings()
+}
+
+// ToggleAnalyze turns tempo and key analysis during scans on or off.
+func (sm *SettingsManager) ToggleAnalyze() error {
+	sm.settings.Analyze = !sm.settings.Analyze
+	return sm.SaveSettings()
+}
+
+// ToggleWriteRatings turns writing ratings to file tags on or off.
+func (sm *SettingsManager) ToggleWriteRatings() error {
+	sm.settings.WriteRatings = !sm.settings.WriteRatings
+	return sm.SaveSettings()
+}
+
+// ToggleTransliterate turns transliteration in search on or off.
+func (sm *SettingsManager) ToggleTransliterate() error {
+	sm.settings.Transliterate = !sm.settings.Transliterate
+	return sm.SaveSettings()
+}
+
+// SetVolume stores the volume level (0-100).
+func (sm *SettingsManager) SetVolume(volume int) error {
+	sm.settings.Volume = volume
+	return sm.SaveSettings()
+}
+
+// ToggleScrobble turns scrobbling on or off.
+func (sm *SettingsManager) ToggleScrobble() error {
+	sm.settings.Scrobble = !sm.settings.Scrobble
+	return sm.SaveSettings()
+}
+
+// ToggleMPD turns the MPD server on or off.
+func (sm *SettingsManager) ToggleMPD() error {
+	sm.settings.MPD = !sm.settings.MPD
+	return sm.SaveSettings()
+}
+
+// SetLastFMSession stores the session key from signing in to Last.fm and
+// forgets the password, which isn't needed again.
+func (sm *SettingsManager) SetLastFMSession(key string) error {
+	sm.settings.LastFMSession = key
+	sm.settings.LastFMPassword = ""
+	return sm.SaveSettings()
+}
+
 // GetThemeNames returns all available theme names
 func (sm *SettingsManager) GetThemeNames() []string {
 	var names []string
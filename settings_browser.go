@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 )
 
 // SettingsBrowser handles the settings interface
@@ -9,23 +10,37 @@ type SettingsBrowser struct {
 	settingsManager *SettingsManager
 	libraryManager  *LibraryManager
 	radioLibrary    *RadioLibrary
-	currentView     string // "main", "themes", "confirm_clear_music", "confirm_clear_radio"
+	historyManager  *HistoryManager
+	currentView     string // "main", "themes", "duplicates", "health", "stats", "confirm_clear_music", "confirm_clear_radio", "confirm_delete_duplicates"
 	selected        int
 	viewport        viewport
 	// Theme selection
 	themeSelected   int
 	themeNames      []string
 	// Confirmation state
-	confirmAction   string // "clear_music", "clear_radio"
+	confirmAction   string // "clear_music", "clear_radio", "delete_duplicates"
 	confirmSelected int    // 0=cancel, 1=confirm
+	// Duplicates view: groups found on entry and the highlighted copy,
+	// counted across all groups.
+	dupGroups   []DuplicateGroup
+	dupSelected int
+	// Health report: current filter (index into healthFilters) and rows.
+	healthFilter   int
+	healthRows     []HealthRow
+	healthSelected int
+	// Listening stats: current window (index into statsWindows) and the
+	// summary for it.
+	statsWindow int
+	stats       ListeningStats
 }
 
 // NewSettingsBrowser creates a new settings browser
-func NewSettingsBrowser(settingsManager *SettingsManager, libraryManager *LibraryManager, radioLibrary *RadioLibrary) *SettingsBrowser {
+func NewSettingsBrowser(settingsManager *SettingsManager, libraryManager *LibraryManager, radioLibrary *RadioLibrary, historyManager *HistoryManager) *SettingsBrowser {
 	return &SettingsBrowser{
 		settingsManager: settingsManager,
 		libraryManager:  libraryManager,
 		radioLibrary:    radioLibrary,
+		historyManager:  historyManager,
 		currentView:     "main",
 		selected:        0,
 		viewport:        viewport{top: 0, height: 20},
@@ -50,7 +65,15 @@ func (sb *SettingsBrowser) MoveUp() {
 		if sb.themeSelected > 0 {
 			sb.themeSelected--
 		}
-	case "confirm_clear_music", "confirm_clear_radio":
+	case "duplicates":
+		if sb.dupSelected > 0 {
+			sb.dupSelected--
+		}
+	case "health":
+		if sb.healthSelected > 0 {
+			sb.healthSelected--
+		}
+	case "confirm_clear_music", "confirm_clear_radio", "confirm_delete_duplicates":
 		if sb.confirmSelected > 0 {
 			sb.confirmSelected--
 		}
@@ -61,7 +84,7 @@ func (sb *SettingsBrowser) MoveUp() {
 func (sb *SettingsBrowser) MoveDown() {
 	switch sb.currentView {
 	case "main":
-		maxItems := 2 // Clear Music Library, Clear Radio Library, Color Themes
+		maxItems := 13 // Clear Music Library, Clear Radio Library, Color Themes, Album Art, Cover Colors, Fingerprint on Scan, Analyze BPM/Key on Scan, Write Ratings to Tags, Transliterate in Search, Scrobbling, MPD Server, Find Duplicates, Library Health, Listening Stats
 		if sb.selected < maxItems {
 			sb.selected++
 		}
@@ -69,7 +92,15 @@ func (sb *SettingsBrowser) MoveDown() {
 		if sb.themeSelected < len(sb.themeNames)-1 {
 			sb.themeSelected++
 		}
-	case "confirm_clear_music", "confirm_clear_radio":
+	case "duplicates":
+		if sb.dupSelected < sb.duplicateCopyCount()-1 {
+			sb.dupSelected++
+		}
+	case "health":
+		if sb.healthSelected < len(sb.healthRows)-1 {
+			sb.healthSelected++
+		}
+	case "confirm_clear_music", "confirm_clear_radio", "confirm_delete_duplicates":
 		if sb.confirmSelected < 1 {
 			sb.confirmSelected++
 		}
@@ -99,6 +130,31 @@ func (sb *SettingsBrowser) EnterSelected() error {
 					break
 				}
 			}
+		case 3: // Album Art (toggles in place)
+			return sb.settingsManager.CycleAlbumArt()
+		case 4: // Cover Colors (toggles in place)
+			return sb.settingsManager.ToggleCoverColors()
+		case 5: // Fingerprint on Scan (toggles in place)
+			return sb.settingsManager.ToggleFingerprints()
+		case 6: // Analyze BPM/Key on Scan (toggles in place)
+			return sb.settingsManager.ToggleAnalyze()
+		case 7: // Write Ratings to Tags (toggles in place)
+			return sb.settingsManager.ToggleWriteRatings()
+		case 8: // Transliterate in Search (toggles in place)
+			return sb.settingsManager.ToggleTransliterate()
+		case 9: // Scrobbling (toggles in place)
+			return sb.settingsManager.ToggleScrobble()
+		case 10: // MPD Server (toggles in place)
+			return sb.settingsManager.ToggleMPD()
+		case 11: // Find Duplicates
+			sb.currentView = "duplicates"
+			sb.RefreshDuplicates()
+		case 12: // Library Health
+			sb.currentView = "health"
+			sb.RefreshHealth()
+		case 13: // Listening Stats
+			sb.currentView = "stats"
+			sb.RefreshStats()
 		}
 	case "themes":
 		// Apply selected theme
@@ -126,6 +182,15 @@ func (sb *SettingsBrowser) EnterSelected() error {
 			}
 		}
 		sb.currentView = "main"
+	case "duplicates":
+		// Keep the highlighted copy of its group.
+		if g, c := sb.SelectedDuplicate(); g >= 0 {
+			sb.dupGroups[g].Keep = c
+		}
+	case "confirm_delete_duplicates":
+		// The deletion itself is done by the caller, which also has to fix up
+		// playlists and the play queue; this just closes the prompt.
+		sb.currentView = "duplicates"
 	}
 	return nil
 }
@@ -133,9 +198,56 @@ func (sb *SettingsBrowser) EnterSelected() error {
 // BackPressed handles back/escape key press
 func (sb *SettingsBrowser) BackPressed() {
 	switch sb.currentView {
-	case "themes", "confirm_clear_music", "confirm_clear_radio":
+	case "themes", "duplicates", "health", "stats", "confirm_clear_music", "confirm_clear_radio":
 		sb.currentView = "main"
+	case "confirm_delete_duplicates":
+		sb.currentView = "duplicates"
+	}
+}
+
+// ConfirmDeleteDuplicates asks before deleting the extra copies in the
+// highlighted duplicate group.
+func (sb *SettingsBrowser) ConfirmDeleteDuplicates() {
+	if g, _ := sb.SelectedDuplicate(); g >= 0 {
+		sb.currentView = "confirm_delete_duplicates"
+		sb.confirmAction = "delete_duplicates"
+		sb.confirmSelected = 0
+	}
+}
+
+// RefreshDuplicates rescans the library for duplicate groups, keeping the
+// highlight in range.
+func (sb *SettingsBrowser) RefreshDuplicates() {
+	sb.dupGroups = findDuplicates(sb.libraryManager.GetSongs(), sb.libraryManager.Fingerprints())
+	if n := sb.duplicateCopyCount(); sb.dupSelected >= n {
+		sb.dupSelected = max(0, n-1)
+	}
+}
+
+// GetDuplicateGroups returns the groups shown in the duplicates view.
+func (sb *SettingsBrowser) GetDuplicateGroups() []DuplicateGroup {
+	return sb.dupGroups
+}
+
+// SelectedDuplicate returns the highlighted group and the copy's index within
+// it, or -1, -1 when there are no duplicates.
+func (sb *SettingsBrowser) SelectedDuplicate() (group, index int) {
+	i := sb.dupSelected
+	for g, grp := range sb.dupGroups {
+		if i < len(grp.Songs) {
+			return g, i
+		}
+		i -= len(grp.Songs)
+	}
+	return -1, -1
+}
+
+func (sb *SettingsBrowser) duplicateCopyCount() int {
+	n := 0
+	for _, g := range sb.dupGroups {
+		n += len(g.Songs)
 	}
+	return n
 }
 
 // GetSelected returns current selection
@@ -166,4 +278,55 @@ func (sb *SettingsBrowser) GetConfirmAction() string {
 // RefreshThemes refreshes the theme list
 func (sb *SettingsBrowser) RefreshThemes() {
 	sb.themeNames = sb.settingsManager.GetThemeNames()
-}
\ No newline at end of file
+}
+
+// RefreshHealth rebuilds the health report for the current filter.
+func (sb *SettingsBrowser) RefreshHealth() {
+	sb.healthRows = healthReport(sb.libraryManager.GetSongs(), sb.libraryManager.GetDiagnostics(), sb.HealthFilter())
+	if sb.healthSelected >= len(sb.healthRows) {
+		sb.healthSelected = max(0, len(sb.healthRows)-1)
+	}
+}
+
+// CycleHealthFilter steps the report's filter forwards (delta 1) or back (-1).
+func (sb *SettingsBrowser) CycleHealthFilter(delta int) {
+	sb.healthFilter = (sb.healthFilter + delta + len(healthFilters)) % len(healthFilters)
+	sb.healthSelected = 0
+	sb.RefreshHealth()
+}
+
+// HealthFilter returns the report's current filter name.
+func (sb *SettingsBrowser) HealthFilter() string {
+	return healthFilters[sb.healthFilter]
+}
+
+// GetHealthRows returns the rows of the health report.
+func (sb *SettingsBrowser) GetHealthRows() []HealthRow {
+	return sb.healthRows
+}
+
+// GetHealthSelected returns the highlighted report row.
+func (sb *SettingsBrowser) GetHealthSelected() int {
+	return sb.healthSelected
+}
+
+// RefreshStats recomputes the listening stats for the current window.
+func (sb *SettingsBrowser) RefreshStats() {
+	sb.stats = sb.historyManager.Stats(statsWindowStart(sb.StatsWindow(), time.Now()))
+}
+
+// CycleStatsWindow steps the stats window forwards (delta 1) or back (-1).
+func (sb *SettingsBrowser) CycleStatsWindow(delta int) {
+	sb.statsWindow = (sb.statsWindow + delta + len(statsWindows)) % len(statsWindows)
+	sb.RefreshStats()
+}
+
+// StatsWindow returns the stats view's current window name.
+func (sb *SettingsBrowser) StatsWindow() string {
+	return statsWindows[sb.statsWindow]
+}
+
+// GetStats returns the listening stats for the current window.
+func (sb *SettingsBrowser) GetStats() ListeningStats {
+	return sb.stats
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SmartSpec defines a smart playlist: the rules a song has to meet, how the
+// matches are ordered and how many are kept. Smart playlists store no songs;
+// they are evaluated against the library whenever it (or the play history)
+// changes.
+type SmartSpec struct {
+	Rules []SmartRule `json:"rules"`
+	Match string      `json:"match,omitempty"` // "all" (default) or "any"
+	Sort  string      `json:"sort,omitempty"`  // a smartSorts key, "-" prefixed to reverse
+	Limit int         `json:"limit,omitempty"` // 0 means no limit
+}
+
+// SmartRule is one condition, e.g. {"genre", ":", "Jazz"} or {"added", "<", "30d"}.
+type SmartRule struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// smartFieldKinds maps each rule field to how it's compared: text fields by
+// equality or substring, numbers numerically, flags against yes or no, and ages (time since the song
+// was added or last played) against a number of days, weeks, months or years.
+var smartFieldKinds = map[string]string{
+	"title":       "text",
+	"artist":      "text",
+	"album":       "text",
+	"albumartist": "text",
+	"genre":       "text",
+	"composer":    "text",
+	"key":         "text",
+	"year":        "number",
+	"duration":    "number",
+	"plays":       "number",
+	"rating":      "number",
+	"bpm":         "number",
+	"favorite":    "flag",
+	"added":       "age",
+	"played":      "age",
+}
+
+// smartOps are the operators each kind of field accepts. ":" and "=" both
+// mean "is"; "~" is "contains" and "!~" "doesn't contain".
+var smartOps = map[string][]string{
+	"text":   {":", "=", "!=", "~", "!~"},
+	"number": {":", "=", "!=", ">", "<", ">=", "<="},
+	"flag":   {":", "="},
+	"age":    {"<", ">"},
+}
+
+// smartSorts are the sort keys; each sorts in its natural direction (newest,
+// most played, A-Z, slowest, round the Camelot wheel) unless prefixed with "-".
+var smartSorts = []string{"title", "artist", "album", "year", "duration", "added", "plays", "played", "rating", "bpm", "key", "random"}
+
+// smartRulesHelp is the one-line syntax summary shown in the rules prompt.
+const smartRulesHelp = `genre:Jazz artist~"miles" duration>300 added<30d plays=0 played>90d rating>=4 favorite:yes · sort:plays limit:50 match:any`
+
+// parseSmartSpec parses rules written as space-separated terms, e.g.
+// `genre:rock artist~"pink floyd" added<30d sort:-added limit:25`.
+func parseSmartSpec(text string) (SmartSpec, error) {
+	var spec SmartSpec
+	for _, term := range splitTerms(text) {
+		field, op, value, ok := splitTerm(term)
+		if !ok {
+			return SmartSpec{}, fmt.Errorf("can't read %q (expected field, operator and value)", term)
+		}
+		field = strings.ToLower(field)
+		switch field {
+		case "sort":
+			key := strings.TrimPrefix(strings.ToLower(value), "-")
+			if !containsString(smartSorts, key) {
+				return SmartSpec{}, fmt.Errorf("unknown sort %q (use %s)", value, strings.Join(smartSorts, ", "))
+			}
+			spec.Sort = strings.ToLower(value)
+			continue
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return SmartSpec{}, fmt.Errorf("limit must be a number")
+			}
+			spec.Limit = n
+			continue
+		case "match":
+			value = strings.ToLower(value)
+			if value != "all" && value != "any" {
+				return SmartSpec{}, fmt.Errorf("match must be all or any")
+			}
+			spec.Match = value
+			continue
+		}
+
+		kind, ok := smartFieldKinds[field]
+		if !ok {
+			return SmartSpec{}, fmt.Errorf("unknown field %q", field)
+		}
+		if !containsString(smartOps[kind], op) {
+			return SmartSpec{}, fmt.Errorf("%s can't be compared with %q (use %s)", field, op, strings.Join(smartOps[kind], " "))
+		}
+		switch kind {
+		case "number":
+			if _, err := parseSmartNumber(field, value); err != nil {
+				return SmartSpec{}, err
+			}
+		case "flag":
+			if _, err := parseFlag(field, value); err != nil {
+				return SmartSpec{}, err
+			}
+		case "age":
+			if _, err := parseAge(value); err != nil {
+				return SmartSpec{}, err
+			}
+		}
+		spec.Rules = append(spec.Rules, SmartRule{Field: field, Op: op, Value: value})
+	}
+	if len(spec.Rules) == 0 {
+		return SmartSpec{}, fmt.Errorf("add at least one rule")
+	}
+	return spec, nil
+}
+
+// String writes the spec back in the syntax parseSmartSpec reads.
+func (sp SmartSpec) String() string {
+	var terms []string
+	for _, r := range sp.Rules {
+		v := r.Value
+		if strings.ContainsAny(v, " \t") {
+			v = strconv.Quote(v)
+		}
+		terms = append(terms, r.Field+r.Op+v)
+	}
+	if sp.Sort != "" {
+		terms = append(terms, "sort:"+sp.Sort)
+	}
+	if sp.Limit > 0 {
+		terms = append(terms, "limit:"+strconv.Itoa(sp.Limit))
+	}
+	if sp.Match == "any" {
+		terms = append(terms, "match:any")
+	}
+	return strings.Join(terms, " ")
+}
+
+// splitTerms splits on whitespace, keeping double-quoted runs together.
+func splitTerms(text string) []string {
+	var terms []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case (r == ' ' || r == '\t') && !quoted:
+			if cur.Len() > 0 {
+				terms = append(terms, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		terms = append(terms, cur.String())
+	}
+	return terms
+}
+
+// splitTerm splits `artist~"pink floyd"` into field, operator and unquoted
+// value.
+func splitTerm(term string) (field, op, value string, ok bool) {
+	i := strings.IndexAny(term, ":=~<>!")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	field = term[:i]
+	op = term[i : i+1]
+	if i+1 < len(term) && (term[i+1] == '=' || term[i+1] == '~') && strings.ContainsRune("<>!", rune(term[i])) {
+		op = term[i : i+2]
+	}
+	value = strings.TrimSpace(term[i+len(op):])
+	if unq, err := strconv.Unquote(value); err == nil {
+		value = unq
+	}
+	return field, op, value, value != ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSmartNumber reads a numeric rule value. Durations may be written in
+// seconds, as m:ss or with units (5m, 90s, 1m30s).
+func parseSmartNumber(field, value string) (float64, error) {
+	if field == "duration" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d.Seconds(), nil
+		}
+		if m, s, ok := strings.Cut(value, ":"); ok {
+			mins, err1 := strconv.Atoi(m)
+			secs, err2 := strconv.Atoi(s)
+			if err1 == nil && err2 == nil {
+				return float64(mins*60 + secs), nil
+			}
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s needs a number, not %q", field, value)
+	}
+	return n, nil
+}
+
+// parseFlag reads a yes/no rule value.
+func parseFlag(field, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s is yes or no, not %q", field, value)
+}
+
+// parseAge reads an age such as "30d", "2w", "6m" or "1y" (days if no unit).
+func parseAge(value string) (time.Duration, error) {
+	unit := 24 * time.Hour
+	num := value
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'd':
+			num = value[:n-1]
+		case 'w':
+			unit, num = 7*24*time.Hour, value[:n-1]
+		case 'm':
+			unit, num = 30*24*time.Hour, value[:n-1]
+		case 'y':
+			unit, num = 365*24*time.Hour, value[:n-1]
+		}
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("ages are written like 30d, 2w, 6m or 1y, not %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// Evaluate returns the library songs matching the spec, sorted and limited.
+func (sp SmartSpec) Evaluate(library *LibraryManager, history *HistoryManager, now time.Time) []Song {
+	var out []Song
+	for _, s := range library.GetSongs() {
+		if s.FilePath == "" {
+			continue
+		}
+		if sp.matches(s, library, history, now) {
+			out = append(out, s)
+		}
+	}
+	sp.sortSongs(out, library, history)
+	if sp.Limit > 0 && len(out) > sp.Limit {
+		out = out[:sp.Limit]
+	}
+	return out
+}
+
+func (sp SmartSpec) matches(s Song, library *LibraryManager, history *HistoryManager, now time.Time) bool {
+	matchAny := sp.Match == "any"
+	for _, r := range sp.Rules {
+		if r.matches(s, library, history, now) == matchAny {
+			return matchAny
+		}
+	}
+	return !matchAny
+}
+
+func (r SmartRule) matches(s Song, library *LibraryManager, history *HistoryManager, now time.Time) bool {
+	switch smartFieldKinds[r.Field] {
+	case "text":
+		// Compared folded, so artist~bjork finds Björk.
+		v := foldString(songField(s, r.Field), false)
+		want := foldString(r.Value, false)
+		if key := normalizeKey(r.Value); r.Field == "key" && key != "" {
+			want = foldString(key, false) // so key:8A finds Am
+		}
+		switch r.Op {
+		case "~":
+			return strings.Contains(v, want)
+		case "!~":
+			return !strings.Contains(v, want)
+		case "!=":
+			return v != want
+		default:
+			return v == want
+		}
+	case "number":
+		want, err := parseSmartNumber(r.Field, r.Value)
+		if err != nil {
+			return false
+		}
+		var v float64
+		switch r.Field {
+		case "year":
+			v = float64(s.Year)
+		case "duration":
+			v = s.DurationSecs
+		case "plays":
+			v = float64(history.GetStats(s.FilePath).Count)
+		case "rating":
+			v = float64(library.Rating(s.FilePath))
+		case "bpm":
+			if s.BPM <= 0 {
+				return false // an unknown tempo matches no comparison
+			}
+			v = s.BPM
+		}
+		switch r.Op {
+		case ">":
+			return v > want
+		case "<":
+			return v < want
+		case ">=":
+			return v >= want
+		case "<=":
+			return v <= want
+		case "!=":
+			return v != want
+		default:
+			return v == want
+		}
+	case "flag":
+		want, err := parseFlag(r.Field, r.Value)
+		return err == nil && library.IsFavorite(s.FilePath) == want
+	case "age":
+		age, err := parseAge(r.Value)
+		if err != nil {
+			return false
+		}
+		var when time.Time
+		if r.Field == "added" {
+			when = library.AddedAt(s.FilePath)
+		} else {
+			when = history.GetStats(s.FilePath).LastPlayed
+		}
+		// Never played counts as longer ago than any age; an unknown added
+		// time never matches.
+		if when.IsZero() {
+			return r.Field == "played" && r.Op == ">"
+		}
+		if r.Op == "<" {
+			return now.Sub(when) < age
+		}
+		return now.Sub(when) > age
+	}
+	return false
+}
+
+// songField returns a text field of a song by rule field name.
+func songField(s Song, field string) string {
+	switch field {
+	case "title":
+		return s.Title
+	case "artist":
+		return s.Artist
+	case "album":
+		return s.Album
+	case "albumartist":
+		return s.AlbumArtist
+	case "genre":
+		return s.Genre
+	case "composer":
+		return s.Composer
+	case "key":
+		return s.Key
+	}
+	return ""
+}
+
+func (sp SmartSpec) sortSongs(songs []Song, library *LibraryManager, history *HistoryManager) {
+	key := strings.TrimPrefix(sp.Sort, "-")
+	reverse := strings.HasPrefix(sp.Sort, "-")
+	if key == "random" {
+		rand.Shuffle(len(songs), func(i, j int) { songs[i], songs[j] = songs[j], songs[i] })
+		return
+	}
+	// less reports whether a sorts before b in the key's natural direction:
+	// A-Z for text, slowest first for bpm, Camelot order for key, and
+	// newest/longest/most played first for the rest.
+	less := func(a, b Song) bool {
+		switch key {
+		case "artist":
+			return strings.ToLower(a.Artist) < strings.ToLower(b.Artist)
+		case "album":
+			return strings.ToLower(a.Album) < strings.ToLower(b.Album)
+		case "year":
+			return a.Year > b.Year
+		case "duration":
+			return a.DurationSecs > b.DurationSecs
+		case "added":
+			return library.AddedAt(a.FilePath).After(library.AddedAt(b.FilePath))
+		case "plays":
+			return history.GetStats(a.FilePath).Count > history.GetStats(b.FilePath).Count
+		case "played":
+			return history.GetStats(a.FilePath).LastPlayed.After(history.GetStats(b.FilePath).LastPlayed)
+		case "rating":
+			return library.Rating(a.FilePath) > library.Rating(b.FilePath)
+		case "bpm":
+			return tempoLess(a, b)
+		case "key":
+			return keyLess(a, b)
+		default:
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		}
+	}
+	sort.SliceStable(songs, func(i, j int) bool {
+		if reverse {
+			return less(songs[j], songs[i])
+		}
+		return less(songs[i], songs[j])
+	})
+}
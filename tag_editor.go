@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+)
+
+// tagField is one editable row in the tag editor.
+type tagField struct {
+	key      string // canonical TagFields key
+	label    string
+	value    string
+	original string
+	mixed    bool // the selected songs disagree and the user hasn't typed yet
+}
+
+// TagEditor holds the state of the edit-tags dialog for one song or a group
+// (an album, artist or genre). With several songs, per-track fields (title and
+// track number) are hidden, fields the songs disagree on start out "mixed",
+// and only fields the user actually changes are written.
+type TagEditor struct {
+	songs    []Song
+	fields   []tagField
+	selected int
+	// Identification results (single-song editor only); the current one is
+	// pre-filled into the fields for review.
+	suggestions []TagSuggestion
+	suggestion  int
+}
+
+var tagFieldLabels = map[string]string{
+	"title":       "Title",
+	"artist":      "Artist",
+	"albumartist": "Album artist",
+	"album":       "Album",
+	"genre":       "Genre",
+	"year":        "Year",
+	"track":       "Track",
+	"disc":        "Disc",
+	"composer":    "Composer",
+	"comment":     "Comment",
+}
+
+// NewTagEditor opens the editor on songs, skipping placeholder entries that
+// have no file behind them. It returns nil if nothing is editable.
+func NewTagEditor(songs []Song) *TagEditor {
+	songs = filterSongs(songs, func(s Song) bool { return s.FilePath != "" })
+	if len(songs) == 0 {
+		return nil
+	}
+	te := &TagEditor{songs: songs}
+	first := songTagFields(songs[0])
+	for _, key := range tagFieldOrder {
+		if len(songs) > 1 && (key == "title" || key == "track") {
+			continue
+		}
+		f := tagField{key: key, label: tagFieldLabels[key], value: first[key]}
+		for _, s := range songs[1:] {
+			if songTagFields(s)[key] != f.value {
+				f.mixed, f.value = true, ""
+				break
+			}
+		}
+		f.original = f.value
+		te.fields = append(te.fields, f)
+	}
+	return te
+}
+
+// Title describes what is being edited, for the dialog header.
+func (te *TagEditor) Title() string {
+	if len(te.songs) == 1 {
+		return "Edit tags: " + te.songs[0].Title
+	}
+	return fmt.Sprintf("Edit tags: %d songs", len(te.songs))
+}
+
+// Suggest pre-fills the fields from the first of sugs; NextSuggestion steps
+// through the rest. Fields the suggestion doesn't cover keep their values.
+func (te *TagEditor) Suggest(sugs []TagSuggestion) {
+	te.suggestions = sugs
+	te.suggestion = 0
+	te.applySuggestion()
+}
+
+// NextSuggestion replaces the fields with the next suggestion, wrapping around.
+func (te *TagEditor) NextSuggestion() {
+	if len(te.suggestions) < 2 {
+		return
+	}
+	te.suggestion = (te.suggestion + 1) % len(te.suggestions)
+	te.applySuggestion()
+}
+
+func (te *TagEditor) applySuggestion() {
+	if len(te.suggestions) == 0 {
+		return
+	}
+	s := te.suggestions[te.suggestion]
+	for i := range te.fields {
+		f := &te.fields[i]
+		if v, ok := s.Fields[f.key]; ok {
+			f.value, f.mixed = v, false
+		} else {
+			f.value, f.mixed = f.original, false
+		}
+	}
+}
+
+// SuggestionLabel describes the suggestion on show, e.g. "Match 1/3 (97%)",
+// or "" when the editor wasn't opened from a lookup.
+func (te *TagEditor) SuggestionLabel() string {
+	if len(te.suggestions) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Match %d/%d (%.0f%%)", te.suggestion+1, len(te.suggestions),
+		te.suggestions[te.suggestion].Score*100)
+}
+
+func (te *TagEditor) Fields() []tagField { return te.fields }
+func (te *TagEditor) Selected() int      { return te.selected }
+
+func (te *TagEditor) MoveUp() {
+	if te.selected > 0 {
+		te.selected--
+	}
+}
+
+func (te *TagEditor) MoveDown() {
+	if te.selected < len(te.fields)-1 {
+		te.selected++
+	}
+}
+
+// AddText appends typed text to the selected field. Typing into a mixed field
+// replaces it, so the new value will be written to every song.
+func (te *TagEditor) AddText(text string) {
+	f := &te.fields[te.selected]
+	f.mixed = false
+	f.value += text
+}
+
+// Backspace deletes the last character of the selected field.
+func (te *TagEditor) Backspace() {
+	f := &te.fields[te.selected]
+	if f.mixed {
+		return
+	}
+	if r := []rune(f.value); len(r) > 0 {
+		f.value = string(r[:len(r)-1])
+	}
+}
+
+// Changes returns the fields the user edited, ready for writeTags.
+func (te *TagEditor) Changes() TagFields {
+	changes := make(TagFields)
+	for _, f := range te.fields {
+		if f.mixed || f.value == f.original {
+			continue
+		}
+		changes[f.key] = strings.TrimSpace(f.value)
+	}
+	return changes
+}
+
+// Apply writes the changes to every song's file and returns the songs that
+// were updated, with their in-memory fields to match. It stops at the first
+// file that can't be written; songs written before it are still returned.
+func (te *TagEditor) Apply() ([]Song, error) {
+	changes := te.Changes()
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	var updated []Song
+	for _, s := range te.songs {
+		if err := writeTags(s.FilePath, changes); err != nil {
+			return updated, fmt.Errorf("%s: %w", getFilenameWithoutExt(s.FilePath), err)
+		}
+		applyTagFields(&s, changes)
+		updated = append(updated, s)
+	}
+	return updated, nil
+}
+
+// tagEditDoneMsg reports a finished tagEditCmd: the songs whose files were
+// rewritten and the error that stopped it, if any.
+type tagEditDoneMsg struct {
+	songs []Song
+	err   error
+}
+
+// tagEditCmd writes the editor's changes to the files in the background. The
+// editor must not be used again once the command is handed to the runtime.
+func tagEditCmd(te *TagEditor) tea.Cmd {
+	return func() tea.Msg {
+		updated, err := te.Apply()
+		return tagEditDoneMsg{songs: updated, err: err}
+	}
+}
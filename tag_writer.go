@@ -0,0 +1,1137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Tag writing. dhowden/tag only reads, so the editor writes tags itself: ID3v2
+// for MP3, Vorbis comments for FLAC and Ogg Vorbis, and a LIST/INFO chunk for
+// WAV. Each writer streams the file into a temp file next to the original and
+// renames it into place, so a failed write never leaves a half-written track.
+
+// TagFields maps canonical field names ("title", "artist", "track", …) to the
+// values to write. Only the fields present are touched; an empty value removes
+// that field from the file. Track and disc use the "n/total" form.
+type TagFields map[string]string
+
+// tagFieldOrder is the canonical field set, in editor display order.
+var tagFieldOrder = []string{"title", "artist", "albumartist", "album", "genre", "year", "track", "disc", "composer", "comment"}
+
+// analysisTagFields are written like tagFieldOrder's fields but aren't in the
+// editor: they're filled in by tempo and key analysis.
+var analysisTagFields = []string{"bpm", "key"}
+
+// writableTagFields is every field the tag writers handle, in writing order.
+var writableTagFields = append(append([]string{}, tagFieldOrder...), analysisTagFields...)
+
+// songTagFields returns the full tag field set for a song, as it would be
+// written to the file.
+func songTagFields(s Song) TagFields {
+	f := TagFields{
+		"title":       s.Title,
+		"artist":      s.Artist,
+		"albumartist": s.AlbumArtist,
+		"album":       s.Album,
+		"genre":       s.Genre,
+		"composer":    s.Composer,
+		"comment":     s.Comment,
+		"year":        "",
+		"track":       formatPosition(s.TrackNumber, s.TrackTotal),
+		"disc":        formatPosition(s.DiscNumber, s.DiscTotal),
+	}
+	if s.Year > 0 {
+		f["year"] = strconv.Itoa(s.Year)
+	}
+	// The scanner substitutes placeholders for missing tags; don't write them.
+	if s.Artist == "Unknown Artist" {
+		f["artist"] = ""
+	}
+	if s.Album == "Unknown Album" {
+		f["album"] = ""
+	}
+	if s.Genre == "Unknown Genre" {
+		f["genre"] = ""
+	}
+	return f
+}
+
+// applyTagFields updates the in-memory song to match fields that were written,
+// restoring the scanner's placeholders for cleared fields.
+func applyTagFields(s *Song, fields TagFields) {
+	for key, value := range fields {
+		switch key {
+		case "title":
+			s.Title = fieldOrUnknown(value, getFilenameWithoutExt(s.FilePath))
+		case "artist":
+			s.Artist = fieldOrUnknown(value, "Unknown Artist")
+		case "albumartist":
+			s.AlbumArtist = value
+		case "album":
+			s.Album = fieldOrUnknown(value, "Unknown Album")
+		case "genre":
+			s.Genre = fieldOrUnknown(value, "Unknown Genre")
+		case "composer":
+			s.Composer = value
+		case "comment":
+			s.Comment = value
+		case "year":
+			s.Year, _ = strconv.Atoi(value)
+		case "track":
+			s.TrackNumber, s.TrackTotal = parsePosition(value)
+		case "disc":
+			s.DiscNumber, s.DiscTotal = parsePosition(value)
+		case "bpm":
+			s.BPM, _ = strconv.ParseFloat(value, 64)
+		case "key":
+			s.Key = normalizeKey(value)
+		}
+	}
+}
+
+// formatPosition renders a track/disc position as "n" or "n/total".
+func formatPosition(n, total int) string {
+	if n <= 0 {
+		return ""
+	}
+	if total > 0 {
+		return fmt.Sprintf("%d/%d", n, total)
+	}
+	return strconv.Itoa(n)
+}
+
+// parsePosition parses "n" or "n/total"; malformed parts come back as 0.
+func parsePosition(v string) (n, total int) {
+	num, tot, _ := strings.Cut(strings.TrimSpace(v), "/")
+	n, _ = strconv.Atoi(strings.TrimSpace(num))
+	total, _ = strconv.Atoi(strings.TrimSpace(tot))
+	return n, total
+}
+
+// writeTags writes fields into the audio file's native tag format.
+func writeTags(path string, fields TagFields) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".mp3":
+		return writeID3v2(path, fields)
+	case ".flac":
+		return writeFLACTags(path, fields)
+	case ".ogg":
+		return writeOggTags(path, fields)
+	case ".wav":
+		return writeWAVInfo(path, fields)
+	default:
+		return fmt.Errorf("writing tags is not supported for %s files", ext)
+	}
+}
+
+// writeRating stores a 0-5 star rating in the file's tags (0 removes it): a
+// POPM frame for MP3, FMPS_RATING for FLAC and Ogg Vorbis. WAV's INFO chunk
+// has no rating field.
+func writeRating(path string, stars int) error {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".mp3", ".flac", ".ogg":
+		return writeTags(path, TagFields{"rating": strconv.Itoa(stars)})
+	default:
+		return fmt.Errorf("ratings can't be stored in %s files", ext)
+	}
+}
+
+// tempoKeyFields returns a song's tempo and key as tags: TBPM and TKEY for
+// MP3, BPM and INITIALKEY for FLAC and Ogg Vorbis. Tempo is written as a whole
+// number, as ID3 requires; an unknown value is left out rather than cleared.
+func tempoKeyFields(s Song) TagFields {
+	f := TagFields{}
+	if s.BPM > 0 {
+		f["bpm"] = strconv.Itoa(int(math.Round(s.BPM)))
+	}
+	if s.Key != "" {
+		f["key"] = s.Key
+	}
+	return f
+}
+
+// writeTempoKey stores a song's tempo and key in its file. WAV's INFO chunk
+// has no field for either.
+func writeTempoKey(s Song) error {
+	switch ext := strings.ToLower(filepath.Ext(s.FilePath)); ext {
+	case ".mp3", ".flac", ".ogg":
+		return writeTags(s.FilePath, tempoKeyFields(s))
+	default:
+		return fmt.Errorf("tempo and key can't be stored in %s files", ext)
+	}
+}
+
+// rewriteFile replaces path with whatever write produces, via a temp file in
+// the same directory that is renamed over the original on success. src, the
+// original opened for reading, is closed before the rename, which Windows
+// refuses while the file is open; the caller's deferred Close is then a no-op.
+func rewriteFile(path string, src io.Closer, write func(w io.Writer) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".resona-tag-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	bw := bufio.NewWriterSize(tmp, 256*1024)
+	if err := write(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = src.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ---- ID3v2 (MP3) ----
+
+// id3Frame is one raw ID3v2.3/2.4 frame. Frames the editor doesn't manage
+// (pictures, lyrics, private data) are carried over byte-for-byte.
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	body  []byte
+}
+
+// id3FrameIDs maps a field to the frames that hold it; the first ID is the one
+// written, the rest are removed when the field is set.
+func id3FrameIDs(field string, version byte) []string {
+	switch field {
+	case "title":
+		return []string{"TIT2"}
+	case "artist":
+		return []string{"TPE1"}
+	case "albumartist":
+		return []string{"TPE2"}
+	case "album":
+		return []string{"TALB"}
+	case "genre":
+		return []string{"TCON"}
+	case "year":
+		if version == 4 {
+			return []string{"TDRC", "TYER"}
+		}
+		return []string{"TYER", "TDRC"}
+	case "track":
+		return []string{"TRCK"}
+	case "disc":
+		return []string{"TPOS"}
+	case "composer":
+		return []string{"TCOM"}
+	case "comment":
+		return []string{"COMM"}
+	case "bpm":
+		return []string{"TBPM"}
+	case "key":
+		return []string{"TKEY"}
+	}
+	return nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0] = byte(n>>21) & 0x7F
+	b[1] = byte(n>>14) & 0x7F
+	b[2] = byte(n>>7) & 0x7F
+	b[3] = byte(n) & 0x7F
+}
+
+// readID3v2Frames parses the ID3v2 tag at the start of r. It returns the tag
+// version (0 when there is no tag), its frames, and the total tag size so the
+// caller can skip to the audio. ID3v2.2 tags are reported with no frames and
+// are replaced outright.
+func readID3v2Frames(r io.Reader) (version byte, frames []id3Frame, tagSize int64, err error) {
+	hdr := make([]byte, 10)
+	if _, err := io.ReadFull(r, hdr); err != nil || string(hdr[0:3]) != "ID3" {
+		return 0, nil, 0, nil
+	}
+	version, flags := hdr[3], hdr[5]
+	size := syncsafe(hdr[6:10])
+	tagSize = 10 + int64(size)
+	if flags&0x10 != 0 {
+		tagSize += 10 // footer
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, 0, fmt.Errorf("truncated ID3v2 tag: %w", err)
+	}
+	if version != 3 && version != 4 {
+		return version, nil, tagSize, nil
+	}
+	if version == 3 && flags&0x80 != 0 {
+		// ID3v2.3 unsynchronises the whole tag: undo it before parsing.
+		data = bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(data) >= 4 { // extended header
+		if version == 4 {
+			pos = syncsafe(data[0:4])
+		} else {
+			pos = 4 + int(binary.BigEndian.Uint32(data[0:4]))
+		}
+	}
+	for pos+10 <= len(data) && data[pos] != 0 {
+		id := string(data[pos : pos+4])
+		var n int
+		if version == 4 {
+			n = syncsafe(data[pos+4 : pos+8])
+		} else {
+			n = int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		}
+		start, end := pos+10, pos+10+n
+		if n < 0 || end > len(data) {
+			break
+		}
+		frames = append(frames, id3Frame{id: id, flags: [2]byte{data[pos+8], data[pos+9]}, body: data[start:end]})
+		pos = end
+	}
+	return version, frames, tagSize, nil
+}
+
+// isLatin1 reports whether s can be stored as ISO-8859-1.
+func isLatin1(s string) bool {
+	for _, r := range s {
+		if r > 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// id3EncodeText returns the encoding byte and encoded text (without a
+// terminator): UTF-8 for v2.4; Latin-1 or BOM-prefixed UTF-16 for v2.3.
+func id3EncodeText(version byte, text string) (byte, []byte) {
+	if version == 4 {
+		return 3, []byte(text)
+	}
+	if isLatin1(text) {
+		out := make([]byte, 0, len(text))
+		for _, r := range text {
+			out = append(out, byte(r))
+		}
+		return 0, out
+	}
+	out := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(text)) {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return 1, out
+}
+
+// id3Terminator is the string terminator for an ID3 text encoding.
+func id3Terminator(enc byte) []byte {
+	if enc == 1 || enc == 2 {
+		return []byte{0, 0}
+	}
+	return []byte{0}
+}
+
+func id3TextFrame(version byte, id, text string) id3Frame {
+	enc, b := id3EncodeText(version, text)
+	return id3Frame{id: id, body: append([]byte{enc}, b...)}
+}
+
+// id3CommentFrame builds a COMM frame with an empty description, which is the
+// one dhowden/tag (and most players) read as "the" comment.
+func id3CommentFrame(version byte, text string) id3Frame {
+	enc, b := id3EncodeText(version, text)
+	body := append([]byte{enc}, "eng"...)
+	if enc == 1 {
+		body = append(body, 0xFF, 0xFE) // BOM for the empty description
+	}
+	body = append(body, id3Terminator(enc)...)
+	body = append(body, b...)
+	return id3Frame{id: "COMM", body: body}
+}
+
+// id3CommentIsDefault reports whether a COMM frame has an empty description.
+func id3CommentIsDefault(body []byte) bool {
+	if len(body) < 5 {
+		return true
+	}
+	desc := body[4:]
+	if body[0] == 1 && len(desc) >= 2 && (desc[0] == 0xFF || desc[0] == 0xFE) {
+		desc = desc[2:] // skip BOM
+	}
+	term := id3Terminator(body[0])
+	return bytes.HasPrefix(desc, term)
+}
+
+// writeID3v2 rewrites the MP3's ID3v2 tag with fields applied, preserving the
+// tag version (v2.3 when there is none) and any frames it doesn't manage.
+func writeID3v2(path string, fields TagFields) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version, frames, tagSize, err := readID3v2Frames(f)
+	if err != nil {
+		return err
+	}
+	if version != 3 && version != 4 {
+		version = 3
+	}
+	return writeID3v2Frames(f, path, version, frames, tagSize, func(frames []id3Frame) []id3Frame {
+		for _, field := range writableTagFields {
+			value, ok := fields[field]
+			if !ok {
+				continue
+			}
+			ids := id3FrameIDs(field, version)
+			frames = removeID3Frames(frames, ids)
+			if value == "" {
+				continue
+			}
+			if ids[0] == "COMM" {
+				frames = append(frames, id3CommentFrame(version, value))
+			} else {
+				frames = append(frames, id3TextFrame(version, ids[0], value))
+			}
+		}
+		if value, ok := fields["rating"]; ok {
+			kept := frames[:0:0]
+			for _, fr := range frames {
+				if fr.id != "POPM" || !bytes.HasPrefix(fr.body, []byte(popmEmail+"\x00")) {
+					kept = append(kept, fr)
+				}
+			}
+			frames = kept
+			if stars, _ := strconv.Atoi(value); stars > 0 {
+				frames = append(frames, id3RatingFrame(stars))
+			}
+		}
+		return frames
+	})
+}
+
+// popmEmail identifies the POPM (popularimeter) frame ratings are written to.
+// Windows Media Player's is used, with its star-to-byte scale, because it's
+// the one other players most often read. Other applications' POPM frames are
+// left alone.
+const popmEmail = "Windows Media Player 9 Series"
+
+var popmStars = [6]byte{0, 1, 64, 128, 196, 255}
+
+// id3RatingFrame builds a POPM frame for a 1-5 star rating, without a play
+// counter.
+func id3RatingFrame(stars int) id3Frame {
+	body := append([]byte(popmEmail), 0, popmStars[clamp(stars, 0, 5)])
+	return id3Frame{id: "POPM", body: body}
+}
+
+// removeID3Frames drops frames with the given IDs. Only default (empty
+// description) comments are removed so other applications' comments survive.
+func removeID3Frames(frames []id3Frame, ids []string) []id3Frame {
+	out := frames[:0:0]
+	for _, fr := range frames {
+		drop := false
+		for _, id := range ids {
+			if fr.id == id && (id != "COMM" || id3CommentIsDefault(fr.body)) {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			out = append(out, fr)
+		}
+	}
+	return out
+}
+
+// writeID3v2Frames lets edit transform the parsed frames, then writes a fresh
+// tag (with padding for future in-place growth) followed by the audio that
+// started at tagSize in src.
+func writeID3v2Frames(src io.ReadSeekCloser, path string, version byte, frames []id3Frame, tagSize int64, edit func([]id3Frame) []id3Frame) error {
+	frames = edit(frames)
+
+	var body bytes.Buffer
+	for _, fr := range frames {
+		hdr := make([]byte, 10)
+		copy(hdr, fr.id)
+		if version == 4 {
+			putSyncsafe(hdr[4:8], len(fr.body))
+		} else {
+			binary.BigEndian.PutUint32(hdr[4:8], uint32(len(fr.body)))
+		}
+		hdr[8], hdr[9] = fr.flags[0], fr.flags[1]
+		body.Write(hdr)
+		body.Write(fr.body)
+	}
+	body.Write(make([]byte, 1024)) // padding
+
+	header := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+	putSyncsafe(header[6:10], body.Len())
+
+	if _, err := src.Seek(tagSize, io.SeekStart); err != nil {
+		return err
+	}
+	return rewriteFile(path, src, func(w io.Writer) error {
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(body.Bytes()); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
+
+// ---- Vorbis comments (FLAC, Ogg Vorbis) ----
+
+// vorbisKeys maps a field to its Vorbis comment keys; every key listed is
+// removed when the field is set.
+func vorbisKeys(field string) []string {
+	switch field {
+	case "title":
+		return []string{"TITLE"}
+	case "artist":
+		return []string{"ARTIST"}
+	case "albumartist":
+		return []string{"ALBUMARTIST", "ALBUM ARTIST"}
+	case "album":
+		return []string{"ALBUM"}
+	case "genre":
+		return []string{"GENRE"}
+	case "year":
+		return []string{"DATE", "YEAR"}
+	case "track":
+		return []string{"TRACKNUMBER", "TRACKTOTAL", "TOTALTRACKS"}
+	case "disc":
+		return []string{"DISCNUMBER", "DISCTOTAL", "TOTALDISCS"}
+	case "composer":
+		return []string{"COMPOSER"}
+	case "comment":
+		return []string{"COMMENT", "DESCRIPTION"}
+	case "bpm":
+		return []string{"BPM"}
+	case "key":
+		return []string{"INITIALKEY", "KEY"}
+	case "rating":
+		return []string{"FMPS_RATING"}
+	}
+	return nil
+}
+
+// vorbisComment is a parsed comment header: the encoder's vendor string and
+// its KEY=value entries in file order.
+type vorbisComment struct {
+	vendor   string
+	comments []string
+}
+
+func parseVorbisComment(b []byte) (vorbisComment, error) {
+	var vc vorbisComment
+	r := bytes.NewReader(b)
+	readString := func() (string, error) {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", err
+		}
+		if int64(n) > int64(r.Len()) {
+			return "", fmt.Errorf("vorbis comment length %d exceeds block", n)
+		}
+		s := make([]byte, n)
+		_, err := io.ReadFull(r, s)
+		return string(s), err
+	}
+	var err error
+	if vc.vendor, err = readString(); err != nil {
+		return vc, err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return vc, err
+	}
+	for i := uint32(0); i < count; i++ {
+		c, err := readString()
+		if err != nil {
+			return vc, err
+		}
+		vc.comments = append(vc.comments, c)
+	}
+	return vc, nil
+}
+
+func (vc vorbisComment) bytes() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len(vc.vendor)))
+	b.WriteString(vc.vendor)
+	binary.Write(&b, binary.LittleEndian, uint32(len(vc.comments)))
+	for _, c := range vc.comments {
+		binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return b.Bytes()
+}
+
+// apply replaces the comments for every field present in fields.
+func (vc *vorbisComment) apply(fields TagFields) {
+	remove := make(map[string]bool)
+	for field := range fields {
+		for _, k := range vorbisKeys(field) {
+			remove[k] = true
+		}
+	}
+	kept := vc.comments[:0:0]
+	for _, c := range vc.comments {
+		key, _, _ := strings.Cut(c, "=")
+		if !remove[strings.ToUpper(key)] {
+			kept = append(kept, c)
+		}
+	}
+	for _, field := range writableTagFields {
+		value := fields[field]
+		if value == "" {
+			continue
+		}
+		keys := vorbisKeys(field)
+		switch field {
+		case "track", "disc":
+			n, total := parsePosition(value)
+			if n > 0 {
+				kept = append(kept, keys[0]+"="+strconv.Itoa(n))
+			}
+			if total > 0 {
+				kept = append(kept, keys[1]+"="+strconv.Itoa(total))
+			}
+		default:
+			kept = append(kept, keys[0]+"="+value)
+		}
+	}
+	// FMPS_RATING is a fraction: 0.2 per star.
+	if stars, _ := strconv.Atoi(fields["rating"]); stars > 0 {
+		kept = append(kept, "FMPS_RATING="+strconv.FormatFloat(float64(clamp(stars, 0, 5))/5, 'f', 1, 64))
+	}
+	vc.comments = kept
+}
+
+// writeFLACTags replaces the FLAC's VORBIS_COMMENT block, dropping old padding
+// and appending a fresh padding block after the metadata.
+func writeFLACTags(path string, fields TagFields) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "fLaC" {
+		return fmt.Errorf("not a FLAC file")
+	}
+
+	type block struct {
+		kind byte
+		body []byte
+	}
+	var blocks []block
+	var vc vorbisComment
+	for {
+		hdr := make([]byte, 4)
+		if _, err := io.ReadFull(f, hdr); err != nil {
+			return fmt.Errorf("truncated FLAC metadata: %w", err)
+		}
+		last, kind := hdr[0]&0x80 != 0, hdr[0]&0x7F
+		n := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+		body := make([]byte, n)
+		if _, err := io.ReadFull(f, body); err != nil {
+			return fmt.Errorf("truncated FLAC metadata: %w", err)
+		}
+		switch kind {
+		case 4: // VORBIS_COMMENT
+			if vc, err = parseVorbisComment(body); err != nil {
+				return fmt.Errorf("invalid vorbis comment: %w", err)
+			}
+		case 1: // PADDING is regenerated below
+		default:
+			blocks = append(blocks, block{kind, body})
+		}
+		if last {
+			break
+		}
+	}
+	if vc.vendor == "" {
+		vc.vendor = "resona"
+	}
+	vc.apply(fields)
+	blocks = append(blocks, block{4, vc.bytes()}, block{1, make([]byte, 1024)})
+
+	return rewriteFile(path, f, func(w io.Writer) error {
+		if _, err := w.Write(magic); err != nil {
+			return err
+		}
+		for i, b := range blocks {
+			hdr := []byte{b.kind, byte(len(b.body) >> 16), byte(len(b.body) >> 8), byte(len(b.body))}
+			if i == len(blocks)-1 {
+				hdr[0] |= 0x80
+			}
+			if _, err := w.Write(hdr); err != nil {
+				return err
+			}
+			if _, err := w.Write(b.body); err != nil {
+				return err
+			}
+		}
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
+
+// oggPage is one Ogg page; data holds the concatenated segments.
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	seq        uint32
+	segments   []byte
+	data       []byte
+}
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+func readOggPage(r io.Reader) (*oggPage, error) {
+	hdr := make([]byte, 27)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if string(hdr[0:4]) != "OggS" {
+		return nil, fmt.Errorf("invalid Ogg page")
+	}
+	p := &oggPage{
+		headerType: hdr[5],
+		granule:    binary.LittleEndian.Uint64(hdr[6:14]),
+		serial:     binary.LittleEndian.Uint32(hdr[14:18]),
+		seq:        binary.LittleEndian.Uint32(hdr[18:22]),
+		segments:   make([]byte, hdr[26]),
+	}
+	if _, err := io.ReadFull(r, p.segments); err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, s := range p.segments {
+		n += int(s)
+	}
+	p.data = make([]byte, n)
+	if _, err := io.ReadFull(r, p.data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// bytes serialises the page with a freshly computed CRC.
+func (p *oggPage) bytes() []byte {
+	b := make([]byte, 27, 27+len(p.segments)+len(p.data))
+	copy(b, "OggS")
+	b[5] = p.headerType
+	binary.LittleEndian.PutUint64(b[6:14], p.granule)
+	binary.LittleEndian.PutUint32(b[14:18], p.serial)
+	binary.LittleEndian.PutUint32(b[18:22], p.seq)
+	b[26] = byte(len(p.segments))
+	b = append(b, p.segments...)
+	b = append(b, p.data...)
+	binary.LittleEndian.PutUint32(b[22:26], oggCRC(b))
+	return b
+}
+
+// paginateOgg lays packets out into pages starting at sequence seq. Header
+// pages carry granule 0, or -1 when no packet finishes on the page.
+func paginateOgg(packets [][]byte, serial, seq uint32) []*oggPage {
+	var pages []*oggPage
+	page := &oggPage{serial: serial, seq: seq}
+	finished := false
+	flush := func(continued bool) {
+		page.granule = ^uint64(0)
+		if finished {
+			page.granule = 0
+		}
+		pages = append(pages, page)
+		page = &oggPage{serial: serial, seq: seq + uint32(len(pages))}
+		if continued {
+			page.headerType = 0x01
+		}
+		finished = false
+	}
+	for _, pkt := range packets {
+		// A packet is laced in 255-byte segments and ends with a shorter one,
+		// which is a zero-length lace when its length is a multiple of 255.
+		// A page that fills up mid-packet continues on the next.
+		rest := pkt
+		for started := false; ; started = true {
+			if len(page.segments) == 255 {
+				flush(started)
+			}
+			n := min(len(rest), 255)
+			page.segments = append(page.segments, byte(n))
+			page.data = append(page.data, rest[:n]...)
+			rest = rest[n:]
+			if n < 255 {
+				break
+			}
+		}
+		finished = true
+	}
+	flush(false)
+	return pages
+}
+
+// writeOggTags replaces the comment header of an Ogg Vorbis stream. The comment
+// and setup packets are re-paginated, and later pages of the stream are
+// renumbered if the header now spans a different number of pages.
+func writeOggTags(path string, fields TagFields) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+
+	first, err := readOggPage(br)
+	if err != nil {
+		return fmt.Errorf("not an Ogg file: %w", err)
+	}
+	serial := first.serial
+
+	// Collect the comment and setup packets, which follow the identification
+	// page and end on a page boundary.
+	var packets [][]byte
+	var cur []byte
+	headerPages := 0
+	for len(packets) < 2 {
+		p, err := readOggPage(br)
+		if err != nil {
+			return fmt.Errorf("truncated Vorbis headers: %w", err)
+		}
+		if p.serial != serial {
+			return fmt.Errorf("multiplexed Ogg streams are not supported")
+		}
+		headerPages++
+		off := 0
+		for _, s := range p.segments {
+			cur = append(cur, p.data[off:off+int(s)]...)
+			off += int(s)
+			if s < 255 {
+				packets = append(packets, cur)
+				cur = nil
+			}
+		}
+	}
+	if len(cur) > 0 || len(packets) != 2 {
+		return fmt.Errorf("unexpected Vorbis header layout")
+	}
+	commentPkt := packets[0]
+	if len(commentPkt) < 7 || commentPkt[0] != 3 || string(commentPkt[1:7]) != "vorbis" {
+		return fmt.Errorf("missing Vorbis comment header")
+	}
+	vc, err := parseVorbisComment(commentPkt[7:])
+	if err != nil {
+		return fmt.Errorf("invalid vorbis comment: %w", err)
+	}
+	vc.apply(fields)
+	newComment := append([]byte("\x03vorbis"), vc.bytes()...)
+	newComment = append(newComment, 1) // framing bit
+
+	newPages := paginateOgg([][]byte{newComment, packets[1]}, serial, first.seq+1)
+	delta := uint32(len(newPages) - headerPages)
+
+	return rewriteFile(path, f, func(w io.Writer) error {
+		if _, err := w.Write(first.bytes()); err != nil {
+			return err
+		}
+		for _, p := range newPages {
+			if _, err := w.Write(p.bytes()); err != nil {
+				return err
+			}
+		}
+		if delta == 0 {
+			_, err := io.Copy(w, br)
+			return err
+		}
+		for {
+			p, err := readOggPage(br)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if p.serial == serial {
+				p.seq += delta
+			}
+			if _, err := w.Write(p.bytes()); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// ---- RIFF INFO (WAV) ----
+
+// riffInfoIDs maps fields to LIST/INFO chunk IDs. Composer and album artist
+// have no standard ID; IMUS and IAAR are the most common choices.
+var riffInfoIDs = map[string]string{
+	"title":       "INAM",
+	"artist":      "IART",
+	"albumartist": "IAAR",
+	"album":       "IPRD",
+	"genre":       "IGNR",
+	"year":        "ICRD",
+	"track":       "ITRK",
+	"composer":    "IMUS",
+	"comment":     "ICMT",
+}
+
+type riffChunk struct {
+	id     string
+	offset int64 // offset of the chunk body in the file
+	size   uint32
+}
+
+// readRIFFChunks lists the top-level chunks of a RIFF/WAVE file.
+func readRIFFChunks(f io.ReadSeeker) ([]riffChunk, error) {
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(f, hdr); err != nil || string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a RIFF/WAVE file")
+	}
+	var chunks []riffChunk
+	pos := int64(12)
+	for {
+		ch := make([]byte, 8)
+		if _, err := io.ReadFull(f, ch); err != nil {
+			break
+		}
+		c := riffChunk{id: string(ch[0:4]), offset: pos + 8, size: binary.LittleEndian.Uint32(ch[4:8])}
+		chunks = append(chunks, c)
+		pos = c.offset + int64(c.size) + int64(c.size&1)
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// parseRIFFInfo decodes the sub-chunks of a LIST/INFO body (after "INFO"),
+// returning the entries keyed by chunk ID and the IDs in file order.
+func parseRIFFInfo(body []byte) (map[string]string, []string) {
+	info := make(map[string]string)
+	var order []string
+	for pos := 0; pos+8 <= len(body); {
+		id := string(body[pos : pos+4])
+		n := int(binary.LittleEndian.Uint32(body[pos+4 : pos+8]))
+		end := pos + 8 + n
+		if end > len(body) {
+			break
+		}
+		if _, seen := info[id]; !seen {
+			order = append(order, id)
+		}
+		info[id] = strings.TrimRight(string(body[pos+8:end]), "\x00 ")
+		pos = end + n&1
+	}
+	return info, order
+}
+
+// readRIFFInfo returns the LIST/INFO entries of a WAV file keyed by chunk ID,
+// or nil if it has none. dhowden/tag doesn't read RIFF, so the scanner uses
+// this for WAV metadata.
+func readRIFFInfo(path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	chunks, err := readRIFFChunks(f)
+	if err != nil {
+		return nil
+	}
+	for _, c := range chunks {
+		if c.id != "LIST" || c.size < 4 || c.size > 1<<20 {
+			continue
+		}
+		body := make([]byte, c.size)
+		if _, err := f.ReadAt(body, c.offset); err != nil || string(body[0:4]) != "INFO" {
+			continue
+		}
+		info, _ := parseRIFFInfo(body[4:])
+		return info
+	}
+	return nil
+}
+
+// writeWAVInfo rewrites the WAV with a new LIST/INFO chunk at the end, merging
+// fields into any existing INFO entries.
+func writeWAVInfo(path string, fields TagFields) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chunks, err := readRIFFChunks(f)
+	if err != nil {
+		return err
+	}
+	info := make(map[string]string)
+	var keep []riffChunk
+	var order []string
+	for _, c := range chunks {
+		if c.id == "LIST" && c.size >= 4 {
+			kind := make([]byte, 4)
+			if _, err := f.ReadAt(kind, c.offset); err == nil && string(kind) == "INFO" {
+				body := make([]byte, c.size)
+				if _, err := f.ReadAt(body, c.offset); err == nil {
+					entries, ids := parseRIFFInfo(body[4:])
+					for _, id := range ids {
+						info[id] = entries[id]
+						order = append(order, id)
+					}
+				}
+				continue
+			}
+		}
+		keep = append(keep, c)
+	}
+	for _, field := range tagFieldOrder {
+		value, set := fields[field]
+		id, ok := riffInfoIDs[field]
+		if !set || !ok {
+			continue
+		}
+		if field == "track" {
+			n, _ := parsePosition(value)
+			value = ""
+			if n > 0 {
+				value = strconv.Itoa(n)
+			}
+		}
+		if value == "" {
+			delete(info, id)
+			continue
+		}
+		if _, exists := info[id]; !exists {
+			order = append(order, id)
+		}
+		info[id] = value
+	}
+
+	var list bytes.Buffer
+	list.WriteString("INFO")
+	for _, id := range order {
+		v, ok := info[id]
+		if !ok {
+			continue
+		}
+		delete(info, id) // write each ID once even if order repeats it
+		data := append([]byte(v), 0)
+		list.WriteString(id)
+		binary.Write(&list, binary.LittleEndian, uint32(len(data)))
+		list.Write(data)
+		if len(data)&1 == 1 {
+			list.WriteByte(0)
+		}
+	}
+
+	total := int64(4) // "WAVE"
+	for _, c := range keep {
+		total += 8 + int64(c.size) + int64(c.size&1)
+	}
+	if list.Len() > 4 {
+		total += 8 + int64(list.Len())
+	}
+
+	return rewriteFile(path, f, func(w io.Writer) error {
+		hdr := make([]byte, 12)
+		copy(hdr, "RIFF")
+		binary.LittleEndian.PutUint32(hdr[4:8], uint32(total))
+		copy(hdr[8:], "WAVE")
+		if _, err := w.Write(hdr); err != nil {
+			return err
+		}
+		for _, c := range keep {
+			ch := make([]byte, 8)
+			copy(ch, c.id)
+			binary.LittleEndian.PutUint32(ch[4:8], c.size)
+			if _, err := w.Write(ch); err != nil {
+				return err
+			}
+			n := int64(c.size) + int64(c.size&1)
+			if _, err := io.Copy(w, io.NewSectionReader(f, c.offset, n)); err != nil {
+				return err
+			}
+		}
+		if list.Len() > 4 {
+			ch := make([]byte, 8)
+			copy(ch, "LIST")
+			binary.LittleEndian.PutUint32(ch[4:8], uint32(list.Len()))
+			if _, err := w.Write(ch); err != nil {
+				return err
+			}
+			_, err := w.Write(list.Bytes())
+			return err
+		}
+		return nil
+	})
+}
+
+// applyRIFFInfo fills song fields from LIST/INFO entries read by readRIFFInfo.
+func applyRIFFInfo(s *Song, info map[string]string) {
+	fields := make(TagFields)
+	for field, id := range riffInfoIDs {
+		if v := strings.TrimSpace(info[id]); v != "" {
+			fields[field] = v
+		}
+	}
+	if y := fields["year"]; len(y) > 4 {
+		fields["year"] = y[:4] // ICRD is often a full date
+	}
+	applyTagFields(s, fields)
+}
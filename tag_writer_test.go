@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dhowden/tag"
+)
+
+// fakeAudio stands in for the audio after the tags; the writers must carry it
+// over byte for byte.
+var fakeAudio = bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00, 0x12, 0x34}, 200)
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readTestTags(t *testing.T, path string) tag.Metadata {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		t.Fatalf("reading tags back: %v", err)
+	}
+	return m
+}
+
+func checkTail(t *testing.T, path string, tail []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, tail) {
+		t.Errorf("audio after the tags was not preserved")
+	}
+}
+
+func TestWriteID3v2RoundTrip(t *testing.T) {
+	path := writeTestFile(t, "song.mp3", fakeAudio)
+	fields := TagFields{"title": "Café Night", "artist": "Nina", "album": "Pastel", "track": "3/12", "comment": "first"}
+	if err := writeTags(path, fields); err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite an existing tag: replace one field and clear another.
+	if err := writeTags(path, TagFields{"album": "Pastel (Deluxe)", "comment": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRating(path, 4); err != nil {
+		t.Fatal(err)
+	}
+	m := readTestTags(t, path)
+	if m.Title() != "Café Night" || m.Artist() != "Nina" || m.Album() != "Pastel (Deluxe)" {
+		t.Errorf("got title %q artist %q album %q", m.Title(), m.Artist(), m.Album())
+	}
+	if n, total := m.Track(); n != 3 || total != 12 {
+		t.Errorf("track = %d/%d, want 3/12", n, total)
+	}
+	if m.Comment() != "" {
+		t.Errorf("comment = %q, want it removed", m.Comment())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	version, frames, _, err := readID3v2Frames(f)
+	if err != nil || version != 3 {
+		t.Fatalf("version %d, err %v", version, err)
+	}
+	var popm []byte
+	for _, fr := range frames {
+		if fr.id == "POPM" {
+			popm = fr.body
+		}
+	}
+	if want := append([]byte(popmEmail+"\x00"), popmStars[4]); !bytes.Equal(popm, want) {
+		t.Errorf("POPM = %q, want %q", popm, want)
+	}
+	checkTail(t, path, fakeAudio)
+}
+
+func TestWriteFLACTagsRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("fLaC")
+	b.Write([]byte{0x00, 0, 0, 34}) // STREAMINFO
+	b.Write(make([]byte, 34))
+	old := vorbisComment{vendor: "test", comments: []string{"TITLE=Old", "ALBUM ARTIST=Someone", "CUSTOM=kept"}}
+	body := old.bytes()
+	b.Write([]byte{0x80 | 4, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))})
+	b.Write(body)
+	b.Write(fakeAudio)
+	path := writeTestFile(t, "song.flac", b.Bytes())
+
+	if err := writeTags(path, TagFields{"title": "New", "albumartist": "Various", "disc": "1/2"}); err != nil {
+		t.Fatal(err)
+	}
+	m := readTestTags(t, path)
+	if m.Title() != "New" || m.AlbumArtist() != "Various" {
+		t.Errorf("got title %q album artist %q", m.Title(), m.AlbumArtist())
+	}
+	if n, total := m.Disc(); n != 1 || total != 2 {
+		t.Errorf("disc = %d/%d, want 1/2", n, total)
+	}
+	raw := m.Raw()
+	if raw["custom"] != "kept" {
+		t.Errorf("unmanaged comment lost: %v", raw)
+	}
+	if _, ok := raw["album artist"]; ok {
+		t.Errorf("old ALBUM ARTIST key not replaced: %v", raw)
+	}
+	checkTail(t, path, fakeAudio)
+}
+
+// buildTestOgg lays out a minimal Vorbis stream: identification, comment and
+// setup headers followed by two audio pages.
+func buildTestOgg(t *testing.T, vc vorbisComment, setupLen int) []byte {
+	t.Helper()
+	ident := []byte("\x01vorbis")
+	ident = append(ident, 0, 0, 0, 0, 1)                   // version, channels
+	ident = binary.LittleEndian.AppendUint32(ident, 44100) // rate
+	ident = append(ident, make([]byte, 12)...)             // bitrates
+	ident = append(ident, 0xB8, 1)                         // block sizes, framing
+	comment := append(append([]byte("\x03vorbis"), vc.bytes()...), 1)
+	setup := append([]byte("\x05vorbis"), bytes.Repeat([]byte{0xAB}, setupLen)...)
+
+	const serial = 0x1234
+	var out bytes.Buffer
+	first := paginateOgg([][]byte{ident}, serial, 0)[0]
+	first.headerType = 0x02
+	out.Write(first.bytes())
+	headers := paginateOgg([][]byte{comment, setup}, serial, 1)
+	for _, p := range headers {
+		out.Write(p.bytes())
+	}
+	for i := 0; i < 2; i++ {
+		p := paginateOgg([][]byte{fakeAudio}, serial, uint32(1+len(headers)+i))[0]
+		p.granule = uint64(1024 * (i + 1))
+		if i == 1 {
+			p.headerType = 0x04
+		}
+		out.Write(p.bytes())
+	}
+	return out.Bytes()
+}
+
+// readTestOggPages reads every page of path, checking each page's CRC and
+// that sequence numbers run on without a gap.
+func readTestOggPages(t *testing.T, path string) []*oggPage {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []*oggPage
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		start := len(data) - r.Len()
+		p, err := readOggPage(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := append([]byte{}, data[start:len(data)-r.Len()]...)
+		crc := binary.LittleEndian.Uint32(raw[22:26])
+		copy(raw[22:26], []byte{0, 0, 0, 0})
+		if oggCRC(raw) != crc {
+			t.Errorf("page %d has a bad CRC", p.seq)
+		}
+		if p.seq != uint32(len(pages)) {
+			t.Errorf("page %d has sequence number %d", len(pages), p.seq)
+		}
+		pages = append(pages, p)
+	}
+	return pages
+}
+
+func TestWriteOggTagsRoundTrip(t *testing.T) {
+	data := buildTestOgg(t, vorbisComment{vendor: "test", comments: []string{"TITLE=Old", "CUSTOM=kept"}}, 300)
+	path := writeTestFile(t, "song.ogg", data)
+
+	if err := writeTags(path, TagFields{"title": "New", "artist": "Nina", "genre": "Jazz"}); err != nil {
+		t.Fatal(err)
+	}
+	m := readTestTags(t, path)
+	if m.Title() != "New" || m.Artist() != "Nina" || m.Genre() != "Jazz" {
+		t.Errorf("got title %q artist %q genre %q", m.Title(), m.Artist(), m.Genre())
+	}
+	if m.Raw()["custom"] != "kept" {
+		t.Errorf("unmanaged comment lost: %v", m.Raw())
+	}
+	readTestOggPages(t, path)
+	checkTail(t, path, fakeAudio)
+}
+
+func TestWriteOggTagsRenumbersPages(t *testing.T) {
+	data := buildTestOgg(t, vorbisComment{vendor: "test"}, 300)
+	path := writeTestFile(t, "song.ogg", data)
+	before := len(readTestOggPages(t, path))
+
+	// A comment this long spreads the headers over more pages, so the audio
+	// pages after them have to be renumbered.
+	long := strings.Repeat("x", 255*255*2)
+	if err := writeTags(path, TagFields{"comment": long}); err != nil {
+		t.Fatal(err)
+	}
+	pages := readTestOggPages(t, path)
+	if len(pages) <= before {
+		t.Fatalf("got %d pages, want more than %d", len(pages), before)
+	}
+	if last := pages[len(pages)-1]; last.headerType != 0x04 || last.granule != 2048 {
+		t.Errorf("last page type %#x granule %d", last.headerType, last.granule)
+	}
+	if m := readTestTags(t, path); m.Comment() != long {
+		t.Errorf("comment of %d bytes read back as %d", len(long), len(m.Comment()))
+	}
+}
+
+// reassembleOgg joins page segments back into packets, failing on a page whose
+// continuation flag doesn't match whether a packet was left open.
+func reassembleOgg(t *testing.T, pages []*oggPage) [][]byte {
+	t.Helper()
+	var packets [][]byte
+	var cur []byte
+	open := false
+	for i, p := range pages {
+		if continued := p.headerType&0x01 != 0; continued != open {
+			t.Errorf("page %d: continued flag %v, packet open %v", i, continued, open)
+		}
+		if len(p.segments) > 255 {
+			t.Errorf("page %d has %d segments", i, len(p.segments))
+		}
+		off := 0
+		for _, s := range p.segments {
+			cur = append(cur, p.data[off:off+int(s)]...)
+			off += int(s)
+			open = s == 255
+			if !open {
+				packets = append(packets, cur)
+				cur = nil
+			}
+		}
+	}
+	if open {
+		t.Errorf("last packet never finished")
+	}
+	return packets
+}
+
+func TestPaginateOgg(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []int
+	}{
+		{"small", []int{10, 20}},
+		{"multiple of 255", []int{255 * 4, 7}},
+		{"fills a page exactly", []int{255 * 254, 3}},
+		{"spans pages", []int{255*600 + 17, 255 * 300}},
+		{"ends on a page boundary", []int{255 * 255, 255*255 - 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var packets [][]byte
+			for i, n := range tt.sizes {
+				packets = append(packets, bytes.Repeat([]byte{byte(i + 1)}, n))
+			}
+			pages := paginateOgg(packets, 7, 1)
+			for i, p := range pages {
+				if p.seq != uint32(1+i) || p.serial != 7 {
+					t.Errorf("page %d: seq %d serial %d", i, p.seq, p.serial)
+				}
+			}
+			got := reassembleOgg(t, pages)
+			if len(got) != len(packets) {
+				t.Fatalf("got %d packets, want %d", len(got), len(packets))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], packets[i]) {
+					t.Errorf("packet %d: got %d bytes, want %d", i, len(got[i]), len(packets[i]))
+				}
+			}
+			if last := pages[len(pages)-1]; last.granule != 0 {
+				t.Errorf("last page granule %d, want 0", last.granule)
+			}
+		})
+	}
+}
+
+// buildTestWAV returns a WAV with a fmt chunk, fakeAudio as its data, and a
+// LIST/INFO chunk holding entries in the given order.
+func buildTestWAV(entries [][2]string) []byte {
+	chunk := func(id string, body []byte) []byte {
+		b := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+		b = append(b, body...)
+		if len(body)&1 == 1 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	fmtBody := binary.LittleEndian.AppendUint16(nil, 1) // PCM
+	fmtBody = binary.LittleEndian.AppendUint16(fmtBody, 1)
+	fmtBody = binary.LittleEndian.AppendUint32(fmtBody, 8000)
+	fmtBody = binary.LittleEndian.AppendUint32(fmtBody, 16000)
+	fmtBody = binary.LittleEndian.AppendUint16(fmtBody, 2)
+	fmtBody = binary.LittleEndian.AppendUint16(fmtBody, 16)
+	info := []byte("INFO")
+	for _, e := range entries {
+		info = append(info, chunk(e[0], append([]byte(e[1]), 0))...)
+	}
+	body := []byte("WAVE")
+	body = append(body, chunk("fmt ", fmtBody)...)
+	body = append(body, chunk("LIST", info)...)
+	body = append(body, chunk("data", fakeAudio)...)
+	return chunk("RIFF", body)
+}
+
+func TestWriteWAVInfoRoundTrip(t *testing.T) {
+	path := writeTestFile(t, "song.wav", buildTestWAV([][2]string{
+		{"ISFT", "Encoder"}, {"INAM", "Old"}, {"ICMT", "note"}, {"IART", "Nina"},
+	}))
+	if err := writeTags(path, TagFields{"title": "New", "album": "Pastel", "comment": "", "track": "4/9"}); err != nil {
+		t.Fatal(err)
+	}
+	info := readRIFFInfo(path)
+	want := map[string]string{"ISFT": "Encoder", "INAM": "New", "IART": "Nina", "IPRD": "Pastel", "ITRK": "4"}
+	if len(info) != len(want) {
+		t.Errorf("got entries %v, want %v", info, want)
+	}
+	for id, v := range want {
+		if info[id] != v {
+			t.Errorf("%s = %q, want %q", id, info[id], v)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	chunks, err := readRIFFChunks(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, c := range chunks {
+		if c.id == "data" {
+			audio := make([]byte, c.size)
+			if _, err := f.ReadAt(audio, c.offset); err != nil || !bytes.Equal(audio, fakeAudio) {
+				t.Errorf("data chunk was not preserved")
+			}
+		}
+		if c.id == "LIST" {
+			body := make([]byte, c.size)
+			if _, err := f.ReadAt(body, c.offset); err != nil {
+				t.Fatal(err)
+			}
+			_, ids = parseRIFFInfo(body[4:])
+		}
+	}
+	// Existing entries keep their places; new ones follow in field order.
+	if got, want := strings.Join(ids, " "), "ISFT INAM IART IPRD ITRK"; got != want {
+		t.Errorf("INFO order %q, want %q", got, want)
+	}
+}
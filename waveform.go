@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+)
+
+// Waveforms for the seek bar: a track's loudness envelope, worked out in the
+// background the first time it plays and cached in ~/.resona/waveforms.
+const (
+	waveformBins     = 256  // envelope resolution, far more than any bar is wide
+	waveformDynRange = 36.0 // dB below the loudest bin that still shows
+)
+
+// waveformLoadedMsg reports that a waveform lookup finished (found or not).
+type waveformLoadedMsg struct{ path string }
+
+// WaveformCache holds the envelopes of tracks that have been played. It
+// mirrors ArtCache: lookups run in the background and the cache is safe to
+// read while they do.
+type WaveformCache struct {
+	dir     string
+	mu      sync.Mutex
+	levels  map[string][]float64 // nil value: looked up, couldn't decode
+	pending map[string]bool
+}
+
+func NewWaveformCache() (*WaveformCache, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	dir := filepath.Join(homeDir, ".resona", "waveforms")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create waveform cache directory: %v", err)
+	}
+	return &WaveformCache{
+		dir:     dir,
+		levels:  make(map[string][]float64),
+		pending: make(map[string]bool),
+	}, nil
+}
+
+// Levels returns a track's envelope, waveformBins values from 0 (silent) to 1
+// (loudest), or nil if it hasn't been worked out (yet).
+func (wc *WaveformCache) Levels(path string) []float64 {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return wc.levels[path]
+}
+
+// LoadCmd starts a background lookup of the song's waveform, or returns nil
+// if it is already loaded or in flight.
+func (wc *WaveformCache) LoadCmd(s Song) tea.Cmd {
+	if s.FilePath == "" {
+		return nil
+	}
+	wc.mu.Lock()
+	_, done := wc.levels[s.FilePath]
+	if done || wc.pending[s.FilePath] {
+		wc.mu.Unlock()
+		return nil
+	}
+	wc.pending[s.FilePath] = true
+	wc.mu.Unlock()
+
+	return func() tea.Msg {
+		levels := wc.load(s.FilePath)
+		wc.mu.Lock()
+		wc.levels[s.FilePath] = levels
+		delete(wc.pending, s.FilePath)
+		wc.mu.Unlock()
+		return waveformLoadedMsg{path: s.FilePath}
+	}
+}
+
+// load reads the waveform from the disk cache, or computes and caches it. The
+// cache file is named for the file's content hash, which covers only the
+// audio, so a retagged or moved file keeps its waveform and a re-encoded one
+// gets a fresh one.
+func (wc *WaveformCache) load(path string) []float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil
+	}
+	hash := contentHash(f, info.Size())
+	f.Close()
+	cachePath := ""
+	if hash != "" {
+		cachePath = filepath.Join(wc.dir, hash[:16]+".wave")
+	}
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil || len(data) != waveformBins {
+		rms, err := waveformRMS(path)
+		if err != nil {
+			return nil
+		}
+		data = waveformEnvelope(rms)
+		if cachePath != "" {
+			_ = os.WriteFile(cachePath, data, 0644)
+		}
+	}
+	levels := make([]float64, len(data))
+	for i, b := range data {
+		levels[i] = float64(b) / 255
+	}
+	return levels
+}
+
+// waveformRMS decodes the whole file and returns the RMS loudness of each of
+// waveformBins equal stretches, accumulated as it streams so a long track
+// isn't held in memory.
+func waveformRMS(path string) ([]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	streamer, _, err := decodeAudioFile(f)
+	if err != nil {
+		return nil, err
+	}
+	defer streamer.Close()
+	total := streamer.Len()
+	if total <= 0 {
+		return nil, fmt.Errorf("unknown length")
+	}
+
+	sums := make([]float64, waveformBins)
+	counts := make([]int, waveformBins)
+	pos := 0
+	buf := make([][2]float64, 4096)
+	for {
+		n, ok := streamer.Stream(buf)
+		for _, frame := range buf[:n] {
+			i := min(pos*waveformBins/total, waveformBins-1)
+			v := (frame[0] + frame[1]) / 2
+			sums[i] += v * v
+			counts[i]++
+			pos++
+		}
+		if !ok {
+			break
+		}
+	}
+	if pos == 0 {
+		return nil, fmt.Errorf("no audio")
+	}
+	rms := make([]float64, waveformBins)
+	for i := range rms {
+		if counts[i] > 0 {
+			rms[i] = math.Sqrt(sums[i] / float64(counts[i]))
+		}
+	}
+	return rms, nil
+}
+
+// waveformEnvelope rates each bin's RMS loudness on a decibel scale relative
+// to the loudest, as a byte: 255 for the loudest, 0 for waveformDynRange dB
+// quieter or less.
+func waveformEnvelope(rms []float64) []byte {
+	loudest := 0.0
+	for _, v := range rms {
+		loudest = math.Max(loudest, v)
+	}
+	out := make([]byte, len(rms))
+	if loudest == 0 {
+		return out
+	}
+	for i, v := range rms {
+		if v <= 0 {
+			continue
+		}
+		db := 20 * math.Log10(v/loudest)
+		level := math.Max(0, 1+db/waveformDynRange)
+		out[i] = byte(math.Round(level * 255))
+	}
+	return out
+}
+
+// waveformColumns resamples an envelope to width columns, each the loudest
+// stretch it covers so short peaks (a drop, a snare hit) don't vanish.
+func waveformColumns(levels []float64, width int) []float64 {
+	cols := make([]float64, width)
+	for i := range cols {
+		lo := i * len(levels) / width
+		hi := max((i+1)*len(levels)/width, lo+1)
+		for _, v := range levels[lo:min(hi, len(levels))] {
+			cols[i] = math.Max(cols[i], v)
+		}
+	}
+	return cols
+}
+
+// waveformGlyphs draw a column's level, lowest first. Silence still gets the
+// lowest bar so the track's extent stays visible.
+var waveformGlyphs = []string{"▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"}
+
+func waveformGlyph(level float64) string {
+	i := int(level * float64(len(waveformGlyphs)))
+	return waveformGlyphs[clamp(i, 0, len(waveformGlyphs)-1)]
+}
+
+// waveformBarCells draws the seek bar as a waveform width cells wide, the
+// played part in the progress gradient and the rest in unplayed's color.
+func waveformBarCells(levels []float64, width int, progress float64, gradient []lipgloss.Style, unplayed lipgloss.Style) []string {
+	played := int(progress*float64(width) + 0.5)
+	cells := make([]string, 0, width)
+	for i, level := range waveformColumns(levels, width) {
+		glyph := waveformGlyph(level)
+		if i < played && len(gradient) > 0 {
+			cells = append(cells, gradient[min(i, len(gradient)-1)].Render(glyph))
+		} else {
+			cells = append(cells, unplayed.Render(glyph))
+		}
+	}
+	return cells
+}